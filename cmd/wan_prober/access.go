@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Roles of clients of the admin API, each allowed what the roles before it
+// are
+const (
+	// Reads admin endpoints, e.g. dashboards
+	accessViewer = "viewer"
+	// Forces interface health and approves failbacks
+	accessOperator = "operator"
+	// Calls every admin endpoint
+	accessAdmin = "admin"
+)
+
+var (
+	accessRoles = []string{accessViewer, accessOperator, accessAdmin}
+
+	// Least role which can call an admin endpoint with a method which
+	// changes something, by pattern. Other endpoints need the admin role.
+	adminEndpointAccess = map[string]string{
+		"/override": accessOperator,
+		"/failback": accessOperator,
+	}
+
+	// Clients of the admin API, when access control is enabled
+	adminAccess []AdminAccess
+)
+
+// Client of the admin API, identified by a bearer token or the common name
+// of its client certificate
+type AdminAccess struct {
+	Name                 string `yaml:"name"`
+	Token                string `yaml:"token"`
+	ClientCertCommonName string `yaml:"client_cert_common_name"`
+	Role                 string `yaml:"role"`
+}
+
+// Check the clients of the admin API. Tokens are sent in the Authorization
+// header, so they can't be used on listeners with basic auth. Client
+// certificates are only trusted when verified, so they need an admin
+// listener with a client CA.
+func validateAdminAccess(access []AdminAccess, listeners []ListenerConfig) error {
+	names := map[string]bool{}
+	tokens := false
+	certificates := false
+	for _, client := range access {
+		if client.Name == "" {
+			return errors.New("admin access entry has no name")
+		}
+		if names[client.Name] {
+			return fmt.Errorf("duplicate admin access entry %s", client.Name)
+		}
+		names[client.Name] = true
+
+		if (client.Token == "") == (client.ClientCertCommonName == "") {
+			return fmt.Errorf("admin access entry %s needs either a token or a client_cert_common_name", client.Name)
+		}
+		if !slices.Contains(accessRoles, client.Role) {
+			return fmt.Errorf(
+				"admin access entry %s has invalid role %s, must be %s, %s or %s",
+				client.Name,
+				client.Role,
+				accessViewer,
+				accessOperator,
+				accessAdmin,
+			)
+		}
+		tokens = tokens || client.Token != ""
+		certificates = certificates || client.ClientCertCommonName != ""
+	}
+
+	verifiesCertificates := false
+	for _, listener := range listeners {
+		if !slices.Contains(listener.Roles, roleAdmin) {
+			continue
+		}
+		if tokens && listener.BasicAuth != nil {
+			return fmt.Errorf("listener %s can't use basic_auth with admin access tokens", listener.Address)
+		}
+		verifiesCertificates = verifiesCertificates || (listener.TLS != nil && listener.TLS.ClientCAFile != "")
+	}
+	if certificates && !verifiesCertificates {
+		return errors.New("admin access entries with a client_cert_common_name need an admin listener with a client_ca_file")
+	}
+
+	return nil
+}
+
+// Client of the admin API making a request, if any
+func adminClient(r *http.Request) *AdminAccess {
+	token, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	for i, client := range adminAccess {
+		if client.Token != "" {
+			if bearer && subtle.ConstantTimeCompare([]byte(token), []byte(client.Token)) == 1 {
+				return &adminAccess[i]
+			}
+			continue
+		}
+		// Only certificates the listener verified against its client CA
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 &&
+			r.TLS.VerifiedChains[0][0].Subject.CommonName == client.ClientCertCommonName {
+			return &adminAccess[i]
+		}
+	}
+
+	return nil
+}
+
+// Least role which can call an admin endpoint with a method
+func requiredAccess(pattern string, method string) string {
+	if method == http.MethodGet || method == http.MethodHead {
+		return accessViewer
+	}
+	if role, exists := adminEndpointAccess[pattern]; exists {
+		return role
+	}
+
+	return accessAdmin
+}
+
+// Serve an admin endpoint only to clients whose role allows the call, when
+// access control is enabled
+func accessHandler(pattern string, handler http.Handler) http.Handler {
+	if len(adminAccess) == 0 {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := adminClient(r)
+		if client == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wan-prober"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		required := requiredAccess(pattern, r.Method)
+		if slices.Index(accessRoles, client.Role) < slices.Index(accessRoles, required) {
+			http.Error(w, fmt.Sprintf("Forbidden, needs the %s role", required), http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
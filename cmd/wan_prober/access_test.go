@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateAdminAccess(t *testing.T) {
+	viewer := AdminAccess{Name: "grafana", Token: "3b9f6c1e8a2d", Role: accessViewer}
+	operator := AdminAccess{Name: "noc", ClientCertCommonName: "noc.example.org", Role: accessOperator}
+	admin := ListenerConfig{Address: "localhost:8020", Roles: []string{roleAdmin}}
+	verifying := ListenerConfig{
+		Address: "0.0.0.0:8443",
+		Roles:   []string{roleAdmin},
+		TLS:     &ListenerTLS{CertFile: "server.pem", KeyFile: "server.key", ClientCAFile: "ca.pem"},
+	}
+	status := ListenerConfig{
+		Address: "0.0.0.0:8444",
+		Roles:   []string{roleStatus},
+		TLS:     &ListenerTLS{CertFile: "server.pem", KeyFile: "server.key", ClientCAFile: "ca.pem"},
+	}
+
+	tests := []struct {
+		name      string
+		access    []AdminAccess
+		listeners []ListenerConfig
+		valid     bool
+	}{
+		{"token", []AdminAccess{viewer}, []ListenerConfig{admin}, true},
+		{"certificate with client CA", []AdminAccess{operator}, []ListenerConfig{admin, verifying}, true},
+		{"certificate without client CA", []AdminAccess{operator}, []ListenerConfig{admin}, false},
+		{"client CA only on status listener", []AdminAccess{operator}, []ListenerConfig{admin, status}, false},
+		{"token with basic auth", []AdminAccess{viewer}, []ListenerConfig{{
+			Address:   "localhost:8020",
+			Roles:     []string{roleAdmin},
+			BasicAuth: &BasicAuthConfig{},
+		}}, false},
+		{"token and certificate", []AdminAccess{{
+			Name:                 "both",
+			Token:                "3b9f6c1e8a2d",
+			ClientCertCommonName: "noc.example.org",
+			Role:                 accessAdmin,
+		}}, []ListenerConfig{verifying}, false},
+		{"invalid role", []AdminAccess{{Name: "root", Token: "3b9f6c1e8a2d", Role: "root"}}, []ListenerConfig{admin}, false},
+		{"duplicate name", []AdminAccess{viewer, viewer}, []ListenerConfig{admin}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateAdminAccess(test.access, test.listeners); (err == nil) != test.valid {
+				t.Errorf("validateAdminAccess() = %v, want valid %t", err, test.valid)
+			}
+		})
+	}
+}
+
+// TLS state of a connection whose client presented a certificate, verified
+// by the listener or not
+func clientCertificateState(commonName string, verified bool) *tls.ConnectionState {
+	certificate := &x509.Certificate{Subject: pkix.Name{CommonName: commonName}}
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+	if verified {
+		state.VerifiedChains = [][]*x509.Certificate{{certificate}}
+	}
+
+	return state
+}
+
+func TestAccessHandler(t *testing.T) {
+	saved := adminAccess
+	t.Cleanup(func() { adminAccess = saved })
+	adminAccess = []AdminAccess{
+		{Name: "grafana", Token: "viewer-token", Role: accessViewer},
+		{Name: "oncall", Token: "operator-token", Role: accessOperator},
+		{Name: "root", Token: "admin-token", Role: accessAdmin},
+		{Name: "noc", ClientCertCommonName: "noc.example.org", Role: accessOperator},
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		tls     *tls.ConnectionState
+		method  string
+		pattern string
+		status  int
+	}{
+		{"viewer reads", "viewer-token", nil, http.MethodGet, "/override", http.StatusOK},
+		{"viewer overrides", "viewer-token", nil, http.MethodPost, "/override", http.StatusForbidden},
+		{"operator overrides", "operator-token", nil, http.MethodPost, "/override", http.StatusOK},
+		{"operator approves failback", "operator-token", nil, http.MethodPost, "/failback", http.StatusOK},
+		{"operator simulates", "operator-token", nil, http.MethodPost, "/debug/simulate", http.StatusForbidden},
+		{"admin simulates", "admin-token", nil, http.MethodPost, "/debug/simulate", http.StatusOK},
+		{"admin overrides", "admin-token", nil, http.MethodPost, "/override", http.StatusOK},
+		{"unknown token", "guessed-token", nil, http.MethodGet, "/override", http.StatusUnauthorized},
+		{"no credentials", "", nil, http.MethodGet, "/override", http.StatusUnauthorized},
+		{"verified certificate", "", clientCertificateState("noc.example.org", true), http.MethodPost, "/override", http.StatusOK},
+		{"unverified certificate", "", clientCertificateState("noc.example.org", false), http.MethodPost, "/override", http.StatusUnauthorized},
+		{"other certificate", "", clientCertificateState("www.example.org", true), http.MethodGet, "/override", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := accessHandler(test.pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			request := httptest.NewRequest(test.method, test.pattern, nil)
+			if test.token != "" {
+				request.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			request.TLS = test.tls
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("%s %s answered %d, want %d", test.method, test.pattern, recorder.Code, test.status)
+			}
+		})
+	}
+}
+
+// Audit entries only name clients by certificates the listener verified
+func TestClientIdentity(t *testing.T) {
+	saved := adminAccess
+	t.Cleanup(func() { adminAccess = saved })
+	adminAccess = nil
+
+	tests := []struct {
+		name   string
+		tls    *tls.ConnectionState
+		client string
+	}{
+		{"verified certificate", clientCertificateState("noc.example.org", true), "CN=noc.example.org"},
+		{"unverified certificate", clientCertificateState("noc.example.org", false), ""},
+		{"no certificate", nil, ""},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodPost, "/override", nil)
+		request.TLS = test.tls
+
+		if client := clientIdentity(ListenerConfig{}, request); client != test.client {
+			t.Errorf("%s: clientIdentity() = %q, want %q", test.name, client, test.client)
+		}
+	}
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// Challenges which prove control of the domains of a certificate
+const (
+	// Serve a token over HTTP on port 80 of the domain
+	acmeChallengeHTTP01 = "http-01"
+	// Publish a token in a TXT record of the domain
+	acmeChallengeDNS01 = "dns-01"
+)
+
+const (
+	defaultACMECacheDir         = "/var/lib/wan-prober/acme"
+	defaultACMEHTTPAddress      = ":80"
+	defaultACMEPropagationDelay = time.Minute
+
+	acmeAccountKeyFile  = "account.key"
+	acmeCertificateFile = "certificate.pem"
+	acmeKeyFile         = "certificate.key"
+
+	// How often the certificate is checked for renewal
+	acmeCheckInterval = 12 * time.Hour
+	// How long to wait before trying again after failing to obtain a
+	// certificate, CAs rate limit failed attempts
+	acmeRetryInterval = time.Hour
+	// Longest time obtaining a certificate may take
+	acmeTimeout = 10 * time.Minute
+	// TTL of the TXT records of DNS-01 challenges
+	acmeDNSTTL = 60
+)
+
+var (
+	// Certificate of listeners with acme TLS, nil without an acme section
+	acmeCertificates *acmeManager
+)
+
+// Obtains a certificate for HTTPS listeners from an ACME CA such as Let's
+// Encrypt, and renews it before it expires
+type acmeManager struct {
+	config ACMEConfig
+	client *acme.Client
+
+	mu          sync.Mutex
+	certificate *tls.Certificate
+	// Key authorizations of pending HTTP-01 challenges, by path
+	http01 map[string]string
+}
+
+// Check the ACME configuration, filling in defaults. Listeners which take
+// their certificate from ACME need an acme section.
+func validateACME(config *ACMEConfig, listeners []ListenerConfig) error {
+	for _, listener := range listeners {
+		if config == nil && listener.TLS != nil && listener.TLS.ACME {
+			return fmt.Errorf("listener %s uses acme, but there is no acme section", listener.Address)
+		}
+	}
+	if config == nil {
+		return nil
+	}
+
+	if len(config.Domains) == 0 {
+		return errors.New("acme needs domains")
+	}
+	if config.DirectoryURL == "" {
+		config.DirectoryURL = acme.LetsEncryptURL
+	}
+	if config.CacheDir == "" {
+		config.CacheDir = defaultACMECacheDir
+	}
+
+	switch config.Challenge {
+	case "", acmeChallengeHTTP01:
+		config.Challenge = acmeChallengeHTTP01
+		if slices.ContainsFunc(config.Domains, func(domain string) bool { return strings.HasPrefix(domain, "*.") }) {
+			return errors.New("acme wildcard domains need the dns-01 challenge")
+		}
+		if config.HTTPAddress == "" {
+			config.HTTPAddress = defaultACMEHTTPAddress
+		}
+	case acmeChallengeDNS01:
+		if config.DNS == nil {
+			return errors.New("acme challenge dns-01 needs a dns provider")
+		}
+		if err := validateDNSProvider(config.DNS, "acme dns"); err != nil {
+			return err
+		}
+		if config.PropagationDelay == 0 {
+			config.PropagationDelay = defaultACMEPropagationDelay
+		}
+	default:
+		return fmt.Errorf(
+			"invalid acme challenge %s, must be %s or %s",
+			config.Challenge,
+			acmeChallengeHTTP01,
+			acmeChallengeDNS01,
+		)
+	}
+
+	return nil
+}
+
+// Manager of the certificate, with the account key and the certificate
+// obtained before loaded from the cache directory
+func newACMEManager(config ACMEConfig) (*acmeManager, error) {
+	if err := os.MkdirAll(config.CacheDir, 0o700); err != nil {
+		return nil, err
+	}
+
+	key, err := acmeAccountKey(filepath.Join(config.CacheDir, acmeAccountKeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load account key: %w", err)
+	}
+
+	m := &acmeManager{
+		config: config,
+		client: &acme.Client{
+			Key:          key,
+			DirectoryURL: config.DirectoryURL,
+			UserAgent:    "wan-prober",
+		},
+		http01: map[string]string{},
+	}
+
+	certificate, err := tls.LoadX509KeyPair(
+		filepath.Join(config.CacheDir, acmeCertificateFile),
+		filepath.Join(config.CacheDir, acmeKeyFile),
+	)
+	switch {
+	case err == nil && coversDomains(certificate.Leaf, config.Domains):
+		m.certificate = &certificate
+	case err == nil:
+		logger.Info("Cached ACME certificate doesn't cover the configured domains, obtaining a new one")
+	case !errors.Is(err, os.ErrNotExist):
+		logger.Warn("Couldn't load cached ACME certificate", "error", err.Error())
+	}
+
+	return m, nil
+}
+
+// Load the account key, creating it the first time
+func acmeAccountKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no key in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key in %s", path)
+	}
+
+	return signer, nil
+}
+
+// Whether a certificate is valid for every domain
+func coversDomains(leaf *x509.Certificate, domains []string) bool {
+	for _, domain := range domains {
+		if !slices.Contains(leaf.DNSNames, domain) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Time to renew a certificate, when a third of its lifetime is left
+func renewalTime(leaf *x509.Certificate) time.Time {
+	return leaf.NotAfter.Add(-leaf.NotAfter.Sub(leaf.NotBefore) / 3)
+}
+
+// Certificate served by listeners, for tls.Config.GetCertificate
+func (m *acmeManager) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.certificate == nil {
+		return nil, errors.New("no ACME certificate obtained yet")
+	}
+
+	return m.certificate, nil
+}
+
+// Answer HTTP-01 challenges of pending authorizations
+func (m *acmeManager) serveHTTP01(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	response, exists := m.http01[r.URL.Path]
+	m.mu.Unlock()
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte(response))
+}
+
+// Open the HTTP-01 challenge listener, so it is bound before privileges are
+// dropped, then obtain a certificate in the background when there is none
+// or it is due for renewal, checking again every 12 hours
+func (m *acmeManager) start(ctx context.Context) error {
+	if m.config.Challenge == acmeChallengeHTTP01 {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/.well-known/acme-challenge/", m.serveHTTP01)
+
+		err := openListener(ctx, m.config.HTTPAddress, func(listener net.Listener) {
+			logger.Info("Listening for ACME challenges", "listen_address", m.config.HTTPAddress)
+			serveHTTP(ctx, &http.Server{Handler: mux}, listener)
+		})
+		if err != nil {
+			return fmt.Errorf("couldn't listen for ACME challenges: %w", err)
+		}
+	}
+
+	go func() {
+		for {
+			wait := m.renew(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Obtain a certificate when there is none or it is due for renewal,
+// returning how long to wait before checking again
+func (m *acmeManager) renew(ctx context.Context) time.Duration {
+	m.mu.Lock()
+	certificate := m.certificate
+	m.mu.Unlock()
+
+	if certificate != nil && time.Now().Before(renewalTime(certificate.Leaf)) {
+		return acmeCheckInterval
+	}
+
+	obtainCtx, cancel := context.WithTimeout(ctx, acmeTimeout)
+	certificate, err := m.obtain(obtainCtx)
+	cancel()
+
+	if err != nil {
+		logger.Error(
+			"Error obtaining ACME certificate",
+			"domains",
+			m.config.Domains,
+			"retry_in",
+			acmeRetryInterval,
+			"error",
+			err.Error(),
+		)
+		return acmeRetryInterval
+	}
+
+	m.mu.Lock()
+	m.certificate = certificate
+	m.mu.Unlock()
+
+	logger.Info(
+		"Obtained ACME certificate",
+		"domains",
+		m.config.Domains,
+		"expires",
+		certificate.Leaf.NotAfter,
+	)
+
+	return acmeCheckInterval
+}
+
+// Order a certificate for the domains, completing a challenge for each
+// domain the CA hasn't authorized yet, and cache it
+func (m *acmeManager) obtain(ctx context.Context) (*tls.Certificate, error) {
+	account := &acme.Account{}
+	if m.config.Email != "" {
+		account.Contact = []string{"mailto:" + m.config.Email}
+	}
+	if _, err := m.client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("couldn't register account: %w", err)
+	}
+
+	order, err := m.client.AuthorizeOrder(ctx, acme.DomainIDs(m.config.Domains...))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create order: %w", err)
+	}
+
+	// One at a time, as a domain and its wildcard share a TXT record
+	for _, authorizationURL := range order.AuthzURLs {
+		if err := m.authorize(ctx, authorizationURL); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err = m.client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("order failed: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: m.config.Domains[0]},
+		DNSNames: m.config.Domains,
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, _, err := m.client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't finalize order: %w", err)
+	}
+
+	certificatePEM := []byte{}
+	for _, der := range chain {
+		certificatePEM = append(certificatePEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	certificate, err := tls.X509KeyPair(certificatePEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	// Served even when it can't be cached, the CA rate limits orders
+	if err := m.cache(keyPEM, certificatePEM); err != nil {
+		logger.Warn(
+			"Couldn't cache ACME certificate, it will be obtained again on restart",
+			"cache_dir",
+			m.config.CacheDir,
+			"error",
+			err.Error(),
+		)
+	}
+
+	return &certificate, nil
+}
+
+// Write a certificate and its key to the cache directory
+func (m *acmeManager) cache(keyPEM []byte, certificatePEM []byte) error {
+	// Key first, so the cached certificate never comes with a stale key
+	if err := writeFileAtomic(filepath.Join(m.config.CacheDir, acmeKeyFile), keyPEM); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(filepath.Join(m.config.CacheDir, acmeCertificateFile), certificatePEM)
+}
+
+// Prove control of the domain of an authorization with the configured
+// challenge, unless the CA already authorized it
+func (m *acmeManager) authorize(ctx context.Context, authorizationURL string) error {
+	authorization, err := m.client.GetAuthorization(ctx, authorizationURL)
+	if err != nil {
+		return err
+	}
+	if authorization.Status == acme.StatusValid {
+		return nil
+	}
+
+	domain := authorization.Identifier.Value
+	index := slices.IndexFunc(authorization.Challenges, func(challenge *acme.Challenge) bool {
+		return challenge.Type == m.config.Challenge
+	})
+	if index < 0 {
+		return fmt.Errorf("CA offers no %s challenge for %s", m.config.Challenge, domain)
+	}
+	challenge := authorization.Challenges[index]
+
+	switch m.config.Challenge {
+	case acmeChallengeHTTP01:
+		response, err := m.client.HTTP01ChallengeResponse(challenge.Token)
+		if err != nil {
+			return err
+		}
+		path := m.client.HTTP01ChallengePath(challenge.Token)
+
+		m.mu.Lock()
+		m.http01[path] = response
+		m.mu.Unlock()
+		defer func() {
+			m.mu.Lock()
+			delete(m.http01, path)
+			m.mu.Unlock()
+		}()
+	case acmeChallengeDNS01:
+		value, err := m.client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		record := dnsRecordSet{
+			name:       "_acme-challenge." + domain,
+			recordType: "TXT",
+			ttl:        acmeDNSTTL,
+			value:      `"` + value + `"`,
+		}
+		client := dynamicDNSClient("")
+
+		if err := changeDNSRecord(ctx, client, "", *m.config.DNS, record, false); err != nil {
+			return fmt.Errorf("couldn't publish challenge record for %s: %w", domain, err)
+		}
+		defer func() {
+			// Cleaned up even when the order times out
+			cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dynamicDNSTimeout)
+			defer cancel()
+			if err := changeDNSRecord(cleanupCtx, client, "", *m.config.DNS, record, true); err != nil {
+				logger.Warn("Couldn't delete ACME challenge record", "record", record.name, "error", err.Error())
+			}
+		}()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(m.config.PropagationDelay):
+		}
+	}
+
+	if _, err := m.client.Accept(ctx, challenge); err != nil {
+		return fmt.Errorf("couldn't accept challenge for %s: %w", domain, err)
+	}
+	if _, err := m.client.WaitAuthorization(ctx, authorization.URI); err != nil {
+		return fmt.Errorf("authorization of %s failed: %w", domain, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,647 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const acmeTestDomain = "wan.example.test"
+
+// Minimal ACME CA (RFC 8555), which trusts the signatures of requests and
+// validates challenges with a function of the test
+type fakeACMEServer struct {
+	*httptest.Server
+
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+
+	mu             sync.Mutex
+	validate       func(challengeType string, domain string, token string) error
+	lastID         int
+	orders         map[string]*fakeACMEOrder
+	authorizations map[string]*fakeACMEAuthorization
+	certificates   map[string][]byte
+}
+
+type fakeACMEOrder struct {
+	authorizations []string
+	finalized      bool
+}
+
+type fakeACMEAuthorization struct {
+	domain string
+	token  string
+	status string
+}
+
+func newFakeACMEServer(t *testing.T) *fakeACMEServer {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Fake ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeACMEServer{
+		caKey:          caKey,
+		caCert:         caCert,
+		orders:         map[string]*fakeACMEOrder{},
+		authorizations: map[string]*fakeACMEAuthorization{},
+		certificates:   map[string][]byte{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", s.serveDirectory)
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) {
+		s.nonce(w)
+	})
+	mux.HandleFunc("POST /account", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", s.URL+"/account/1")
+		s.reply(w, http.StatusCreated, map[string]any{"status": "valid"})
+	})
+	mux.HandleFunc("POST /order", s.serveNewOrder)
+	mux.HandleFunc("POST /order/{id}", s.serveOrder)
+	mux.HandleFunc("POST /authz/{id}", s.serveAuthorization)
+	mux.HandleFunc("POST /challenge/{id}/{type}", s.serveChallenge)
+	mux.HandleFunc("POST /finalize/{id}", s.serveFinalize)
+	mux.HandleFunc("POST /cert/{id}", s.serveCertificate)
+
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Orders created, each of which is a certificate obtained or tried for
+func (s *fakeACMEServer) orderCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.orders)
+}
+
+func (s *fakeACMEServer) nextID() string {
+	s.lastID += 1
+	return fmt.Sprint(s.lastID)
+}
+
+func (s *fakeACMEServer) nonce(w http.ResponseWriter) {
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString([]byte(time.Now().String())))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (s *fakeACMEServer) reply(w http.ResponseWriter, status int, body any) {
+	s.nonce(w)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Payload of a JWS request, empty for POST-as-GET requests
+func (s *fakeACMEServer) payload(r *http.Request, payload any) error {
+	jws := struct {
+		Payload string `json:"payload"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return err
+	}
+	if jws.Payload == "" {
+		return nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(decoded, payload)
+}
+
+func (s *fakeACMEServer) serveDirectory(w http.ResponseWriter, r *http.Request) {
+	s.reply(w, http.StatusOK, map[string]any{
+		"newNonce":   s.URL + "/nonce",
+		"newAccount": s.URL + "/account",
+		"newOrder":   s.URL + "/order",
+		"revokeCert": s.URL + "/revoke",
+		"keyChange":  s.URL + "/key-change",
+	})
+}
+
+func (s *fakeACMEServer) serveNewOrder(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		Identifiers []struct {
+			Value string `json:"value"`
+		} `json:"identifiers"`
+	}{}
+	if err := s.payload(r, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order := &fakeACMEOrder{}
+	for _, identifier := range request.Identifiers {
+		id := s.nextID()
+		s.authorizations[id] = &fakeACMEAuthorization{
+			domain: identifier.Value,
+			token:  base64.RawURLEncoding.EncodeToString([]byte("token-" + id)),
+			status: "pending",
+		}
+		order.authorizations = append(order.authorizations, id)
+	}
+	id := s.nextID()
+	s.orders[id] = order
+
+	w.Header().Set("Location", s.URL+"/order/"+id)
+	s.reply(w, http.StatusCreated, s.orderJSON(id))
+}
+
+// Called with the lock held
+func (s *fakeACMEServer) orderJSON(id string) map[string]any {
+	order := s.orders[id]
+
+	status := "ready"
+	authorizations := []string{}
+	for _, authorizationID := range order.authorizations {
+		authorizations = append(authorizations, s.URL+"/authz/"+authorizationID)
+		switch s.authorizations[authorizationID].status {
+		case "invalid":
+			status = "invalid"
+		case "pending":
+			if status == "ready" {
+				status = "pending"
+			}
+		}
+	}
+
+	body := map[string]any{
+		"status":         status,
+		"authorizations": authorizations,
+		"finalize":       s.URL + "/finalize/" + id,
+	}
+	if order.finalized {
+		body["status"] = "valid"
+		body["certificate"] = s.URL + "/cert/" + id
+	}
+
+	return body
+}
+
+func (s *fakeACMEServer) serveOrder(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.orders[r.PathValue("id")]; !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.reply(w, http.StatusOK, s.orderJSON(r.PathValue("id")))
+}
+
+// Called with the lock held
+func (s *fakeACMEServer) challengeJSON(id string, challengeType string) map[string]any {
+	authorization := s.authorizations[id]
+
+	return map[string]any{
+		"type":   challengeType,
+		"url":    s.URL + "/challenge/" + id + "/" + challengeType,
+		"token":  authorization.token,
+		"status": authorization.status,
+	}
+}
+
+func (s *fakeACMEServer) serveAuthorization(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	authorization, exists := s.authorizations[id]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.reply(w, http.StatusOK, map[string]any{
+		"status":     authorization.status,
+		"identifier": map[string]string{"type": "dns", "value": authorization.domain},
+		"challenges": []any{
+			s.challengeJSON(id, acmeChallengeHTTP01),
+			s.challengeJSON(id, acmeChallengeDNS01),
+		},
+	})
+}
+
+// Accepting a challenge validates it straight away
+func (s *fakeACMEServer) serveChallenge(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	id := r.PathValue("id")
+	authorization, exists := s.authorizations[id]
+	validate := s.validate
+	s.mu.Unlock()
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	status := "valid"
+	if err := validate(r.PathValue("type"), authorization.domain, authorization.token); err != nil {
+		status = "invalid"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	authorization.status = status
+	s.reply(w, http.StatusOK, s.challengeJSON(id, r.PathValue("type")))
+}
+
+func (s *fakeACMEServer) serveFinalize(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		CSR string `json:"csr"`
+	}{}
+	if err := s.payload(r, &request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(request.CSR)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	leaf, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	order, exists := s.orders[id]
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+	order.finalized = true
+	s.certificates[id] = append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})...,
+	)
+
+	s.reply(w, http.StatusOK, s.orderJSON(id))
+}
+
+func (s *fakeACMEServer) serveCertificate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	chain, exists := s.certificates[r.PathValue("id")]
+	s.mu.Unlock()
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.nonce(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(chain)
+}
+
+// Name server accepting dynamic updates (RFC 2136) of TXT records
+type fakeUpdateServer struct {
+	addr string
+
+	mu      sync.Mutex
+	records map[string]string
+}
+
+func newFakeUpdateServer(t *testing.T) *fakeUpdateServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeUpdateServer{addr: listener.Addr().String(), records: map[string]string{}}
+	server := &dns.Server{
+		Listener: listener,
+		// The default refuses updates
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction {
+			return dns.MsgAccept
+		},
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, msg *dns.Msg) {
+			s.mu.Lock()
+			for _, rr := range msg.Ns {
+				if rr.Header().Class == dns.ClassANY {
+					delete(s.records, rr.Header().Name)
+				} else if txt, ok := rr.(*dns.TXT); ok {
+					s.records[txt.Hdr.Name] = strings.Join(txt.Txt, "")
+				}
+			}
+			s.mu.Unlock()
+
+			response := new(dns.Msg)
+			response.SetReply(msg)
+			w.WriteMsg(response)
+		}),
+	}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return s
+}
+
+func (s *fakeUpdateServer) record(name string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	value, exists := s.records[name]
+	return value, exists
+}
+
+func newTestACMEManager(t *testing.T, ca *fakeACMEServer, config ACMEConfig) *acmeManager {
+	t.Helper()
+
+	config.Domains = []string{acmeTestDomain}
+	config.DirectoryURL = ca.URL + "/directory"
+	config.CacheDir = t.TempDir()
+	if err := validateACME(&config, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := newACMEManager(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+// Obtain a certificate and check it's served and cached
+func checkObtainCertificate(t *testing.T, m *acmeManager) {
+	t.Helper()
+
+	if wait := m.renew(context.Background()); wait != acmeCheckInterval {
+		t.Fatalf("renew waits %s after obtaining a certificate, want %s", wait, acmeCheckInterval)
+	}
+
+	certificate, err := m.getCertificate(&tls.ClientHelloInfo{ServerName: acmeTestDomain})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !coversDomains(certificate.Leaf, []string{acmeTestDomain}) {
+		t.Errorf("certificate is for %v, want %s", certificate.Leaf.DNSNames, acmeTestDomain)
+	}
+
+	cached, err := tls.LoadX509KeyPair(
+		filepath.Join(m.config.CacheDir, acmeCertificateFile),
+		filepath.Join(m.config.CacheDir, acmeKeyFile),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cached.Leaf.Equal(certificate.Leaf) {
+		t.Error("cached certificate isn't the one served")
+	}
+}
+
+func TestACMEHTTP01(t *testing.T) {
+	ca := newFakeACMEServer(t)
+	m := newTestACMEManager(t, ca, ACMEConfig{Challenge: acmeChallengeHTTP01})
+
+	ca.validate = func(challengeType string, domain string, token string) error {
+		if challengeType != acmeChallengeHTTP01 {
+			return fmt.Errorf("unexpected challenge %s", challengeType)
+		}
+
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("GET", "http://"+domain+"/.well-known/acme-challenge/"+token, nil)
+		m.serveHTTP01(recorder, request)
+
+		expected, err := m.client.HTTP01ChallengeResponse(token)
+		if err != nil {
+			return err
+		}
+		if recorder.Code != http.StatusOK || recorder.Body.String() != expected {
+			return fmt.Errorf("challenge response %d %q, want %q", recorder.Code, recorder.Body.String(), expected)
+		}
+
+		return nil
+	}
+
+	checkObtainCertificate(t, m)
+
+	if len(m.http01) != 0 {
+		t.Errorf("challenge responses left after the order: %v", m.http01)
+	}
+}
+
+func TestACMEDNS01(t *testing.T) {
+	ca := newFakeACMEServer(t)
+	nameServer := newFakeUpdateServer(t)
+	m := newTestACMEManager(t, ca, ACMEConfig{
+		Challenge: acmeChallengeDNS01,
+		DNS: &DNSProviderConfig{
+			Provider: dynamicDNSRFC2136,
+			Zone:     "example.test",
+			Server:   nameServer.addr,
+		},
+		PropagationDelay: time.Millisecond,
+	})
+	record := "_acme-challenge." + acmeTestDomain + "."
+
+	ca.validate = func(challengeType string, domain string, token string) error {
+		if challengeType != acmeChallengeDNS01 {
+			return fmt.Errorf("unexpected challenge %s", challengeType)
+		}
+
+		expected, err := m.client.DNS01ChallengeRecord(token)
+		if err != nil {
+			return err
+		}
+		if value, _ := nameServer.record(record); value != expected {
+			return fmt.Errorf("challenge record %q, want %q", value, expected)
+		}
+
+		return nil
+	}
+
+	checkObtainCertificate(t, m)
+
+	if _, exists := nameServer.record(record); exists {
+		t.Error("challenge record wasn't deleted after the order")
+	}
+}
+
+// A failed order is retried sooner than the certificate is checked for renewal
+func TestACMERetryAfterFailure(t *testing.T) {
+	ca := newFakeACMEServer(t)
+	m := newTestACMEManager(t, ca, ACMEConfig{Challenge: acmeChallengeHTTP01})
+	ca.validate = func(challengeType string, domain string, token string) error {
+		return errors.New("connection refused")
+	}
+
+	if wait := m.renew(context.Background()); wait != acmeRetryInterval {
+		t.Errorf("renew waits %s after failing, want %s", wait, acmeRetryInterval)
+	}
+	if _, err := m.getCertificate(&tls.ClientHelloInfo{}); err == nil {
+		t.Error("certificate served after failing to obtain one")
+	}
+}
+
+func TestACMERenewal(t *testing.T) {
+	ca := newFakeACMEServer(t)
+	m := newTestACMEManager(t, ca, ACMEConfig{Challenge: acmeChallengeHTTP01})
+	ca.validate = func(challengeType string, domain string, token string) error {
+		return nil
+	}
+
+	checkObtainCertificate(t, m)
+	if orders := ca.orderCount(); orders != 1 {
+		t.Fatalf("%d orders for the first certificate, want 1", orders)
+	}
+
+	// Restarting loads the cached certificate, which isn't due for renewal
+	restarted, err := newACMEManager(m.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := restarted.renew(context.Background()); wait != acmeCheckInterval {
+		t.Errorf("renew waits %s with a fresh certificate, want %s", wait, acmeCheckInterval)
+	}
+	if orders := ca.orderCount(); orders != 1 {
+		t.Errorf("%d orders with a fresh certificate, want 1", orders)
+	}
+
+	// Less than a third of its lifetime left
+	leaf := *restarted.certificate.Leaf
+	leaf.NotBefore = time.Now().Add(-80 * 24 * time.Hour)
+	leaf.NotAfter = time.Now().Add(10 * 24 * time.Hour)
+	restarted.certificate = &tls.Certificate{Leaf: &leaf}
+
+	checkObtainCertificate(t, restarted)
+	if orders := ca.orderCount(); orders != 2 {
+		t.Errorf("%d orders after renewal, want 2", orders)
+	}
+}
+
+func TestRenewalTime(t *testing.T) {
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	leaf := &x509.Certificate{NotBefore: notBefore, NotAfter: notBefore.Add(90 * 24 * time.Hour)}
+
+	if renewal := renewalTime(leaf); !renewal.Equal(notBefore.Add(60 * 24 * time.Hour)) {
+		t.Errorf("renewal time %s, want 60 days after %s", renewal, notBefore)
+	}
+}
+
+// The challenge listener is open when start returns, so privileges can be
+// dropped afterwards
+func TestACMEStartListensForChallenges(t *testing.T) {
+	ca := newFakeACMEServer(t)
+	ca.validate = func(challengeType string, domain string, token string) error {
+		return errors.New("not validating")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	m := newTestACMEManager(t, ca, ACMEConfig{Challenge: acmeChallengeHTTP01, HTTPAddress: address})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if err := m.start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get("http://" + address + "/.well-known/acme-challenge/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown challenge status %d, want %d", response.StatusCode, http.StatusNotFound)
+	}
+}
+
+// A certificate which can't be cached is served anyway, rather than
+// ordered again an hour later
+func TestACMECacheFailure(t *testing.T) {
+	ca := newFakeACMEServer(t)
+	m := newTestACMEManager(t, ca, ACMEConfig{Challenge: acmeChallengeHTTP01})
+	ca.validate = func(challengeType string, domain string, token string) error {
+		return nil
+	}
+
+	// A directory in the way of the key, which can't be replaced by a file
+	if err := os.Mkdir(filepath.Join(m.config.CacheDir, acmeKeyFile), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if wait := m.renew(context.Background()); wait != acmeCheckInterval {
+		t.Errorf("renew waits %s after failing to cache, want %s", wait, acmeCheckInterval)
+	}
+	if _, err := m.getCertificate(&tls.ClientHelloInfo{ServerName: acmeTestDomain}); err != nil {
+		t.Errorf("certificate which couldn't be cached isn't served: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// Longest time an external command run as an action may take
+	actionCommandTimeout = 30 * time.Second
+)
+
+// Kinds of actions performed on interface state transitions
+const (
+	actionHook = "hook"
+	// Signals to co-located devices
+	actionWakeOnLAN = "wake_on_lan"
+	actionUDP       = "udp"
+	actionTCP       = "tcp"
+	// Reconfiguration of the interface by its network manager
+	actionNetworkdReconfigure = "networkd_reconfigure"
+	actionNMActivate          = "nm_activate"
+	actionNMDeactivate        = "nm_deactivate"
+)
+
+var (
+	// Returned when an action wasn't performed because actions are dry run
+	// or this is the standby instance, so callers don't record it as applied
+	errActionSkipped = errors.New("action skipped")
+)
+
+// Perform an action which changes the system in response to a state
+// transition, when actions are dry run the action is only logged. Only
+// the active instance of an HA pair performs actions, so the pair doesn't
+// change the system twice.
+func performAction(
+	ctx context.Context,
+	kind string,
+	iface string,
+	description string,
+	perform func(ctx context.Context) error,
+) error {
+	if !haActive.Load() {
+		logger.Info(
+			"Standby instance, not performing action",
+			"kind",
+			kind,
+			"interface",
+			iface,
+			"action",
+			description,
+		)
+		return errActionSkipped
+	}
+
+	return performInstanceAction(ctx, kind, iface, description, perform)
+}
+
+// Perform an action which each instance of an HA pair performs for itself,
+// whether it is active or standby, like publishing the health which the
+// VRRP election between them is decided on
+func performInstanceAction(
+	ctx context.Context,
+	kind string,
+	iface string,
+	description string,
+	perform func(ctx context.Context) error,
+) error {
+	if *dryRunActions {
+		logger.Info(
+			"Dry run, not performing action",
+			"kind",
+			kind,
+			"interface",
+			iface,
+			"action",
+			description,
+		)
+		return errActionSkipped
+	}
+
+	logger.Info(
+		"Performing action",
+		"kind",
+		kind,
+		"interface",
+		iface,
+		"action",
+		description,
+	)
+
+	if err := perform(ctx); err != nil {
+		logger.Warn(
+			"Action failed",
+			"kind",
+			kind,
+			"interface",
+			iface,
+			"action",
+			description,
+			"error",
+			err.Error(),
+		)
+		return err
+	}
+
+	return nil
+}
+
+// Run an external command as an action, env is added to the environment
+// of the command
+func runCommandAction(ctx context.Context, kind string, iface string, command []string, env []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("no command given")
+	}
+
+	description := strings.Join(command, " ")
+	if len(env) > 0 {
+		description = strings.Join(env, " ") + " " + description
+	}
+
+	return performAction(ctx, kind, iface, description, func(ctx context.Context) error {
+		timeout, cancel := context.WithTimeout(ctx, actionCommandTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(timeout, command[0], command[1:]...)
+		cmd.Env = append(cmd.Environ(), env...)
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(output)))
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPerformActionStandby(t *testing.T) {
+	dryRun := *dryRunActions
+	active := haActive.Load()
+	t.Cleanup(func() {
+		*dryRunActions = dryRun
+		haActive.Store(active)
+	})
+	*dryRunActions = false
+
+	tests := []struct {
+		name    string
+		active  bool
+		perform bool
+	}{
+		{"active", true, true},
+		{"standby", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			haActive.Store(test.active)
+
+			performed := false
+			err := performAction(context.Background(), actionHook, loopback, "test", func(ctx context.Context) error {
+				performed = true
+				return nil
+			})
+			// Callers mustn't record skipped actions as applied
+			if (test.perform && err != nil) || (!test.perform && !errors.Is(err, errActionSkipped)) {
+				t.Errorf("performAction() error = %v", err)
+			}
+			if performed != test.perform {
+				t.Errorf("action performed = %t, want %t", performed, test.perform)
+			}
+		})
+	}
+}
+
+// Remediation of a standby instance doesn't touch the interface
+func TestRemediateStandby(t *testing.T) {
+	dryRun := *dryRunActions
+	active := haActive.Load()
+	t.Cleanup(func() {
+		*dryRunActions = dryRun
+		haActive.Store(active)
+	})
+	*dryRunActions = false
+	haActive.Store(false)
+
+	marker := t.TempDir() + "/remediated"
+	config := RemediationConfig{Hook: []string{"touch", marker}}
+	if err := remediate(context.Background(), Interface{Name: loopback}, config, actionHook); !errors.Is(err, errActionSkipped) {
+		t.Errorf("remediate() error = %v, want errActionSkipped", err)
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Error("standby instance ran the remediation hook")
+	}
+}
+
+// Standby instances keep publishing their health to keepalived, which
+// decides from it which instance becomes active
+func TestVRRPTrackFileStandby(t *testing.T) {
+	dryRun := *dryRunActions
+	active := haActive.Load()
+	t.Cleanup(func() {
+		*dryRunActions = dryRun
+		haActive.Store(active)
+	})
+	*dryRunActions = false
+	haActive.Store(false)
+
+	trackFile := t.TempDir() + "/track"
+	tracker := newVRRPTracker(VRRPConfig{TrackFile: trackFile})
+	tracker.update(context.Background(), loopback, true)
+
+	value, err := os.ReadFile(trackFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "1\n" {
+		t.Errorf("track file = %q, want 1", value)
+	}
+}
+
+// Dry run writes aren't recorded, so they are made once actions are
+// performed
+func TestVRRPTrackFileDryRun(t *testing.T) {
+	trackFile := t.TempDir() + "/track"
+	tracker := newVRRPTracker(VRRPConfig{TrackFile: trackFile})
+	tracker.update(context.Background(), loopback, true)
+
+	if tracker.written != -1 {
+		t.Errorf("written = %d after a dry run, want -1", tracker.written)
+	}
+	if _, err := os.Stat(trackFile); err == nil {
+		t.Error("dry run wrote the track file")
+	}
+}
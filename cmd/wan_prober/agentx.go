@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+)
+
+// AgentX protocol constants (RFC 2741)
+const (
+	agentxVersion = 1
+
+	agentxPDUOpen     = 1
+	agentxPDUClose    = 2
+	agentxPDURegister = 3
+	agentxPDUGet      = 5
+	agentxPDUGetNext  = 6
+	agentxPDUGetBulk  = 7
+	agentxPDUResponse = 18
+
+	agentxFlagNonDefaultContext = 0x08
+	agentxFlagNetworkByteOrder  = 0x10
+
+	agentxTypeInteger      = 2
+	agentxTypeOctetString  = 4
+	agentxTypeGauge32      = 66
+	agentxTypeNoSuchObject = 128
+	agentxTypeEndOfMibView = 130
+
+	agentxErrorProcessing = 268
+
+	// Seconds the master agent waits for responses from us
+	agentxTimeout = 5
+	// How long to wait before reconnecting to the master agent
+	agentxReconnectInterval = 10 * time.Second
+)
+
+type oid []uint32
+
+func parseOID(s string) (oid, error) {
+	parts := strings.Split(strings.Trim(s, "."), ".")
+	o := oid{}
+	for _, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID: %s", s)
+		}
+		o = append(o, uint32(n))
+	}
+	return o, nil
+}
+
+type agentxVarBind struct {
+	name      oid
+	valueType uint16
+	integer   uint32
+	str       string
+}
+
+type agentxHeader struct {
+	pduType       byte
+	flags         byte
+	sessionID     uint32
+	transactionID uint32
+	packetID      uint32
+	length        uint32
+}
+
+func (h agentxHeader) order() binary.ByteOrder {
+	if h.flags&agentxFlagNetworkByteOrder != 0 {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// AgentX subagent which exposes interface status to an SNMP master agent
+type agentxSubagent struct {
+	config  AgentXConfig
+	base    oid
+	ifaces  []Interface
+	started time.Time
+}
+
+func writeOID(buf *bytes.Buffer, o oid, include bool) {
+	includeByte := byte(0)
+	if include {
+		includeByte = 1
+	}
+	buf.Write([]byte{byte(len(o)), 0, includeByte, 0})
+	for _, subid := range o {
+		binary.Write(buf, binary.BigEndian, subid)
+	}
+}
+
+func writeOctetString(buf *bytes.Buffer, s string) {
+	binary.Write(buf, binary.BigEndian, uint32(len(s)))
+	buf.WriteString(s)
+	for i := len(s); i%4 != 0; i++ {
+		buf.WriteByte(0)
+	}
+}
+
+func readOID(r io.Reader, order binary.ByteOrder) (oid, bool, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, false, err
+	}
+
+	o := oid{}
+	if head[1] != 0 {
+		o = oid{1, 3, 6, 1, uint32(head[1])}
+	}
+	for i := 0; i < int(head[0]); i++ {
+		var subid uint32
+		if err := binary.Read(r, order, &subid); err != nil {
+			return nil, false, err
+		}
+		o = append(o, subid)
+	}
+
+	return o, head[2] != 0, nil
+}
+
+func (a *agentxSubagent) writePDU(conn net.Conn, header agentxHeader, payload []byte) error {
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{agentxVersion, header.pduType, header.flags | agentxFlagNetworkByteOrder, 0})
+	binary.Write(buf, binary.BigEndian, header.sessionID)
+	binary.Write(buf, binary.BigEndian, header.transactionID)
+	binary.Write(buf, binary.BigEndian, header.packetID)
+	binary.Write(buf, binary.BigEndian, uint32(len(payload)))
+	buf.Write(payload)
+
+	_, err := conn.Write(buf.Bytes())
+	return err
+}
+
+func (a *agentxSubagent) readPDU(conn net.Conn) (agentxHeader, []byte, error) {
+	raw := make([]byte, 20)
+	if _, err := io.ReadFull(conn, raw); err != nil {
+		return agentxHeader{}, nil, err
+	}
+
+	header := agentxHeader{
+		pduType: raw[1],
+		flags:   raw[2],
+	}
+	order := header.order()
+	header.sessionID = order.Uint32(raw[4:8])
+	header.transactionID = order.Uint32(raw[8:12])
+	header.packetID = order.Uint32(raw[12:16])
+	header.length = order.Uint32(raw[16:20])
+
+	payload := make([]byte, header.length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		return agentxHeader{}, nil, err
+	}
+
+	return header, payload, nil
+}
+
+// All variables currently exposed, in lexicographic OID order
+func (a *agentxSubagent) variables() []agentxVarBind {
+	statuses := map[string]api.InterfaceStatusResponse{}
+	for _, status := range interfaceStatuses() {
+		statuses[status.Name] = status
+	}
+
+	// Interface table entry, indexed by position in the configuration
+	entry := append(slices.Clone(a.base), 1, 1)
+
+	vars := []agentxVarBind{}
+	for column := uint32(1); column <= 6; column++ {
+		for i, iface := range a.ifaces {
+			index := uint32(i + 1)
+			name := append(slices.Clone(entry), column, index)
+			status := statuses[iface.Name]
+
+			v := agentxVarBind{name: name}
+			switch column {
+			case 1:
+				v.valueType = agentxTypeInteger
+				v.integer = index
+			case 2:
+				v.valueType = agentxTypeOctetString
+				v.str = iface.Name
+			case 3:
+				v.valueType = agentxTypeOctetString
+				v.str = iface.Description
+			case 4:
+				// TruthValue: true(1), false(2)
+				v.valueType = agentxTypeInteger
+				v.integer = 2
+				if status.Healthy {
+					v.integer = 1
+				}
+			case 5:
+				v.valueType = agentxTypeGauge32
+				v.integer = uint32(status.LastProbe)
+			case 6:
+				v.valueType = agentxTypeGauge32
+				v.integer = uint32(status.LastChange)
+			}
+			vars = append(vars, v)
+		}
+	}
+
+	return vars
+}
+
+// Find the variable with exactly the given name
+func lookupVar(vars []agentxVarBind, name oid) agentxVarBind {
+	for _, v := range vars {
+		if slices.Compare(v.name, name) == 0 {
+			return v
+		}
+	}
+	return agentxVarBind{name: name, valueType: agentxTypeNoSuchObject}
+}
+
+// Find the first variable after (or at, when include is set) the given name
+// and before the end of the search range
+func nextVar(vars []agentxVarBind, start oid, include bool, end oid) agentxVarBind {
+	for _, v := range vars {
+		c := slices.Compare(v.name, start)
+		if c > 0 || (include && c == 0) {
+			if len(end) > 0 && slices.Compare(v.name, end) >= 0 {
+				break
+			}
+			return v
+		}
+	}
+	return agentxVarBind{name: start, valueType: agentxTypeEndOfMibView}
+}
+
+func (a *agentxSubagent) response(varbinds []agentxVarBind, errorStatus uint16) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, uint32(time.Since(a.started)/(10*time.Millisecond)))
+	binary.Write(buf, binary.BigEndian, errorStatus)
+	binary.Write(buf, binary.BigEndian, uint16(0))
+
+	for _, v := range varbinds {
+		binary.Write(buf, binary.BigEndian, v.valueType)
+		binary.Write(buf, binary.BigEndian, uint16(0))
+		writeOID(buf, v.name, false)
+		switch v.valueType {
+		case agentxTypeInteger, agentxTypeGauge32:
+			binary.Write(buf, binary.BigEndian, v.integer)
+		case agentxTypeOctetString:
+			writeOctetString(buf, v.str)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// Answer a Get, GetNext or GetBulk request
+func (a *agentxSubagent) handleRequest(header agentxHeader, payload []byte) ([]agentxVarBind, error) {
+	order := header.order()
+	r := bytes.NewReader(payload)
+
+	if header.flags&agentxFlagNonDefaultContext != 0 {
+		var length uint32
+		if err := binary.Read(r, order, &length); err != nil {
+			return nil, err
+		}
+		r.Seek(int64((length+3)/4*4), io.SeekCurrent)
+	}
+
+	var nonRepeaters, maxRepetitions uint16
+	if header.pduType == agentxPDUGetBulk {
+		binary.Read(r, order, &nonRepeaters)
+		binary.Read(r, order, &maxRepetitions)
+	}
+
+	vars := a.variables()
+	results := []agentxVarBind{}
+
+	for i := 0; r.Len() > 0; i++ {
+		start, include, err := readOID(r, order)
+		if err != nil {
+			return nil, err
+		}
+		end, _, err := readOID(r, order)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case header.pduType == agentxPDUGet:
+			results = append(results, lookupVar(vars, start))
+		case header.pduType == agentxPDUGetNext || i < int(nonRepeaters):
+			results = append(results, nextVar(vars, start, include, end))
+		default:
+			for n := 0; n < int(maxRepetitions); n++ {
+				v := nextVar(vars, start, include, end)
+				results = append(results, v)
+				if v.valueType == agentxTypeEndOfMibView {
+					break
+				}
+				start, include = v.name, false
+			}
+		}
+	}
+
+	return results, nil
+}
+
+func (a *agentxSubagent) session(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: agentxTimeout * time.Second}
+	network := "unix"
+	if strings.Contains(a.config.Address, ":") && !strings.HasPrefix(a.config.Address, "/") {
+		network = "tcp"
+	}
+
+	conn, err := dialer.DialContext(ctx, network, a.config.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	open := &bytes.Buffer{}
+	open.Write([]byte{agentxTimeout, 0, 0, 0})
+	writeOID(open, a.base, false)
+	writeOctetString(open, binName)
+	if err := a.writePDU(conn, agentxHeader{pduType: agentxPDUOpen, packetID: 1}, open.Bytes()); err != nil {
+		return err
+	}
+
+	header, payload, err := a.readPDU(conn)
+	if err != nil {
+		return err
+	}
+	if header.pduType != agentxPDUResponse || len(payload) < 8 || header.order().Uint16(payload[4:6]) != 0 {
+		return fmt.Errorf("master agent refused session")
+	}
+	sessionID := header.sessionID
+
+	register := &bytes.Buffer{}
+	register.Write([]byte{agentxTimeout, 127, 0, 0})
+	writeOID(register, a.base, false)
+	if err := a.writePDU(conn, agentxHeader{
+		pduType:   agentxPDURegister,
+		sessionID: sessionID,
+		packetID:  2,
+	}, register.Bytes()); err != nil {
+		return err
+	}
+
+	logger.Info("Connected to SNMP master agent", "address", a.config.Address, "oid", a.config.BaseOID)
+
+	for {
+		header, payload, err := a.readPDU(conn)
+		if err != nil {
+			return err
+		}
+
+		switch header.pduType {
+		case agentxPDUGet, agentxPDUGetNext, agentxPDUGetBulk:
+			errorStatus := uint16(0)
+			varbinds, err := a.handleRequest(header, payload)
+			if err != nil {
+				errorStatus = agentxErrorProcessing
+				varbinds = nil
+			}
+
+			header.pduType = agentxPDUResponse
+			header.flags = 0
+			if err := a.writePDU(conn, header, a.response(varbinds, errorStatus)); err != nil {
+				return err
+			}
+		case agentxPDUResponse:
+			if len(payload) >= 8 && header.order().Uint16(payload[4:6]) != 0 {
+				return fmt.Errorf("master agent refused registration")
+			}
+		case agentxPDUClose:
+			return fmt.Errorf("master agent closed session")
+		default:
+			// Sets and other requests aren't supported, refuse them
+			header.pduType = agentxPDUResponse
+			header.flags = 0
+			if err := a.writePDU(conn, header, a.response(nil, agentxErrorProcessing)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Expose interface status through an SNMP master agent, reconnecting
+// whenever the connection to the master agent is lost
+func runAgentX(ctx context.Context, config AgentXConfig, ifaces []Interface) error {
+	base, err := parseOID(config.BaseOID)
+	if err != nil {
+		return err
+	}
+
+	a := &agentxSubagent{
+		config:  config,
+		base:    base,
+		ifaces:  ifaces,
+		started: time.Now(),
+	}
+
+	go func() {
+		for {
+			if err := a.session(ctx); err != nil && ctx.Err() == nil {
+				logger.Warn(
+					"SNMP master agent connection failed",
+					"address",
+					config.Address,
+					"error",
+					err.Error(),
+				)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(agentxReconnectInterval):
+			}
+		}
+	}()
+
+	return nil
+}
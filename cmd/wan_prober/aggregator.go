@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+)
+
+const (
+	// Path of the SPIFFE IDs of probers, followed by their site
+	spiffeSitePrefix = "/site/"
+)
+
+var (
+	// Status pushed by each site, by siteKey
+	siteStatusMap = sync.Map{}
+)
+
+// Site of a tenant, tenant is empty without tenants
+type siteKey struct {
+	tenant string
+	site   string
+}
+
+// Accept status pushed by remote probers and serve the combined status of all sites
+func runAggregator(ctx context.Context) {
+	var tenants []AggregatorTenant
+	if *aggregatorTenantsFile != "" {
+		var err error
+		tenants, err = readAggregatorTenants(*aggregatorTenantsFile)
+		if err != nil {
+			slog.Error(
+				"Invalid aggregator tenants",
+				"tenants_file",
+				*aggregatorTenantsFile,
+				"error",
+				err.Error(),
+			)
+			os.Exit(1)
+		}
+
+		logger.Info("Serving aggregator tenants", "tenants", len(tenants))
+	}
+
+	tlsConfig, err := aggregatorTLSConfig(
+		*aggregatorTLSCertFile,
+		*aggregatorTLSKeyFile,
+		*aggregatorClientCAFile,
+		*aggregatorSPIFFETrustDomain,
+	)
+	if err != nil {
+		slog.Error("Invalid aggregator TLS configuration", "error", err.Error())
+		os.Exit(1)
+	}
+
+	logger.Info(
+		"Running in aggregator mode",
+		"listen_address",
+		*httpListenAddress,
+		"tls",
+		tlsConfig != nil,
+		"client_certificates",
+		*aggregatorClientCAFile != "",
+	)
+
+	listener, err := listenWithRetry(ctx, *httpListenAddress)
+	if err != nil {
+		logger.Error("Error starting HTTP server", "error", err.Error())
+		os.Exit(1)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	serveHTTP(ctx, &http.Server{Handler: aggregatorHandler(tenants)}, listener)
+
+	<-ctx.Done()
+	httpServers.Wait()
+}
+
+// Endpoints of the aggregator, served by a mux of their own so nothing else
+// registered with the default mux, such as expvar, is exposed. Without
+// tenants every site is served at the root, with tenants each tenant only
+// sees its own sites, and there are no endpoints serving the sites of
+// every tenant.
+func aggregatorHandler(tenants []AggregatorTenant) http.Handler {
+	mux := http.NewServeMux()
+
+	if len(tenants) == 0 {
+		mux.HandleFunc("/push", sitePushHandler(""))
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			resp := siteStatuses("")
+
+			writeJSON(w, r, http.StatusOK, resp)
+		})
+		mux.Handle("/metrics", siteMetricsHandler(""))
+
+		return mux
+	}
+
+	for _, tenant := range tenants {
+		prefix := "/tenants/" + tenant.Name + "/"
+		mux.HandleFunc(prefix+"push", tenantAuthHandler(tenant, sitePushHandler(tenant.Name)))
+		mux.HandleFunc(prefix+"{$}", tenantAuthHandler(tenant, func(w http.ResponseWriter, r *http.Request) {
+			resp := siteStatuses(tenant.Name)
+
+			writeJSON(w, r, http.StatusOK, resp)
+		}))
+		mux.HandleFunc(prefix+"metrics", tenantAuthHandler(tenant, siteMetricsHandler(tenant.Name).ServeHTTP))
+	}
+
+	return mux
+}
+
+// Accept status pushed by the sites of a tenant
+func sitePushHandler(tenant string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		push := api.SiteStatusPush{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&push); err != nil {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+
+		if push.Site == "" {
+			http.Error(w, "Missing site", http.StatusBadRequest)
+			return
+		}
+
+		identity := ""
+		if *aggregatorClientCAFile != "" {
+			var site string
+			var err error
+			identity, site, err = pushIdentity(r, *aggregatorSPIFFETrustDomain)
+			if err != nil {
+				http.Error(w, "Unauthorized, "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if site != push.Site {
+				logger.Warn(
+					"Refused status push for another site",
+					"identity",
+					identity,
+					"site",
+					push.Site,
+					"remote",
+					r.RemoteAddr,
+				)
+				http.Error(w, fmt.Sprintf("Certificate isn't valid for site %s", push.Site), http.StatusForbidden)
+				return
+			}
+		}
+
+		siteStatusMap.Store(siteKey{tenant: tenant, site: push.Site}, api.SiteStatusResponse{
+			Site:       push.Site,
+			LastPush:   time.Now().Unix(),
+			Identity:   identity,
+			Interfaces: push.Interfaces,
+		})
+
+		logger.Debug(
+			"Received status push",
+			"tenant",
+			tenant,
+			"site",
+			push.Site,
+			"identity",
+			identity,
+			"remote",
+			r.RemoteAddr,
+		)
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TLS configuration of the aggregator, nil when it serves plain HTTP. With
+// a client CA, probers present certificates signed by it, while clients
+// only reading status needn't.
+func aggregatorTLSConfig(certFile string, keyFile string, clientCAFile string, spiffeTrustDomain string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("TLS needs both a certificate and a key")
+	}
+	if certFile == "" {
+		if clientCAFile != "" {
+			return nil, errors.New("client certificates need a TLS certificate and key")
+		}
+		return nil, nil
+	}
+	if spiffeTrustDomain != "" && clientCAFile == "" {
+		return nil, errors.New("SPIFFE identities need a client CA")
+	}
+
+	tlsConfig, err := listenerTLSConfig(ListenerTLS{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: clientCAFile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ClientCAs != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	return tlsConfig, nil
+}
+
+// Identity of the prober a push came from and the site it may push status
+// for, from its verified client certificate. With a trust domain it is the
+// SPIFFE ID spiffe://<trust domain>/site/<site>, any other SPIFFE ID is
+// refused. Without one, the common name is both.
+func pushIdentity(r *http.Request, spiffeTrustDomain string) (string, string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return "", "", errors.New("client certificate required")
+	}
+	certificate := r.TLS.VerifiedChains[0][0]
+
+	if spiffeTrustDomain == "" {
+		if certificate.Subject.CommonName == "" {
+			return "", "", errors.New("client certificate has no common name")
+		}
+		return certificate.Subject.CommonName, certificate.Subject.CommonName, nil
+	}
+
+	for _, uri := range certificate.URIs {
+		if uri.Scheme != "spiffe" || uri.Host != spiffeTrustDomain {
+			continue
+		}
+		site, found := strings.CutPrefix(uri.Path, spiffeSitePrefix)
+		if !found || site == "" || strings.Contains(site, "/") || uri.RawQuery != "" || uri.Fragment != "" {
+			return "", "", fmt.Errorf("SPIFFE ID %s isn't of the form spiffe://%s%s<site>", uri, spiffeTrustDomain, spiffeSitePrefix)
+		}
+		return uri.String(), site, nil
+	}
+
+	return "", "", fmt.Errorf("client certificate has no SPIFFE ID in trust domain %s", spiffeTrustDomain)
+}
+
+// Current status of all sites of a tenant which have pushed status, sorted
+// by site name
+func siteStatuses(tenant string) []api.SiteStatusResponse {
+	statuses := []api.SiteStatusResponse{}
+	now := time.Now()
+
+	siteStatusMap.Range(func(key, val interface{}) bool {
+		if key.(siteKey).tenant != tenant {
+			return true
+		}
+
+		switch v := val.(type) {
+		case api.SiteStatusResponse:
+			v.Stale = now.Sub(time.Unix(v.LastPush, 0)) > *aggregatorStale
+			statuses = append(statuses, v)
+		}
+
+		return true
+	})
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Site < statuses[j].Site
+	})
+
+	return statuses
+}
+
+// Metrics of the sites of a tenant, from a registry of their own so
+// tenants can't scrape each other's sites
+func siteMetricsHandler(tenant string) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(newSitesCollector(tenant))
+
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Collects the status pushed by the sites of a tenant
+type sitesCollector struct {
+	tenant   string
+	lastPush *prometheus.Desc
+	stale    *prometheus.Desc
+	healthy  *prometheus.Desc
+}
+
+func newSitesCollector(tenant string) *sitesCollector {
+	return &sitesCollector{
+		tenant: tenant,
+		lastPush: prometheus.NewDesc(
+			"wan_prober_site_last_push_timestamp_seconds",
+			"When a site last pushed its status.",
+			[]string{"tenant", "site"},
+			nil,
+		),
+		stale: prometheus.NewDesc(
+			"wan_prober_site_stale",
+			"Whether a site hasn't pushed its status recently.",
+			[]string{"tenant", "site"},
+			nil,
+		),
+		healthy: prometheus.NewDesc(
+			"wan_prober_site_interface_healthy",
+			"Whether an interface of a site was healthy when the site last pushed its status.",
+			[]string{"tenant", "site", "interface", "name"},
+			nil,
+		),
+	}
+}
+
+func (c *sitesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.lastPush
+	ch <- c.stale
+	ch <- c.healthy
+}
+
+func (c *sitesCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, site := range siteStatuses(c.tenant) {
+		ch <- prometheus.MustNewConstMetric(c.lastPush, prometheus.GaugeValue, float64(site.LastPush), c.tenant, site.Site)
+		ch <- prometheus.MustNewConstMetric(c.stale, prometheus.GaugeValue, boolGauge(site.Stale), c.tenant, site.Site)
+		// The display name of one interface can be the kernel name of
+		// another, so series are told apart by the kernel name, which
+		// only repeats in a malformed push
+		names := map[string]bool{}
+		for _, iface := range site.Interfaces {
+			if names[iface.Name] {
+				continue
+			}
+			names[iface.Name] = true
+
+			displayName := iface.DisplayName
+			if displayName == "" {
+				displayName = iface.Name
+			}
+			ch <- prometheus.MustNewConstMetric(
+				c.healthy,
+				prometheus.GaugeValue,
+				boolGauge(iface.Healthy),
+				c.tenant,
+				site.Site,
+				displayName,
+				iface.Name,
+			)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "expvar"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+)
+
+func TestAggregatorTLSConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		certFile          string
+		keyFile           string
+		clientCAFile      string
+		spiffeTrustDomain string
+		valid             bool
+	}{
+		{"plain HTTP", "", "", "", "", true},
+		{"certificate without key", "aggregator.crt", "", "", "", false},
+		{"key without certificate", "", "aggregator.key", "", "", false},
+		{"client CA without certificate", "", "", "devices-ca.crt", "", false},
+		{"SPIFFE without client CA", "aggregator.crt", "aggregator.key", "", "example.org", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tlsConfig, err := aggregatorTLSConfig(test.certFile, test.keyFile, test.clientCAFile, test.spiffeTrustDomain)
+			if (err == nil) != test.valid {
+				t.Errorf("aggregatorTLSConfig() = %v, want valid %t", err, test.valid)
+			}
+			if tlsConfig != nil {
+				t.Errorf("aggregatorTLSConfig() = %+v, want no TLS", tlsConfig)
+			}
+		})
+	}
+}
+
+func TestPushIdentity(t *testing.T) {
+	tests := []struct {
+		name              string
+		commonName        string
+		uri               string
+		spiffeTrustDomain string
+		identity          string
+		site              string
+		valid             bool
+	}{
+		{"common name", "hq", "", "", "hq", "hq", true},
+		{"no common name", "", "", "", "", "", false},
+		{"SPIFFE ID", "", "spiffe://example.org/site/hq", "example.org", "spiffe://example.org/site/hq", "hq", true},
+		{"SPIFFE ID of a workload", "", "spiffe://example.org/ns/prod/sa/hq", "example.org", "", "", false},
+		{"SPIFFE ID with nested site", "", "spiffe://example.org/site/eu/hq", "example.org", "", "", false},
+		{"SPIFFE ID without site", "", "spiffe://example.org/site/", "example.org", "", "", false},
+		{"SPIFFE ID of another path ending in the site", "", "spiffe://example.org/hq", "example.org", "", "", false},
+		{"SPIFFE ID in another trust domain", "hq", "spiffe://example.com/site/hq", "example.org", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			certificate := &x509.Certificate{Subject: pkix.Name{CommonName: test.commonName}}
+			if test.uri != "" {
+				uri, err := url.Parse(test.uri)
+				if err != nil {
+					t.Fatal(err)
+				}
+				certificate.URIs = []*url.URL{uri}
+			}
+			request := httptest.NewRequest("POST", "/push", nil)
+			request.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{certificate},
+				VerifiedChains:   [][]*x509.Certificate{{certificate}},
+			}
+
+			identity, site, err := pushIdentity(request, test.spiffeTrustDomain)
+			if (err == nil) != test.valid {
+				t.Fatalf("pushIdentity() = %v, want valid %t", err, test.valid)
+			}
+			if identity != test.identity || site != test.site {
+				t.Errorf("pushIdentity() = (%q, %q), want (%q, %q)", identity, site, test.identity, test.site)
+			}
+		})
+	}
+}
+
+// Certificates the aggregator didn't verify don't identify a prober
+func TestPushIdentityUnverified(t *testing.T) {
+	certificate := &x509.Certificate{Subject: pkix.Name{CommonName: "hq"}}
+	request := httptest.NewRequest("POST", "/push", nil)
+	request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate}}
+
+	if _, _, err := pushIdentity(request, ""); err == nil {
+		t.Error("unverified certificate identified a prober")
+	}
+}
+
+// Nothing registered with the default mux is served by the aggregator
+func TestAggregatorHandlerDefaultMux(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants []AggregatorTenant
+	}{
+		{"without tenants", nil},
+		{"with tenants", []AggregatorTenant{{Name: "acme", Token: "acme-token"}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			aggregatorHandler(test.tenants).ServeHTTP(recorder, httptest.NewRequest("GET", "/debug/vars", nil))
+
+			if strings.Contains(recorder.Body.String(), "memstats") {
+				t.Error("aggregator serves expvar")
+			}
+		})
+	}
+}
+
+// Scrape the site metrics of a tenant
+func scrapeSiteMetrics(t *testing.T, tenant string) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	siteMetricsHandler(tenant).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("metrics answered %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	body, err := io.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+// An interface whose display name is the kernel name of another interface
+// doesn't break scrapes with duplicate series
+func TestSiteMetricsDuplicateInterfaceNames(t *testing.T) {
+	tenant := "duplicate-names"
+	siteStatusMap.Store(siteKey{tenant: tenant, site: "hq"}, api.SiteStatusResponse{
+		Site:     "hq",
+		LastPush: time.Now().Unix(),
+		Interfaces: []api.InterfaceStatusResponse{
+			{Name: "eth0", DisplayName: "wan2", Healthy: true},
+			{Name: "wan2", Healthy: false},
+			// Malformed push repeating an interface
+			{Name: "wan2", Healthy: true},
+		},
+	})
+	t.Cleanup(func() { siteStatusMap.Delete(siteKey{tenant: tenant, site: "hq"}) })
+
+	metrics := scrapeSiteMetrics(t, tenant)
+	for _, series := range []string{
+		`wan_prober_site_interface_healthy{interface="wan2",name="eth0",site="hq",tenant="duplicate-names"} 1`,
+		`wan_prober_site_interface_healthy{interface="wan2",name="wan2",site="hq",tenant="duplicate-names"} 0`,
+	} {
+		if !strings.Contains(metrics, series) {
+			t.Errorf("metrics don't contain %s:\n%s", series, metrics)
+		}
+	}
+}
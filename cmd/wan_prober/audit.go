@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	auditMu      sync.Mutex
+	auditEntries = []AuditEntry{}
+)
+
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Admin access entry, basic auth user or verified client certificate subject,
+	// when clients are authenticated
+	Client  string `json:"client,omitempty"`
+	Address string `json:"address"`
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Query   string `json:"query,omitempty"`
+	Status  int    `json:"status"`
+}
+
+// Response writer which keeps the status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Identify the client of a request from its admin access entry, or the
+// authentication of its listener
+func clientIdentity(config ListenerConfig, r *http.Request) string {
+	if client := adminClient(r); client != nil {
+		return client.Name
+	}
+	if config.BasicAuth != nil {
+		if username, _, ok := r.BasicAuth(); ok {
+			return username
+		}
+	}
+	// Only certificates the listener verified, others name whoever they like
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+
+	return ""
+}
+
+// Serve an admin endpoint, logging calls which change something and
+// keeping them for /audit
+func auditHandler(config ListenerConfig, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(recorder, r)
+
+		entry := AuditEntry{
+			Time:    time.Now(),
+			Client:  clientIdentity(config, r),
+			Address: r.RemoteAddr,
+			Method:  r.Method,
+			Path:    r.URL.Path,
+			Query:   r.URL.RawQuery,
+			Status:  recorder.status,
+		}
+
+		logger.Info(
+			"Admin API call",
+			"client",
+			entry.Client,
+			"address",
+			entry.Address,
+			"method",
+			entry.Method,
+			"path",
+			entry.Path,
+			"query",
+			entry.Query,
+			"status",
+			entry.Status,
+		)
+
+		auditMu.Lock()
+		auditEntries = append(auditEntries, entry)
+		if len(auditEntries) > footprint.auditEntries {
+			auditEntries = auditEntries[len(auditEntries)-footprint.auditEntries:]
+		}
+		auditMu.Unlock()
+	})
+}
+
+// Serve recent admin API calls, oldest first
+func registerAuditHandler() {
+	handleRoleFunc(roleAdmin, "/audit", func(w http.ResponseWriter, r *http.Request) {
+		auditMu.Lock()
+		resp := append([]AuditEntry{}, auditEntries...)
+		auditMu.Unlock()
+
+		writeJSON(w, r, http.StatusOK, resp)
+	})
+}
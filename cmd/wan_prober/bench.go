@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/health"
+	"github.com/adaricorp/wan-prober/probe"
+	"github.com/peterbourgon/ff/v4"
+)
+
+// Outcomes of the probes made by a benchmark
+type benchResults struct {
+	mu        sync.Mutex
+	probes    int
+	latencies []time.Duration
+	outcomes  map[string]int
+	resolvers map[string]int
+	errors    map[string]int
+}
+
+func (r *benchResults) add(result probe.Result, err error, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.probes += 1
+	r.outcomes[probeOutcome(err)] += 1
+	if result.Resolver != "" {
+		r.resolvers[result.Resolver] += 1
+	}
+	if err == nil {
+		r.latencies = append(r.latencies, duration)
+	} else {
+		r.errors[err.Error()] += 1
+	}
+}
+
+// Format counts as "key count" pairs, most frequent first
+func formatCounts(counts map[string]int) string {
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+
+	pairs := []string{}
+	for _, key := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s %d", key, counts[key]))
+	}
+
+	return strings.Join(pairs, ", ")
+}
+
+// Probe a target from an interface over and over for a while and print
+// its latency distribution, success rate and resolver behaviour, returns
+// the exit code
+func runBench(ctx context.Context, args []string) int {
+	fs := ff.NewFlagSet(binName + " bench")
+	iface := fs.StringLong("interface", "", "Interface to probe from, the default route is used when empty")
+	target := fs.StringLong("target", "", "Target to probe")
+	prober := fs.StringLong("probe", "http", "Probe type")
+	duration := fs.DurationLong("duration", 30*time.Second, "How long to probe the target for")
+	interval := fs.DurationLong("interval", time.Second, "Time between probes of each worker")
+	concurrency := fs.IntLong("concurrency", 1, "Number of probes to run at once")
+	timeout := fs.DurationLong("timeout", 5*time.Second, "Probe timeout")
+
+	if err := ff.Parse(fs, args); err != nil || *target == "" || *concurrency < 1 {
+		printUsage(fs)
+	}
+
+	proberFn, exists := probers[*prober]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "Invalid probe type: %s\n", *prober)
+		return 1
+	}
+
+	fallbackResolvers := []string{}
+	for _, fallbackResolver := range defaultFallbackResolvers {
+		fallbackResolvers = append(fallbackResolvers, fallbackResolver.String())
+	}
+	config := probe.Config{
+		BindInterface:     *iface,
+		FallbackResolvers: fallbackResolvers,
+		Timeout:           *timeout,
+	}
+
+	results := &benchResults{
+		outcomes:  map[string]int{},
+		resolvers: map[string]int{},
+		errors:    map[string]int{},
+	}
+
+	benchCtx, cancel := context.WithTimeout(ctx, *duration)
+	defer cancel()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range *concurrency {
+		wg.Go(func() {
+			for benchCtx.Err() == nil {
+				probeStart := time.Now()
+				result, err := proberFn(ctx, *target, config, &dnsCache, logger)
+				results.add(result, err, time.Since(probeStart))
+
+				timer := time.NewTimer(*interval)
+				select {
+				case <-benchCtx.Done():
+					timer.Stop()
+				case <-timer.C:
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	successes := results.outcomes[outcomeSuccess]
+	fmt.Printf("Target:     %s (%s)\n", *target, *prober)
+	if *iface != "" {
+		fmt.Printf("Interface:  %s\n", *iface)
+	}
+	fmt.Printf("Duration:   %s\n", time.Since(start).Round(time.Millisecond))
+	fmt.Printf(
+		"Probes:     %d, %d successful (%.1f%%)\n",
+		results.probes,
+		successes,
+		100*float64(successes)/float64(max(results.probes, 1)),
+	)
+	if len(results.latencies) > 0 {
+		fmt.Printf(
+			"Latency:    min %s, p50 %s, p90 %s, p99 %s, max %s\n",
+			health.LatencyPercentile(results.latencies, 0).Round(time.Microsecond),
+			health.LatencyPercentile(results.latencies, 0.5).Round(time.Microsecond),
+			health.LatencyPercentile(results.latencies, 0.9).Round(time.Microsecond),
+			health.LatencyPercentile(results.latencies, 0.99).Round(time.Microsecond),
+			health.LatencyPercentile(results.latencies, 1).Round(time.Microsecond),
+		)
+	}
+	fmt.Printf("Outcomes:   %s\n", formatCounts(results.outcomes))
+	if len(results.resolvers) > 0 {
+		fmt.Printf("Resolvers:  %s\n", formatCounts(results.resolvers))
+	}
+	if len(results.errors) > 0 {
+		fmt.Printf("Errors:     %s\n", formatCounts(results.errors))
+	}
+
+	if successes == 0 {
+		return 1
+	}
+
+	return 0
+}
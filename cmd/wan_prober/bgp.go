@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BGP daemons which can announce routes for interfaces
+const (
+	bgpDaemonBIRD  = "bird"
+	bgpDaemonGoBGP = "gobgp"
+)
+
+const (
+	actionBGP = "bgp"
+
+	defaultBIRDSocket = "/run/bird/bird.ctl"
+
+	// How long to wait before retrying changes the daemon refused
+	bgpRetryInterval = 30 * time.Second
+	// Longest time the BIRD control socket may take to answer
+	birdTimeout = 10 * time.Second
+)
+
+var (
+	defaultGoBGPCommand = []string{"gobgp"}
+)
+
+// Announces routes for healthy interfaces and withdraws them for unhealthy
+// ones through a local BGP daemon
+type bgpSpeaker struct {
+	mu         sync.Mutex
+	config     BGPConfig
+	interfaces map[string]InterfaceBGP
+	// Health which should be announced, and which last was, by interface
+	desired map[string]bool
+	applied map[string]bool
+	wake    chan struct{}
+}
+
+// Check the BGP configuration, filling in defaults
+func validateBGP(config *BGPConfig, interfaces []Interface) error {
+	switch config.Daemon {
+	case bgpDaemonBIRD:
+		if config.Socket == "" {
+			config.Socket = defaultBIRDSocket
+		}
+	case bgpDaemonGoBGP:
+		if len(config.Command) == 0 {
+			config.Command = defaultGoBGPCommand
+		}
+	default:
+		return fmt.Errorf("invalid daemon %s, must be %s or %s", config.Daemon, bgpDaemonBIRD, bgpDaemonGoBGP)
+	}
+
+	for _, iface := range interfaces {
+		if iface.BGP == nil {
+			continue
+		}
+		switch config.Daemon {
+		case bgpDaemonBIRD:
+			if len(iface.BGP.Protocols) == 0 {
+				return fmt.Errorf("interface %s needs bgp protocols to enable and disable in BIRD", iface.Name)
+			}
+		case bgpDaemonGoBGP:
+			if len(iface.BGP.Prefixes) == 0 {
+				return fmt.Errorf("interface %s needs bgp prefixes to announce with GoBGP", iface.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func newBGPSpeaker(config BGPConfig, interfaces []Interface) *bgpSpeaker {
+	s := &bgpSpeaker{
+		config:     config,
+		interfaces: map[string]InterfaceBGP{},
+		desired:    map[string]bool{},
+		applied:    map[string]bool{},
+		wake:       make(chan struct{}, 1),
+	}
+
+	for _, iface := range interfaces {
+		if iface.BGP != nil {
+			s.interfaces[iface.Name] = *iface.BGP
+		}
+	}
+
+	return s
+}
+
+// Record the health of an interface, its routes are changed in the
+// background when it differs from what was announced
+func (s *bgpSpeaker) update(iface string, healthy bool) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if _, exists := s.interfaces[iface]; !exists {
+		s.mu.Unlock()
+		return
+	}
+	if desired, exists := s.desired[iface]; exists && desired == healthy {
+		// Unchanged, changes which failed are retried by run
+		s.mu.Unlock()
+		return
+	}
+	s.desired[iface] = healthy
+	s.mu.Unlock()
+
+	s.wakeUp()
+}
+
+// Apply what wasn't applied yet without waiting for the retry interval,
+// e.g. changes which were skipped while this instance was standby
+func (s *bgpSpeaker) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Apply changes of health to the daemon in order, retrying those it refused
+func (s *bgpSpeaker) run(ctx context.Context) {
+	timer := time.NewTimer(bgpRetryInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+
+		s.mu.Lock()
+		changes := map[string]bool{}
+		for iface, healthy := range s.desired {
+			if applied, exists := s.applied[iface]; !exists || applied != healthy {
+				changes[iface] = healthy
+			}
+		}
+		s.mu.Unlock()
+
+		for iface, healthy := range changes {
+			if err := s.apply(ctx, iface, healthy); err != nil {
+				continue
+			}
+
+			s.mu.Lock()
+			s.applied[iface] = healthy
+			s.mu.Unlock()
+		}
+
+		timer.Reset(bgpRetryInterval)
+	}
+}
+
+// Announce or withdraw the routes of an interface
+func (s *bgpSpeaker) apply(ctx context.Context, iface string, healthy bool) error {
+	config := s.interfaces[iface]
+
+	switch s.config.Daemon {
+	case bgpDaemonBIRD:
+		command := "disable"
+		if healthy {
+			command = "enable"
+		}
+		for _, protocol := range config.Protocols {
+			err := performAction(ctx, actionBGP, iface, "bird "+command+" "+protocol, func(ctx context.Context) error {
+				return birdCommand(ctx, s.config.Socket, command+" "+protocol)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	case bgpDaemonGoBGP:
+		communities, med := config.Communities, config.MED
+		if !healthy {
+			communities, med = config.UnhealthyCommunities, config.UnhealthyMED
+		}
+		// Routes of unhealthy interfaces are kept with other attributes,
+		// making them less preferred, when those are configured
+		withdraw := !healthy && len(communities) == 0 && med == nil
+
+		for _, prefix := range config.Prefixes {
+			family := "ipv4"
+			if prefix.Addr().Is6() {
+				family = "ipv6"
+			}
+
+			args := []string{"global", "rib", "add", "-a", family, prefix.String()}
+			if withdraw {
+				args = []string{"global", "rib", "del", "-a", family, prefix.String()}
+			} else {
+				if len(communities) > 0 {
+					args = append(args, "community", strings.Join(communities, ","))
+				}
+				if med != nil {
+					args = append(args, "med", strconv.FormatUint(uint64(*med), 10))
+				}
+			}
+
+			command := append(append([]string{}, s.config.Command...), args...)
+			if err := runCommandAction(ctx, actionBGP, iface, command, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Run a command on the BIRD control socket, failing when BIRD answers
+// with a run-time or parse error
+func birdCommand(ctx context.Context, socket string, command string) error {
+	dialer := net.Dialer{Timeout: birdTimeout}
+	conn, err := dialer.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(birdTimeout))
+
+	reader := bufio.NewReader(conn)
+
+	// Welcome message
+	if _, _, err := readBIRDReply(reader); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(conn, "%s\n", command); err != nil {
+		return err
+	}
+
+	code, text, err := readBIRDReply(reader)
+	if err != nil {
+		return err
+	}
+	if code >= 8000 {
+		return fmt.Errorf("bird: %s", text)
+	}
+
+	return nil
+}
+
+// Read a reply from BIRD: lines starting with a 4 digit code followed by
+// a dash continue, the line whose code is followed by a space ends it
+func readBIRDReply(reader *bufio.Reader) (int, string, error) {
+	lines := []string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return 0, "", err
+		}
+		line = strings.TrimRight(line, "\n")
+
+		if len(line) < 5 || line[0] == ' ' {
+			// Continuation without a code
+			lines = append(lines, strings.TrimSpace(line))
+			continue
+		}
+
+		code, err := strconv.Atoi(line[:4])
+		if err != nil {
+			return 0, "", errors.New("invalid reply from bird: " + line)
+		}
+		lines = append(lines, line[5:])
+
+		if line[4] == ' ' {
+			return code, strings.Join(lines, "\n"), nil
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+const (
+	blackboxDNSPort = "53"
+)
+
+// Module of a blackbox_exporter configuration, only the settings which
+// have an equivalent in wan-prober probes are read
+type BlackboxModule struct {
+	Prober string `yaml:"prober"`
+	HTTP   struct {
+		Method            string   `yaml:"method"`
+		ValidHTTPVersions []string `yaml:"valid_http_versions"`
+	} `yaml:"http"`
+	TCP struct {
+		PreferredIPProtocol string `yaml:"preferred_ip_protocol"`
+	} `yaml:"tcp"`
+	ICMP struct {
+		PreferredIPProtocol string `yaml:"preferred_ip_protocol"`
+	} `yaml:"icmp"`
+	DNS struct {
+		QueryName string `yaml:"query_name"`
+		QueryType string `yaml:"query_type"`
+		DNSSEC    bool   `yaml:"dnssec"`
+	} `yaml:"dns"`
+}
+
+// Read the modules of a blackbox_exporter configuration file
+func readBlackboxModules(path string) (map[string]BlackboxModule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	blackboxConfig := struct {
+		Modules map[string]BlackboxModule `yaml:"modules"`
+	}{}
+	if err := yaml.Unmarshal(data, &blackboxConfig); err != nil {
+		return nil, err
+	}
+
+	return blackboxConfig.Modules, nil
+}
+
+// Fill in the probe settings of a target from a blackbox_exporter module,
+// settings given in the target itself take precedence
+func applyBlackboxModule(target Target, module BlackboxModule) (Target, error) {
+	switch module.Prober {
+	case "http":
+		if target.Probe == "" {
+			target.Probe = "http"
+		}
+		if target.HTTP.Method == "" {
+			// blackbox_exporter sends GET requests by default
+			target.HTTP.Method = module.HTTP.Method
+			if target.HTTP.Method == "" {
+				target.HTTP.Method = "GET"
+			}
+		}
+		if target.HTTP.Protocol == "" && slices.Contains(module.HTTP.ValidHTTPVersions, "HTTP/2.0") {
+			target.HTTP.Protocol = "h2"
+			if len(module.HTTP.ValidHTTPVersions) == 1 {
+				target.HTTP.Protocol = "h2-only"
+			}
+		}
+	case "tcp":
+		if target.Probe == "" {
+			target.Probe = "tcp"
+		}
+		if target.IPProtocol == "" {
+			target.IPProtocol = module.TCP.PreferredIPProtocol
+		}
+	case "icmp":
+		if target.Probe == "" {
+			target.Probe = "icmp"
+		}
+		if target.IPProtocol == "" {
+			target.IPProtocol = module.ICMP.PreferredIPProtocol
+		}
+	case "dns":
+		if module.DNS.QueryName == "" {
+			return target, fmt.Errorf("dns module has no query_name")
+		}
+
+		// blackbox_exporter targets of DNS modules are the
+		// resolver to query rather than the name to query for
+		if target.Probe == "" {
+			target.Probe = "dns"
+		}
+		if target.DNS.Server == "" {
+			target.DNS.Server = target.Host
+			if _, _, err := net.SplitHostPort(target.DNS.Server); err != nil {
+				target.DNS.Server = net.JoinHostPort(target.DNS.Server, blackboxDNSPort)
+			}
+		}
+		target.Host = module.DNS.QueryName
+		if target.DNS.Type == "" {
+			target.DNS.Type = strings.ToUpper(module.DNS.QueryType)
+		}
+		if module.DNS.DNSSEC {
+			target.DNS.EDNS.DO = true
+		}
+	default:
+		return target, fmt.Errorf("unsupported prober: %s", module.Prober)
+	}
+
+	return target, nil
+}
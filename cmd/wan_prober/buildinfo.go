@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"net/http"
+	"runtime"
+
+	"github.com/prometheus/common/version"
+)
+
+var (
+	// Counters served at /debug/vars
+	probeCounts          = expvar.NewMap("probes")
+	stateTransitionCount = expvar.NewInt("state_transitions")
+	notificationCounts   = expvar.NewMap("notifications_sent")
+)
+
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision"`
+	Branch    string `json:"branch,omitempty"`
+	BuildDate string `json:"build_date"`
+	BuildUser string `json:"build_user,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Optional features enabled by the configuration and flags
+	Features []string `json:"features"`
+	// SHA-256 of the configuration file, identifying its generation
+	ConfigHash string `json:"config_hash"`
+}
+
+// Hash a configuration file, so fleets can tell which generation of the
+// configuration a host runs
+func configHash(configFile []byte) string {
+	sum := sha256.Sum256(configFile)
+
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// List the optional features enabled by a configuration and the flags
+func enabledFeatures(config Config) []string {
+	features := []string{}
+	add := func(feature string, enabled bool) {
+		if enabled {
+			features = append(features, feature)
+		}
+	}
+
+	dhcp, ppp, cpe := false, false, false
+	for _, iface := range config.Interfaces {
+		dhcp = dhcp || iface.DHCP != nil
+		ppp = ppp || iface.PPP != nil
+		cpe = cpe || iface.CPE != nil
+	}
+
+	add("health_policy", config.ProbeConfiguration.HealthPolicy != "")
+	add("dhcp", dhcp)
+	add("ppp", ppp)
+	add("cpe", cpe)
+	add("notifications", len(config.Notifications) > 0)
+	add("heartbeats", len(config.Heartbeats) > 0)
+	add("ha", config.HA != nil)
+	add("push", config.Push != nil)
+	add("peering", config.Peering != nil)
+	add("consul", config.Consul != nil)
+	add("etcd", config.Etcd != nil)
+	add("kubernetes", config.Kubernetes != nil)
+	add("zabbix", config.Zabbix != nil)
+	add("nsca", config.NSCA != nil)
+	add("agentx", config.AgentX != nil)
+	add("dbus_service", config.DBusService != nil)
+	add("geoip", config.GeoIP != nil)
+	add("bgp", config.BGP != nil)
+	add("vrrp", config.VRRP != nil)
+	add("dynamic_dns", config.DynamicDNS != nil)
+	add("acme", config.ACME != nil)
+	add("admin_access", len(config.AdminAccess) > 0)
+	add("status_rate_limit", config.StatusRateLimit != nil)
+	add("blackbox_modules", config.BlackboxModulesFile != "")
+	add("remote_config", *configURL != "")
+	add("result_log", *resultLogFile != "")
+	add("ndjson_output", *outputFormat == outputNDJSON)
+	add("probe_trace_ids", *probeTraceIDs)
+	add("dry_run_actions", *dryRunActions)
+	add("simulation", len(*simulate) > 0 || *simulateAPI)
+	add("run_as_user", *runAsUser != "")
+	add("small_footprint", *smallFootprintMode)
+	add("windows_service", *serviceMode == serviceRun)
+
+	return features
+}
+
+// Serve the build and configuration of this process at /buildinfo, and
+// publish it with the counters at /debug/vars
+func registerBuildInfoHandler(config Config, configFile []byte) {
+	info := BuildInfo{
+		Version:    version.Version,
+		Revision:   version.Revision,
+		Branch:     version.Branch,
+		BuildDate:  version.BuildDate,
+		BuildUser:  version.BuildUser,
+		GoVersion:  runtime.Version(),
+		Features:   enabledFeatures(config),
+		ConfigHash: configHash(configFile),
+	}
+
+	expvar.Publish("build_info", expvar.Func(func() any { return info }))
+
+	handleRoleFunc(roleStatus, "/buildinfo", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, info)
+	})
+}
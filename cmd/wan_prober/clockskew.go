@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	// Latest clock skew observed through each interface
+	clockSkewMap sync.Map
+)
+
+// Record the clock skew observed by a probe, warning when it's larger
+// than the configured maximum
+func observeClockSkew(iface Interface, target string, skew time.Duration, maxSkew time.Duration) {
+	clockSkew.WithLabelValues(iface.displayName(), target).Set(skew.Seconds())
+	clockSkewMap.Store(iface.Name, skew.Seconds())
+
+	if maxSkew > 0 && skew.Abs() > maxSkew {
+		logger.Warn(
+			"Clock skew is above threshold",
+			"interface",
+			iface.Name,
+			"description",
+			iface.Description,
+			"target",
+			target,
+			"skew",
+			skew.Round(time.Millisecond).String(),
+		)
+	}
+}
+
+// Look up the latest clock skew observed through an interface in seconds,
+// returns nil if none was observed
+func observedClockSkew(iface string) *float64 {
+	val, exists := clockSkewMap.Load(iface)
+	if !exists {
+		return nil
+	}
+
+	switch v := val.(type) {
+	case float64:
+		return &v
+	}
+
+	return nil
+}
@@ -0,0 +1,459 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/health"
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+// Names of the configured interfaces, in the order of the configuration
+func interfaceNames(interfaces []Interface) []string {
+	names := make([]string, 0, len(interfaces))
+	for _, iface := range interfaces {
+		names = append(names, iface.Name)
+	}
+
+	return names
+}
+
+// Fill in the defaults of a parsed configuration and check that its
+// options are valid and fit together, so a configuration which passes
+// can be started
+func prepareConfig(config *Config) error {
+	if config.ProbeConfiguration.MinInterval == 0 {
+		config.ProbeConfiguration.MinInterval = 30 * time.Second
+	}
+
+	if config.ProbeConfiguration.Timeout == 0 {
+		config.ProbeConfiguration.Timeout = 5 * time.Second
+	}
+
+	budget := config.ProbeConfiguration.AttemptBudget
+	if budget.Total < 0 || budget.DNS < 0 || budget.Dial < 0 ||
+		(budget.Total > 0 && (budget.DNS > budget.Total || budget.Dial > budget.Total)) {
+		return fmt.Errorf(
+			"attempt budget phases must be positive and fit in the total, total %s, dns %s, dial %s",
+			budget.Total,
+			budget.DNS,
+			budget.Dial,
+		)
+	}
+
+	if config.ProbeConfiguration.Attempts == 0 {
+		config.ProbeConfiguration.Attempts = 3
+	}
+
+	if config.ProbeConfiguration.MaxClockSkew == 0 {
+		config.ProbeConfiguration.MaxClockSkew = 10 * time.Second
+	}
+
+	if config.ProbeConfiguration.SiteOutageWindow == 0 {
+		config.ProbeConfiguration.SiteOutageWindow = time.Minute
+	}
+
+	if len(config.FallbackResolvers) == 0 {
+		config.FallbackResolvers = defaultFallbackResolvers
+	}
+
+	if err := prepareTargets(config); err != nil {
+		return err
+	}
+
+	for i := range config.Notifications {
+		if config.Notifications[i].Name == "" {
+			config.Notifications[i].Name = fmt.Sprintf(
+				"%s-%d",
+				config.Notifications[i].Type,
+				i,
+			)
+		}
+
+		if config.Notifications[i].MaxQueued == 0 {
+			config.Notifications[i].MaxQueued = footprint.queuedNotifications
+		}
+	}
+
+	if err := prepareIntegrations(config); err != nil {
+		return err
+	}
+
+	if len(config.Listeners) == 0 {
+		config.Listeners = []ListenerConfig{
+			{
+				Address: *httpListenAddress,
+				Roles:   listenerRoles,
+			},
+		}
+	}
+	if err := validateListeners(config.Listeners); err != nil {
+		return fmt.Errorf("invalid listener: %w", err)
+	}
+	if err := validateAdminAccess(config.AdminAccess, config.Listeners); err != nil {
+		return fmt.Errorf("invalid admin access: %w", err)
+	}
+
+	if err := validateACME(config.ACME, config.Listeners); err != nil {
+		return fmt.Errorf("invalid ACME configuration: %w", err)
+	}
+
+	if config.BGP != nil {
+		if err := validateBGP(config.BGP, config.Interfaces); err != nil {
+			return fmt.Errorf("invalid BGP configuration: %w", err)
+		}
+	}
+
+	if err := prepareInterfaces(config); err != nil {
+		return err
+	}
+	ifaces := interfaceNames(config.Interfaces)
+
+	if config.VRRP != nil {
+		if err := validateVRRP(*config.VRRP, ifaces); err != nil {
+			return fmt.Errorf("invalid VRRP configuration: %w", err)
+		}
+	}
+
+	if config.DNSCache != nil {
+		if config.DNSCache.File == "" {
+			return errors.New("DNS cache needs a file")
+		}
+		if config.DNSCache.SaveInterval == 0 {
+			config.DNSCache.SaveInterval = defaultDNSCacheSaveInterval
+		}
+	}
+
+	if config.StatusRateLimit != nil {
+		if _, err := newClientRateLimiter(*config.StatusRateLimit); err != nil {
+			return fmt.Errorf("invalid status rate limit: %w", err)
+		}
+	}
+
+	if config.Timezone != "" {
+		if _, err := time.LoadLocation(config.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %s: %w", config.Timezone, err)
+		}
+	}
+
+	if config.DynamicDNS != nil {
+		if err := validateDynamicDNS(config.DynamicDNS, ifaces); err != nil {
+			return fmt.Errorf("invalid dynamic DNS configuration: %w", err)
+		}
+	}
+
+	healthPolicies := map[string]string{"probe_config": config.ProbeConfiguration.HealthPolicy}
+	for _, iface := range config.Interfaces {
+		healthPolicies[iface.Name] = iface.HealthPolicy
+	}
+	for scope, policy := range healthPolicies {
+		if policy == "" {
+			continue
+		}
+
+		if _, err := health.CompilePolicy(policy); err != nil {
+			return fmt.Errorf("invalid health policy of %s: %w", scope, err)
+		}
+	}
+
+	for option, value := range map[string]string{
+		"no_valid_targets_health": config.ProbeConfiguration.NoValidTargetsHealth,
+		"inconclusive_health":     config.ProbeConfiguration.InconclusiveHealth,
+	} {
+		if value != "" && value != healthHealthy && value != healthUnhealthy {
+			return fmt.Errorf("invalid %s %s, must be healthy or unhealthy", option, value)
+		}
+	}
+
+	if config.HA != nil {
+		if err := validateHA(config.HA); err != nil {
+			return fmt.Errorf("invalid HA configuration: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Map blackbox_exporter modules onto targets, fill in the defaults of
+// targets and check their options
+func prepareTargets(config *Config) error {
+	if config.BlackboxModulesFile != "" {
+		modules, err := readBlackboxModules(config.BlackboxModulesFile)
+		if err != nil {
+			return fmt.Errorf(
+				"couldn't read blackbox_exporter modules from %s: %w",
+				config.BlackboxModulesFile,
+				err,
+			)
+		}
+
+		for i := range config.Targets {
+			if config.Targets[i].Module == "" {
+				continue
+			}
+
+			module, exists := modules[config.Targets[i].Module]
+			if !exists {
+				return fmt.Errorf(
+					"target %s refers to unknown blackbox_exporter module %s",
+					config.Targets[i].Host,
+					config.Targets[i].Module,
+				)
+			}
+
+			config.Targets[i], err = applyBlackboxModule(config.Targets[i], module)
+			if err != nil {
+				return fmt.Errorf(
+					"couldn't map blackbox_exporter module %s onto target %s: %w",
+					config.Targets[i].Module,
+					config.Targets[i].Host,
+					err,
+				)
+			}
+		}
+	}
+
+	for i := range config.Targets {
+		target := &config.Targets[i]
+
+		if target.Module != "" && config.BlackboxModulesFile == "" {
+			return fmt.Errorf(
+				"target %s refers to a blackbox_exporter module without a blackbox_modules_file",
+				target.Host,
+			)
+		}
+
+		switch target.IPProtocol {
+		case "", probe.IPProtocol4, probe.IPProtocol6:
+		default:
+			return fmt.Errorf("target %s has invalid IP protocol %s", target.Host, target.IPProtocol)
+		}
+
+		if target.Weight < 0 {
+			return fmt.Errorf("target %s has negative weight %g", target.Host, target.Weight)
+		}
+		if target.Weight == 0 {
+			target.Weight = 1
+		}
+
+		if target.HTTP.Method == "" {
+			target.HTTP.Method = "HEAD"
+		}
+
+		switch target.HTTP.Protocol {
+		case "", probe.HTTPProtocol1, probe.HTTPProtocol2, probe.HTTPProtocol2Only:
+		case probe.HTTPProtocol3:
+			if target.Probe == "http" && !strings.HasPrefix(target.Host, "https://") {
+				return fmt.Errorf("target %s uses HTTP/3, which needs an https:// URL", target.Host)
+			}
+		default:
+			return fmt.Errorf("target %s has invalid HTTP protocol %s", target.Host, target.HTTP.Protocol)
+		}
+	}
+
+	return nil
+}
+
+// Fill in the defaults of the status integrations and check they have the
+// options they need
+func prepareIntegrations(config *Config) error {
+	if config.Push != nil {
+		if config.Push.URL == "" || config.Push.Site == "" {
+			return errors.New("push configuration needs a url and site")
+		}
+
+		if config.Push.Interval == 0 {
+			config.Push.Interval = config.ProbeConfiguration.MinInterval
+		}
+
+		if tls := config.Push.TLS; tls != nil && (tls.CertFile == "") != (tls.KeyFile == "") {
+			return errors.New("push TLS configuration needs both a cert_file and key_file")
+		}
+	}
+
+	if config.Peering != nil {
+		if config.Peering.Site == "" {
+			return errors.New("peering configuration needs a site")
+		}
+
+		if config.Peering.Interval == 0 {
+			config.Peering.Interval = time.Minute
+		}
+	}
+
+	if config.Consul != nil {
+		if config.Consul.Address == "" {
+			config.Consul.Address = "http://127.0.0.1:8500"
+		}
+
+		if config.Consul.Service == "" {
+			config.Consul.Service = "wan-prober"
+		}
+
+		if config.Consul.TTL == 0 {
+			config.Consul.TTL = 3 * config.ProbeConfiguration.MinInterval
+		}
+	}
+
+	if config.Etcd != nil {
+		if config.Etcd.Endpoint == "" {
+			config.Etcd.Endpoint = "http://127.0.0.1:2379"
+		}
+
+		if config.Etcd.Prefix == "" {
+			config.Etcd.Prefix = "/wan-prober"
+		}
+	}
+
+	if config.Kubernetes != nil {
+		if config.Kubernetes.NodeName == "" {
+			config.Kubernetes.NodeName = os.Getenv("NODE_NAME")
+		}
+
+		if config.Kubernetes.NodeName == "" {
+			return errors.New("kubernetes configuration needs a node name")
+		}
+
+		if config.Kubernetes.ConditionPrefix == "" {
+			config.Kubernetes.ConditionPrefix = "WANHealthy-"
+		}
+	}
+
+	if config.Zabbix != nil {
+		if config.Zabbix.Server == "" || config.Zabbix.Host == "" {
+			return errors.New("zabbix configuration needs a server and host")
+		}
+
+		if config.Zabbix.Key == "" {
+			config.Zabbix.Key = "wan.healthy[{interface}]"
+		}
+	}
+
+	if config.NSCA != nil {
+		if config.NSCA.Server == "" || config.NSCA.Host == "" {
+			return errors.New("NSCA configuration needs a server and host")
+		}
+
+		if config.NSCA.Service == "" {
+			config.NSCA.Service = "WAN {interface}"
+		}
+	}
+
+	if config.AgentX != nil {
+		if config.AgentX.Address == "" {
+			config.AgentX.Address = "/var/agentx/master"
+		}
+
+		if config.AgentX.BaseOID == "" {
+			// netSnmpPlaypen, until a private enterprise number is assigned
+			config.AgentX.BaseOID = "1.3.6.1.4.1.8072.9999.9999.1"
+		}
+	}
+
+	if config.DBusService != nil {
+		if config.DBusService.Address == "" {
+			config.DBusService.Address = os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+		}
+		if config.DBusService.Address == "" {
+			config.DBusService.Address = dbusSystemBusAddress
+		}
+
+		if config.DBusService.Name == "" {
+			config.DBusService.Name = dbusServiceDefaultName
+		}
+	}
+
+	for i := range config.Heartbeats {
+		if config.Heartbeats[i].Interval == 0 {
+			config.Heartbeats[i].Interval = time.Minute
+		}
+	}
+
+	return nil
+}
+
+// Fill in the defaults of interfaces and check their options
+func prepareInterfaces(config *Config) error {
+	ifaces := []string{}
+	displayNames := []string{}
+	for _, iface := range config.Interfaces {
+		if slices.Contains(ifaces, iface.Name) {
+			return fmt.Errorf("interface %s is defined more than once", iface.Name)
+		}
+		ifaces = append(ifaces, iface.Name)
+
+		// Display names replace kernel names in metric labels,
+		// so they must be unique
+		if slices.Contains(displayNames, iface.displayName()) {
+			return fmt.Errorf(
+				"display name %s of interface %s is used more than once",
+				iface.displayName(),
+				iface.Name,
+			)
+		}
+		displayNames = append(displayNames, iface.displayName())
+
+		if iface.NAT64Prefix.IsValid() && (!iface.NAT64Prefix.Addr().Is6() || iface.NAT64Prefix.Bits() != 96) {
+			return fmt.Errorf(
+				"NAT64 prefix %s of interface %s must be an IPv6 /96 prefix",
+				iface.NAT64Prefix,
+				iface.Name,
+			)
+		}
+
+		if iface.IPv6Only {
+			ipv6Resolvers := 0
+			for _, resolver := range config.FallbackResolvers {
+				if !resolver.Addr().Unmap().Is4() {
+					ipv6Resolvers += 1
+				}
+			}
+			if ipv6Resolvers == 0 {
+				return fmt.Errorf("IPv6-only interface %s needs IPv6 fallback resolvers", iface.Name)
+			}
+			if config.HostResolver != nil && config.HostResolver.Addr().Unmap().Is4() {
+				logger.Warn(
+					"Host resolver is IPv4, so IPv6-only interface will rely on fallback resolvers",
+					"interface",
+					iface.Name,
+					"host_resolver",
+					config.HostResolver.String(),
+				)
+			}
+		}
+
+		if err := validateTransitionActions(iface.Actions); err != nil {
+			return fmt.Errorf("invalid actions of interface %s: %w", iface.Name, err)
+		}
+
+		if iface.Remediation != nil {
+			if err := validateRemediation(iface.Remediation); err != nil {
+				return fmt.Errorf("invalid remediation of interface %s: %w", iface.Name, err)
+			}
+		}
+
+		if iface.EgressCheck != nil {
+			if err := validateEgressCheck(*iface.EgressCheck); err != nil {
+				return fmt.Errorf("invalid egress check of interface %s: %w", iface.Name, err)
+			}
+		}
+
+		if cpe := iface.CPE; cpe != nil {
+			if cpe.Interval == 0 {
+				cpe.Interval = 5 * time.Minute
+			}
+
+			switch cpe.Protocol {
+			case "", cpeProtocolNATPMP, cpeProtocolUPnP:
+			default:
+				return fmt.Errorf("unsupported CPE protocol %s of interface %s", cpe.Protocol, iface.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrepareConfigDefaults(t *testing.T) {
+	config := Config{
+		Targets:    []Target{{Host: "example.com", Probe: "http"}},
+		Interfaces: []Interface{{Name: "eth0"}},
+	}
+	if err := prepareConfig(&config); err != nil {
+		t.Fatalf("prepareConfig() error = %v", err)
+	}
+
+	if config.ProbeConfiguration.MinInterval != 30*time.Second {
+		t.Errorf("min_interval = %s, want 30s", config.ProbeConfiguration.MinInterval)
+	}
+	if config.ProbeConfiguration.Attempts != 3 {
+		t.Errorf("attempts = %d, want 3", config.ProbeConfiguration.Attempts)
+	}
+	if target := config.Targets[0]; target.Weight != 1 || target.HTTP.Method != "HEAD" {
+		t.Errorf("target weight %g and method %s, want 1 and HEAD", target.Weight, target.HTTP.Method)
+	}
+	if len(config.Listeners) != 1 || config.Listeners[0].Address != *httpListenAddress {
+		t.Errorf("listeners = %+v, want the --http-listen-address listener", config.Listeners)
+	}
+}
+
+func TestPrepareConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		err    string
+	}{
+		{
+			"HTTP/3 target without https",
+			Config{Targets: []Target{{Host: "example.com", Probe: "http", HTTP: TargetHTTP{Protocol: "h3"}}}},
+			"https://",
+		},
+		{
+			"invalid IP protocol",
+			Config{Targets: []Target{{Host: "example.com", IPProtocol: "ip5"}}},
+			"invalid IP protocol",
+		},
+		{
+			"negative weight",
+			Config{Targets: []Target{{Host: "example.com", Weight: -1}}},
+			"negative weight",
+		},
+		{
+			"duplicate interface",
+			Config{Interfaces: []Interface{{Name: "eth0"}, {Name: "eth0"}}},
+			"more than once",
+		},
+		{
+			"duplicate display name",
+			Config{Interfaces: []Interface{{Name: "eth0", DisplayName: "wan"}, {Name: "eth1", DisplayName: "wan"}}},
+			"display name wan",
+		},
+		{
+			"HA peer without id",
+			Config{HA: &HAConfig{Secret: "s", Listen: ":7946", Peer: "192.0.2.1:7946"}},
+			"needs an id",
+		},
+		{
+			"half push TLS",
+			Config{Push: &PushConfig{URL: "https://aggregator", Site: "site", TLS: &PushTLS{CertFile: "cert.pem"}}},
+			"cert_file and key_file",
+		},
+		{
+			"invalid timezone",
+			Config{Timezone: "Nowhere/Nothing"},
+			"invalid timezone",
+		},
+		{
+			"invalid inconclusive health",
+			Config{ProbeConfiguration: ProbeConfiguration{InconclusiveHealth: "maybe"}},
+			"must be healthy or unhealthy",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := prepareConfig(&test.config)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("prepareConfig() error = %v, want one containing %q", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+)
+
+// Parse a configuration file in the format given by its extension, TOML
+// and JSON files are converted to YAML so every format has the same schema
+func unmarshalConfig(path string, data []byte, config any) error {
+	var document any
+	// Line numbers only point into the file when it is YAML
+	positions := true
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		if err := toml.Unmarshal(data, &document); err != nil {
+			return err
+		}
+	case ".json":
+		if err := json.Unmarshal(data, &document); err != nil {
+			return err
+		}
+	}
+
+	if document != nil {
+		positions = false
+		converted, err := yaml.Marshal(document)
+		if err != nil {
+			return err
+		}
+		data = converted
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	if err := applyConfigDefaults(root.Content[0]); err != nil {
+		return err
+	}
+
+	if errs := validateConfigNode(root.Content[0], reflect.TypeOf(config), "", positions); len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	return root.Decode(config)
+}
+
+// Find the value of a key in a YAML mapping
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// Merge the fields of src into dst which dst doesn't set itself, nested
+// mappings are merged field by field
+func mergeMapping(dst *yaml.Node, src *yaml.Node) {
+	if dst.Kind != yaml.MappingNode || src == nil || src.Kind != yaml.MappingNode {
+		return
+	}
+
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+
+		existing := mappingValue(dst, key.Value)
+		if existing == nil {
+			dst.Content = append(dst.Content, copyNode(key), copyNode(value))
+		} else if existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode {
+			mergeMapping(existing, value)
+		}
+	}
+}
+
+// Deep copy a YAML node so merged entries don't share nodes
+func copyNode(node *yaml.Node) *yaml.Node {
+	copied := *node
+	copied.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		copied.Content[i] = copyNode(child)
+	}
+
+	return &copied
+}
+
+// Merge target_defaults, named target_templates and interface_defaults
+// into the targets and interfaces of a configuration, fields set in an entry
+// take precedence over its template, which takes precedence over defaults
+func applyConfigDefaults(config *yaml.Node) error {
+	targetDefaults := mappingValue(config, "target_defaults")
+	interfaceDefaults := mappingValue(config, "interface_defaults")
+	templates := mappingValue(config, "target_templates")
+
+	if targets := mappingValue(config, "targets"); targets != nil {
+		for _, target := range targets.Content {
+			if target.Kind != yaml.MappingNode {
+				continue
+			}
+
+			if name := mappingValue(target, "template"); name != nil {
+				template := (*yaml.Node)(nil)
+				if templates != nil {
+					template = mappingValue(templates, name.Value)
+				}
+				if template == nil {
+					return fmt.Errorf("line %d: unknown target template: %s", name.Line, name.Value)
+				}
+				mergeMapping(target, template)
+			}
+
+			mergeMapping(target, targetDefaults)
+		}
+	}
+
+	if interfaces := mappingValue(config, "interfaces"); interfaces != nil {
+		for _, iface := range interfaces.Content {
+			mergeMapping(iface, interfaceDefaults)
+		}
+	}
+
+	// Sections are fully merged, so they aren't part of the decoded configuration
+	content := []*yaml.Node{}
+	for i := 0; i+1 < len(config.Content); i += 2 {
+		switch config.Content[i].Value {
+		case "target_defaults", "interface_defaults", "target_templates":
+		default:
+			content = append(content, config.Content[i], config.Content[i+1])
+		}
+	}
+	config.Content = content
+
+	return nil
+}
+
+// Problem with a value of a configuration file
+type ConfigError struct {
+	// Position of the value, zero when the file format doesn't have them
+	Line   int
+	Column int
+	// Dotted path of the field, e.g. targets[2].http.method
+	Path    string
+	Message string
+	// Known field which an unknown field was probably meant to be
+	Suggestion string
+}
+
+func (e *ConfigError) Error() string {
+	message := e.Message
+	if e.Path != "" {
+		message = e.Path + ": " + message
+	}
+	if e.Line > 0 {
+		message = fmt.Sprintf("line %d column %d: %s", e.Line, e.Column, message)
+	}
+	if e.Suggestion != "" {
+		message += fmt.Sprintf(", did you mean `%s`?", e.Suggestion)
+	}
+
+	return message
+}
+
+var (
+	yamlErrorLinePrefix = regexp.MustCompile(`^(yaml: )?(unmarshal errors:\s*)?(line \d+: )?`)
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType        = reflect.TypeFor[time.Duration]()
+)
+
+// Fields of a struct by their YAML names, including the fields of structs
+// inlined into it
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for field := range t.Fields() {
+		name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if options == "inline" && field.Type.Kind() == reflect.Struct {
+			maps.Copy(fields, yamlFields(field.Type))
+			continue
+		}
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+// Check every value of a configuration node against the type it is decoded
+// into, so errors can say which field is wrong and where it is
+func validateConfigNode(node *yaml.Node, t reflect.Type, path string, positions bool) []error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	configError := func(message string) *ConfigError {
+		e := &ConfigError{Path: path, Message: message}
+		if positions {
+			e.Line, e.Column = node.Line, node.Column
+		}
+		return e
+	}
+
+	// Types which decode themselves are checked by decoding the node
+	_, hasUnmarshalYAML := reflect.PointerTo(t).MethodByName("UnmarshalYAML")
+	if hasUnmarshalYAML || reflect.PointerTo(t).Implements(textUnmarshalerType) ||
+		(t.Kind() != reflect.Struct && t.Kind() != reflect.Slice && t.Kind() != reflect.Map) {
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			if t == durationType {
+				return []error{configError(fmt.Sprintf("invalid duration `%s`, expected e.g. 30s or 1m30s", node.Value))}
+			}
+
+			message := err.Error()
+			var typeError *yaml.TypeError
+			if errors.As(err, &typeError) && len(typeError.Errors) > 0 {
+				message = typeError.Errors[0]
+			}
+			return []error{configError(yamlErrorLinePrefix.ReplaceAllString(message, ""))}
+		}
+		return nil
+	}
+
+	if node.Tag == "!!null" {
+		return nil
+	}
+
+	errs := []error{}
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return []error{configError("expected a mapping")}
+		}
+
+		fields := yamlFields(t)
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := key.Value
+			if path != "" {
+				fieldPath = path + "." + key.Value
+			}
+
+			fieldType, exists := fields[key.Value]
+			if !exists {
+				e := &ConfigError{
+					Path:       fieldPath,
+					Message:    "unknown field",
+					Suggestion: closestField(key.Value, fields),
+				}
+				if positions {
+					e.Line, e.Column = key.Line, key.Column
+				}
+				errs = append(errs, e)
+				continue
+			}
+
+			errs = append(errs, validateConfigNode(value, fieldType, fieldPath, positions)...)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return []error{configError("expected a list")}
+		}
+
+		for i, item := range node.Content {
+			errs = append(errs, validateConfigNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), positions)...)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return []error{configError("expected a mapping")}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			fieldPath := node.Content[i].Value
+			if path != "" {
+				fieldPath = path + "." + fieldPath
+			}
+			errs = append(errs, validateConfigNode(node.Content[i+1], t.Elem(), fieldPath, positions)...)
+		}
+	}
+
+	return errs
+}
+
+// Find the known field closest to an unknown field name, returns an empty
+// string when none is close enough to be a likely typo
+func closestField(name string, fields map[string]reflect.Type) string {
+	closest := ""
+	closestDistance := max(2, len(name)/3) + 1
+	for field := range fields {
+		if distance := editDistance(name, field); distance < closestDistance {
+			closest, closestDistance = field, distance
+		}
+	}
+
+	return closest
+}
+
+// Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
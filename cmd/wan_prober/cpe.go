@@ -0,0 +1,474 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+const (
+	cpeProtocolNATPMP = "nat-pmp"
+	cpeProtocolUPnP   = "upnp"
+
+	natPMPPort = 5351
+	ssdpAddr   = "239.255.255.250:1900"
+
+	// Lifetime of the port mapping made to test mapping capability
+	cpeTestMappingLifetime = 60
+)
+
+var (
+	// External reachability state of checked interfaces
+	cpeStatusMap sync.Map
+
+	cgnatPrefix = netip.MustParsePrefix("100.64.0.0/10")
+
+	errNoIGD = errors.New("no UPnP internet gateway device found")
+)
+
+// Check the external IP and port mapping capability of the CPE of an interface
+// with NAT-PMP or UPnP, comparing it to the public IP observed from outside
+func runCPECheck(ctx context.Context, iface Interface, timeout time.Duration) {
+	config := *iface.CPE
+
+	dialer := net.Dialer{
+		Timeout: timeout,
+		Control: netbind.BindToDevice(iface.Name),
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext:       dialer.DialContext,
+		},
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	previous := api.CPEStatus{}
+	for {
+		status := checkCPE(ctx, iface, config, client, timeout)
+
+		if status.Error != "" {
+			logger.Warn(
+				"Error checking CPE",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"error",
+				status.Error,
+			)
+		} else if status.CGNAT != previous.CGNAT || status.PortMapping != previous.PortMapping ||
+			status.ExternalIP != previous.ExternalIP || status.ASN != previous.ASN {
+			logger.Info(
+				"CPE external reachability changed",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"protocol",
+				status.Protocol,
+				"external_ip",
+				status.ExternalIP,
+				"public_ip",
+				status.PublicIP,
+				"port_mapping",
+				status.PortMapping,
+				"cgnat",
+				status.CGNAT,
+				"asn",
+				status.ASN,
+				"as_organization",
+				status.ASOrganization,
+				"country",
+				status.Country,
+			)
+		}
+
+		cpeStatusMap.Store(iface.Name, status)
+		previous = status
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkCPE(
+	ctx context.Context,
+	iface Interface,
+	config CPECheckConfig,
+	client *http.Client,
+	timeout time.Duration,
+) api.CPEStatus {
+	status := api.CPEStatus{
+		LastCheck: time.Now().Unix(),
+	}
+
+	gateway := config.Gateway
+	if !gateway.IsValid() {
+		var err error
+		gateway, err = interfaceGateway(iface.Name)
+		if err != nil {
+			status.Error = err.Error()
+			return status
+		}
+	}
+
+	var externalIP netip.Addr
+	var err error
+
+	if config.Protocol == "" || config.Protocol == cpeProtocolNATPMP {
+		status.Protocol = cpeProtocolNATPMP
+		externalIP, status.PortMapping, err = checkNATPMP(iface.Name, gateway, timeout)
+	}
+	if config.Protocol == cpeProtocolUPnP || (config.Protocol == "" && err != nil) {
+		status.Protocol = cpeProtocolUPnP
+		externalIP, status.PortMapping, err = checkUPnP(ctx, iface.Name, client, timeout)
+	}
+	if err != nil {
+		status.Error = err.Error()
+		return status
+	}
+	status.ExternalIP = externalIP.String()
+
+	// Addresses which aren't routable on the internet mean there is another
+	// layer of NAT in front of the CPE
+	status.CGNAT = cgnatPrefix.Contains(externalIP) || externalIP.IsPrivate()
+
+	// Prefer the IP we are seen from, the CPE's may not be public
+	seenIP := externalIP
+
+	if config.PublicIPURL != "" {
+		publicIP, err := fetchPublicIP(ctx, client, config.PublicIPURL)
+		if err != nil {
+			status.Error = fmt.Sprintf("could not fetch public IP: %s", err)
+			return status
+		}
+		status.PublicIP = publicIP.String()
+		seenIP = publicIP
+
+		if publicIP != externalIP {
+			status.CGNAT = true
+		}
+	}
+
+	if geoIP != nil {
+		annotation := geoIP.Annotate(seenIP)
+		status.ASN = annotation.ASN
+		status.ASOrganization = annotation.ASOrganization
+		status.Country = annotation.Country
+	}
+
+	return status
+}
+
+// Fetch the public IP address we are seen from as plain text
+func fetchPublicIP(ctx context.Context, client *http.Client, url string) (netip.Addr, error) {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 256))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	return addr.Unmap(), nil
+}
+
+// Send a NAT-PMP request to the gateway and wait for its response
+func natPMPRequest(iface string, gateway netip.Addr, request []byte, timeout time.Duration) ([]byte, error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+		Control: netbind.BindToDevice(iface),
+	}
+
+	conn, err := dialer.Dial("udp", netip.AddrPortFrom(gateway, natPMPPort).String())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 16)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, fmt.Errorf("no NAT-PMP response from gateway: %w", err)
+	}
+	response = response[:n]
+
+	if len(response) < 8 || response[0] != 0 || response[1] != request[1]+128 {
+		return nil, errors.New("invalid NAT-PMP response from gateway")
+	}
+	if result := binary.BigEndian.Uint16(response[2:4]); result != 0 {
+		return response, fmt.Errorf("NAT-PMP request failed with result code %d", result)
+	}
+
+	return response, nil
+}
+
+// Query the external address with NAT-PMP and test whether a
+// port mapping can be made
+func checkNATPMP(iface string, gateway netip.Addr, timeout time.Duration) (netip.Addr, bool, error) {
+	response, err := natPMPRequest(iface, gateway, []byte{0, 0}, timeout)
+	if err != nil {
+		return netip.Addr{}, false, err
+	}
+	if len(response) < 12 {
+		return netip.Addr{}, false, errors.New("short NAT-PMP response from gateway")
+	}
+	externalIP := netip.AddrFrom4([4]byte(response[8:12]))
+
+	port := uint16(40000 + rand.IntN(20000))
+	mapping := make([]byte, 12)
+	mapping[1] = 2
+	binary.BigEndian.PutUint16(mapping[4:6], port)
+	binary.BigEndian.PutUint16(mapping[6:8], port)
+	binary.BigEndian.PutUint32(mapping[8:12], cpeTestMappingLifetime)
+
+	if _, err := natPMPRequest(iface, gateway, mapping, timeout); err != nil {
+		logger.Debug("NAT-PMP port mapping failed", "interface", iface, "error", err.Error())
+		return externalIP, false, nil
+	}
+
+	// Remove the test mapping again
+	binary.BigEndian.PutUint16(mapping[6:8], 0)
+	binary.BigEndian.PutUint32(mapping[8:12], 0)
+	natPMPRequest(iface, gateway, mapping, timeout)
+
+	return externalIP, true, nil
+}
+
+// Find the control URL of the WAN connection service of an internet
+// gateway device with SSDP
+func discoverIGD(ctx context.Context, iface string, client *http.Client, timeout time.Duration) (string, string, error) {
+	listenConfig := net.ListenConfig{
+		Control: netbind.BindToDevice(iface),
+	}
+	conn, err := listenConfig.ListenPacket(ctx, "udp4", ":0")
+	if err != nil {
+		return "", "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+
+	addr, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := conn.WriteTo([]byte(search), addr); err != nil {
+		return "", "", err
+	}
+
+	buf := make([]byte, 2048)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return "", "", errNoIGD
+	}
+
+	response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid SSDP response: %w", err)
+	}
+	location := response.Header.Get("Location")
+	if location == "" {
+		return "", "", errNoIGD
+	}
+
+	descriptionResponse, err := client.Get(location)
+	if err != nil {
+		return "", "", fmt.Errorf("could not fetch IGD description: %w", err)
+	}
+	defer descriptionResponse.Body.Close()
+
+	description := struct {
+		Services []struct {
+			ServiceType string `xml:"serviceType"`
+			ControlURL  string `xml:"controlURL"`
+		} `xml:"device>deviceList>device>deviceList>device>serviceList>service"`
+	}{}
+	if err := xml.NewDecoder(descriptionResponse.Body).Decode(&description); err != nil {
+		return "", "", fmt.Errorf("could not decode IGD description: %w", err)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, service := range description.Services {
+		if strings.Contains(service.ServiceType, "WANIPConnection") ||
+			strings.Contains(service.ServiceType, "WANPPPConnection") {
+			controlURL, err := base.Parse(service.ControlURL)
+			if err != nil {
+				return "", "", err
+			}
+			return controlURL.String(), service.ServiceType, nil
+		}
+	}
+
+	return "", "", errNoIGD
+}
+
+// Call an action of a UPnP service, returning the response body
+func upnpAction(
+	ctx context.Context,
+	client *http.Client,
+	controlURL string,
+	serviceType string,
+	action string,
+	arguments string,
+) ([]byte, error) {
+	envelope := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" ` +
+		`s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>` +
+		`<u:` + action + ` xmlns:u="` + serviceType + `">` + arguments + `</u:` + action + `>` +
+		`</s:Body></s:Envelope>`
+
+	request, err := http.NewRequestWithContext(ctx, "POST", controlURL, strings.NewReader(envelope))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", `"`+serviceType+`#`+action+`"`)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return body, fmt.Errorf("UPnP %s failed with status %d", action, response.StatusCode)
+	}
+
+	return body, nil
+}
+
+// Query the external address with UPnP IGD and test whether a
+// port mapping can be made
+func checkUPnP(ctx context.Context, iface string, client *http.Client, timeout time.Duration) (netip.Addr, bool, error) {
+	controlURL, serviceType, err := discoverIGD(ctx, iface, client, timeout)
+	if err != nil {
+		return netip.Addr{}, false, err
+	}
+
+	body, err := upnpAction(ctx, client, controlURL, serviceType, "GetExternalIPAddress", "")
+	if err != nil {
+		return netip.Addr{}, false, err
+	}
+
+	externalIPResponse := struct {
+		ExternalIP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}{}
+	if err := xml.Unmarshal(body, &externalIPResponse); err != nil {
+		return netip.Addr{}, false, fmt.Errorf("could not decode UPnP response: %w", err)
+	}
+	externalIP, err := netip.ParseAddr(strings.TrimSpace(externalIPResponse.ExternalIP))
+	if err != nil {
+		return netip.Addr{}, false, fmt.Errorf("invalid external IP from UPnP: %w", err)
+	}
+
+	addrs, err := interfaceIPv4Addresses(iface)
+	if err != nil || len(addrs) == 0 {
+		return externalIP, false, nil
+	}
+
+	port := fmt.Sprint(40000 + rand.IntN(20000))
+	_, err = upnpAction(
+		ctx,
+		client,
+		controlURL,
+		serviceType,
+		"AddPortMapping",
+		"<NewRemoteHost></NewRemoteHost>"+
+			"<NewExternalPort>"+port+"</NewExternalPort>"+
+			"<NewProtocol>UDP</NewProtocol>"+
+			"<NewInternalPort>"+port+"</NewInternalPort>"+
+			"<NewInternalClient>"+addrs[0].String()+"</NewInternalClient>"+
+			"<NewEnabled>1</NewEnabled>"+
+			"<NewPortMappingDescription>wan-prober</NewPortMappingDescription>"+
+			fmt.Sprintf("<NewLeaseDuration>%d</NewLeaseDuration>", cpeTestMappingLifetime),
+	)
+	if err != nil {
+		logger.Debug("UPnP port mapping failed", "interface", iface, "error", err.Error())
+		return externalIP, false, nil
+	}
+
+	// Remove the test mapping again
+	upnpAction(
+		ctx,
+		client,
+		controlURL,
+		serviceType,
+		"DeletePortMapping",
+		"<NewRemoteHost></NewRemoteHost>"+
+			"<NewExternalPort>"+port+"</NewExternalPort>"+
+			"<NewProtocol>UDP</NewProtocol>",
+	)
+
+	return externalIP, true, nil
+}
+
+// Look up the CPE reachability state of an interface, returns nil if it isn't checked
+func cpeStatus(iface string) *api.CPEStatus {
+	val, exists := cpeStatusMap.Load(iface)
+	if !exists {
+		return nil
+	}
+
+	switch v := val.(type) {
+	case api.CPEStatus:
+		return &v
+	}
+
+	return nil
+}
@@ -0,0 +1,609 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// D-Bus protocol constants (D-Bus specification)
+const (
+	dbusMethodCall   = 1
+	dbusMethodReturn = 2
+	dbusError        = 3
+	dbusSignal       = 4
+
+	dbusFieldPath        = 1
+	dbusFieldInterface   = 2
+	dbusFieldMember      = 3
+	dbusFieldErrorName   = 4
+	dbusFieldReplySerial = 5
+	dbusFieldDestination = 6
+	dbusFieldSender      = 7
+	dbusFieldSignature   = 8
+
+	dbusSystemBusAddress = "unix:path=/run/dbus/system_bus_socket"
+
+	// Longest time a method call may take, as in libdbus
+	dbusCallTimeout = 25 * time.Second
+)
+
+type dbusObjectPath string
+
+type dbusSignature string
+
+// Value of a variant with its signature
+type dbusVariant struct {
+	Signature string
+	Value     any
+}
+
+// Error returned by a method call
+type dbusCallError struct {
+	Name    string
+	Message string
+}
+
+func (e dbusCallError) Error() string {
+	return e.Name + ": " + e.Message
+}
+
+type dbusMessage struct {
+	Type   byte
+	Flags  byte
+	Serial uint32
+	Fields map[byte]any
+	// Values of the body, as described by the signature field
+	Body []any
+}
+
+func (m dbusMessage) field(code byte) string {
+	switch value := m.Fields[code].(type) {
+	case string:
+		return value
+	case dbusObjectPath:
+		return string(value)
+	case dbusSignature:
+		return string(value)
+	}
+
+	return ""
+}
+
+// Connection to a message bus
+type dbusConn struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	serial uint32
+	// Unique name the bus assigned to the connection
+	name string
+}
+
+// Connect to the system bus, authenticating as the user of the process
+func dialSystemBus() (*dbusConn, error) {
+	address := os.Getenv("DBUS_SYSTEM_BUS_ADDRESS")
+	if address == "" {
+		address = dbusSystemBusAddress
+	}
+
+	return dialDBus(address)
+}
+
+// Connect to a message bus at a unix socket address
+func dialDBus(address string) (*dbusConn, error) {
+	path := ""
+	for _, part := range strings.Split(strings.TrimPrefix(address, "unix:"), ",") {
+		if value, found := strings.CutPrefix(part, "path="); found {
+			path = value
+		}
+	}
+	if !strings.HasPrefix(address, "unix:") || path == "" {
+		return nil, fmt.Errorf("unsupported D-Bus address: %s", address)
+	}
+
+	conn, err := net.DialTimeout("unix", path, dbusCallTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &dbusConn{conn: conn, reader: bufio.NewReader(conn)}
+	if err := c.authenticate(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("D-Bus authentication failed: %w", err)
+	}
+
+	reply, err := c.Call("org.freedesktop.DBus", "/org/freedesktop/DBus", "org.freedesktop.DBus", "Hello", "")
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(reply) > 0 {
+		c.name, _ = reply[0].(string)
+	}
+
+	return c, nil
+}
+
+// Authenticate with the EXTERNAL mechanism, which the bus checks against
+// the credentials of the socket
+func (c *dbusConn) authenticate() error {
+	c.conn.SetDeadline(time.Now().Add(dbusCallTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	uid := hex.EncodeToString([]byte(strconv.Itoa(os.Getuid())))
+	if _, err := fmt.Fprintf(c.conn, "\x00AUTH EXTERNAL %s\r\n", uid); err != nil {
+		return err
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK ") {
+		return errors.New(strings.TrimSpace(line))
+	}
+
+	_, err = io.WriteString(c.conn, "BEGIN\r\n")
+	return err
+}
+
+func (c *dbusConn) Close() error {
+	return c.conn.Close()
+}
+
+// Call a method and wait for its reply, signature describes args
+func (c *dbusConn) Call(
+	destination string,
+	path dbusObjectPath,
+	iface string,
+	member string,
+	signature string,
+	args ...any,
+) ([]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.serial += 1
+	serial := c.serial
+
+	message := dbusMessage{
+		Type:   dbusMethodCall,
+		Serial: serial,
+		Fields: map[byte]any{
+			dbusFieldPath:        path,
+			dbusFieldInterface:   iface,
+			dbusFieldMember:      member,
+			dbusFieldDestination: destination,
+		},
+		Body: args,
+	}
+	if signature != "" {
+		message.Fields[dbusFieldSignature] = dbusSignature(signature)
+	}
+
+	c.conn.SetDeadline(time.Now().Add(dbusCallTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	if _, err := c.conn.Write(encodeDBusMessage(message)); err != nil {
+		return nil, err
+	}
+
+	for {
+		reply, err := readDBusMessage(c.reader)
+		if err != nil {
+			return nil, err
+		}
+		if replySerial, _ := reply.Fields[dbusFieldReplySerial].(uint32); replySerial != serial {
+			// Signals and other messages aren't of interest
+			continue
+		}
+
+		switch reply.Type {
+		case dbusMethodReturn:
+			return reply.Body, nil
+		case dbusError:
+			callErr := dbusCallError{Name: reply.field(dbusFieldErrorName)}
+			if len(reply.Body) > 0 {
+				callErr.Message, _ = reply.Body[0].(string)
+			}
+			return nil, callErr
+		}
+	}
+}
+
+// Send a message without waiting for a reply, such as a signal or the
+// reply to a method call
+func (c *dbusConn) Send(message dbusMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.serial += 1
+	message.Serial = c.serial
+
+	c.conn.SetWriteDeadline(time.Now().Add(dbusCallTimeout))
+	defer c.conn.SetWriteDeadline(time.Time{})
+
+	_, err := c.conn.Write(encodeDBusMessage(message))
+	return err
+}
+
+// Read the next message sent to the connection. Replies are read by Call,
+// so only services which make no more calls read messages themselves.
+func (c *dbusConn) Read() (dbusMessage, error) {
+	return readDBusMessage(c.reader)
+}
+
+// Encode a message in little endian byte order
+func encodeDBusMessage(message dbusMessage) []byte {
+	body := &dbusEncoder{}
+	for _, sig := range splitDBusSignature(message.field(dbusFieldSignature)) {
+		body.encode(sig, message.Body[0])
+		message.Body = message.Body[1:]
+	}
+
+	fields := []any{}
+	for _, code := range []byte{
+		dbusFieldPath,
+		dbusFieldInterface,
+		dbusFieldMember,
+		dbusFieldErrorName,
+		dbusFieldReplySerial,
+		dbusFieldDestination,
+		dbusFieldSender,
+		dbusFieldSignature,
+	} {
+		if value, exists := message.Fields[code]; exists {
+			fields = append(fields, []any{code, dbusVariant{Signature: dbusSignatureOf(value), Value: value}})
+		}
+	}
+
+	header := &dbusEncoder{}
+	header.buf = append(header.buf, 'l', message.Type, message.Flags, 1)
+	header.encode("u", uint32(len(body.buf)))
+	header.encode("u", message.Serial)
+	header.encode("a(yv)", fields)
+	header.align(8)
+
+	return append(header.buf, body.buf...)
+}
+
+// Read a message of either byte order
+func readDBusMessage(reader io.Reader) (dbusMessage, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(reader, fixed); err != nil {
+		return dbusMessage{}, err
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if fixed[0] == 'B' {
+		order = binary.BigEndian
+	}
+	bodyLength := order.Uint32(fixed[4:8])
+	fieldsLength := order.Uint32(fixed[12:16])
+	headerLength := (16 + fieldsLength + 7) &^ 7
+	if headerLength+bodyLength > 1<<27 {
+		return dbusMessage{}, errors.New("D-Bus message too long")
+	}
+
+	data := make([]byte, headerLength+bodyLength)
+	copy(data, fixed)
+	if _, err := io.ReadFull(reader, data[16:]); err != nil {
+		return dbusMessage{}, err
+	}
+
+	message := dbusMessage{
+		Type:   fixed[1],
+		Flags:  fixed[2],
+		Serial: order.Uint32(fixed[8:12]),
+		Fields: map[byte]any{},
+	}
+
+	decoder := &dbusDecoder{data: data[:headerLength], offset: 12, order: order}
+	fields, err := decoder.decode("a(yv)")
+	if err != nil {
+		return message, fmt.Errorf("invalid D-Bus header: %w", err)
+	}
+	for _, field := range fields.([]any) {
+		entry := field.([]any)
+		message.Fields[entry[0].(byte)] = entry[1].(dbusVariant).Value
+	}
+
+	// Offsets in the body are aligned from the start of the message, which
+	// the 8 byte aligned header keeps intact
+	decoder = &dbusDecoder{data: data, offset: int(headerLength), order: order}
+	for _, sig := range splitDBusSignature(message.field(dbusFieldSignature)) {
+		value, err := decoder.decode(sig)
+		if err != nil {
+			return message, fmt.Errorf("invalid D-Bus body: %w", err)
+		}
+		message.Body = append(message.Body, value)
+	}
+
+	return message, nil
+}
+
+// Split a signature into its single complete types
+func splitDBusSignature(signature string) []string {
+	types := []string{}
+	for signature != "" {
+		end := dbusTypeEnd(signature)
+		types = append(types, signature[:end])
+		signature = signature[end:]
+	}
+
+	return types
+}
+
+// Length of the first single complete type of a signature
+func dbusTypeEnd(signature string) int {
+	switch signature[0] {
+	case 'a':
+		return 1 + dbusTypeEnd(signature[1:])
+	case '(', '{':
+		depth := 0
+		for i, c := range signature {
+			switch c {
+			case '(', '{':
+				depth += 1
+			case ')', '}':
+				depth -= 1
+				if depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(signature)
+	}
+
+	return 1
+}
+
+// Signature of a basic value
+func dbusSignatureOf(value any) string {
+	switch value.(type) {
+	case byte:
+		return "y"
+	case bool:
+		return "b"
+	case int32:
+		return "i"
+	case uint32:
+		return "u"
+	case int64:
+		return "x"
+	case uint64:
+		return "t"
+	case float64:
+		return "d"
+	case dbusObjectPath:
+		return "o"
+	case dbusSignature:
+		return "g"
+	case dbusVariant:
+		return "v"
+	}
+
+	return "s"
+}
+
+func dbusAlignment(signature string) int {
+	switch signature[0] {
+	case 'y', 'g', 'v':
+		return 1
+	case 'n', 'q':
+		return 2
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	}
+
+	return 4
+}
+
+// Marshals values in little endian byte order
+type dbusEncoder struct {
+	buf []byte
+}
+
+func (e *dbusEncoder) align(n int) {
+	for len(e.buf)%n != 0 {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+// Marshal a value of a single complete type, arrays and structs are
+// []any and dict entries []any of their key and value
+func (e *dbusEncoder) encode(signature string, value any) {
+	e.align(dbusAlignment(signature))
+
+	switch signature[0] {
+	case 'y':
+		e.buf = append(e.buf, value.(byte))
+	case 'b':
+		v := uint32(0)
+		if value.(bool) {
+			v = 1
+		}
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, v)
+	case 'i':
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(value.(int32)))
+	case 'u':
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, value.(uint32))
+	case 'x':
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, uint64(value.(int64)))
+	case 't':
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, value.(uint64))
+	case 'd':
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(value.(float64)))
+	case 's', 'o':
+		s := fmt.Sprint(value)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, uint32(len(s)))
+		e.buf = append(append(e.buf, s...), 0)
+	case 'g':
+		s := fmt.Sprint(value)
+		e.buf = append(append(append(e.buf, byte(len(s))), s...), 0)
+	case 'v':
+		variant := value.(dbusVariant)
+		e.encode("g", variant.Signature)
+		e.encode(variant.Signature, variant.Value)
+	case 'a':
+		elem := signature[1:]
+		lengthAt := len(e.buf)
+		e.buf = append(e.buf, 0, 0, 0, 0)
+		// Padding before the first element isn't part of the length
+		e.align(dbusAlignment(elem))
+		start := len(e.buf)
+		for _, item := range value.([]any) {
+			e.encode(elem, item)
+		}
+		binary.LittleEndian.PutUint32(e.buf[lengthAt:], uint32(len(e.buf)-start))
+	case '(', '{':
+		items := value.([]any)
+		for i, sig := range splitDBusSignature(signature[1 : len(signature)-1]) {
+			e.encode(sig, items[i])
+		}
+	}
+}
+
+// Unmarshals values of a message
+type dbusDecoder struct {
+	data   []byte
+	offset int
+	order  binary.ByteOrder
+}
+
+func (d *dbusDecoder) take(n int) ([]byte, error) {
+	if d.offset+n > len(d.data) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.offset : d.offset+n]
+	d.offset += n
+
+	return b, nil
+}
+
+// Unmarshal a value of a single complete type
+func (d *dbusDecoder) decode(signature string) (any, error) {
+	n := dbusAlignment(signature)
+	d.offset = (d.offset + n - 1) / n * n
+
+	switch signature[0] {
+	case 'y':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0], nil
+	case 'b', 'i', 'u', 'h':
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		v := d.order.Uint32(b)
+		switch signature[0] {
+		case 'b':
+			return v != 0, nil
+		case 'i':
+			return int32(v), nil
+		}
+		return v, nil
+	case 'n', 'q':
+		b, err := d.take(2)
+		if err != nil {
+			return nil, err
+		}
+		return d.order.Uint16(b), nil
+	case 'x', 't', 'd':
+		b, err := d.take(8)
+		if err != nil {
+			return nil, err
+		}
+		v := d.order.Uint64(b)
+		switch signature[0] {
+		case 'x':
+			return int64(v), nil
+		case 'd':
+			return math.Float64frombits(v), nil
+		}
+		return v, nil
+	case 's', 'o':
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.take(int(d.order.Uint32(b)) + 1)
+		if err != nil {
+			return nil, err
+		}
+		if signature[0] == 'o' {
+			return dbusObjectPath(s[:len(s)-1]), nil
+		}
+		return string(s[:len(s)-1]), nil
+	case 'g':
+		b, err := d.take(1)
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.take(int(b[0]) + 1)
+		if err != nil {
+			return nil, err
+		}
+		return dbusSignature(s[:len(s)-1]), nil
+	case 'v':
+		sig, err := d.decode("g")
+		if err != nil {
+			return nil, err
+		}
+		if sig == dbusSignature("") {
+			return nil, errors.New("empty variant signature")
+		}
+		value, err := d.decode(string(sig.(dbusSignature)))
+		if err != nil {
+			return nil, err
+		}
+		return dbusVariant{Signature: string(sig.(dbusSignature)), Value: value}, nil
+	case 'a':
+		b, err := d.take(4)
+		if err != nil {
+			return nil, err
+		}
+		elem := signature[1:]
+		n := dbusAlignment(elem)
+		d.offset = (d.offset + n - 1) / n * n
+		end := d.offset + int(d.order.Uint32(b))
+		if end > len(d.data) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		items := []any{}
+		for d.offset < end {
+			item, err := d.decode(elem)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case '(', '{':
+		items := []any{}
+		for _, sig := range splitDBusSignature(signature[1 : len(signature)-1]) {
+			item, err := d.decode(sig)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("unsupported D-Bus type %c", signature[0])
+}
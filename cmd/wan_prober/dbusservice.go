@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dbusServiceDefaultName = "com.adaricorp.WANProber"
+	dbusServicePath        = dbusObjectPath("/com/adaricorp/WANProber")
+	dbusInterfacesPath     = dbusServicePath + "/interfaces"
+	// Interfaces of the service's objects
+	dbusManagerInterface = "com.adaricorp.WANProber"
+	dbusStatusInterface  = "com.adaricorp.WANProber.Interface"
+
+	dbusPropertiesInterface     = "org.freedesktop.DBus.Properties"
+	dbusIntrospectableInterface = "org.freedesktop.DBus.Introspectable"
+	dbusPeerInterface           = "org.freedesktop.DBus.Peer"
+
+	// Don't wait in the queue for a name which is already owned
+	dbusNameFlagDoNotQueue    = 4
+	dbusNameReplyPrimaryOwner = 1
+	// Caller of a method doesn't want its reply
+	dbusFlagNoReplyExpected = 1
+
+	dbusServiceReconnectInterval = 30 * time.Second
+)
+
+// Introspection data of the service's interfaces
+const dbusServiceIntrospection = `  <interface name="org.freedesktop.DBus.Introspectable">
+    <method name="Introspect">
+      <arg name="xml_data" type="s" direction="out"/>
+    </method>
+  </interface>
+  <interface name="org.freedesktop.DBus.Peer">
+    <method name="Ping"/>
+  </interface>
+  <interface name="org.freedesktop.DBus.Properties">
+    <method name="Get">
+      <arg name="interface_name" type="s" direction="in"/>
+      <arg name="property_name" type="s" direction="in"/>
+      <arg name="value" type="v" direction="out"/>
+    </method>
+    <method name="GetAll">
+      <arg name="interface_name" type="s" direction="in"/>
+      <arg name="properties" type="a{sv}" direction="out"/>
+    </method>
+    <signal name="PropertiesChanged">
+      <arg name="interface_name" type="s"/>
+      <arg name="changed_properties" type="a{sv}"/>
+      <arg name="invalidated_properties" type="as"/>
+    </signal>
+  </interface>
+`
+
+const dbusManagerIntrospection = `  <interface name="com.adaricorp.WANProber">
+    <property name="Interfaces" type="ao" access="read"/>
+  </interface>
+`
+
+const dbusStatusIntrospection = `  <interface name="com.adaricorp.WANProber.Interface">
+    <property name="Name" type="s" access="read"/>
+    <property name="Description" type="s" access="read"/>
+    <property name="DisplayName" type="s" access="read"/>
+    <property name="Healthy" type="b" access="read"/>
+    <property name="Reason" type="s" access="read"/>
+    <property name="LastChange" type="x" access="read"/>
+    <property name="LastProbe" type="x" access="read"/>
+    <signal name="StateChanged">
+      <arg name="interface" type="s"/>
+      <arg name="healthy" type="b"/>
+      <arg name="reason" type="s"/>
+    </signal>
+  </interface>
+`
+
+// Serves the status of interfaces on a message bus, with an object for
+// each interface whose properties are its latest status
+type dbusService struct {
+	config DBusServiceConfig
+	ifaces []Interface
+
+	mu sync.Mutex
+	// Connection to the bus, nil while disconnected
+	conn *dbusConn
+}
+
+func newDBusService(config DBusServiceConfig, ifaces []Interface) *dbusService {
+	return &dbusService{config: config, ifaces: ifaces}
+}
+
+// Serve status until a context is done, reconnecting whenever the
+// connection to the bus is lost
+func (s *dbusService) run(ctx context.Context) {
+	for {
+		if err := s.session(ctx); err != nil && ctx.Err() == nil {
+			logger.Warn(
+				"D-Bus service connection failed",
+				"address",
+				s.config.Address,
+				"name",
+				s.config.Name,
+				"error",
+				err.Error(),
+			)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dbusServiceReconnectInterval):
+		}
+	}
+}
+
+// Connect to the bus, own the service name and answer method calls until
+// the connection is lost or a context is done
+func (s *dbusService) session(ctx context.Context) error {
+	conn, err := dialDBus(s.config.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reply, err := conn.Call(
+		"org.freedesktop.DBus",
+		"/org/freedesktop/DBus",
+		"org.freedesktop.DBus",
+		"RequestName",
+		"su",
+		s.config.Name,
+		uint32(dbusNameFlagDoNotQueue),
+	)
+	if err != nil {
+		return err
+	}
+	if len(reply) == 0 || reply[0] != uint32(dbusNameReplyPrimaryOwner) {
+		return fmt.Errorf("name %s is owned by another connection", s.config.Name)
+	}
+
+	logger.Info(
+		"Serving status on D-Bus",
+		"address",
+		s.config.Address,
+		"name",
+		s.config.Name,
+	)
+
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn = nil
+		s.mu.Unlock()
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			// Unblock the read below
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		message, err := conn.Read()
+		if err != nil {
+			return err
+		}
+		if message.Type == dbusMethodCall {
+			s.handle(conn, message)
+		}
+	}
+}
+
+// Answer a method call
+func (s *dbusService) handle(conn *dbusConn, call dbusMessage) {
+	path := dbusObjectPath(call.field(dbusFieldPath))
+	iface := call.field(dbusFieldInterface)
+	member := call.field(dbusFieldMember)
+
+	var err error
+	switch {
+	case member == "Introspect" && (iface == "" || iface == dbusIntrospectableInterface):
+		err = s.reply(conn, call, "s", s.introspect(path))
+	case member == "Ping" && (iface == "" || iface == dbusPeerInterface):
+		err = s.reply(conn, call, "")
+	case iface == dbusPropertiesInterface:
+		err = s.handleProperties(conn, call, path, member)
+	default:
+		err = s.replyError(
+			conn,
+			call,
+			"org.freedesktop.DBus.Error.UnknownMethod",
+			fmt.Sprintf("No method %s of interface %s", member, iface),
+		)
+	}
+
+	if err != nil {
+		logger.Warn("Error answering D-Bus method call", "member", member, "error", err.Error())
+	}
+}
+
+// Answer a method call of the properties interface
+func (s *dbusService) handleProperties(conn *dbusConn, call dbusMessage, path dbusObjectPath, member string) error {
+	properties, iface, exists := s.properties(path)
+	if !exists {
+		return s.replyError(
+			conn,
+			call,
+			"org.freedesktop.DBus.Error.UnknownObject",
+			fmt.Sprintf("No object at %s", path),
+		)
+	}
+
+	requested := ""
+	if len(call.Body) > 0 {
+		requested, _ = call.Body[0].(string)
+	}
+	if requested != "" && requested != iface {
+		return s.replyError(
+			conn,
+			call,
+			"org.freedesktop.DBus.Error.UnknownInterface",
+			fmt.Sprintf("No interface %s at %s", requested, path),
+		)
+	}
+
+	switch member {
+	case "GetAll":
+		return s.reply(conn, call, "a{sv}", dbusPropertyDict(properties))
+	case "Get":
+		name := ""
+		if len(call.Body) > 1 {
+			name, _ = call.Body[1].(string)
+		}
+		value, exists := properties[name]
+		if !exists {
+			return s.replyError(
+				conn,
+				call,
+				"org.freedesktop.DBus.Error.UnknownProperty",
+				fmt.Sprintf("No property %s of interface %s", name, iface),
+			)
+		}
+		return s.reply(conn, call, "v", value)
+	case "Set":
+		return s.replyError(
+			conn,
+			call,
+			"org.freedesktop.DBus.Error.PropertyReadOnly",
+			"Properties are read-only",
+		)
+	}
+
+	return s.replyError(
+		conn,
+		call,
+		"org.freedesktop.DBus.Error.UnknownMethod",
+		fmt.Sprintf("No method %s of interface %s", member, dbusPropertiesInterface),
+	)
+}
+
+// Properties of the object at a path, and the interface they belong to
+func (s *dbusService) properties(path dbusObjectPath) (map[string]dbusVariant, string, bool) {
+	if path == dbusServicePath {
+		paths := []any{}
+		for _, path := range s.objectPaths() {
+			paths = append(paths, path)
+		}
+
+		return map[string]dbusVariant{
+			"Interfaces": {Signature: "ao", Value: paths},
+		}, dbusManagerInterface, true
+	}
+
+	for _, iface := range s.ifaces {
+		if dbusInterfacePath(iface.Name) == path {
+			return dbusStatusProperties(iface), dbusStatusInterface, true
+		}
+	}
+
+	return nil, "", false
+}
+
+// Properties of the object of an interface, from its latest status
+func dbusStatusProperties(iface Interface) map[string]dbusVariant {
+	status, _ := interfaceStates.Load(iface.Name)
+
+	return map[string]dbusVariant{
+		"Name":        {Signature: "s", Value: iface.Name},
+		"Description": {Signature: "s", Value: iface.Description},
+		"DisplayName": {Signature: "s", Value: iface.displayName()},
+		"Healthy":     {Signature: "b", Value: status.Healthy},
+		"Reason":      {Signature: "s", Value: status.Reason},
+		"LastChange":  {Signature: "x", Value: status.LastChange},
+		"LastProbe":   {Signature: "x", Value: status.LastProbe},
+	}
+}
+
+// Introspection data of the object at a path, which lists the objects
+// below it so tools can walk the tree from the root
+func (s *dbusService) introspect(path dbusObjectPath) string {
+	data := &strings.Builder{}
+	data.WriteString(`<!DOCTYPE node PUBLIC "-//freedesktop//DTD D-BUS Object Introspection 1.0//EN"` + "\n")
+	data.WriteString(`"http://www.freedesktop.org/standards/dbus/1.0/introspect.dtd">` + "\n")
+	data.WriteString("<node>\n")
+
+	if _, iface, exists := s.properties(path); exists {
+		data.WriteString(dbusServiceIntrospection)
+		if iface == dbusManagerInterface {
+			data.WriteString(dbusManagerIntrospection)
+		} else {
+			data.WriteString(dbusStatusIntrospection)
+		}
+	}
+
+	prefix := strings.TrimSuffix(string(path), "/") + "/"
+	children := []string{}
+	for _, object := range append([]dbusObjectPath{dbusServicePath}, s.objectPaths()...) {
+		if rest, found := strings.CutPrefix(string(object), prefix); found {
+			child, _, _ := strings.Cut(rest, "/")
+			if !slices.Contains(children, child) {
+				children = append(children, child)
+			}
+		}
+	}
+	for _, child := range children {
+		fmt.Fprintf(data, "  <node name=\"%s\"/>\n", child)
+	}
+
+	data.WriteString("</node>\n")
+
+	return data.String()
+}
+
+// Paths of the objects of interfaces
+func (s *dbusService) objectPaths() []dbusObjectPath {
+	paths := []dbusObjectPath{}
+	for _, iface := range s.ifaces {
+		paths = append(paths, dbusInterfacePath(iface.Name))
+	}
+
+	return paths
+}
+
+// Reply to a method call, unless the caller doesn't want a reply
+func (s *dbusService) reply(conn *dbusConn, call dbusMessage, signature string, body ...any) error {
+	if call.Flags&dbusFlagNoReplyExpected != 0 {
+		return nil
+	}
+
+	message := dbusMessage{
+		Type: dbusMethodReturn,
+		Fields: map[byte]any{
+			dbusFieldReplySerial: call.Serial,
+			dbusFieldDestination: call.field(dbusFieldSender),
+		},
+		Body: body,
+	}
+	if signature != "" {
+		message.Fields[dbusFieldSignature] = dbusSignature(signature)
+	}
+
+	return conn.Send(message)
+}
+
+// Reply to a method call with an error
+func (s *dbusService) replyError(conn *dbusConn, call dbusMessage, name string, text string) error {
+	if call.Flags&dbusFlagNoReplyExpected != 0 {
+		return nil
+	}
+
+	return conn.Send(dbusMessage{
+		Type: dbusError,
+		Fields: map[byte]any{
+			dbusFieldErrorName:   name,
+			dbusFieldReplySerial: call.Serial,
+			dbusFieldDestination: call.field(dbusFieldSender),
+			dbusFieldSignature:   dbusSignature("s"),
+		},
+		Body: []any{text},
+	})
+}
+
+// Signal a state transition of an interface, with StateChanged and the
+// properties it changed
+func (s *dbusService) stateChanged(status InterfaceStatus) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		// Clients read the current state once the service reconnects
+		return
+	}
+
+	path := dbusInterfacePath(status.Name)
+	current, _ := interfaceStates.Load(status.Name)
+
+	signals := []dbusMessage{
+		{
+			Type: dbusSignal,
+			Fields: map[byte]any{
+				dbusFieldPath:      path,
+				dbusFieldInterface: dbusStatusInterface,
+				dbusFieldMember:    "StateChanged",
+				dbusFieldSignature: dbusSignature("sbs"),
+			},
+			Body: []any{status.Name, status.Healthy, status.Reason},
+		},
+		{
+			Type: dbusSignal,
+			Fields: map[byte]any{
+				dbusFieldPath:      path,
+				dbusFieldInterface: dbusPropertiesInterface,
+				dbusFieldMember:    "PropertiesChanged",
+				dbusFieldSignature: dbusSignature("sa{sv}as"),
+			},
+			Body: []any{
+				dbusStatusInterface,
+				dbusPropertyDict(map[string]dbusVariant{
+					"Healthy":    {Signature: "b", Value: status.Healthy},
+					"Reason":     {Signature: "s", Value: status.Reason},
+					"LastChange": {Signature: "x", Value: current.LastChange},
+				}),
+				[]any{},
+			},
+		},
+	}
+
+	for _, signal := range signals {
+		if err := conn.Send(signal); err != nil {
+			logger.Warn(
+				"Error sending D-Bus signal",
+				"interface",
+				status.Name,
+				"error",
+				err.Error(),
+			)
+			return
+		}
+	}
+}
+
+// Properties as an a{sv} dictionary, sorted by name
+func dbusPropertyDict(properties map[string]dbusVariant) []any {
+	names := []string{}
+	for name := range properties {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	dict := []any{}
+	for _, name := range names {
+		dict = append(dict, []any{name, properties[name]})
+	}
+
+	return dict
+}
+
+// Object path of an interface. Object paths only allow letters, digits and
+// underscores, so other bytes are escaped as _ and two hex digits.
+func dbusInterfacePath(name string) dbusObjectPath {
+	escaped := &strings.Builder{}
+	for _, b := range []byte(name) {
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(escaped, "_%02x", b)
+		}
+	}
+	if escaped.Len() == 0 {
+		escaped.WriteByte('_')
+	}
+
+	return dbusInterfacesPath + "/" + dbusObjectPath(escaped.String())
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+	"github.com/miekg/dns"
+)
+
+// DNS providers whose records can be updated
+const (
+	dynamicDNSRFC2136    = "rfc2136"
+	dynamicDNSCloudflare = "cloudflare"
+	dynamicDNSRoute53    = "route53"
+	dynamicDNSDeSEC      = "desec"
+)
+
+const (
+	actionDynamicDNS = "dynamic_dns"
+
+	defaultDynamicDNSTTL         = 60
+	defaultDynamicDNSInterval    = 5 * time.Minute
+	defaultDynamicDNSPublicIPURL = "https://api.ipify.org"
+	defaultTSIGAlgorithm         = "hmac-sha256"
+
+	// How long to wait before retrying an update which failed
+	dynamicDNSRetryInterval = 30 * time.Second
+	// Longest time fetching the public IP or updating a provider may take
+	dynamicDNSTimeout = 30 * time.Second
+)
+
+const (
+	cloudflareAPIURL = "https://api.cloudflare.com/client/v4"
+	route53APIURL    = "https://route53.amazonaws.com"
+	deSECAPIURL      = "https://desec.io/api/v1"
+)
+
+// Points hostnames at the public IP of the most preferred healthy
+// interface, so inbound connections follow failovers
+type dynamicDNSUpdater struct {
+	mu      sync.Mutex
+	config  DynamicDNSConfig
+	healthy map[string]bool
+	wake    chan struct{}
+	// Address the records last pointed at
+	published netip.Addr
+}
+
+// Record set at a DNS provider
+type dnsRecordSet struct {
+	name       string
+	recordType string
+	ttl        int
+	// Value in zone file presentation, e.g. quoted for TXT records
+	value string
+}
+
+// Check the settings of a DNS provider used by a section of the
+// configuration, filling in defaults
+func validateDNSProvider(config *DNSProviderConfig, section string) error {
+	switch config.Provider {
+	case dynamicDNSRFC2136:
+		if config.Server == "" || config.Zone == "" {
+			return fmt.Errorf("%s provider rfc2136 needs a server and a zone", section)
+		}
+		if _, _, err := net.SplitHostPort(config.Server); err != nil {
+			config.Server = net.JoinHostPort(config.Server, "53")
+		}
+		if config.TSIGKey != "" && config.TSIGAlgorithm == "" {
+			config.TSIGAlgorithm = defaultTSIGAlgorithm
+		}
+	case dynamicDNSCloudflare, dynamicDNSDeSEC:
+		if config.Token == "" || config.Zone == "" {
+			return fmt.Errorf("%s provider %s needs a token and a zone", section, config.Provider)
+		}
+	case dynamicDNSRoute53:
+		if config.AccessKeyID == "" || config.SecretAccessKey == "" || config.Zone == "" {
+			return fmt.Errorf("%s provider route53 needs an access_key_id, secret_access_key and zone", section)
+		}
+	default:
+		return fmt.Errorf(
+			"invalid %s provider %s, must be %s, %s, %s or %s",
+			section,
+			config.Provider,
+			dynamicDNSRFC2136,
+			dynamicDNSCloudflare,
+			dynamicDNSRoute53,
+			dynamicDNSDeSEC,
+		)
+	}
+
+	return nil
+}
+
+// Check the dynamic DNS configuration, filling in defaults
+func validateDynamicDNS(config *DynamicDNSConfig, interfaces []string) error {
+	if len(config.Hostnames) == 0 {
+		return errors.New("dynamic_dns needs hostnames")
+	}
+
+	if err := validateDNSProvider(&config.DNSProviderConfig, "dynamic_dns"); err != nil {
+		return err
+	}
+
+	for _, iface := range config.Interfaces {
+		if !slices.Contains(interfaces, iface) {
+			return fmt.Errorf("dynamic_dns has unknown interface %s", iface)
+		}
+	}
+	if len(config.Interfaces) == 0 {
+		config.Interfaces = interfaces
+	}
+
+	if config.TTL == 0 {
+		config.TTL = defaultDynamicDNSTTL
+	}
+	if config.Interval == 0 {
+		config.Interval = defaultDynamicDNSInterval
+	}
+	if config.PublicIPURL == "" {
+		config.PublicIPURL = defaultDynamicDNSPublicIPURL
+	}
+
+	return nil
+}
+
+func newDynamicDNSUpdater(config DynamicDNSConfig) *dynamicDNSUpdater {
+	return &dynamicDNSUpdater{
+		config:  config,
+		healthy: map[string]bool{},
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Record the health of an interface, the records are checked in the
+// background when it changed
+func (u *dynamicDNSUpdater) update(iface string, healthy bool) {
+	if u == nil {
+		return
+	}
+
+	u.mu.Lock()
+	if previous, exists := u.healthy[iface]; exists && previous == healthy {
+		u.mu.Unlock()
+		return
+	}
+	u.healthy[iface] = healthy
+	u.mu.Unlock()
+
+	u.wakeUp()
+}
+
+// Check the records without waiting for the interval, e.g. when they
+// weren't updated while this instance was standby
+func (u *dynamicDNSUpdater) wakeUp() {
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Update the records when the active interface or its public IP changes,
+// checking again every interval so address changes of the active
+// interface are picked up
+func (u *dynamicDNSUpdater) run(ctx context.Context) {
+	timer := time.NewTimer(u.config.Interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-u.wake:
+		case <-timer.C:
+		}
+
+		interval := u.config.Interval
+		// Skipped updates are made when this instance becomes active
+		if err := u.sync(ctx); err != nil && !errors.Is(err, errActionSkipped) {
+			interval = dynamicDNSRetryInterval
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(interval)
+	}
+}
+
+// Point the records at the public IP of the active interface, when they
+// don't already
+func (u *dynamicDNSUpdater) sync(ctx context.Context) error {
+	u.mu.Lock()
+	active := ""
+	for _, iface := range u.config.Interfaces {
+		if u.healthy[iface] {
+			active = iface
+			break
+		}
+	}
+	u.mu.Unlock()
+
+	if active == "" {
+		// Leave the records alone, there is nowhere better to point them
+		return nil
+	}
+
+	client := dynamicDNSClient(active)
+
+	addr, err := activePublicIP(ctx, client, active, u.config.PublicIPURL)
+	if err != nil {
+		logger.Warn(
+			"Error finding public IP for dynamic DNS",
+			"interface",
+			active,
+			"error",
+			err.Error(),
+		)
+		return err
+	}
+	if addr == u.published {
+		return nil
+	}
+
+	for _, hostname := range u.config.Hostnames {
+		description := fmt.Sprintf("point %s at %s with %s", hostname, addr, u.config.Provider)
+		err := performAction(ctx, actionDynamicDNS, active, description, func(ctx context.Context) error {
+			timeout, cancel := context.WithTimeout(ctx, dynamicDNSTimeout)
+			defer cancel()
+
+			return changeDNSRecord(timeout, client, active, u.config.DNSProviderConfig, dnsRecordSet{
+				name:       hostname,
+				recordType: addressRecordType(addr),
+				ttl:        u.config.TTL,
+				value:      addr.String(),
+			}, false)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	u.published = addr
+
+	return nil
+}
+
+// HTTP client sending requests through an interface
+func dynamicDNSClient(iface string) *http.Client {
+	dialer := net.Dialer{
+		Timeout: dynamicDNSTimeout,
+		Control: netbind.BindToDevice(iface),
+	}
+
+	return &http.Client{
+		Timeout: dynamicDNSTimeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext:       dialer.DialContext,
+			Proxy:             http.ProxyFromEnvironment,
+		},
+	}
+}
+
+// Public IP of an interface, as seen by its CPE check when there is one,
+// otherwise fetched through the interface
+func activePublicIP(ctx context.Context, client *http.Client, iface string, publicIPURL string) (netip.Addr, error) {
+	if status := cpeStatus(iface); status != nil && status.PublicIP != "" {
+		if addr, err := netip.ParseAddr(status.PublicIP); err == nil {
+			return addr, nil
+		}
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, dynamicDNSTimeout)
+	defer cancel()
+
+	return fetchPublicIP(timeout, client, publicIPURL)
+}
+
+// DNS record type holding an address
+func addressRecordType(addr netip.Addr) string {
+	if addr.Is6() {
+		return "AAAA"
+	}
+
+	return "A"
+}
+
+// Replace a record set with the provider, or delete it
+func changeDNSRecord(
+	ctx context.Context,
+	client *http.Client,
+	iface string,
+	config DNSProviderConfig,
+	set dnsRecordSet,
+	remove bool,
+) error {
+	switch config.Provider {
+	case dynamicDNSRFC2136:
+		return rfc2136Update(ctx, iface, config, set, remove)
+	case dynamicDNSCloudflare:
+		return cloudflareUpdate(ctx, client, config, set, remove)
+	case dynamicDNSRoute53:
+		return route53Update(ctx, client, config, set, remove)
+	case dynamicDNSDeSEC:
+		return deSECUpdate(ctx, client, config, set, remove)
+	}
+
+	return fmt.Errorf("invalid DNS provider %s", config.Provider)
+}
+
+// Replace or delete the record set with a dynamic update (RFC 2136), signed
+// with TSIG (RFC 8945) when a key is configured
+func rfc2136Update(ctx context.Context, iface string, config DNSProviderConfig, set dnsRecordSet, remove bool) error {
+	name := dns.Fqdn(set.name)
+
+	msg := new(dns.Msg)
+	msg.SetUpdate(dns.Fqdn(config.Zone))
+	msg.RemoveRRset([]dns.RR{&dns.ANY{Hdr: dns.RR_Header{
+		Name:   name,
+		Rrtype: dns.StringToType[set.recordType],
+		Class:  dns.ClassINET,
+	}}})
+	if !remove {
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, set.ttl, set.recordType, set.value))
+		if err != nil {
+			return err
+		}
+		msg.Insert([]dns.RR{rr})
+	}
+
+	client := dns.Client{
+		Net: "tcp",
+		Dialer: &net.Dialer{
+			Timeout: dynamicDNSTimeout,
+			Control: netbind.BindToDevice(iface),
+		},
+	}
+	if config.TSIGKey != "" {
+		key := dns.Fqdn(config.TSIGKey)
+		msg.SetTsig(key, dns.Fqdn(config.TSIGAlgorithm), 300, time.Now().Unix())
+		client.TsigSecret = map[string]string{key: config.TSIGSecret}
+	}
+
+	response, _, err := client.ExchangeContext(ctx, msg, config.Server)
+	if err != nil {
+		return err
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update refused: %s", dns.RcodeToString[response.Rcode])
+	}
+
+	return nil
+}
+
+// Send a request to a JSON API and decode its response
+func jsonRequest(
+	ctx context.Context,
+	client *http.Client,
+	method string,
+	url string,
+	headers map[string]string,
+	body any,
+	result any,
+) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	contents, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s %s responded with status %d: %s", method, url, response.StatusCode, contents)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(contents, result)
+}
+
+// Update the record through the Cloudflare API, creating it when missing,
+// or delete it
+func cloudflareUpdate(
+	ctx context.Context,
+	client *http.Client,
+	config DNSProviderConfig,
+	set dnsRecordSet,
+	remove bool,
+) error {
+	headers := map[string]string{"Authorization": "Bearer " + config.Token}
+	records := cloudflareAPIURL + "/zones/" + url.PathEscape(config.Zone) + "/dns_records"
+	name := strings.TrimSuffix(set.name, ".")
+
+	list := struct {
+		Result []struct {
+			ID string `json:"id"`
+		} `json:"result"`
+	}{}
+	query := url.Values{"type": {set.recordType}, "name": {name}}
+	if err := jsonRequest(ctx, client, "GET", records+"?"+query.Encode(), headers, nil, &list); err != nil {
+		return err
+	}
+
+	if remove {
+		for _, record := range list.Result {
+			if err := jsonRequest(ctx, client, "DELETE", records+"/"+url.PathEscape(record.ID), headers, nil, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	record := map[string]any{
+		"type":    set.recordType,
+		"name":    name,
+		"content": set.value,
+		"ttl":     set.ttl,
+	}
+	if len(list.Result) == 0 {
+		return jsonRequest(ctx, client, "POST", records, headers, record, nil)
+	}
+
+	return jsonRequest(ctx, client, "PATCH", records+"/"+url.PathEscape(list.Result[0].ID), headers, record, nil)
+}
+
+// Replace or delete the record set through the deSEC API
+func deSECUpdate(ctx context.Context, client *http.Client, config DNSProviderConfig, set dnsRecordSet, remove bool) error {
+	domain := strings.TrimSuffix(config.Zone, ".")
+	subname := strings.TrimSuffix(strings.TrimSuffix(set.name, "."), domain)
+	if subname != "" && !strings.HasSuffix(subname, ".") {
+		return fmt.Errorf("hostname %s is not in zone %s", set.name, domain)
+	}
+	subname = strings.TrimSuffix(subname, ".")
+
+	// An empty record set deletes it
+	values := []string{}
+	if !remove {
+		values = append(values, set.value)
+	}
+	rrsets := []map[string]any{{
+		"subname": subname,
+		"type":    set.recordType,
+		"ttl":     set.ttl,
+		"records": values,
+	}}
+
+	return jsonRequest(
+		ctx,
+		client,
+		"PATCH",
+		deSECAPIURL+"/domains/"+url.PathEscape(domain)+"/rrsets/",
+		map[string]string{"Authorization": "Token " + config.Token},
+		rrsets,
+		nil,
+	)
+}
+
+// Route 53 ChangeResourceRecordSets request
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"https://route53.amazonaws.com/doc/2013-04-01/ ChangeResourceRecordSetsRequest"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53Change struct {
+	Action    string                   `xml:"Action"`
+	RecordSet route53ResourceRecordSet `xml:"ResourceRecordSet"`
+}
+
+type route53ResourceRecordSet struct {
+	Name   string   `xml:"Name"`
+	Type   string   `xml:"Type"`
+	TTL    int      `xml:"TTL"`
+	Values []string `xml:"ResourceRecords>ResourceRecord>Value"`
+}
+
+// Upsert or delete the record set through the Route 53 API, deleting needs
+// the same TTL and value as the record set
+func route53Update(ctx context.Context, client *http.Client, config DNSProviderConfig, set dnsRecordSet, remove bool) error {
+	action := "UPSERT"
+	if remove {
+		action = "DELETE"
+	}
+	change := route53ChangeRequest{
+		Changes: []route53Change{{
+			Action: action,
+			RecordSet: route53ResourceRecordSet{
+				Name:   dns.Fqdn(set.name),
+				Type:   set.recordType,
+				TTL:    set.ttl,
+				Values: []string{set.value},
+			},
+		}},
+	}
+
+	body, err := xml.Marshal(change)
+	if err != nil {
+		return err
+	}
+	body = append([]byte(xml.Header), body...)
+
+	zone := strings.TrimPrefix(config.Zone, "/hostedzone/")
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		route53APIURL+"/2013-04-01/hostedzone/"+url.PathEscape(zone)+"/rrset/",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "text/xml")
+	signAWSRequest(request, body, config.AccessKeyID, config.SecretAccessKey, "us-east-1", "route53", time.Now())
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		contents, _ := io.ReadAll(io.LimitReader(response.Body, 4096))
+		return fmt.Errorf("route53 responded with status %d: %s", response.StatusCode, contents)
+	}
+
+	return nil
+}
+
+// Sign a request with AWS Signature Version 4
+func signAWSRequest(
+	request *http.Request,
+	body []byte,
+	accessKeyID string,
+	secretAccessKey string,
+	region string,
+	service string,
+	now time.Time,
+) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	request.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		"host:" + request.URL.Host,
+		"x-amz-content-sha256:" + hex.EncodeToString(payloadHash[:]),
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	request.Header.Set(
+		"Authorization",
+		fmt.Sprintf(
+			"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+			accessKeyID,
+			scope,
+			signedHeaders,
+			hex.EncodeToString(key),
+		),
+	)
+}
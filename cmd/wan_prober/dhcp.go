@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+)
+
+const (
+	dhcpPollInterval = 10 * time.Second
+)
+
+var (
+	networkdLeasesPath = "/run/systemd/netif/leases"
+
+	// DHCP state of monitored interfaces
+	dhcpStatusMap sync.Map
+
+	errNoLease = errors.New("no DHCP lease found for interface")
+)
+
+// A DHCP lease read from a lease file
+type dhcpLease struct {
+	Address netip.Addr
+	DNS     netip.Addr
+	Renew   time.Time
+	Expires time.Time
+}
+
+// Read the current lease of an interface from a configured lease file,
+// systemd-networkd lease state or dhclient lease files
+func readDHCPLease(iface string, leaseFile string) (dhcpLease, error) {
+	if leaseFile != "" {
+		return parseLeaseFile(leaseFile)
+	}
+
+	if link, err := net.InterfaceByName(iface); err == nil {
+		lease, err := parseNetworkdLease(filepath.Join(networkdLeasesPath, strconv.Itoa(link.Index)))
+		if err == nil {
+			return lease, nil
+		}
+	}
+
+	for _, glob := range dhclientLeasesGlobs {
+		leaseFiles, _ := filepath.Glob(fmt.Sprintf(glob, iface))
+		for _, leaseFile := range leaseFiles {
+			if lease, err := parseDhclientLease(leaseFile); err == nil {
+				return lease, nil
+			}
+		}
+	}
+
+	return dhcpLease{}, errNoLease
+}
+
+// Parse a lease file in either systemd-networkd or dhclient format
+func parseLeaseFile(path string) (dhcpLease, error) {
+	lease, err := parseNetworkdLease(path)
+	if err == nil {
+		return lease, nil
+	}
+
+	return parseDhclientLease(path)
+}
+
+// Parse a systemd-networkd lease file, which holds KEY=value lines
+func parseNetworkdLease(path string) (dhcpLease, error) {
+	lease := dhcpLease{}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return lease, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return lease, err
+	}
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+
+		switch key {
+		case "ADDRESS":
+			lease.Address, _ = netip.ParseAddr(value)
+		case "DNS":
+			lease.DNS, _ = netip.ParseAddr(strings.Fields(value + " ")[0])
+		case "T1", "LIFETIME":
+			// Times are relative to when the lease was written
+			seconds, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+			at := info.ModTime().Add(time.Duration(seconds) * time.Second)
+			if key == "T1" {
+				lease.Renew = at
+			} else {
+				lease.Expires = at
+			}
+		}
+	}
+
+	if !lease.Address.IsValid() {
+		return lease, errNoLease
+	}
+
+	return lease, nil
+}
+
+// Parse the most recent lease in a dhclient lease file
+func parseDhclientLease(path string) (dhcpLease, error) {
+	lease := dhcpLease{}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return lease, err
+	}
+
+	// Later leases in the file are more recent
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSuffix(strings.TrimSpace(line), ";")
+
+		if line == "lease {" {
+			lease = dhcpLease{}
+		} else if value, found := strings.CutPrefix(line, "fixed-address "); found {
+			lease.Address, _ = netip.ParseAddr(value)
+		} else if value, found := strings.CutPrefix(line, "option domain-name-servers "); found {
+			first, _, _ := strings.Cut(value, ",")
+			lease.DNS, _ = netip.ParseAddr(strings.TrimSpace(first))
+		} else if value, found := strings.CutPrefix(line, "renew "); found {
+			lease.Renew = parseDhclientTime(value)
+		} else if value, found := strings.CutPrefix(line, "expire "); found {
+			lease.Expires = parseDhclientTime(value)
+		}
+	}
+
+	if !lease.Address.IsValid() {
+		return lease, errNoLease
+	}
+
+	return lease, nil
+}
+
+// Parse a dhclient lease time like "4 2026/10/15 12:00:00" which is in UTC
+func parseDhclientTime(value string) time.Time {
+	fields := strings.Fields(value)
+	if len(fields) != 3 {
+		return time.Time{}
+	}
+
+	t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// Find the IPv4 addresses currently assigned to an interface
+func interfaceIPv4Addresses(iface string) ([]netip.Addr, error) {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := link.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ipv4Addrs := []netip.Addr{}
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().Is4() && prefix.Addr().IsGlobalUnicast() {
+			ipv4Addrs = append(ipv4Addrs, prefix.Addr())
+		}
+	}
+
+	return ipv4Addrs, nil
+}
+
+// Watch the DHCP lease and addresses of an interface, recording
+// lease renewals, expiry and address changes
+func runDHCPMonitor(ctx context.Context, iface Interface) {
+	status := api.DHCPStatus{}
+
+	ticker := time.NewTicker(dhcpPollInterval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+
+		addrs, err := interfaceIPv4Addresses(iface.Name)
+		if err != nil {
+			logger.Debug(
+				"Could not read interface addresses",
+				"interface",
+				iface.Name,
+				"error",
+				err.Error(),
+			)
+		}
+
+		lease, leaseErr := readDHCPLease(iface.Name, iface.DHCP.LeaseFile)
+
+		address := ""
+		if leaseErr == nil {
+			address = lease.Address.String()
+
+			if !lease.Expires.IsZero() {
+				expires := lease.Expires.Unix()
+				if status.LeaseExpires != nil && expires > *status.LeaseExpires {
+					status.LastRenewal = now.Unix()
+					logger.Info(
+						"DHCP lease renewed",
+						"interface",
+						iface.Name,
+						"address",
+						address,
+						"expires",
+						lease.Expires.Format(time.RFC3339),
+					)
+				}
+				status.LeaseExpires = &expires
+			}
+		} else if len(addrs) > 0 {
+			// Clients like udhcpc don't keep a lease file, so fall back
+			// to the address assigned to the interface
+			address = addrs[0].String()
+		}
+
+		// The lease is held while its address is assigned and it hasn't expired
+		assigned := false
+		for _, addr := range addrs {
+			if addr.String() == address {
+				assigned = true
+			}
+		}
+		expired := leaseErr == nil && !lease.Expires.IsZero() && now.After(lease.Expires)
+		lost := address == "" || !assigned || expired
+
+		if address != status.Address {
+			if status.LastAddressChange != 0 || status.Address != "" {
+				logger.Warn(
+					"DHCP address changed",
+					"interface",
+					iface.Name,
+					"old_address",
+					status.Address,
+					"new_address",
+					address,
+				)
+			}
+			status.Address = address
+			status.LastAddressChange = now.Unix()
+		}
+
+		if lost != status.Lost {
+			if lost {
+				logger.Warn("DHCP lease lost", "interface", iface.Name, "address", address)
+			} else {
+				logger.Info("DHCP lease acquired", "interface", iface.Name, "address", address)
+			}
+			status.Lost = lost
+		}
+
+		dhcpStatusMap.Store(iface.Name, status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Look up the DHCP state of an interface, returns nil if it isn't monitored
+func dhcpStatus(iface string) *api.DHCPStatus {
+	val, exists := dhcpStatusMap.Load(iface)
+	if !exists {
+		return nil
+	}
+
+	switch v := val.(type) {
+	case api.DHCPStatus:
+		return &v
+	}
+
+	return nil
+}
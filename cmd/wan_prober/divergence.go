@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/notify"
+	"github.com/adaricorp/wan-prober/probe"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dnsDivergences = &dnsDivergenceTracker{
+		diverged: map[string]map[string]bool{},
+	}
+
+	dnsDivergence = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_dns_divergence",
+			Help: "Whether the host and fallback resolvers answered with no address in common for a target.",
+		},
+		[]string{"interface", "target"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(dnsDivergence)
+}
+
+// Remembers which targets resolve differently with the host and fallback
+// resolvers of each interface, sending an event when divergence starts
+type dnsDivergenceTracker struct {
+	mu        sync.Mutex
+	sendEvent func(notify.Event)
+	diverged  map[string]map[string]bool
+}
+
+// Set where to send divergence events
+func (t *dnsDivergenceTracker) configure(sendEvent func(notify.Event)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sendEvent = sendEvent
+}
+
+// Record the comparison of the answers for a target resolved through an
+// interface
+func (t *dnsDivergenceTracker) observe(
+	iface Interface,
+	target string,
+	comparison probe.ResolverComparison,
+	now time.Time,
+) {
+	dnsDivergence.WithLabelValues(iface.displayName(), target).Set(boolGauge(comparison.Diverged))
+
+	t.mu.Lock()
+	if t.diverged[iface.Name] == nil {
+		t.diverged[iface.Name] = map[string]bool{}
+	}
+	previous := t.diverged[iface.Name][target]
+	t.diverged[iface.Name][target] = comparison.Diverged
+	sendEvent := t.sendEvent
+	t.mu.Unlock()
+
+	if !comparison.Diverged || previous {
+		return
+	}
+
+	hostAddrs := joinAddrs(comparison.HostAddrs)
+	fallbackAddrs := joinAddrs(comparison.FallbackAddrs)
+
+	logger.Warn(
+		"Host and fallback DNS resolvers disagree about target",
+		"interface",
+		iface.Name,
+		"description",
+		iface.Description,
+		"target",
+		target,
+		"host_addresses",
+		hostAddrs,
+		"resolver",
+		comparison.Resolver,
+		"fallback_addresses",
+		fallbackAddrs,
+	)
+
+	if sendEvent != nil {
+		sendEvent(notify.Event{
+			Type:              notify.EventDNSDivergence,
+			Interface:         iface.Name,
+			Description:       iface.Description,
+			DisplayName:       iface.DisplayName,
+			Target:            target,
+			HostAddresses:     hostAddrs,
+			FallbackResolver:  comparison.Resolver,
+			FallbackAddresses: fallbackAddrs,
+			Since:             now.Unix(),
+			Time:              now.Unix(),
+		})
+	}
+}
+
+// Comma separated list of addresses
+func joinAddrs(addrs []netip.Addr) string {
+	strs := []string{}
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+
+	return strings.Join(strs, ",")
+}
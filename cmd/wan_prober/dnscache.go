@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+const (
+	defaultDNSCacheSaveInterval = 5 * time.Minute
+)
+
+// Internal DNS cache as stored on disk
+type dnsCacheFile struct {
+	Entries map[string]dnsCacheFileEntry `json:"entries"`
+}
+
+type dnsCacheFileEntry struct {
+	Addrs []netip.Addr `json:"addresses"`
+	Time  time.Time    `json:"time"`
+}
+
+// Fill the internal DNS cache from a file saved by an earlier run, so
+// targets can be probed through fallback paths right after a restart
+// during a resolver outage. Entries older than the maximum age are
+// skipped, returns how many entries were loaded.
+func loadDNSCache(cache *sync.Map, path string, maxAge time.Duration, now time.Time) (int, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	file := dnsCacheFile{}
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	for target, entry := range file.Entries {
+		if maxAge > 0 && now.Sub(entry.Time) > maxAge {
+			continue
+		}
+		if _, exists := cache.Load(target); exists {
+			// Resolved since startup, which is fresher
+			continue
+		}
+
+		addrs := []net.IPAddr{}
+		for _, addr := range entry.Addrs {
+			addrs = append(addrs, net.IPAddr{IP: net.IP(addr.AsSlice()), Zone: addr.Zone()})
+		}
+		probe.StoreCacheEntry(cache, target, probe.CacheEntry{Addrs: addrs, Time: entry.Time}, footprint.dnsCacheEntries)
+		loaded += 1
+	}
+
+	return loaded, nil
+}
+
+// Write the internal DNS cache to a file, replacing it atomically
+func saveDNSCache(cache *sync.Map, path string) error {
+	file := dnsCacheFile{Entries: map[string]dnsCacheFileEntry{}}
+
+	cache.Range(func(key, val any) bool {
+		target, ok := key.(string)
+		entry, isEntry := val.(probe.CacheEntry)
+		if !ok || !isEntry {
+			return true
+		}
+
+		addrs := []netip.Addr{}
+		for _, a := range entry.Addrs {
+			if ip, ok := netip.AddrFromSlice(a.IP); ok {
+				addrs = append(addrs, ip.Unmap().WithZone(a.Zone))
+			}
+		}
+		file.Entries[target] = dnsCacheFileEntry{Addrs: addrs, Time: entry.Time}
+
+		return true
+	})
+
+	contents, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, contents)
+}
+
+// Save the internal DNS cache periodically
+func runDNSCachePersistence(ctx context.Context, cache *sync.Map, config DNSCacheConfig) {
+	ticker := time.NewTicker(config.SaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := saveDNSCache(cache, config.File); err != nil {
+			logger.Warn(
+				"Error saving DNS cache",
+				"file",
+				config.File,
+				"error",
+				err.Error(),
+			)
+		}
+	}
+}
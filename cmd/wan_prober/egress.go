@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+var (
+	// Source address the echo service last saw for each interface
+	egressAddressMap = sync.Map{}
+)
+
+// Check the egress check configuration of an interface
+func validateEgressCheck(config EgressCheckConfig) error {
+	if config.URL == "" {
+		return errors.New("egress_check needs a url")
+	}
+	if config.Header != "" && config.JSONField != "" {
+		return errors.New("egress_check can read the address from a header or a json_field, not both")
+	}
+
+	return nil
+}
+
+// Ask an echo service which source address requests sent through an
+// interface arrive from
+func checkEgress(ctx context.Context, iface string, config EgressCheckConfig, timeout time.Duration) (netip.Addr, error) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+		Control: netbind.BindToDevice(iface),
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext:       dialer.DialContext,
+		},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "GET", config.URL, nil)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return netip.Addr{}, fmt.Errorf("echo service responded with status %d", response.StatusCode)
+	}
+
+	value := ""
+	if config.Header != "" {
+		// Reflected headers such as X-Forwarded-For may list proxies
+		// after the client
+		value, _, _ = strings.Cut(response.Header.Get(config.Header), ",")
+	} else {
+		body, err := io.ReadAll(io.LimitReader(response.Body, 4096))
+		if err != nil {
+			return netip.Addr{}, err
+		}
+
+		value = string(body)
+		if config.JSONField != "" {
+			fields := map[string]any{}
+			if err := json.Unmarshal(body, &fields); err != nil {
+				return netip.Addr{}, fmt.Errorf("invalid JSON from echo service: %w", err)
+			}
+			field, ok := fields[config.JSONField].(string)
+			if !ok {
+				return netip.Addr{}, fmt.Errorf("echo service response has no %s field", config.JSONField)
+			}
+			value, _, _ = strings.Cut(field, ",")
+		}
+	}
+
+	addr, err := netip.ParseAddr(strings.TrimSpace(value))
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid address from echo service: %w", err)
+	}
+
+	return addr.Unmap(), nil
+}
+
+// Whether the source address seen for an interface is one it should use,
+// either within its expected prefixes or, when none are configured, not
+// the address last seen through another interface
+func egressExpected(iface string, config EgressCheckConfig, addr netip.Addr) (bool, string) {
+	if len(config.Expected) > 0 {
+		for _, prefix := range config.Expected {
+			if prefix.Contains(addr) {
+				return true, ""
+			}
+		}
+		return false, ""
+	}
+
+	other := ""
+	egressAddressMap.Range(func(key, val any) bool {
+		if key.(string) != iface && val.(netip.Addr) == addr {
+			other = key.(string)
+			return false
+		}
+		return true
+	})
+
+	return other == "", other
+}
+
+// Source address last seen for an interface, empty when not checked
+func egressAddress(iface string) string {
+	val, exists := egressAddressMap.Load(iface)
+	if !exists {
+		return ""
+	}
+
+	return val.(netip.Addr).String()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/internal/health"
+)
+
+var (
+	failbacks = &interfaceFailbacks{
+		held: map[string]*health.Failback{},
+	}
+)
+
+// Failback policies of interfaces, shared by their probe loops and the
+// approval API
+type interfaceFailbacks struct {
+	mu   sync.Mutex
+	held map[string]*health.Failback
+}
+
+// Record the health found by a round of an interface, returning whether
+// its healthy verdict is held back and how much of the delay remains
+func (f *interfaceFailbacks) observe(iface Interface, healthy bool, now time.Time) (bool, time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	failback, exists := f.held[iface.Name]
+	if !exists {
+		failback = &health.Failback{Delay: iface.FailbackDelay, Sticky: iface.StickyFailback}
+		f.held[iface.Name] = failback
+	}
+
+	return failback.Observe(healthy, now)
+}
+
+// Approve failing back to an interface, false when it isn't pending
+func (f *interfaceFailbacks) approve(iface string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	failback, exists := f.held[iface]
+	if !exists {
+		return false
+	}
+
+	return failback.Approve()
+}
+
+// Failback of an interface which traffic hasn't returned to yet, if any
+func pendingFailback(iface string) *api.PendingFailback {
+	failbacks.mu.Lock()
+	defer failbacks.mu.Unlock()
+
+	failback, exists := failbacks.held[iface]
+	if !exists || !failback.Pending() {
+		return nil
+	}
+
+	pending := &api.PendingFailback{
+		Interface: iface,
+		Approved:  failback.Approved(),
+	}
+	if since := failback.HealthySince(); !since.IsZero() {
+		pending.HealthySince = since.Unix()
+		pending.HealthySinceRFC3339 = reportUnix(pending.HealthySince)
+	}
+
+	return pending
+}
+
+// Serve the failback API, GET lists interfaces which traffic hasn't
+// returned to yet and POST approves failing back to the interface of the
+// interface query parameter
+func registerFailbackHandler(ifaces []Interface) {
+	handleRoleFunc(roleAdmin, "/failback", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			name := r.URL.Query().Get("interface")
+			if !slices.ContainsFunc(ifaces, func(iface Interface) bool { return iface.Name == name }) {
+				http.Error(w, "Unknown interface", http.StatusBadRequest)
+				return
+			}
+
+			if !failbacks.approve(name) {
+				http.Error(w, "Interface has no pending failback", http.StatusConflict)
+				return
+			}
+
+			logger.Warn("Approved failback", "interface", name)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp := []api.PendingFailback{}
+		for _, iface := range ifaces {
+			if pending := pendingFailback(iface.Name); pending != nil {
+				resp = append(resp, *pending)
+			}
+		}
+		slices.SortFunc(resp, func(a, b api.PendingFailback) int {
+			return cmp.Compare(a.Interface, b.Interface)
+		})
+
+		writeJSON(w, r, http.StatusOK, resp)
+	})
+}
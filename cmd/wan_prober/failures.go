@@ -0,0 +1,116 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+// How a class of probe failure counts towards the health of an interface
+type failureHandling struct {
+	// Counted as the target being unreachable through the interface, rather
+	// than as an error which could be unrelated to the network connection
+	unreachable bool
+	// No more attempts are made to probe the target this round
+	final bool
+	// Wait a timeout before the next attempt, in case the error is
+	// temporary and will clear
+	backoff bool
+	// Logged when a probe fails
+	message string
+	level   slog.Level
+	// Outcome recorded in the probe result log
+	outcome string
+}
+
+var (
+	failureClasses = map[probe.Class]failureHandling{
+		probe.ClassTimeout: {
+			unreachable: true,
+			message:     "Probe target is unreachable",
+			level:       slog.LevelWarn,
+			outcome:     outcomeTimeout,
+		},
+		// It's likely the network connection is unhealthy if the host
+		// resolver and fallback resolvers aren't answering
+		probe.ClassDNSUnreachable: {
+			unreachable: true,
+			message:     "All DNS resolvers are unreachable",
+			level:       slog.LevelWarn,
+			outcome:     outcomeDNSUnreachable,
+		},
+		// Kernel tells us network is not usable
+		probe.ClassNetDown: {
+			unreachable: true,
+			final:       true,
+			message:     "Network is down or misconfigured",
+			level:       slog.LevelWarn,
+			outcome:     outcomeNetDown,
+		},
+		// NXDOMAIN can't be treated as a successful response as we don't
+		// know who answered (host resolver or fallback)
+		probe.ClassDNSNXDomain: {
+			final:   true,
+			message: "Probe target doesn't exist",
+			level:   slog.LevelWarn,
+			outcome: outcomeNXDomain,
+		},
+		// Response was modified or truncated on the way, so the network
+		// connection isn't usable as-is
+		probe.ClassBodyMismatch: {
+			unreachable: true,
+			message:     "Probe target response was modified",
+			level:       slog.LevelWarn,
+			outcome:     outcomeBodyMismatch,
+		},
+		// DNS on this connection can't be trusted
+		probe.ClassDNSSEC: {
+			unreachable: true,
+			message:     "DNSSEC validation failed",
+			level:       slog.LevelWarn,
+			outcome:     outcomeDNSSECFailure,
+		},
+		// Dish knows it has no connectivity
+		probe.ClassStarlinkOutage: {
+			unreachable: true,
+			message:     "Starlink dish reports an outage",
+			level:       slog.LevelWarn,
+			outcome:     outcomeStarlinkOutage,
+		},
+		// Target is reachable, but too lossy to be usable
+		probe.ClassPacketLoss: {
+			unreachable: true,
+			message:     "Packet loss is above threshold",
+			level:       slog.LevelWarn,
+			outcome:     outcomePacketLoss,
+		},
+		// Target is reachable, but not with full sized packets which
+		// breaks most real traffic
+		probe.ClassMTUBelowFloor: {
+			unreachable: true,
+			message:     "Path MTU is below floor",
+			level:       slog.LevelWarn,
+			outcome:     outcomeMTUBelowFloor,
+		},
+	}
+
+	// Errors during probes which could be unrelated to the health of the
+	// network connection, including SERVFAIL answers, failed TLS handshakes
+	// and unexpected HTTP statuses
+	otherFailure = failureHandling{
+		backoff: true,
+		message: "Error during probe",
+		level:   slog.LevelError,
+		outcome: outcomeError,
+	}
+)
+
+// Class of the error returned by a prober, and how it is handled
+func classifyFailure(err error) (probe.Class, failureHandling) {
+	class := probe.ErrorClass(err)
+	if handling, exists := failureClasses[class]; exists {
+		return class, handling
+	}
+
+	return class, otherFailure
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"runtime/debug"
+
+	"github.com/adaricorp/wan-prober/internal/state"
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+// Bounds on what caches and histories hold, and how the garbage collector
+// trades CPU for memory
+type footprintLimits struct {
+	// Recent admin API calls kept for /audit
+	auditEntries int
+	// Recent state transitions kept for each interface
+	stateTransitions int
+	// Targets kept in the internal DNS cache
+	dnsCacheEntries int
+	// Distinct log messages tracked for deduplication
+	dedupEntries int
+	// Default number of notifications queued for each sink
+	queuedNotifications int
+	// Default for HTTP probes reading response bodies, the prober's
+	// default is used when zero
+	maxBodyBytes int64
+	// Garbage collector target percentage and soft memory limit, left to
+	// the runtime when zero
+	gcPercent   int
+	memoryLimit int64
+}
+
+var (
+	defaultFootprint = footprintLimits{
+		auditEntries:        200,
+		stateTransitions:    state.DefaultMaxTransitions,
+		dnsCacheEntries:     probe.DefaultMaxCacheEntries,
+		dedupEntries:        1000,
+		queuedNotifications: 100,
+	}
+
+	// For routers with 128MB of memory or less, where the allocation rate
+	// of the default footprint causes GC churn
+	smallFootprint = footprintLimits{
+		auditEntries:        50,
+		stateTransitions:    5,
+		dnsCacheEntries:     64,
+		dedupEntries:        200,
+		queuedNotifications: 20,
+		maxBodyBytes:        64 * 1024,
+		gcPercent:           50,
+		memoryLimit:         48 * 1024 * 1024,
+	}
+
+	footprint = defaultFootprint
+)
+
+// Switch to the small footprint profile. The garbage collector settings
+// aren't changed when GOGC or GOMEMLIMIT are set in the environment.
+func useSmallFootprint() {
+	footprint = smallFootprint
+
+	if _, exists := os.LookupEnv("GOGC"); !exists {
+		debug.SetGCPercent(footprint.gcPercent)
+	}
+	if _, exists := os.LookupEnv("GOMEMLIMIT"); !exists {
+		debug.SetMemoryLimit(footprint.memoryLimit)
+	}
+}
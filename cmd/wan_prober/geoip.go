@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+var (
+	// Annotates public IPs when GeoIP databases are configured
+	geoIP *GeoIP
+)
+
+// Looks up the ASN and country of addresses in local MMDB files
+type GeoIP struct {
+	asn     *maxminddb.Reader
+	country *maxminddb.Reader
+}
+
+func NewGeoIP(config GeoIPConfig) (*GeoIP, error) {
+	g := &GeoIP{}
+
+	if config.ASNDatabase != "" {
+		reader, err := maxminddb.Open(config.ASNDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("could not open ASN database: %w", err)
+		}
+		g.asn = reader
+	}
+
+	if config.CountryDatabase != "" {
+		reader, err := maxminddb.Open(config.CountryDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("could not open country database: %w", err)
+		}
+		g.country = reader
+	}
+
+	return g, nil
+}
+
+type geoIPAnnotation struct {
+	ASN            uint
+	ASOrganization string
+	Country        string
+}
+
+// Look up the ASN and country of an address, fields are left empty
+// when the address isn't in a database
+func (g *GeoIP) Annotate(addr netip.Addr) geoIPAnnotation {
+	annotation := geoIPAnnotation{}
+
+	if g.asn != nil {
+		record := struct {
+			ASN          uint   `maxminddb:"autonomous_system_number"`
+			Organization string `maxminddb:"autonomous_system_organization"`
+		}{}
+		if err := g.asn.Lookup(addr).Decode(&record); err == nil {
+			annotation.ASN = record.ASN
+			annotation.ASOrganization = record.Organization
+		}
+	}
+
+	if g.country != nil {
+		record := struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+		}{}
+		if err := g.country.Lookup(addr).Decode(&record); err == nil {
+			annotation.Country = record.Country.ISOCode
+		}
+	}
+
+	return annotation
+}
+
+func (g *GeoIP) Close() {
+	if g.asn != nil {
+		g.asn.Close()
+	}
+	if g.country != nil {
+		g.country.Close()
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// How often HA state is advertised to the peer or read from the state file
+	haInterval = time.Second
+
+	defaultHAPeerTimeout = 5 * time.Second
+)
+
+var (
+	// Whether this instance is the active member of an HA pair,
+	// instances which aren't part of a pair are always active
+	haActive atomic.Bool
+
+	// Called whenever this instance becomes active
+	haActivatedMu sync.Mutex
+	haActivated   []func()
+)
+
+type haAdvertisement struct {
+	ID       string `json:"id"`
+	Priority int    `json:"priority"`
+}
+
+// Advertisement as sent, authenticated by an HMAC over it with the pair's
+// shared secret
+type haSignedAdvertisement struct {
+	Advertisement json.RawMessage `json:"advertisement"`
+	MAC           []byte          `json:"mac"`
+}
+
+// Check the HA configuration, filling in defaults
+func validateHA(config *HAConfig) error {
+	if config.StateFile != "" {
+		return nil
+	}
+
+	if config.Listen == "" || config.Peer == "" {
+		return errors.New("HA needs a state file, or a listen address and peer")
+	}
+	if config.ID == "" {
+		// Peers with the same ID and priority would both win the election
+		return errors.New("HA peer election needs an id, unique to each instance of the pair")
+	}
+	if config.Secret == "" {
+		// Otherwise anyone able to reach the listen address could make
+		// this instance standby
+		return errors.New("HA peer election needs a secret shared by the pair")
+	}
+
+	if config.PeerTimeout == 0 {
+		config.PeerTimeout = defaultHAPeerTimeout
+	}
+
+	return nil
+}
+
+// Whether an advertisement wins the election against another, by having
+// a higher priority or the lowest ID on a tie
+func (a haAdvertisement) beats(other haAdvertisement) bool {
+	return a.Priority > other.Priority || (a.Priority == other.Priority && a.ID < other.ID)
+}
+
+// Encode an advertisement for sending, with its HMAC
+func signAdvertisement(secret string, advertisement haAdvertisement) ([]byte, error) {
+	payload, err := json.Marshal(advertisement)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return json.Marshal(haSignedAdvertisement{
+		Advertisement: payload,
+		MAC:           mac.Sum(nil),
+	})
+}
+
+// Decode a received advertisement, which must have been signed with the
+// pair's secret
+func openAdvertisement(secret string, data []byte) (haAdvertisement, error) {
+	signed := haSignedAdvertisement{}
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return haAdvertisement{}, err
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(signed.Advertisement)
+	if !hmac.Equal(mac.Sum(nil), signed.MAC) {
+		return haAdvertisement{}, errors.New("advertisement isn't signed with the HA secret")
+	}
+
+	advertisement := haAdvertisement{}
+	if err := json.Unmarshal(signed.Advertisement, &advertisement); err != nil {
+		return haAdvertisement{}, err
+	}
+
+	return advertisement, nil
+}
+
+// Whether a datagram was sent from the peer's address
+func fromPeer(from net.Addr, peer *net.UDPAddr) bool {
+	sender, ok := from.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+
+	return sender.AddrPort().Addr().Unmap() == peer.AddrPort().Addr().Unmap() && sender.Port == peer.Port
+}
+
+// Call a function whenever this instance becomes the active member of its
+// HA pair, so state which wasn't applied on standby gets applied
+func onHAActivated(f func()) {
+	haActivatedMu.Lock()
+	defer haActivatedMu.Unlock()
+
+	haActivated = append(haActivated, f)
+}
+
+func setHAActive(active bool, reason string) {
+	if haActive.Swap(active) == active {
+		return
+	}
+
+	role := "standby"
+	if active {
+		role = "active"
+	}
+	logger.Warn("HA role changed", "role", role, "reason", reason)
+
+	if !active {
+		return
+	}
+
+	haActivatedMu.Lock()
+	activated := slices.Clone(haActivated)
+	haActivatedMu.Unlock()
+
+	for _, f := range activated {
+		f()
+	}
+}
+
+// Follow the state written to a file by an external VRRP daemon,
+// the instance is active while the file contains MASTER
+func runHAStateFile(ctx context.Context, path string) {
+	ticker := time.NewTicker(haInterval)
+	defer ticker.Stop()
+
+	for {
+		state, err := os.ReadFile(path)
+		if err != nil {
+			logger.Warn("Couldn't read HA state file", "path", path, "error", err.Error())
+			setHAActive(false, "state file unreadable")
+		} else {
+			setHAActive(strings.TrimSpace(string(state)) == "MASTER", "state file")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Elect an active instance by exchanging priorities with a peer over UDP,
+// the instance whose advertisement beats the other's is active, and an
+// instance which stops hearing from its peer becomes active
+func runHAPeer(ctx context.Context, config HAConfig) error {
+	conn, err := net.ListenPacket("udp", config.Listen)
+	if err != nil {
+		return err
+	}
+
+	peerAddr, err := net.ResolveUDPAddr("udp", config.Peer)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	ours := haAdvertisement{
+		ID:       config.ID,
+		Priority: config.Priority,
+	}
+	advertisement, err := signAdvertisement(config.Secret, ours)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	var (
+		peerSeen atomic.Int64
+		peerWins atomic.Bool
+	)
+
+	// Stay standby until the peer has had a chance to be heard from
+	peerSeen.Store(time.Now().UnixNano())
+	peerWins.Store(true)
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := conn.ReadFrom(buf)
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Warn("Error receiving HA advertisement", "error", err.Error())
+				}
+				return
+			}
+
+			if !fromPeer(from, peerAddr) {
+				logger.Warn("Ignoring HA advertisement from an address other than the peer", "from", from.String())
+				continue
+			}
+
+			peer, err := openAdvertisement(config.Secret, buf[:n])
+			if err != nil {
+				logger.Warn("Invalid HA advertisement", "from", from.String(), "error", err.Error())
+				continue
+			}
+
+			if peer.ID == ours.ID {
+				// Also what our own advertisements look like when the
+				// peer address loops back to us
+				logger.Warn("Ignoring HA advertisement with our own ID", "id", peer.ID)
+				continue
+			}
+
+			peerWins.Store(peer.beats(ours))
+			peerSeen.Store(time.Now().UnixNano())
+		}
+	}()
+
+	go func() {
+		defer conn.Close()
+
+		ticker := time.NewTicker(haInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := conn.WriteTo(advertisement, peerAddr); err != nil {
+				logger.Debug("Error sending HA advertisement", "error", err.Error())
+			}
+
+			if time.Since(time.Unix(0, peerSeen.Load())) > config.PeerTimeout {
+				setHAActive(true, "peer timed out")
+			} else if peerWins.Load() {
+				setHAActive(false, "peer has higher priority")
+			} else {
+				setHAActive(true, "peer has lower priority")
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidateHA(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HAConfig
+		valid  bool
+	}{
+		{"state file", HAConfig{StateFile: "/run/keepalived/wan-prober.state"}, true},
+		{"peer", HAConfig{ID: "a", Secret: "s", Listen: "127.0.0.1:8021", Peer: "127.0.0.1:8022"}, true},
+		{"peer without id", HAConfig{Secret: "s", Listen: "127.0.0.1:8021", Peer: "127.0.0.1:8022"}, false},
+		{"peer without secret", HAConfig{ID: "a", Listen: "127.0.0.1:8021", Peer: "127.0.0.1:8022"}, false},
+		{"peer without listen address", HAConfig{ID: "a", Secret: "s", Peer: "127.0.0.1:8022"}, false},
+		{"nothing", HAConfig{}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateHA(&test.config); (err == nil) != test.valid {
+				t.Errorf("validateHA(%+v) = %v, want valid %t", test.config, err, test.valid)
+			}
+		})
+	}
+}
+
+func TestValidateHADefaultPeerTimeout(t *testing.T) {
+	config := HAConfig{ID: "a", Secret: "s", Listen: "127.0.0.1:8021", Peer: "127.0.0.1:8022"}
+	if err := validateHA(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	if config.PeerTimeout != 5*time.Second {
+		t.Errorf("peer timeout = %s, want 5s", config.PeerTimeout)
+	}
+}
+
+// Exactly one of a pair wins the election
+func TestHAAdvertisementBeats(t *testing.T) {
+	tests := []struct {
+		name string
+		a    haAdvertisement
+		b    haAdvertisement
+	}{
+		{"higher priority", haAdvertisement{ID: "b", Priority: 200}, haAdvertisement{ID: "a", Priority: 100}},
+		{"lower id on a tie", haAdvertisement{ID: "a", Priority: 100}, haAdvertisement{ID: "b", Priority: 100}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.a.beats(test.b) {
+				t.Errorf("%+v doesn't beat %+v", test.a, test.b)
+			}
+			if test.b.beats(test.a) {
+				t.Errorf("%+v beats %+v", test.b, test.a)
+			}
+		})
+	}
+}
+
+func TestHAActivated(t *testing.T) {
+	active := haActive.Load()
+	activated := haActivated
+	t.Cleanup(func() {
+		haActive.Store(active)
+		haActivated = activated
+	})
+	haActive.Store(true)
+
+	calls := 0
+	onHAActivated(func() {
+		calls += 1
+	})
+
+	for _, active := range []bool{true, false, false, true, true} {
+		setHAActive(active, "test")
+	}
+
+	if calls != 1 {
+		t.Errorf("activated %d times, want 1", calls)
+	}
+}
+
+func TestOpenAdvertisement(t *testing.T) {
+	sent := haAdvertisement{ID: "a", Priority: 100}
+	signed, err := signAdvertisement("secret", sent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := openAdvertisement("secret", signed)
+	if err != nil {
+		t.Fatalf("openAdvertisement() error = %v", err)
+	}
+	if received != sent {
+		t.Errorf("openAdvertisement() = %+v, want %+v", received, sent)
+	}
+
+	if _, err := openAdvertisement("other", signed); err == nil {
+		t.Error("opened an advertisement signed with another secret")
+	}
+
+	tampered := bytes.Replace(signed, []byte("100"), []byte("999"), 1)
+	if _, err := openAdvertisement("secret", tampered); err == nil {
+		t.Error("opened an advertisement whose priority was changed")
+	}
+
+	if _, err := openAdvertisement("secret", []byte(`{"id":"b","priority":999}`)); err == nil {
+		t.Error("opened an unsigned advertisement")
+	}
+}
+
+func TestFromPeer(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 8021}
+
+	tests := []struct {
+		name string
+		from net.Addr
+		peer bool
+	}{
+		{"peer", &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 8021}, true},
+		{"peer as IPv4-mapped IPv6", &net.UDPAddr{IP: net.ParseIP("::ffff:192.0.2.2"), Port: 8021}, true},
+		{"other host", &net.UDPAddr{IP: net.ParseIP("192.0.2.3"), Port: 8021}, false},
+		{"other port", &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 9000}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if fromPeer(test.from, peer) != test.peer {
+				t.Errorf("fromPeer(%s) = %t, want %t", test.from, !test.peer, test.peer)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+// Periodically ping a heartbeat URL so that an external service
+// can alert when pings stop arriving
+func runHeartbeat(ctx context.Context, heartbeat Heartbeat, timeout time.Duration) {
+	dialer := net.Dialer{
+		Timeout: timeout,
+		Control: netbind.BindToDevice(heartbeat.Interface),
+	}
+
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext:       dialer.DialContext,
+		},
+	}
+
+	ticker := time.NewTicker(heartbeat.Interval)
+	defer ticker.Stop()
+
+	for {
+		if heartbeat.Interface == "" || interfaceHealthy(heartbeat.Interface) {
+			if err := sendHeartbeat(ctx, client, heartbeat.URL); err != nil {
+				logger.Warn(
+					"Error sending heartbeat",
+					"interface",
+					heartbeat.Interface,
+					"url",
+					heartbeat.URL,
+					"error",
+					err.Error(),
+				)
+			} else {
+				logger.Debug(
+					"Sent heartbeat",
+					"interface",
+					heartbeat.Interface,
+					"url",
+					heartbeat.URL,
+				)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendHeartbeat(ctx context.Context, client *http.Client, url string) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("heartbeat responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// Check whether the last probe of an interface found it healthy
+func interfaceHealthy(name string) bool {
+	status, exists := interfaceStates.Load(name)
+	return exists && status.Healthy
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/csv"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Data exported by the history export
+const (
+	historyResults     = "results"
+	historyTransitions = "transitions"
+	historyUptime      = "uptime"
+)
+
+// Time an interface was healthy and unhealthy in a period, from the health
+// of its rounds
+type uptimeSummary struct {
+	first       time.Time
+	last        time.Time
+	lastHealthy bool
+	healthy     time.Duration
+	unhealthy   time.Duration
+	outages     int
+	outage      time.Duration
+	longest     time.Duration
+}
+
+// Record the health of a round, the time since the previous round counts
+// towards the previous round's state unless the gap is longer than maxGap,
+// when nothing was probing
+func (u *uptimeSummary) observe(end time.Time, healthy bool, maxGap time.Duration) {
+	if u.first.IsZero() {
+		u.first = end
+	} else if gap := end.Sub(u.last); gap <= maxGap {
+		if u.lastHealthy {
+			u.healthy += gap
+		} else {
+			u.unhealthy += gap
+			u.outage += gap
+			u.longest = max(u.longest, u.outage)
+		}
+	}
+
+	if !healthy && (u.last.IsZero() || u.lastHealthy) {
+		u.outages += 1
+		u.outage = 0
+	}
+	u.last = end
+	u.lastHealthy = healthy
+}
+
+// Parse a bound of the exported period, as RFC 3339, a date or unix seconds
+func parseHistoryTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, value, reportLocation); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q, must be RFC 3339, a date like 2006-01-02 or unix seconds", value)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 0, 64)
+}
+
+// Write the history of a period read from the probe result log as CSV:
+// probe results, state transitions as probes found them, or the uptime of
+// each interface
+func writeHistoryCSV(w *csv.Writer, config Config, data string, from time.Time, to time.Time) error {
+	inPeriod := func(t time.Time) bool {
+		return !t.Before(from) && t.Before(to)
+	}
+	evaluator := newRoundEvaluator(config)
+	maxGap := 3 * (config.ProbeConfiguration.MinInterval + maxRoundJitter)
+
+	var onRecord func(record ProbeResultRecord) error
+	switch data {
+	case historyResults:
+		w.Write([]string{
+			"time",
+			"interface",
+			"target",
+			"probe",
+			"round",
+			"attempt",
+			"outcome",
+			"duration_seconds",
+			"error_class",
+			"error",
+		})
+		onRecord = func(record ProbeResultRecord) error {
+			if !inPeriod(record.Time) {
+				return nil
+			}
+			return w.Write([]string{
+				reportTime(record.Time).Format(time.RFC3339),
+				record.Interface,
+				record.Target,
+				record.Probe,
+				strconv.Itoa(record.Round),
+				strconv.Itoa(record.Attempt),
+				record.Outcome,
+				strconv.FormatFloat(record.Duration, 'f', 6, 64),
+				record.ErrorClass,
+				record.Error,
+			})
+		}
+	case historyTransitions:
+		w.Write([]string{"time", "interface", "healthy", "reason", "previous_state_duration_seconds"})
+	}
+
+	lastHealthy := map[string]bool{}
+	lastChange := map[string]time.Time{}
+	uptimes := map[string]*uptimeSummary{}
+
+	err := resultLog.read(func(paths []string) error {
+		return readResultLogs(paths, onRecord, func(ifaceName string, round *replayRound) error {
+			if data == historyResults {
+				return nil
+			}
+
+			healthy, reason := evaluator.evaluate(ifaceName, round)
+			previous, exists := lastHealthy[ifaceName]
+			lastHealthy[ifaceName] = healthy
+			since := lastChange[ifaceName]
+			if !exists || previous != healthy {
+				lastChange[ifaceName] = round.end
+			}
+
+			if !inPeriod(round.end) {
+				return nil
+			}
+
+			switch data {
+			case historyTransitions:
+				if !exists || previous == healthy {
+					return nil
+				}
+				return w.Write([]string{
+					reportTime(round.end).Format(time.RFC3339),
+					ifaceName,
+					strconv.FormatBool(healthy),
+					reason,
+					formatSeconds(round.end.Sub(since)),
+				})
+			case historyUptime:
+				if uptimes[ifaceName] == nil {
+					uptimes[ifaceName] = &uptimeSummary{}
+				}
+				uptimes[ifaceName].observe(round.end, healthy, maxGap)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	if data == historyUptime {
+		w.Write([]string{
+			"interface",
+			"from",
+			"to",
+			"monitored_seconds",
+			"healthy_seconds",
+			"unhealthy_seconds",
+			"uptime_percent",
+			"outages",
+			"longest_outage_seconds",
+		})
+		for _, ifaceName := range slices.Sorted(maps.Keys(uptimes)) {
+			uptime := uptimes[ifaceName]
+			monitored := uptime.healthy + uptime.unhealthy
+			percent := ""
+			if monitored > 0 {
+				percent = strconv.FormatFloat(100*uptime.healthy.Seconds()/monitored.Seconds(), 'f', 3, 64)
+			}
+			w.Write([]string{
+				ifaceName,
+				reportTime(uptime.first).Format(time.RFC3339),
+				reportTime(uptime.last).Format(time.RFC3339),
+				formatSeconds(monitored),
+				formatSeconds(uptime.healthy),
+				formatSeconds(uptime.unhealthy),
+				percent,
+				strconv.Itoa(uptime.outages),
+				formatSeconds(uptime.longest),
+			})
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// Serve the history export at /history/export, which downloads the data
+// query parameter (results, transitions or uptime) between from and to as
+// CSV from the probe result log
+func registerHistoryExportHandler(config Config) {
+	handleRoleFunc(roleStatus, "/history/export", func(w http.ResponseWriter, r *http.Request) {
+		if resultLog == nil {
+			http.Error(w, "History export needs the probe result log, see --result-log-file", http.StatusNotFound)
+			return
+		}
+
+		query := r.URL.Query()
+		if format := cmp.Or(query.Get("format"), "csv"); format != "csv" {
+			http.Error(w, "Invalid format, must be csv", http.StatusBadRequest)
+			return
+		}
+
+		data := cmp.Or(query.Get("data"), historyUptime)
+		if !slices.Contains([]string{historyResults, historyTransitions, historyUptime}, data) {
+			http.Error(
+				w,
+				fmt.Sprintf("Invalid data, must be %s, %s or %s", historyResults, historyTransitions, historyUptime),
+				http.StatusBadRequest,
+			)
+			return
+		}
+
+		from := time.Time{}
+		to := time.Now()
+		var err error
+		if value := query.Get("from"); value != "" {
+			if from, err = parseHistoryTime(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if value := query.Get("to"); value != "" {
+			if to, err = parseHistoryTime(value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		// Exported in full before sending, so a failed export isn't
+		// served as a truncated file
+		var buf bytes.Buffer
+		if err := writeHistoryCSV(csv.NewWriter(&buf), config, data, from, to); err != nil {
+			logger.Error("Error exporting history", "error", err.Error())
+			http.Error(w, "Failed to export history", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="wan-prober-%s.csv"`, data))
+		sendBody(w, r, http.StatusOK, buf.Bytes(), gzipBody(buf.Bytes()))
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// Publishes the health of each interface as a custom condition
+// on the Kubernetes node the prober runs on
+type KubernetesFeed struct {
+	config      KubernetesConfig
+	apiServer   string
+	token       string
+	client      *http.Client
+	transitions map[string]time.Time
+	healthy     map[string]bool
+}
+
+// Create a feed using the in-cluster service account credentials
+func NewKubernetesFeed(config KubernetesConfig, timeout time.Duration) (*KubernetesFeed, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a kubernetes cluster")
+	}
+
+	token, err := os.ReadFile(kubernetesServiceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("could not read service account token: %w", err)
+	}
+
+	ca, err := os.ReadFile(kubernetesServiceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("could not read service account CA: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("could not parse service account CA")
+	}
+
+	return &KubernetesFeed{
+		config:    config,
+		apiServer: "https://" + net.JoinHostPort(host, port),
+		token:     string(bytes.TrimSpace(token)),
+		client: &http.Client{
+			Timeout: timeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		},
+		transitions: map[string]time.Time{},
+		healthy:     map[string]bool{},
+	}, nil
+}
+
+func (f *KubernetesFeed) Name() string {
+	return "kubernetes"
+}
+
+func (f *KubernetesFeed) Update(ctx context.Context, status InterfaceStatus) error {
+	now := time.Now()
+
+	if healthy, exists := f.healthy[status.Name]; !exists || healthy != status.Healthy {
+		f.healthy[status.Name] = status.Healthy
+		f.transitions[status.Name] = now
+	}
+
+	conditionStatus := "False"
+	reason := "WANUnhealthy"
+	message := "Interface " + status.Name + " is unhealthy"
+	if status.Healthy {
+		conditionStatus = "True"
+		reason = "WANHealthy"
+		message = "Interface " + status.Name + " is healthy"
+	}
+
+	// Conditions are merged by type, so other conditions are left alone
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"conditions": []map[string]string{
+				{
+					"type":               f.config.ConditionPrefix + status.Name,
+					"status":             conditionStatus,
+					"reason":             reason,
+					"message":            message,
+					"lastHeartbeatTime":  now.UTC().Format(time.RFC3339),
+					"lastTransitionTime": f.transitions[status.Name].UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode node patch: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"PATCH",
+		f.apiServer+"/api/v1/nodes/"+f.config.NodeName+"/status",
+		bytes.NewReader(patch),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/strategic-merge-patch+json")
+	request.Header.Set("Authorization", "Bearer "+f.token)
+
+	response, err := f.client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("kubernetes API responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Groups of endpoints which listeners serve
+const (
+	// Status API, self-test, build info and peering
+	roleStatus = "status"
+	// Prometheus metrics and expvar counters
+	roleMetrics = "metrics"
+	// Endpoints which change the behaviour of wan-prober
+	roleAdmin = "admin"
+)
+
+const (
+	// Time requests in flight get to finish when shutting down
+	httpShutdownTimeout = 5 * time.Second
+)
+
+var (
+	// Backoff between attempts to open a listener whose address isn't
+	// available, which are given up after listenRetryTimeout
+	listenRetryMinDelay = time.Second
+	listenRetryMaxDelay = 30 * time.Second
+	listenRetryTimeout  = 5 * time.Minute
+
+	// Servers which are shut down before exiting
+	httpServers sync.WaitGroup
+
+	// Roles in the order their endpoints are matched, the status role
+	// serves / so it must come last
+	listenerRoles = []string{roleAdmin, roleMetrics, roleStatus}
+
+	roleMuxes = map[string]*http.ServeMux{
+		roleStatus:  http.NewServeMux(),
+		roleMetrics: http.NewServeMux(),
+		roleAdmin:   http.NewServeMux(),
+	}
+)
+
+// Register a handler for an endpoint of a role
+func handleRole(role string, pattern string, handler http.Handler) {
+	roleMuxes[role].Handle(pattern, handler)
+}
+
+// Register a handler function for an endpoint of a role
+func handleRoleFunc(role string, pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	roleMuxes[role].HandleFunc(pattern, handler)
+}
+
+// Check the listener configuration, listeners must have an address and
+// valid roles
+func validateListeners(listeners []ListenerConfig) error {
+	for _, listener := range listeners {
+		if listener.Address == "" {
+			return errors.New("listener has no address")
+		}
+		if len(listener.Roles) == 0 {
+			return fmt.Errorf("listener %s has no roles", listener.Address)
+		}
+		for _, role := range listener.Roles {
+			if !slices.Contains(listenerRoles, role) {
+				return fmt.Errorf(
+					"listener %s has invalid role %s, must be %s, %s or %s",
+					listener.Address,
+					role,
+					roleStatus,
+					roleMetrics,
+					roleAdmin,
+				)
+			}
+		}
+		if listener.TLS != nil && !listener.TLS.ACME && (listener.TLS.CertFile == "" || listener.TLS.KeyFile == "") {
+			return fmt.Errorf("listener %s needs cert_file and key_file, or acme, for TLS", listener.Address)
+		}
+		if listener.BasicAuth != nil && listener.BasicAuth.Username == "" {
+			return fmt.Errorf("listener %s needs a basic_auth username", listener.Address)
+		}
+	}
+
+	return nil
+}
+
+// Build the handler of a listener, serving the endpoints of its roles
+// behind its authentication
+func listenerHandler(config ListenerConfig) http.Handler {
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, role := range listenerRoles {
+			if !slices.Contains(config.Roles, role) {
+				continue
+			}
+			if handler, pattern := roleMuxes[role].Handler(r); pattern != "" {
+				if role == roleAdmin {
+					handler = auditHandler(config, accessHandler(pattern, handler))
+				}
+				if role == roleStatus && statusRateLimiter != nil {
+					handler = rateLimitHandler(statusRateLimiter, handler)
+				}
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		http.NotFound(w, r)
+	})
+
+	if config.BasicAuth != nil {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			username, password, ok := r.BasicAuth()
+			if !ok ||
+				subtle.ConstantTimeCompare([]byte(username), []byte(config.BasicAuth.Username)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(password), []byte(config.BasicAuth.Password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="wan-prober"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+
+	return handler
+}
+
+// Load the TLS configuration of a listener, clients must present a
+// certificate signed by the client CA when one is configured
+func listenerTLSConfig(config ListenerTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if config.ACME {
+		tlsConfig.GetCertificate = acmeCertificates.getCertificate
+	} else {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(config.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", config.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// Open a listener, retrying with backoff while its address isn't available
+// yet, e.g. before an address is assigned at boot or while a previous
+// instance is shutting down. Gives up when the address is still unavailable
+// after listenRetryTimeout, so a port held by another process is reported
+// rather than waited on forever.
+func listenWithRetry(ctx context.Context, address string) (net.Listener, error) {
+	delay := listenRetryMinDelay
+	deadline := time.Now().Add(listenRetryTimeout)
+	for {
+		listener, err := net.Listen("tcp", address)
+		if err == nil || !(errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE)) {
+			return listener, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf(
+				"listen address %s still unavailable after %s: %w",
+				address,
+				listenRetryTimeout,
+				err,
+			)
+		}
+		delay = min(delay, remaining)
+
+		logger.Warn(
+			"Listen address not available, will retry",
+			"listen_address",
+			address,
+			"retry_in",
+			delay,
+			"error",
+			err.Error(),
+		)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = min(2*delay, listenRetryMaxDelay)
+	}
+}
+
+// Serve HTTP requests until the context is cancelled, then wait for
+// requests in flight before closing the server
+func serveHTTP(ctx context.Context, server *http.Server, listener net.Listener) {
+	httpServers.Add(1)
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("Error starting HTTP server", "error", err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	go func() {
+		defer httpServers.Done()
+
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Warn("Error shutting down HTTP server", "error", err.Error())
+		}
+	}()
+}
+
+// Open every listener and serve their roles. Listeners whose address isn't
+// available yet keep being retried in the background, the others are open
+// when this returns so privileges can be dropped afterwards.
+func startListeners(ctx context.Context, listeners []ListenerConfig) error {
+	for _, config := range listeners {
+		var tlsConfig *tls.Config
+		if config.TLS != nil {
+			var err error
+			tlsConfig, err = listenerTLSConfig(*config.TLS)
+			if err != nil {
+				return fmt.Errorf("listener %s: %w", config.Address, err)
+			}
+		}
+
+		serve := func(listener net.Listener) {
+			if tlsConfig != nil {
+				listener = tls.NewListener(listener, tlsConfig)
+			}
+
+			logger.Info(
+				"Listening for HTTP requests",
+				"listen_address",
+				config.Address,
+				"roles",
+				config.Roles,
+				"tls",
+				config.TLS != nil,
+			)
+
+			serveHTTP(ctx, &http.Server{Handler: listenerHandler(config)}, listener)
+		}
+
+		if err := openListener(ctx, config.Address, serve); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open a listener and serve it. When its address isn't available yet it
+// keeps being retried in the background, exiting when it can't be opened
+// in time, otherwise it is open when this returns.
+func openListener(ctx context.Context, address string, serve func(net.Listener)) error {
+	listener, err := net.Listen("tcp", address)
+	if errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE) {
+		go func() {
+			listener, err := listenWithRetry(ctx, address)
+			if err != nil {
+				if ctx.Err() != nil {
+					// Shutting down
+					return
+				}
+				logger.Error("Error starting HTTP server", "error", err.Error())
+				os.Exit(1)
+			}
+
+			serve(listener)
+		}()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	serve(listener)
+	return nil
+}
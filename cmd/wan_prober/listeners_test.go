@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func shortenListenRetries(t *testing.T, timeout time.Duration) {
+	t.Helper()
+
+	minDelay, maxDelay, retryTimeout := listenRetryMinDelay, listenRetryMaxDelay, listenRetryTimeout
+	listenRetryMinDelay, listenRetryMaxDelay, listenRetryTimeout = 10*time.Millisecond, 20*time.Millisecond, timeout
+	t.Cleanup(func() {
+		listenRetryMinDelay, listenRetryMaxDelay, listenRetryTimeout = minDelay, maxDelay, retryTimeout
+	})
+}
+
+// A port held by another process is reported once the retries run out,
+// rather than retried forever
+func TestListenWithRetryGivesUp(t *testing.T) {
+	shortenListenRetries(t, 100*time.Millisecond)
+
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	start := time.Now()
+	listener, err := listenWithRetry(context.Background(), held.Addr().String())
+	if err == nil {
+		listener.Close()
+		t.Fatal("listenWithRetry() opened a listener on a port in use")
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("listenWithRetry() error = %v, want EADDRINUSE", err)
+	}
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("listenWithRetry() took %s to give up, want about %s", took, listenRetryTimeout)
+	}
+}
+
+func TestListenWithRetryAddressFreed(t *testing.T) {
+	shortenListenRetries(t, 5*time.Second)
+
+	held, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(50*time.Millisecond, func() {
+		held.Close()
+	})
+
+	listener, err := listenWithRetry(context.Background(), held.Addr().String())
+	if err != nil {
+		t.Fatalf("listenWithRetry() error = %v", err)
+	}
+	listener.Close()
+}
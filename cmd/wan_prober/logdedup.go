@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+type dedupEntry struct {
+	handler    slog.Handler
+	record     slog.Record
+	firstSeen  time.Time
+	suppressed int
+}
+
+type dedupState struct {
+	mu       sync.Mutex
+	interval time.Duration
+	entries  map[string]*dedupEntry
+}
+
+// Log handler which collapses identical log records seen within an interval
+// into a single summary record containing the number of suppressed records
+type DedupHandler struct {
+	handler slog.Handler
+	state   *dedupState
+	prefix  string
+}
+
+func NewDedupHandler(handler slog.Handler, interval time.Duration) *DedupHandler {
+	h := &DedupHandler{
+		handler: handler,
+		state: &dedupState{
+			interval: interval,
+			entries:  map[string]*dedupEntry{},
+		},
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for now := range ticker.C {
+			h.flush(context.Background(), now)
+		}
+	}()
+
+	return h
+}
+
+// Emit summaries for entries which have expired before now
+func (h *DedupHandler) flush(ctx context.Context, now time.Time) {
+	h.state.mu.Lock()
+	expired := []*dedupEntry{}
+	for key, entry := range h.state.entries {
+		if now.Sub(entry.firstSeen) >= h.state.interval {
+			delete(h.state.entries, key)
+			if entry.suppressed > 0 {
+				expired = append(expired, entry)
+			}
+		}
+	}
+	h.state.mu.Unlock()
+
+	for _, entry := range expired {
+		summarize(ctx, entry)
+	}
+}
+
+func summarize(ctx context.Context, entry *dedupEntry) {
+	record := entry.record.Clone()
+	record.Time = time.Now()
+	record.AddAttrs(
+		slog.Int("suppressed", entry.suppressed),
+		slog.Time("since", entry.firstSeen),
+	)
+	entry.handler.Handle(ctx, record)
+}
+
+func (h *DedupHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *DedupHandler) Handle(ctx context.Context, record slog.Record) error {
+	var key strings.Builder
+	key.WriteString(h.prefix)
+	key.WriteString(record.Level.String())
+	key.WriteString(record.Message)
+	record.Attrs(func(attr slog.Attr) bool {
+		fmt.Fprintf(&key, " %s=%v", attr.Key, attr.Value)
+		return true
+	})
+
+	h.state.mu.Lock()
+	entry, exists := h.state.entries[key.String()]
+	if exists && record.Time.Sub(entry.firstSeen) < h.state.interval {
+		entry.suppressed += 1
+		h.state.mu.Unlock()
+		return nil
+	}
+	if !exists && len(h.state.entries) >= footprint.dedupEntries {
+		// Too many distinct messages to track, log them as they are
+		h.state.mu.Unlock()
+		return h.handler.Handle(ctx, record)
+	}
+	h.state.entries[key.String()] = &dedupEntry{
+		handler:   h.handler,
+		record:    record.Clone(),
+		firstSeen: record.Time,
+	}
+	h.state.mu.Unlock()
+
+	if exists && entry.suppressed > 0 {
+		summarize(ctx, entry)
+	}
+
+	return h.handler.Handle(ctx, record)
+}
+
+func (h *DedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefix := h.prefix
+	for _, attr := range attrs {
+		prefix += fmt.Sprintf("%s=%v ", attr.Key, attr.Value)
+	}
+
+	return &DedupHandler{
+		handler: h.handler.WithAttrs(attrs),
+		state:   h.state,
+		prefix:  prefix,
+	}
+}
+
+func (h *DedupHandler) WithGroup(name string) slog.Handler {
+	return &DedupHandler{
+		handler: h.handler.WithGroup(name),
+		state:   h.state,
+		prefix:  h.prefix + name + ".",
+	}
+}
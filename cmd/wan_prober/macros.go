@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Target macros which are expanded at runtime for each probed interface
+const (
+	macroGateway = "@gateway"
+	macroDHCPDNS = "@dhcp-dns"
+)
+
+var (
+	procRoutePath       = "/proc/net/route"
+	networkdLinksPath   = "/run/systemd/netif/links"
+	dhclientLeasesGlobs = []string{
+		"/var/lib/dhcp/dhclient*.%s.leases",
+		"/var/lib/dhclient/dhclient*-%s.lease*",
+		"/var/lib/NetworkManager/dhclient*-%s.lease",
+	}
+
+	errNoGateway = errors.New("no default gateway found for interface")
+	errNoDHCPDNS = errors.New("no DHCP provided DNS server found for interface")
+)
+
+// Expand macros in the host and DNS server of a target
+// to the current addresses for an interface
+func expandTargetMacros(target Target, iface string) (Target, error) {
+	expand := func(s string) (string, error) {
+		if strings.Contains(s, macroGateway) {
+			gateway, err := interfaceGateway(iface)
+			if err != nil {
+				return "", err
+			}
+			s = strings.ReplaceAll(s, macroGateway, gateway.String())
+		}
+
+		if strings.Contains(s, macroDHCPDNS) {
+			server, err := interfaceDHCPDNS(iface)
+			if err != nil {
+				return "", err
+			}
+			s = strings.ReplaceAll(s, macroDHCPDNS, server.String())
+		}
+
+		return s, nil
+	}
+
+	host, err := expand(target.Host)
+	if err != nil {
+		return target, err
+	}
+
+	server, err := expand(target.DNS.Server)
+	if err != nil {
+		return target, err
+	}
+	if server != target.DNS.Server {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+	}
+
+	target.Host = host
+	target.DNS.Server = server
+
+	return target, nil
+}
+
+// Find the IPv4 default gateway of an interface from the kernel routing table
+func interfaceGateway(iface string) (netip.Addr, error) {
+	file, err := os.Open(procRoutePath)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("could not read routing table: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[0] != iface {
+			continue
+		}
+		if fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+
+		gateway, err := strconv.ParseUint(fields[2], 16, 32)
+		if err != nil || gateway == 0 {
+			continue
+		}
+
+		// Addresses in the routing table are in host byte order
+		addr := [4]byte{}
+		binary.LittleEndian.PutUint32(addr[:], uint32(gateway))
+
+		return netip.AddrFrom4(addr), nil
+	}
+
+	return netip.Addr{}, errNoGateway
+}
+
+// Find the DNS server provided by DHCP for an interface from
+// systemd-networkd link state or dhclient lease files
+func interfaceDHCPDNS(iface string) (netip.Addr, error) {
+	if link, err := net.InterfaceByName(iface); err == nil {
+		state, err := os.ReadFile(filepath.Join(networkdLinksPath, strconv.Itoa(link.Index)))
+		if err == nil {
+			for _, line := range strings.Split(string(state), "\n") {
+				servers, found := strings.CutPrefix(line, "DNS=")
+				if !found {
+					continue
+				}
+				for _, server := range strings.Fields(servers) {
+					if addr, err := netip.ParseAddr(server); err == nil {
+						return addr, nil
+					}
+				}
+			}
+		}
+	}
+
+	for _, glob := range dhclientLeasesGlobs {
+		leaseFiles, _ := filepath.Glob(fmt.Sprintf(glob, iface))
+		for _, leaseFile := range leaseFiles {
+			if lease, err := parseDhclientLease(leaseFile); err == nil && lease.DNS.IsValid() {
+				return lease.DNS, nil
+			}
+		}
+	}
+
+	return netip.Addr{}, errNoDHCPDNS
+}
@@ -0,0 +1,878 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/internal/state"
+	"github.com/adaricorp/wan-prober/probe"
+	"github.com/peterbourgon/ff/v4"
+	"github.com/peterbourgon/ff/v4/ffhelp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/version"
+)
+
+const (
+	binName = "wan_prober"
+
+	// Largest random delay of probe rounds from their scheduled start
+	maxRoundJitter = 5 * time.Second
+)
+
+var (
+	configFilePath     *string
+	configURL          *string
+	configURLInsecure  *bool
+	configPollInterval *time.Duration
+	httpListenAddress  *string
+	logger             *slog.Logger
+	logLevel           *string
+	logDedupInterval   *time.Duration
+	aggregatorMode     *bool
+	aggregatorStale    *time.Duration
+	dryRunActions      *bool
+	strictStartup      *bool
+	runAsUser          *string
+	simulate           *[]string
+	replayFiles        *[]string
+	simulateAPI        *bool
+	smallFootprintMode *bool
+	slogLevel          *slog.LevelVar = new(slog.LevelVar)
+
+	resultLogFile       *string
+	resultLogMaxSize    *int
+	resultLogMaxBackups *int
+	resultLog           *ResultLog
+	probeTraceIDs       *bool
+	outputFormat        *string
+	serviceMode         *string
+	output              *outputStream
+
+	aggregatorTenantsFile       *string
+	aggregatorTLSCertFile       *string
+	aggregatorTLSKeyFile        *string
+	aggregatorClientCAFile      *string
+	aggregatorSPIFFETrustDomain *string
+
+	probers = map[string]probe.ProbeFn{
+		"http":     probe.ProbeHTTP,
+		"dns":      probe.ProbeDNS,
+		"starlink": probe.ProbeStarlink,
+		"mtu":      probe.ProbeMTU,
+		"twamp":    probe.ProbeTWAMP,
+		"ntp":      probe.ProbeNTP,
+		"tcp":      probe.ProbeTCP,
+		"icmp":     probe.ProbeICMP,
+	}
+
+	defaultFallbackResolvers = []AddrPort{
+		AddrPort{netip.MustParseAddrPort("8.8.8.8:53")},
+		AddrPort{netip.MustParseAddrPort("[2001:4860:4860::8888]:53")},
+		AddrPort{netip.MustParseAddrPort("1.1.1.1:53")},
+		AddrPort{netip.MustParseAddrPort("[2606:4700:4700::1111]:53")},
+	}
+
+	// Arguments of the bench subcommand, nil when not benchmarking
+	benchArgs []string
+
+	dnsCache        = sync.Map{}
+	interfaceStates = &state.Store{}
+
+	// How long inbound reachability reports from peers are trusted for
+	peerReportMaxAge time.Duration
+)
+
+// Print program usage
+func printUsage(fs ff.Flags) {
+	fmt.Fprintf(os.Stderr, "%s\n", ffhelp.Flags(fs))
+	os.Exit(1)
+}
+
+// Print program version
+func printVersion() {
+	fmt.Printf("%s v%s built on %s\n", binName, version.Version, version.BuildDate)
+	os.Exit(0)
+}
+
+// Parse flags and set up logging, before anything else in main
+func parseFlags(args []string) {
+	fs := ff.NewFlagSet(binName)
+	displayVersion := fs.BoolLong("version", "Print version")
+	configFilePath = fs.StringLong(
+		"config-file",
+		"wan-prober.yml",
+		"Path to configuration file",
+	)
+	configURL = fs.StringLong(
+		"config-url",
+		"",
+		"URL to fetch configuration from, cached in the configuration file (https://, etcd+https://host:2379/key or consul+https://host:8500/key)",
+	)
+	configURLInsecure = fs.BoolLong(
+		"config-url-insecure",
+		"Allow a configuration URL over plain HTTP, which lets anyone on the path run hook commands",
+	)
+	configPollInterval = fs.DurationLong(
+		"config-poll-interval",
+		time.Minute,
+		"How often to check the configuration URL for changes",
+	)
+	httpListenAddress = fs.StringLong(
+		"http-listen-address",
+		"localhost:8020",
+		"Listen address for HTTP server, serving every role unless listeners are configured",
+	)
+	aggregatorMode = fs.BoolLong(
+		"aggregator",
+		"Run as an aggregator of status pushed by remote probers instead of probing",
+	)
+	aggregatorStale = fs.DurationLong(
+		"aggregator-stale-after",
+		5*time.Minute,
+		"Mark a site as stale when it hasn't pushed status for this long",
+	)
+	aggregatorTenantsFile = fs.StringLong(
+		"aggregator-tenants-file",
+		"",
+		"Serve the sites of each tenant in this file separately, to clients with the tenant's token",
+	)
+	aggregatorTLSCertFile = fs.StringLong(
+		"aggregator-tls-cert-file",
+		"",
+		"Serve the aggregator over HTTPS with this certificate",
+	)
+	aggregatorTLSKeyFile = fs.StringLong(
+		"aggregator-tls-key-file",
+		"",
+		"Key of the aggregator's HTTPS certificate",
+	)
+	aggregatorClientCAFile = fs.StringLong(
+		"aggregator-client-ca-file",
+		"",
+		"Only accept pushes from probers with a client certificate signed by this CA, for the site it names",
+	)
+	aggregatorSPIFFETrustDomain = fs.StringLong(
+		"aggregator-spiffe-trust-domain",
+		"",
+		"Identify probers by the SPIFFE ID spiffe://<domain>/site/<site> in this trust domain of their client certificate instead of its common name",
+	)
+	dryRunActions = fs.BoolLong(
+		"dry-run-actions",
+		"Log the hooks, transition actions and remediations which would be performed instead of performing them",
+	)
+	strictStartup = fs.BoolLong(
+		"strict-startup",
+		"Exit when the startup self-test finds a problem instead of only reporting it",
+	)
+	runAsUser = fs.StringLong(
+		"run-as-user",
+		"",
+		"Switch to this user once listening, keeping only the network capabilities probes need",
+	)
+	simulate = fs.StringListLong(
+		"simulate",
+		"Force probe outcomes for testing, as interface=outcome or interface/target=outcome with outcome up, down or error (repeatable)",
+	)
+	simulateAPI = fs.BoolLong(
+		"simulate-api",
+		"Serve /debug/simulate to force probe outcomes at runtime",
+	)
+	replayFiles = fs.StringListLong(
+		"replay",
+		"Replay probe result logs through health evaluation and notification rules, printing transitions and events as JSON lines instead of probing (repeatable, oldest first)",
+	)
+	logLevel = fs.StringEnumLong(
+		"log-level",
+		"Log level: debug, info, warn, error",
+		"info",
+		"debug",
+		"error",
+		"warn",
+	)
+	logDedupInterval = fs.DurationLong(
+		"log-dedup-interval",
+		0,
+		"Collapse repeated identical log messages within this interval into a summary (0 disables)",
+	)
+	smallFootprintMode = fs.BoolLong(
+		"small-footprint",
+		"Keep smaller caches and histories and collect garbage more often, for routers with little memory",
+	)
+	resultLogFile = fs.StringLong(
+		"result-log-file",
+		"",
+		"Path to file for writing probe results as JSON lines",
+	)
+	resultLogMaxSize = fs.IntLong(
+		"result-log-max-size",
+		10,
+		"Maximum size in megabytes of probe result log before it is rotated",
+	)
+	resultLogMaxBackups = fs.IntLong(
+		"result-log-max-backups",
+		3,
+		"Number of rotated probe result logs to keep",
+	)
+
+	probeTraceIDs = fs.BoolLong(
+		"probe-trace-ids",
+		"Give each probe attempt a trace ID, sent by HTTP probes in a traceparent header, recorded in the result log and attached to probe duration metrics as exemplars",
+	)
+
+	outputFormat = fs.StringEnumLong(
+		"output",
+		"Also write probe round results and state transitions to stdout: none, ndjson (logs go to stderr with ndjson)",
+		outputNone,
+		outputNDJSON,
+	)
+
+	serviceMode = fs.StringEnumLong(
+		"service",
+		"Manage the Windows service: install or uninstall it with the other flags given, run is used by the service (logs go to the Event Log)",
+		serviceNone,
+		serviceInstall,
+		serviceUninstall,
+		serviceRun,
+	)
+
+	err := ff.Parse(fs, args,
+		ff.WithEnvVarPrefix(strings.ToUpper(binName)),
+		ff.WithEnvVarSplit(" "),
+	)
+	if err != nil {
+		printUsage(fs)
+	}
+
+	if args := fs.GetArgs(); len(args) > 0 {
+		if args[0] != "bench" {
+			printUsage(fs)
+		}
+		benchArgs = args[1:]
+	}
+
+	if *displayVersion {
+		printVersion()
+	}
+
+	if *smallFootprintMode {
+		useSmallFootprint()
+	}
+	interfaceStates.MaxTransitions = footprint.stateTransitions
+
+	switch *logLevel {
+	case "debug":
+		slogLevel.Set(slog.LevelDebug)
+	case "info":
+		slogLevel.Set(slog.LevelInfo)
+	case "warn":
+		slogLevel.Set(slog.LevelWarn)
+	case "error":
+		slogLevel.Set(slog.LevelError)
+	}
+
+	logOutput := os.Stdout
+	if *outputFormat == outputNDJSON {
+		// Stdout only carries the output stream
+		logOutput = os.Stderr
+		output = newOutputStream(os.Stdout)
+	}
+
+	var logHandler slog.Handler = slog.NewTextHandler(logOutput, &slog.HandlerOptions{
+		Level: slogLevel,
+	})
+	var eventLogErr error
+	if *serviceMode == serviceRun {
+		if handler, err := newEventLogHandler(serviceName, slogLevel); err != nil {
+			eventLogErr = err
+		} else {
+			logHandler = handler
+		}
+	}
+	if *logDedupInterval > 0 {
+		logHandler = NewDedupHandler(logHandler, *logDedupInterval)
+	}
+
+	logger = slog.New(logHandler)
+	slog.SetDefault(logger)
+
+	if eventLogErr != nil {
+		logger.Error("Couldn't open Event Log, logging to stdout", "error", eventLogErr.Error())
+	}
+}
+
+func main() {
+	parseFlags(os.Args[1:])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	exitSignal := make(chan os.Signal, 1)
+	signal.Notify(exitSignal, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-exitSignal
+		cancel()
+		httpServers.Wait()
+		os.Exit(0)
+	}()
+
+	switch *serviceMode {
+	case serviceInstall, serviceUninstall:
+		if err := manageService(*serviceMode, serviceArgs(os.Args[1:])); err != nil {
+			logger.Error("Couldn't "+*serviceMode+" service", "service", serviceName, "error", err.Error())
+			os.Exit(1)
+		}
+		logger.Info("Service "+*serviceMode+"ed", "service", serviceName)
+		return
+	case serviceRun:
+		if err := startService(exitSignal); err != nil {
+			logger.Error("Couldn't run as service", "service", serviceName, "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if benchArgs != nil {
+		os.Exit(runBench(ctx, benchArgs))
+	}
+
+	if *aggregatorMode {
+		runAggregator(ctx)
+		return
+	}
+
+	if *configURL != "" {
+		if err := checkConfigURL(*configURL); err != nil {
+			slog.Error("Invalid configuration URL", "config_url", *configURL, "error", err.Error())
+			os.Exit(1)
+		}
+
+		if _, err := refreshConfigCache(ctx, *configURL, *configFilePath); err != nil {
+			slog.Warn(
+				"Couldn't fetch remote configuration, using cached configuration",
+				"config_url",
+				*configURL,
+				"config_file",
+				*configFilePath,
+				"error",
+				err.Error(),
+			)
+		}
+
+		go watchRemoteConfig(ctx, *configURL, *configFilePath, *configPollInterval)
+	}
+
+	configFile, err := os.ReadFile(*configFilePath)
+	if err != nil {
+		slog.Error(
+			"Couldn't open configuration file",
+			"config_file",
+			*configFilePath,
+			"error",
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	config := Config{}
+	if err := unmarshalConfig(*configFilePath, configFile, &config); err != nil {
+		slog.Error(
+			"Couldn't parse configuration file",
+			"config_file",
+			*configFilePath,
+			"error",
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	if err := prepareConfig(&config); err != nil {
+		slog.Error(
+			"Invalid configuration",
+			"config_file",
+			*configFilePath,
+			"error",
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+	ifaces := interfaceNames(config.Interfaces)
+
+	adminAccess = config.AdminAccess
+
+	if config.Peering != nil {
+		peerReportMaxAge = 3 * config.Peering.Interval
+	}
+
+	if config.StatusRateLimit != nil {
+		statusRateLimiter, err = newClientRateLimiter(*config.StatusRateLimit)
+		if err != nil {
+			slog.Error(
+				"Invalid status rate limit",
+				"config_file",
+				*configFilePath,
+				"error",
+				err.Error(),
+			)
+			os.Exit(1)
+		}
+	}
+
+	if err := setReportTimezone(config.Timezone); err != nil {
+		slog.Error(
+			"Invalid timezone",
+			"config_file",
+			*configFilePath,
+			"timezone",
+			config.Timezone,
+			"error",
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	transitionActions := map[string][]TransitionAction{}
+	for _, iface := range config.Interfaces {
+		transitionActions[iface.Name] = iface.Actions
+	}
+
+	if config.GeoIP != nil {
+		geoIP, err = NewGeoIP(*config.GeoIP)
+		if err != nil {
+			slog.Error(
+				"Couldn't open GeoIP databases",
+				"config_file",
+				*configFilePath,
+				"error",
+				err.Error(),
+			)
+			os.Exit(1)
+		}
+		defer geoIP.Close()
+	}
+
+	if *resultLogFile != "" {
+		resultLog, err = NewResultLog(
+			*resultLogFile,
+			int64(*resultLogMaxSize)*1024*1024,
+			*resultLogMaxBackups,
+		)
+		if err != nil {
+			slog.Error(
+				"Couldn't open probe result log",
+				"result_log_file",
+				*resultLogFile,
+				"error",
+				err.Error(),
+			)
+			os.Exit(1)
+		}
+		defer resultLog.Close()
+	}
+
+	if config.HA == nil {
+		haActive.Store(true)
+	} else if config.HA.StateFile != "" {
+		go runHAStateFile(ctx, config.HA.StateFile)
+	} else if err := runHAPeer(ctx, *config.HA); err != nil {
+		slog.Error(
+			"Couldn't start HA peer election",
+			"config_file",
+			*configFilePath,
+			"error",
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	notifier, err := NewNotifier(ctx, config)
+	if err != nil {
+		slog.Error(
+			"Couldn't configure notifications",
+			"config_file",
+			*configFilePath,
+			"error",
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	if len(*replayFiles) > 0 {
+		if err := runReplay(config, notifier, *replayFiles, os.Stdout); err != nil {
+			slog.Error("Couldn't replay probe results", "error", err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
+	targetOutages.configure(
+		ifaces,
+		3*(config.ProbeConfiguration.MinInterval+maxRoundJitter),
+		config.ProbeConfiguration.TargetOutageCooldown,
+		notifier.Broadcast,
+	)
+	targetViews.configure(3 * (config.ProbeConfiguration.MinInterval + maxRoundJitter))
+	dnsDivergences.configure(notifier.Broadcast)
+
+	statusFeeds := []*statusFeedQueue{}
+	if config.Consul != nil {
+		statusFeeds = append(statusFeeds, newStatusFeedQueue(
+			ctx,
+			NewConsulFeed(*config.Consul, config.ProbeConfiguration.Timeout),
+		))
+	}
+	if config.Etcd != nil {
+		statusFeeds = append(statusFeeds, newStatusFeedQueue(
+			ctx,
+			NewEtcdFeed(*config.Etcd, config.ProbeConfiguration.Timeout),
+		))
+	}
+
+	if config.Kubernetes != nil {
+		feed, err := NewKubernetesFeed(*config.Kubernetes, config.ProbeConfiguration.Timeout)
+		if err != nil {
+			slog.Error(
+				"Couldn't configure kubernetes integration",
+				"config_file",
+				*configFilePath,
+				"error",
+				err.Error(),
+			)
+			os.Exit(1)
+		}
+		statusFeeds = append(statusFeeds, newStatusFeedQueue(ctx, feed))
+	}
+
+	if config.Zabbix != nil {
+		statusFeeds = append(statusFeeds, newStatusFeedQueue(
+			ctx,
+			NewZabbixFeed(*config.Zabbix, config.ProbeConfiguration.Timeout),
+		))
+	}
+	if config.NSCA != nil {
+		feed, err := NewNSCAFeed(*config.NSCA, config.ProbeConfiguration.Timeout)
+		if err != nil {
+			slog.Error(
+				"Couldn't configure NSCA integration",
+				"config_file",
+				*configFilePath,
+				"error",
+				err.Error(),
+			)
+			os.Exit(1)
+		}
+		statusFeeds = append(statusFeeds, newStatusFeedQueue(ctx, feed))
+	}
+
+	if config.Peering != nil {
+		registerPeerHandlers(*config.Peering, config.Interfaces)
+	}
+
+	if err := checkPrivileges(ctx, config); err != nil {
+		slog.Error(
+			"Missing privileges",
+			"config_file",
+			*configFilePath,
+			"error",
+			err.Error(),
+		)
+		os.Exit(1)
+	}
+
+	selfTest := runSelfTest(ctx, config)
+	for _, check := range selfTest.Checks {
+		if !check.Passed {
+			logger.Warn(
+				"Self-test check failed",
+				"check",
+				check.Check,
+				"interface",
+				check.Interface,
+				"resolver",
+				check.Resolver,
+				"error",
+				check.Error,
+			)
+		}
+	}
+	if selfTest.Ready {
+		logger.Info("Self-test passed", "checks", len(selfTest.Checks))
+	} else if *strictStartup {
+		slog.Error(
+			"Self-test failed, exiting because of --strict-startup",
+			"config_file",
+			*configFilePath,
+		)
+		os.Exit(1)
+	} else {
+		logger.Warn("Self-test found problems, probing anyway")
+	}
+
+	handleRoleFunc(roleStatus, "/selftest", func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		if !selfTest.Ready {
+			status = http.StatusServiceUnavailable
+		}
+
+		writeJSON(w, r, status, selfTest)
+	})
+
+	for _, flag := range *simulate {
+		simulation, err := parseSimulation(flag)
+		if err == nil {
+			err = setSimulation(simulation)
+		}
+		if err != nil {
+			slog.Error("Invalid simulation", "simulation", flag, "error", err.Error())
+			os.Exit(1)
+		}
+	}
+	if *simulateAPI {
+		registerSimulationHandler()
+	}
+	registerAuditHandler()
+	registerTargetQualityHandler()
+	registerSchedulerHandler()
+	registerTargetsHandler()
+	registerHistoryExportHandler(config)
+
+	prometheus.MustRegister(newInterfaceStatisticsCollector(config.Interfaces))
+	prometheus.MustRegister(newProbeConnectionsCollector(config.Interfaces))
+	prometheus.MustRegister(newTargetsCollector())
+	handleRole(roleMetrics, "/metrics", metricsHandler())
+	handleRole(roleMetrics, "/debug/vars", expvar.Handler())
+	registerBuildInfoHandler(config, configFile)
+
+	handleRoleFunc(roleStatus, "/api/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		serveStatusDocument(w, r, "/api/v1/status", func() (any, error) {
+			return api.StatusResponse{
+				SchemaVersion: api.SchemaVersion,
+				Interfaces:    interfaceStatuses(),
+			}, nil
+		})
+	})
+
+	// Legacy shape for existing scripts, new fields are only served under
+	// /api/v1
+	handleRoleFunc(roleStatus, "/", func(w http.ResponseWriter, r *http.Request) {
+		serveStatusDocument(w, r, "/", func() (any, error) {
+			return api.LegacyStatus(interfaceStatuses())
+		})
+	})
+
+	if config.ACME != nil {
+		acmeCertificates, err = newACMEManager(*config.ACME)
+		if err != nil {
+			logger.Error("Error setting up ACME", "cache_dir", config.ACME.CacheDir, "error", err.Error())
+			os.Exit(1)
+		}
+		if err := acmeCertificates.start(ctx); err != nil {
+			logger.Error("Error setting up ACME", "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if err := startListeners(ctx, config.Listeners); err != nil {
+		logger.Error("Error starting HTTP server", "error", err.Error())
+		os.Exit(1)
+	}
+
+	if *runAsUser != "" {
+		if config.ACME != nil {
+			// Renewals cache the certificate after privileges are dropped
+			if err := chownToUser(config.ACME.CacheDir, *runAsUser); err != nil {
+				logger.Error(
+					"Couldn't give the ACME cache to the user",
+					"user",
+					*runAsUser,
+					"cache_dir",
+					config.ACME.CacheDir,
+					"error",
+					err.Error(),
+				)
+				os.Exit(1)
+			}
+		}
+
+		// Sockets which need privileges to open are open now
+		if err := dropPrivileges(*runAsUser); err != nil {
+			logger.Error("Couldn't drop privileges", "user", *runAsUser, "error", err.Error())
+			os.Exit(1)
+		}
+		logger.Info("Dropped privileges", "user", *runAsUser)
+	}
+
+	if *configURL != "" {
+		// Changes could otherwise never be applied
+		if err := checkConfigCacheWritable(*configFilePath); err != nil {
+			logger.Error(
+				"Remote configuration can't be cached in the configuration file's directory",
+				"config_file",
+				*configFilePath,
+				"error",
+				err.Error(),
+			)
+			os.Exit(1)
+		}
+	}
+
+	if config.DNSCache != nil {
+		loaded, err := loadDNSCache(&dnsCache, config.DNSCache.File, config.DNSCache.MaxAge, time.Now())
+		if err != nil {
+			logger.Warn(
+				"Couldn't load DNS cache",
+				"file",
+				config.DNSCache.File,
+				"error",
+				err.Error(),
+			)
+		} else {
+			logger.Info("Loaded DNS cache", "file", config.DNSCache.File, "entries", loaded)
+		}
+
+		go runDNSCachePersistence(ctx, &dnsCache, *config.DNSCache)
+	}
+
+	channel := make(chan InterfaceStatus)
+	registerOverrideHandler(ctx, channel, config.Interfaces)
+	registerFailbackHandler(config.Interfaces)
+
+	for _, iface := range config.Interfaces {
+		go superviseProbeLoop(ctx, channel, config, iface)
+
+		if iface.DHCP != nil {
+			go runDHCPMonitor(ctx, iface)
+		}
+
+		if iface.PPP != nil {
+			go runPPPMonitor(ctx, channel, iface)
+		}
+
+		if iface.CPE != nil {
+			go runCPECheck(ctx, iface, config.ProbeConfiguration.Timeout)
+		}
+	}
+
+	for _, heartbeat := range config.Heartbeats {
+		go runHeartbeat(ctx, heartbeat, config.ProbeConfiguration.Timeout)
+	}
+
+	if config.Peering != nil {
+		go runPeering(ctx, *config.Peering, config.ProbeConfiguration.Timeout)
+	}
+
+	if config.AgentX != nil {
+		if err := runAgentX(ctx, *config.AgentX, config.Interfaces); err != nil {
+			logger.Error("Couldn't start SNMP subagent", "error", err.Error())
+			os.Exit(1)
+		}
+	}
+
+	var dbusStatus *dbusService
+	if config.DBusService != nil {
+		dbusStatus = newDBusService(*config.DBusService, config.Interfaces)
+		go dbusStatus.run(ctx)
+	}
+
+	if config.Push != nil {
+		client, err := pushClient(*config.Push, config.ProbeConfiguration.Timeout)
+		if err != nil {
+			logger.Error("Invalid push TLS configuration", "error", err.Error())
+			os.Exit(1)
+		}
+		go runPush(ctx, client, *config.Push)
+	}
+
+	var bgp *bgpSpeaker
+	if config.BGP != nil {
+		bgp = newBGPSpeaker(*config.BGP, config.Interfaces)
+		onHAActivated(bgp.wakeUp)
+		go bgp.run(ctx)
+	}
+
+	var vrrp *vrrpTracker
+	if config.VRRP != nil {
+		vrrp = newVRRPTracker(*config.VRRP)
+	}
+
+	var dynamicDNS *dynamicDNSUpdater
+	if config.DynamicDNS != nil {
+		dynamicDNS = newDynamicDNSUpdater(*config.DynamicDNS)
+		onHAActivated(dynamicDNS.wakeUp)
+		go dynamicDNS.run(ctx)
+	}
+
+	for status := range channel {
+		now := time.Now().Unix()
+		status = overrides.apply(status)
+
+		bgp.update(status.Name, status.Healthy)
+		vrrp.update(ctx, status.Name, status.Healthy)
+		dynamicDNS.update(status.Name, status.Healthy)
+
+		for _, feed := range statusFeeds {
+			feed.push(status)
+		}
+
+		notifier.Update(status, time.Unix(now, 0))
+		if status.Healthy {
+			// Connectivity is available again,
+			// so deliver anything queued during an outage
+			notifier.Replay()
+		}
+
+		if interfaceStates.Update(status.Name, status.DisplayName, status.Healthy, status.Reason, now) {
+			stateTransitionCount.Add(1)
+			writeTransitionOutput(status)
+			dbusStatus.stateChanged(status)
+			go runTransitionActions(
+				ctx,
+				status.Name,
+				transitionActions[status.Name],
+				status.Healthy,
+				status.Reason,
+			)
+		}
+	}
+}
+
+// Current status of all interfaces which have been probed
+func interfaceStatuses() []api.InterfaceStatusResponse {
+	statuses := []api.InterfaceStatusResponse{}
+
+	interfaceStates.Range(func(status api.InterfaceStatusResponse) bool {
+		if peerReportMaxAge > 0 {
+			status.InboundReachable = inboundReachable(status.Name, peerReportMaxAge)
+		}
+		status.DHCP = dhcpStatus(status.Name)
+		status.PPP = pppStatus(status.Name)
+		status.CPE = cpeStatus(status.Name)
+		status.EgressIP = egressAddress(status.Name)
+		status.Stalled = watchdog.isStalled(status.Name)
+		status.Statistics, _ = readInterfaceStatistics(status.Name)
+		status.ClockSkew = observedClockSkew(status.Name)
+		status.Override = healthOverride(status.Name)
+		status.Failback = pendingFailback(status.Name)
+		if schedule := roundSchedule(status.Name); schedule != nil {
+			status.Interval = schedule.Interval
+			status.RoundDuration = schedule.RoundDuration
+			status.NextProbeAt = schedule.NextProbeAt
+		}
+		localizeStatus(&status)
+		statuses = append(statuses, status)
+
+		return true
+	})
+
+	return statuses
+}
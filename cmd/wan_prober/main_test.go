@@ -0,0 +1,18 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Every flag has its default
+	parseFlags(nil)
+	logger = slog.New(slog.DiscardHandler)
+	slog.SetDefault(logger)
+	// Tests mustn't change the system
+	*dryRunActions = true
+
+	os.Exit(m.Run())
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"time"
+
+	"github.com/adaricorp/wan-prober/probe"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	probePhaseDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "wan_prober_probe_phase_duration_seconds",
+			Help:    "Time spent in each phase of successful probes.",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+		},
+		[]string{"interface", "target", "phase"},
+	)
+
+	dnsResolutions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wan_prober_dns_resolutions_total",
+			Help: "Probe target resolutions by the resolver path which answered: host, fallback or cache.",
+		},
+		[]string{"interface", "resolver", "resolver_address"},
+	)
+	dnsResolutionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "wan_prober_dns_resolution_duration_seconds",
+			Help:    "Time taken to resolve probe targets by the resolver path which answered, including failed attempts of earlier paths.",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		[]string{"interface", "resolver"},
+	)
+
+	pathMTU = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_path_mtu_bytes",
+			Help: "Path MTU discovered towards a target.",
+		},
+		[]string{"interface", "target"},
+	)
+
+	certificateExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_certificate_expiry_days",
+			Help: "Days until the certificate presented by an HTTPS target expires.",
+		},
+		[]string{"interface", "target"},
+	)
+
+	clockSkew = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_clock_skew_seconds",
+			Help: "Offset of a target's clock from the local clock.",
+		},
+		[]string{"interface", "target"},
+	)
+
+	twampRTT = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_twamp_rtt_seconds",
+			Help: "Mean round trip time of TWAMP test packets, excluding reflector processing time.",
+		},
+		[]string{"interface", "target"},
+	)
+	twampJitter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_twamp_jitter_seconds",
+			Help: "Mean difference between round trip times of consecutive TWAMP test packets.",
+		},
+		[]string{"interface", "target"},
+	)
+	twampLoss = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_twamp_loss_ratio",
+			Help: "Fraction of TWAMP test packets which weren't reflected.",
+		},
+		[]string{"interface", "target"},
+	)
+	twampOneWayDelay = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_twamp_one_way_delay_seconds",
+			Help: "Mean one way delay of TWAMP test packets, requires synchronized clocks.",
+		},
+		[]string{"interface", "target", "direction"},
+	)
+
+	starlinkPopPingDropRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_starlink_pop_ping_drop_rate",
+			Help: "Fraction of pings from the Starlink dish to its point of presence which are dropped.",
+		},
+		[]string{"interface"},
+	)
+	starlinkPopPingLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_starlink_pop_ping_latency_seconds",
+			Help: "Latency of pings from the Starlink dish to its point of presence.",
+		},
+		[]string{"interface"},
+	)
+	starlinkFractionObstructed = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_starlink_fraction_obstructed",
+			Help: "Fraction of the Starlink dish's view of the sky which is obstructed.",
+		},
+		[]string{"interface"},
+	)
+	starlinkSNRAboveNoiseFloor = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_starlink_snr_above_noise_floor",
+			Help: "Whether the Starlink dish signal is above the noise floor.",
+		},
+		[]string{"interface"},
+	)
+	starlinkOutage = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "wan_prober_starlink_outage",
+			Help: "Whether the Starlink dish reports an outage.",
+		},
+		[]string{"interface"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(probePhaseDuration)
+	prometheus.MustRegister(dnsResolutions)
+	prometheus.MustRegister(dnsResolutionDuration)
+	prometheus.MustRegister(pathMTU)
+	prometheus.MustRegister(clockSkew)
+	prometheus.MustRegister(certificateExpiry)
+	prometheus.MustRegister(twampRTT)
+	prometheus.MustRegister(twampJitter)
+	prometheus.MustRegister(twampLoss)
+	prometheus.MustRegister(twampOneWayDelay)
+	prometheus.MustRegister(starlinkPopPingDropRate)
+	prometheus.MustRegister(starlinkPopPingLatency)
+	prometheus.MustRegister(starlinkFractionObstructed)
+	prometheus.MustRegister(starlinkSNRAboveNoiseFloor)
+	prometheus.MustRegister(starlinkOutage)
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Record the telemetry reported by a Starlink dish
+func observeStarlinkStatus(iface string, status probe.StarlinkStatus) {
+	starlinkPopPingDropRate.WithLabelValues(iface).Set(status.PopPingDropRate)
+	starlinkPopPingLatency.WithLabelValues(iface).Set(status.PopPingLatencyMs / 1000)
+	starlinkFractionObstructed.WithLabelValues(iface).Set(status.FractionObstructed)
+	starlinkSNRAboveNoiseFloor.WithLabelValues(iface).Set(boolGauge(status.SNRAboveNoiseFloor))
+	starlinkOutage.WithLabelValues(iface).Set(boolGauge(status.Outage))
+}
+
+// Record the phase timings of a successful probe
+func observeProbeTimings(iface string, target string, timings probe.Timings, total time.Duration, trace probeTrace) {
+	phases := map[string]time.Duration{
+		"dns":     timings.DNS,
+		"connect": timings.Connect,
+		"tls":     timings.TLS,
+		"ttfb":    timings.TTFB,
+		"total":   total,
+	}
+
+	for phase, duration := range phases {
+		if duration > 0 {
+			observeWithTrace(probePhaseDuration.WithLabelValues(iface, target, phase), duration.Seconds(), trace)
+		}
+	}
+}
+
+// Record which resolver path resolved a probe target and how long it took,
+// targets which weren't resolved are ignored
+func observeResolution(iface string, result probe.Result) {
+	switch result.Resolver {
+	case probe.ResolverHost, probe.ResolverFallback, probe.ResolverCache:
+	default:
+		return
+	}
+
+	dnsResolutions.WithLabelValues(iface, result.Resolver, result.ResolverAddress).Inc()
+	dnsResolutionDuration.WithLabelValues(iface, result.Resolver).Observe(result.Timings.DNS.Seconds())
+}
+
+// Record the delay, jitter and loss measured by a TWAMP probe
+func observeTWAMPStats(iface string, target string, stats probe.TWAMPStats) {
+	twampLoss.WithLabelValues(iface, target).Set(stats.Loss)
+	if stats.Received == 0 {
+		return
+	}
+
+	twampRTT.WithLabelValues(iface, target).Set(stats.RTT.Seconds())
+	twampJitter.WithLabelValues(iface, target).Set(stats.Jitter.Seconds())
+	twampOneWayDelay.WithLabelValues(iface, target, "forward").Set(stats.ForwardDelay.Seconds())
+	twampOneWayDelay.WithLabelValues(iface, target, "backward").Set(stats.BackwardDelay.Seconds())
+}
+
+// Record when the certificate of an HTTPS target expires, warning when
+// it expires within the configured number of days
+func observeCertificateExpiry(iface string, target string, notAfter time.Time, warningDays int) {
+	days := time.Until(notAfter).Hours() / 24
+	certificateExpiry.WithLabelValues(iface, target).Set(days)
+
+	if warningDays > 0 && days < float64(warningDays) {
+		logger.Warn(
+			"Target certificate expires soon",
+			"interface",
+			iface,
+			"target",
+			target,
+			"not_after",
+			notAfter.Format(time.RFC3339),
+		)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Ask systemd-networkd to reconfigure an interface, which reapplies its
+// .network file and restarts DHCP as after networkctl reconfigure
+func networkdReconfigure(iface string) error {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+
+	bus, err := dialSystemBus()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	_, err = bus.Call(
+		"org.freedesktop.network1",
+		"/org/freedesktop/network1",
+		"org.freedesktop.network1.Manager",
+		"ReconfigureLink",
+		"i",
+		int32(link.Index),
+	)
+
+	return err
+}
+
+// Find the NetworkManager device of an interface
+func nmDevice(bus *dbusConn, iface string) (dbusObjectPath, error) {
+	reply, err := bus.Call(
+		"org.freedesktop.NetworkManager",
+		"/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager",
+		"GetDeviceByIpIface",
+		"s",
+		iface,
+	)
+	if err != nil {
+		return "", err
+	}
+	if len(reply) == 0 {
+		return "", fmt.Errorf("no NetworkManager device for %s", iface)
+	}
+	device, ok := reply[0].(dbusObjectPath)
+	if !ok {
+		return "", fmt.Errorf("no NetworkManager device for %s", iface)
+	}
+
+	return device, nil
+}
+
+// Activate a NetworkManager connection on an interface, the best available
+// connection when uuid is empty, as nmcli connection up or device connect
+func nmActivate(iface string, uuid string) error {
+	bus, err := dialSystemBus()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	device, err := nmDevice(bus, iface)
+	if err != nil {
+		return err
+	}
+
+	connection := dbusObjectPath("/")
+	if uuid != "" {
+		reply, err := bus.Call(
+			"org.freedesktop.NetworkManager",
+			"/org/freedesktop/NetworkManager/Settings",
+			"org.freedesktop.NetworkManager.Settings",
+			"GetConnectionByUuid",
+			"s",
+			uuid,
+		)
+		if err != nil {
+			return err
+		}
+		if len(reply) > 0 {
+			connection, _ = reply[0].(dbusObjectPath)
+		}
+	}
+
+	_, err = bus.Call(
+		"org.freedesktop.NetworkManager",
+		"/org/freedesktop/NetworkManager",
+		"org.freedesktop.NetworkManager",
+		"ActivateConnection",
+		"ooo",
+		connection,
+		device,
+		dbusObjectPath("/"),
+	)
+
+	return err
+}
+
+// Disconnect an interface in NetworkManager, as nmcli device disconnect
+func nmDeactivate(iface string) error {
+	bus, err := dialSystemBus()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+
+	device, err := nmDevice(bus, iface)
+	if err != nil {
+		return err
+	}
+
+	_, err = bus.Call(
+		"org.freedesktop.NetworkManager",
+		device,
+		"org.freedesktop.NetworkManager.Device",
+		"Disconnect",
+		"",
+	)
+
+	return err
+}
+
+// Perform a network manager action on an interface
+func runNetworkManagerAction(ctx context.Context, iface string, action TransitionAction) error {
+	switch action.Type {
+	case actionNetworkdReconfigure:
+		return performAction(ctx, action.Type, iface, "networkd ReconfigureLink", func(ctx context.Context) error {
+			return networkdReconfigure(iface)
+		})
+	case actionNMActivate:
+		description := "NetworkManager ActivateConnection"
+		if action.Connection != "" {
+			description += " " + action.Connection
+		}
+		return performAction(ctx, action.Type, iface, description, func(ctx context.Context) error {
+			return nmActivate(iface, action.Connection)
+		})
+	case actionNMDeactivate:
+		return performAction(ctx, action.Type, iface, "NetworkManager Device.Disconnect", func(ctx context.Context) error {
+			return nmDeactivate(iface)
+		})
+	}
+
+	return fmt.Errorf("invalid network manager action %s", action.Type)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/notify"
+)
+
+type notifyState struct {
+	healthy bool
+	since   time.Time
+	changes []time.Time
+	fired   map[int]bool
+}
+
+type Notifier struct {
+	mu         sync.Mutex
+	queues     []*notify.Queue
+	rules      []NotificationRule
+	interfaces map[string]Interface
+	states     map[string]*notifyState
+	// Longest time between interfaces going down for a site outage
+	siteOutageWindow time.Duration
+	siteOutage       bool
+	// Receives events instead of the sinks when set, used by replays
+	onEvent func(sink string, event notify.Event)
+}
+
+func NewNotifier(ctx context.Context, config Config) (*Notifier, error) {
+	n := &Notifier{
+		rules:            config.NotificationRules,
+		interfaces:       map[string]Interface{},
+		states:           map[string]*notifyState{},
+		siteOutageWindow: config.ProbeConfiguration.SiteOutageWindow,
+	}
+
+	for _, iface := range config.Interfaces {
+		n.interfaces[iface.Name] = iface
+	}
+
+	sinkNames := []string{}
+	for _, sinkConfig := range config.Notifications {
+		var sink notify.Sink
+
+		switch sinkConfig.Type {
+		case "webhook":
+			if sinkConfig.URL == "" {
+				return nil, fmt.Errorf("notification sink %s has no url", sinkConfig.Name)
+			}
+			sink = notify.NewWebhookSink(sinkConfig.URL, config.ProbeConfiguration.Timeout)
+		default:
+			return nil, fmt.Errorf(
+				"notification sink %s has invalid type: %s",
+				sinkConfig.Name,
+				sinkConfig.Type,
+			)
+		}
+
+		if slices.Contains(sinkNames, sinkConfig.Name) {
+			return nil, fmt.Errorf("notification sink %s is defined more than once", sinkConfig.Name)
+		}
+		sinkNames = append(sinkNames, sinkConfig.Name)
+
+		queue := notify.NewQueue(
+			notify.QueueConfig{
+				Name:             sinkConfig.Name,
+				MaxQueued:        sinkConfig.MaxQueued,
+				MaxAge:           sinkConfig.MaxAge,
+				MinStateDuration: sinkConfig.MinStateDuration,
+				Delivered: func() {
+					notificationCounts.Add(sinkConfig.Name, 1)
+				},
+			},
+			sink,
+			logger,
+		)
+		n.queues = append(n.queues, queue)
+
+		go queue.Run(ctx)
+	}
+
+	for i, rule := range n.rules {
+		if err := rule.validate(sinkNames); err != nil {
+			return nil, fmt.Errorf("notification rule %d is invalid: %w", i, err)
+		}
+	}
+
+	if len(n.rules) == 0 {
+		// Without any rules, notify every sink about every event
+		n.rules = []NotificationRule{{Events: notify.EventTypes}}
+	}
+
+	return n, nil
+}
+
+// Record the latest status of an interface and send notifications
+// for any rules which match its current state
+func (n *Notifier) Update(status InterfaceStatus, now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	state, exists := n.states[status.Name]
+	if !exists {
+		// Don't notify about the state found by the first probe,
+		// only about changes from it
+		state = &notifyState{
+			healthy: status.Healthy,
+			since:   now,
+			fired:   map[int]bool{},
+		}
+		for i := range n.rules {
+			state.fired[i] = true
+		}
+		n.states[status.Name] = state
+		n.checkSiteOutage(now)
+
+		for _, queue := range n.queues {
+			queue.Seed(status.Name, status.Healthy)
+		}
+
+		return
+	}
+
+	if state.healthy != status.Healthy {
+		state.healthy = status.Healthy
+		state.since = now
+		state.fired = map[int]bool{}
+		state.changes = append(state.changes, now)
+		n.checkSiteOutage(now)
+	}
+
+	// Only keep state changes which are recent enough to count as flapping
+	for len(state.changes) > 0 && now.Sub(state.changes[0]) > flapWindow {
+		state.changes = state.changes[1:]
+	}
+
+	iface := n.interfaces[status.Name]
+
+	for i, rule := range n.rules {
+		if state.fired[i] || !rule.appliesTo(notify.EventStateChange) || !rule.matches(iface, state, now) {
+			continue
+		}
+		state.fired[i] = true
+
+		event := notify.Event{
+			Type:        notify.EventStateChange,
+			Interface:   status.Name,
+			Description: status.Description,
+			DisplayName: status.DisplayName,
+			Healthy:     status.Healthy,
+			Reason:      status.Reason,
+			Severity:    rule.Severity,
+			Override:    status.Override,
+			Since:       state.since.Unix(),
+			Time:        now.Unix(),
+		}
+		if cpe := cpeStatus(status.Name); cpe != nil {
+			event.PublicIP = cpe.PublicIP
+			event.ASN = cpe.ASN
+			event.ASOrganization = cpe.ASOrganization
+			event.Country = cpe.Country
+		}
+
+		n.deliver(rule.Sinks, event)
+	}
+}
+
+// Send an event which isn't about an interface to the sinks of the rules
+// which apply to its type
+func (n *Notifier) Broadcast(event notify.Event) {
+	for _, rule := range n.rules {
+		if rule.appliesTo(event.Type) {
+			event.Severity = rule.Severity
+			n.deliver(rule.Sinks, event)
+		}
+	}
+}
+
+// Queue an event for some sinks, or every sink when none are given
+func (n *Notifier) deliver(sinks []string, event notify.Event) {
+	if !haActive.Load() {
+		// Only the active member of an HA pair sends notifications
+		return
+	}
+	event = localizeEvent(event)
+
+	for _, queue := range n.queues {
+		if len(sinks) > 0 && !slices.Contains(sinks, queue.Name()) {
+			continue
+		}
+		if n.onEvent != nil {
+			n.onEvent(queue.Name(), event)
+			continue
+		}
+		if event.Type == notify.EventStateChange {
+			queue.Push(event)
+		} else {
+			// Held back events are only for interface state changes
+			queue.Enqueue(event)
+		}
+	}
+}
+
+// Detect every interface going down at about the same time, or being down
+// when wan-prober starts, which suggests the whole site lost power or its
+// upstream rather than several links failing on their own
+func (n *Notifier) checkSiteOutage(now time.Time) {
+	if len(n.interfaces) < 2 || len(n.states) < len(n.interfaces) {
+		return
+	}
+
+	down := true
+	var first, last time.Time
+	for _, state := range n.states {
+		if state.healthy {
+			down = false
+			break
+		}
+		if first.IsZero() || state.since.Before(first) {
+			first = state.since
+		}
+		if state.since.After(last) {
+			last = state.since
+		}
+	}
+
+	if down && !n.siteOutage && last.Sub(first) <= n.siteOutageWindow {
+		n.siteOutage = true
+		logger.Warn(
+			"Every interface went down at once, suspecting a site outage",
+			"interfaces",
+			len(n.interfaces),
+			"within",
+			last.Sub(first),
+		)
+		n.Broadcast(notify.Event{Type: notify.EventSiteOutage, Since: first.Unix(), Time: now.Unix()})
+	} else if !down && n.siteOutage {
+		n.siteOutage = false
+		logger.Info("An interface is back up, site outage is over")
+		n.Broadcast(notify.Event{Type: notify.EventSiteRecovered, Healthy: true, Since: now.Unix(), Time: now.Unix()})
+	}
+}
+
+// Retry delivery of queued events immediately,
+// used when connectivity has been restored
+func (n *Notifier) Replay() {
+	for _, queue := range n.queues {
+		queue.Signal()
+	}
+}
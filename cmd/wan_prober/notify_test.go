@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A flap away from the first state found which reverts sooner than the
+// minimum state duration is never notified, in either direction
+func TestNotifierFirstFlapReverted(t *testing.T) {
+	active := haActive.Load()
+	t.Cleanup(func() {
+		haActive.Store(active)
+	})
+	haActive.Store(true)
+
+	var notifications atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notifications.Add(1)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	minStateDuration := 100 * time.Millisecond
+	notifier, err := NewNotifier(ctx, Config{
+		ProbeConfiguration: ProbeConfiguration{Timeout: time.Second},
+		Interfaces:         []Interface{{Name: "notify-flap0"}},
+		Notifications: []NotificationSink{
+			{Name: "webhook", Type: "webhook", URL: server.URL, MinStateDuration: minStateDuration},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, healthy := range []bool{true, false, true} {
+		notifier.Update(InterfaceStatus{Name: "notify-flap0", Healthy: healthy}, time.Now())
+	}
+	time.Sleep(3 * minStateDuration)
+
+	if n := notifications.Load(); n != 0 {
+		t.Errorf("sent %d notifications for a flap which reverted, want 0", n)
+	}
+}
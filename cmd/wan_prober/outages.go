@@ -0,0 +1,152 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/notify"
+)
+
+var (
+	targetOutages = &targetOutageTracker{
+		observations: map[string]map[string]targetObservation{},
+		outages:      map[string]*targetOutage{},
+	}
+)
+
+// Latest result of probing a target from an interface
+type targetObservation struct {
+	time    time.Time
+	success bool
+}
+
+type targetOutage struct {
+	since time.Time
+	// Target isn't probed until then
+	excludedUntil time.Time
+}
+
+// Detects targets which fail from every interface while other targets
+// work, which are outages of the target rather than of the links
+type targetOutageTracker struct {
+	mu           sync.Mutex
+	interfaces   []string
+	window       time.Duration
+	cooldown     time.Duration
+	sendEvent    func(notify.Event)
+	observations map[string]map[string]targetObservation
+	outages      map[string]*targetOutage
+}
+
+// Set the interfaces probing targets, how recent their results must be to
+// count, how long targets in an outage aren't probed for and where to send
+// outage events
+func (t *targetOutageTracker) configure(
+	interfaces []string,
+	window time.Duration,
+	cooldown time.Duration,
+	sendEvent func(notify.Event),
+) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.interfaces = interfaces
+	t.window = window
+	t.cooldown = cooldown
+	t.sendEvent = sendEvent
+}
+
+// Record the result of probing a target from an interface, and find out
+// whether the target's outage started or ended
+func (t *targetOutageTracker) observe(target string, iface string, success bool, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.observations[target] == nil {
+		t.observations[target] = map[string]targetObservation{}
+	}
+	t.observations[target][iface] = targetObservation{time: now, success: success}
+
+	outage, exists := t.outages[target]
+	if exists {
+		if success {
+			delete(t.outages, target)
+			logger.Info("Target-side outage is over", "target", target, "interface", iface)
+			t.send(notify.Event{Type: notify.EventTargetRecovered, Target: target, Since: outage.since.Unix(), Time: now.Unix()})
+		} else if t.cooldown > 0 && now.After(outage.excludedUntil) {
+			// Still failing after the cooldown, so wait another one
+			outage.excludedUntil = now.Add(t.cooldown)
+		}
+		return
+	}
+
+	if success || !t.targetFailsEverywhere(target, now) || !t.otherTargetWorks(target, now) {
+		return
+	}
+
+	t.outages[target] = &targetOutage{since: now, excludedUntil: now.Add(t.cooldown)}
+	logger.Warn(
+		"Target fails from every interface while other targets work, classifying as target-side outage",
+		"target",
+		target,
+	)
+	t.send(notify.Event{Type: notify.EventTargetOutage, Target: target, Since: now.Unix(), Time: now.Unix()})
+}
+
+// Whether the latest probes of a target from every interface failed, which
+// needs more than one interface to tell the target from the link
+func (t *targetOutageTracker) targetFailsEverywhere(target string, now time.Time) bool {
+	if len(t.interfaces) < 2 {
+		return false
+	}
+
+	for _, iface := range t.interfaces {
+		observation, exists := t.observations[target][iface]
+		if !exists || observation.success || now.Sub(observation.time) > t.window {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether another target was recently reached from any interface, showing
+// that the links work
+func (t *targetOutageTracker) otherTargetWorks(target string, now time.Time) bool {
+	for other, observations := range t.observations {
+		if other == target {
+			continue
+		}
+		for _, observation := range observations {
+			if observation.success && now.Sub(observation.time) <= t.window {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (t *targetOutageTracker) send(event notify.Event) {
+	if t.sendEvent != nil {
+		t.sendEvent(event)
+	}
+}
+
+// Whether a target is in a target-side outage
+func (t *targetOutageTracker) inOutage(target string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, exists := t.outages[target]
+	return exists
+}
+
+// Whether a target in an outage shouldn't be probed yet
+func (t *targetOutageTracker) excluded(target string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	outage, exists := t.outages[target]
+	return exists && now.Before(outage.excludedUntil)
+}
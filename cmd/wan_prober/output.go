@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Formats of the results written to stdout
+const (
+	outputNone   = "none"
+	outputNDJSON = "ndjson"
+)
+
+// Types of lines written to the output stream
+const (
+	outputRound      = "round"
+	outputTransition = "transition"
+)
+
+// Line of the output stream, describing the result of a probe round or a
+// state transition of an interface
+type OutputLine struct {
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Interface   string    `json:"interface"`
+	DisplayName string    `json:"display_name,omitempty"`
+	Healthy     bool      `json:"healthy"`
+	Reason      string    `json:"reason,omitempty"`
+	// Probe round of the interface, counted from 1 at startup
+	Round int `json:"round,omitempty"`
+	// Targets of the round, and how many of them succeeded, were valid,
+	// were unreachable and were excluded for an outage
+	Targets     int `json:"targets,omitempty"`
+	Successes   int `json:"successes,omitempty"`
+	Valid       int `json:"valid,omitempty"`
+	Unreachable int `json:"unreachable,omitempty"`
+	Outages     int `json:"outages,omitempty"`
+	// How long the state before a transition lasted
+	PreviousStateDuration float64 `json:"previous_state_duration_seconds,omitempty"`
+}
+
+// Writes round results and state transitions as JSON lines, for consuming
+// the prober as a pipeline source without the HTTP API
+type outputStream struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func newOutputStream(out io.Writer) *outputStream {
+	return &outputStream{encoder: json.NewEncoder(out)}
+}
+
+// Write a line, when the output stream is enabled
+func (o *outputStream) write(line OutputLine) {
+	if o == nil {
+		return
+	}
+
+	line.Time = reportTime(line.Time)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err := o.encoder.Encode(line); err != nil {
+		logger.Error("Error writing output stream", "error", err.Error())
+	}
+}
+
+// Write the latest state transition of an interface to the output stream
+func writeTransitionOutput(status InterfaceStatus) {
+	if output == nil {
+		return
+	}
+
+	current, exists := interfaceStates.Load(status.Name)
+	if !exists || len(current.Transitions) == 0 {
+		return
+	}
+	transition := current.Transitions[len(current.Transitions)-1]
+
+	output.write(OutputLine{
+		Type:                  outputTransition,
+		Time:                  time.Unix(transition.Time, 0),
+		Interface:             status.Name,
+		DisplayName:           status.DisplayName,
+		Healthy:               transition.Healthy,
+		Reason:                transition.Reason,
+		PreviousStateDuration: float64(transition.PreviousStateDuration),
+	})
+}
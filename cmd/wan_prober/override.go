@@ -0,0 +1,224 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/internal/health"
+)
+
+// Actions of health overrides
+const (
+	overrideForceDown = "force_down"
+	overrideForceUp   = "force_up"
+)
+
+var (
+	overrides = &healthOverrides{
+		active: map[string]api.HealthOverride{},
+		timers: map[string]*time.Timer{},
+		probed: map[string]InterfaceStatus{},
+	}
+)
+
+// Health of interfaces forced by operators, which replaces the health
+// found by probes until the override is cleared or expires
+type healthOverrides struct {
+	mu     sync.Mutex
+	active map[string]api.HealthOverride
+	// Ends overrides which expire
+	timers map[string]*time.Timer
+	// Latest status of each interface before overrides, sent again when
+	// an override changes so it takes effect without waiting for a round
+	probed  map[string]InterfaceStatus
+	channel chan<- InterfaceStatus
+	// Ends when statuses are no longer received
+	ctx context.Context
+}
+
+// Override of an interface, if any
+func healthOverride(iface string) *api.HealthOverride {
+	overrides.mu.Lock()
+	defer overrides.mu.Unlock()
+
+	override, exists := overrides.active[iface]
+	if !exists {
+		return nil
+	}
+
+	return &override
+}
+
+// Apply the override of an interface to a status found by its probes
+func (o *healthOverrides) apply(status InterfaceStatus) InterfaceStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.probed[status.Name] = status
+
+	override, exists := o.active[status.Name]
+	if !exists {
+		return status
+	}
+
+	status.Override = override.Action
+	switch override.Action {
+	case overrideForceDown:
+		status.Healthy = false
+		status.Reason = health.ReasonForcedDown
+	case overrideForceUp:
+		status.Healthy = true
+		status.Reason = health.ReasonForcedUp
+	}
+
+	return status
+}
+
+// Set or clear the override of an interface, an empty action clears it
+func (o *healthOverrides) set(override api.HealthOverride, expiry time.Duration) error {
+	switch override.Action {
+	case "", overrideForceDown, overrideForceUp:
+	default:
+		return fmt.Errorf("invalid action, must be %s or %s", overrideForceDown, overrideForceUp)
+	}
+
+	o.mu.Lock()
+	if timer, exists := o.timers[override.Interface]; exists {
+		timer.Stop()
+		delete(o.timers, override.Interface)
+	}
+
+	if override.Action == "" {
+		delete(o.active, override.Interface)
+		logger.Warn("Cleared health override", "interface", override.Interface)
+	} else {
+		now := time.Now()
+		override.Since = now.Unix()
+		if expiry > 0 {
+			override.Expires = now.Add(expiry).Unix()
+			o.timers[override.Interface] = time.AfterFunc(expiry, func() {
+				o.expire(override)
+			})
+		}
+		o.active[override.Interface] = override
+
+		logger.Warn(
+			"Forcing interface health",
+			"interface",
+			override.Interface,
+			"action",
+			override.Action,
+			"comment",
+			override.Comment,
+			"expires",
+			expiry,
+		)
+	}
+	o.mu.Unlock()
+
+	o.resend(override.Interface)
+
+	return nil
+}
+
+// End an override which expired, unless it was replaced since
+func (o *healthOverrides) expire(override api.HealthOverride) {
+	o.mu.Lock()
+	if o.active[override.Interface] != override {
+		o.mu.Unlock()
+		return
+	}
+	delete(o.active, override.Interface)
+	delete(o.timers, override.Interface)
+	o.mu.Unlock()
+
+	logger.Warn("Health override expired", "interface", override.Interface, "action", override.Action)
+
+	o.resend(override.Interface)
+}
+
+// Send the latest probed status of an interface again, so a changed
+// override takes effect right away
+func (o *healthOverrides) resend(iface string) {
+	o.mu.Lock()
+	status, exists := o.probed[iface]
+	channel := o.channel
+	ctx := o.ctx
+	o.mu.Unlock()
+
+	if !exists || channel == nil {
+		// Applied once the interface finishes its first round
+		return
+	}
+
+	go func() {
+		select {
+		case channel <- status:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// Serve the override API, GET lists overrides and POST forces the health of
+// an interface from the interface, action, expires and comment query
+// parameters, an empty action clears the override
+func registerOverrideHandler(ctx context.Context, channel chan<- InterfaceStatus, ifaces []Interface) {
+	overrides.mu.Lock()
+	overrides.channel = channel
+	overrides.ctx = ctx
+	overrides.mu.Unlock()
+
+	handleRoleFunc(roleAdmin, "/override", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			override := api.HealthOverride{
+				Interface: r.URL.Query().Get("interface"),
+				Action:    r.URL.Query().Get("action"),
+				Comment:   r.URL.Query().Get("comment"),
+			}
+			if !slices.ContainsFunc(ifaces, func(iface Interface) bool { return iface.Name == override.Interface }) {
+				http.Error(w, "Unknown interface", http.StatusBadRequest)
+				return
+			}
+
+			expiry := time.Duration(0)
+			if expires := r.URL.Query().Get("expires"); expires != "" {
+				var err error
+				expiry, err = time.ParseDuration(expires)
+				if err != nil || expiry < 0 {
+					http.Error(w, "Invalid expires, must be a duration like 30m", http.StatusBadRequest)
+					return
+				}
+			}
+
+			if err := overrides.set(override, expiry); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		overrides.mu.Lock()
+		resp := []api.HealthOverride{}
+		for _, override := range overrides.active {
+			override.SinceRFC3339 = reportUnix(override.Since)
+			override.ExpiresRFC3339 = reportUnix(override.Expires)
+			resp = append(resp, override)
+		}
+		overrides.mu.Unlock()
+		slices.SortFunc(resp, func(a, b api.HealthOverride) int {
+			return cmp.Compare(a.Interface, b.Interface)
+		})
+
+		writeJSON(w, r, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Expand an {interface} placeholder in a host or service name template
+func expandInterfaceTemplate(template string, iface string) string {
+	return strings.ReplaceAll(template, "{interface}", iface)
+}
+
+// Sends the health of each interface as a Zabbix trapper item
+type ZabbixFeed struct {
+	config  ZabbixConfig
+	timeout time.Duration
+}
+
+func NewZabbixFeed(config ZabbixConfig, timeout time.Duration) *ZabbixFeed {
+	return &ZabbixFeed{
+		config:  config,
+		timeout: timeout,
+	}
+}
+
+func (f *ZabbixFeed) Name() string {
+	return "zabbix"
+}
+
+func (f *ZabbixFeed) Update(ctx context.Context, status InterfaceStatus) error {
+	value := "0"
+	if status.Healthy {
+		value = "1"
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"request": "sender data",
+		"data": []map[string]string{
+			{
+				"host":  expandInterfaceTemplate(f.config.Host, status.Name),
+				"key":   expandInterfaceTemplate(f.config.Key, status.Name),
+				"value": value,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode zabbix data: %w", err)
+	}
+
+	dialer := net.Dialer{Timeout: f.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", f.config.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(f.timeout))
+
+	// Zabbix protocol header followed by the length of the payload
+	packet := bytes.NewBufferString("ZBXD\x01")
+	binary.Write(packet, binary.LittleEndian, uint64(len(data)))
+	packet.Write(data)
+
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		return err
+	}
+
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("could not read zabbix response: %w", err)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(conn, int64(binary.LittleEndian.Uint64(header[5:]))))
+	if err != nil {
+		return fmt.Errorf("could not read zabbix response: %w", err)
+	}
+
+	response := struct {
+		Response string `json:"response"`
+		Info     string `json:"info"`
+	}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("could not decode zabbix response: %w", err)
+	}
+
+	if response.Response != "success" || !strings.Contains(response.Info, "failed: 0") {
+		return fmt.Errorf("zabbix rejected item: %s", response.Info)
+	}
+
+	return nil
+}
+
+const (
+	nscaPacketVersion = 3
+	nscaIVLength      = 128
+
+	nscaStateOK       = 0
+	nscaStateCritical = 2
+)
+
+// Sends the health of each interface as an NSCA passive service check result
+type NSCAFeed struct {
+	config  NSCAConfig
+	timeout time.Duration
+}
+
+func NewNSCAFeed(config NSCAConfig, timeout time.Duration) (*NSCAFeed, error) {
+	switch config.Encryption {
+	case "", "none", "xor":
+	default:
+		return nil, fmt.Errorf("unsupported NSCA encryption: %s", config.Encryption)
+	}
+
+	return &NSCAFeed{
+		config:  config,
+		timeout: timeout,
+	}, nil
+}
+
+func (f *NSCAFeed) Name() string {
+	return "nsca"
+}
+
+// Build an NSCA data packet, laid out as the C struct used by send_nsca
+func (f *NSCAFeed) packet(timestamp uint32, status InterfaceStatus) []byte {
+	outputLength := 4096
+	if f.config.LegacyPacket {
+		outputLength = 512
+	}
+
+	returnCode := int16(nscaStateCritical)
+	output := "WAN CRITICAL - interface " + status.Name + " is unhealthy"
+	if status.Healthy {
+		returnCode = nscaStateOK
+		output = "WAN OK - interface " + status.Name + " is healthy"
+	}
+
+	fixedString := func(s string, length int) []byte {
+		b := make([]byte, length)
+		copy(b, s[:min(len(s), length-1)])
+		return b
+	}
+
+	packet := &bytes.Buffer{}
+	binary.Write(packet, binary.BigEndian, int16(nscaPacketVersion))
+	packet.Write([]byte{0, 0})
+	binary.Write(packet, binary.BigEndian, uint32(0))
+	binary.Write(packet, binary.BigEndian, timestamp)
+	binary.Write(packet, binary.BigEndian, returnCode)
+	packet.Write(fixedString(expandInterfaceTemplate(f.config.Host, status.Name), 64))
+	packet.Write(fixedString(expandInterfaceTemplate(f.config.Service, status.Name), 128))
+	packet.Write(fixedString(output, outputLength))
+	for packet.Len()%4 != 0 {
+		packet.WriteByte(0)
+	}
+
+	b := packet.Bytes()
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(b))
+
+	return b
+}
+
+func (f *NSCAFeed) Update(ctx context.Context, status InterfaceStatus) error {
+	dialer := net.Dialer{Timeout: f.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", f.config.Server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(f.timeout))
+
+	// Server starts by sending an IV and timestamp
+	init := make([]byte, nscaIVLength+4)
+	if _, err := io.ReadFull(conn, init); err != nil {
+		return fmt.Errorf("could not read NSCA initialization packet: %w", err)
+	}
+
+	packet := f.packet(binary.BigEndian.Uint32(init[nscaIVLength:]), status)
+
+	if f.config.Encryption == "xor" {
+		for i := range packet {
+			packet[i] ^= init[i%nscaIVLength]
+		}
+		if f.config.Password != "" {
+			for i := range packet {
+				packet[i] ^= f.config.Password[i%len(f.config.Password)]
+			}
+		}
+	}
+
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type PeerEndpoint struct {
+	Interface string `json:"interface"`
+	URL       string `json:"url"`
+}
+
+type PeerInfo struct {
+	Site      string         `json:"site"`
+	Endpoints []PeerEndpoint `json:"endpoints"`
+}
+
+type PeerReachability struct {
+	Interface string `json:"interface"`
+	Reachable bool   `json:"reachable"`
+}
+
+type PeerReport struct {
+	Site    string             `json:"site"`
+	Results []PeerReachability `json:"results"`
+}
+
+type inboundReport struct {
+	reachable bool
+	time      time.Time
+}
+
+var (
+	inboundMu sync.Mutex
+	// Inbound reachability of our interfaces as reported by peers,
+	// keyed by interface name then by reporting site
+	inboundReports = map[string]map[string]inboundReport{}
+)
+
+// Register handlers used by peers to discover our endpoints
+// and to report whether they could reach them
+func registerPeerHandlers(config PeeringConfig, interfaces []Interface) {
+	info := PeerInfo{
+		Site:      config.Site,
+		Endpoints: []PeerEndpoint{},
+	}
+	for _, iface := range interfaces {
+		if iface.AdvertiseURL != "" {
+			info.Endpoints = append(info.Endpoints, PeerEndpoint{
+				Interface: iface.Name,
+				URL:       iface.AdvertiseURL,
+			})
+		}
+	}
+
+	handleRoleFunc(roleStatus, "/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	handleRoleFunc(roleStatus, "/peer", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, info)
+	})
+
+	handleRoleFunc(roleStatus, "/peer/report", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		report := PeerReport{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&report); err != nil {
+			http.Error(w, "Invalid report", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+
+		inboundMu.Lock()
+		for _, result := range report.Results {
+			if _, exists := inboundReports[result.Interface]; !exists {
+				inboundReports[result.Interface] = map[string]inboundReport{}
+			}
+			inboundReports[result.Interface][report.Site] = inboundReport{
+				reachable: result.Reachable,
+				time:      now,
+			}
+		}
+		inboundMu.Unlock()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Whether an interface is reachable from the outside, according to recent
+// peer reports, nil when no peer has reported recently
+func inboundReachable(iface string, maxAge time.Duration) *bool {
+	inboundMu.Lock()
+	defer inboundMu.Unlock()
+
+	var reachable *bool
+	for _, report := range inboundReports[iface] {
+		if time.Since(report.time) > maxAge {
+			continue
+		}
+
+		if reachable == nil {
+			reachable = new(bool)
+		}
+		if report.reachable {
+			*reachable = true
+		}
+	}
+
+	return reachable
+}
+
+// Periodically probe the advertised endpoints of each peer
+// and report the results back to it
+func runPeering(ctx context.Context, config PeeringConfig, timeout time.Duration) {
+	client := &http.Client{
+		Timeout: timeout,
+	}
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, peer := range config.Peers {
+			if err := checkPeer(ctx, client, config.Site, peer); err != nil {
+				logger.Warn(
+					"Error checking peer",
+					"peer",
+					peer.URL,
+					"error",
+					err.Error(),
+				)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkPeer(ctx context.Context, client *http.Client, site string, peer Peer) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", peer.URL+"/peer", nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("peer responded with status %d", response.StatusCode)
+	}
+
+	info := PeerInfo{}
+	if err := json.NewDecoder(response.Body).Decode(&info); err != nil {
+		return fmt.Errorf("could not decode peer info: %w", err)
+	}
+
+	report := PeerReport{
+		Site:    site,
+		Results: []PeerReachability{},
+	}
+
+	for _, endpoint := range info.Endpoints {
+		reachable := true
+		if err := pingPeerEndpoint(ctx, client, endpoint.URL); err != nil {
+			reachable = false
+
+			logger.Warn(
+				"Peer interface is unreachable",
+				"peer",
+				info.Site,
+				"interface",
+				endpoint.Interface,
+				"error",
+				err.Error(),
+			)
+		}
+
+		report.Results = append(report.Results, PeerReachability{
+			Interface: endpoint.Interface,
+			Reachable: reachable,
+		})
+	}
+
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("could not encode report: %w", err)
+	}
+
+	request, err = http.NewRequestWithContext(ctx, "POST", peer.URL+"/peer/report", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	reportResponse, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	reportResponse.Body.Close()
+
+	if reportResponse.StatusCode < 200 || reportResponse.StatusCode > 299 {
+		return fmt.Errorf("peer responded to report with status %d", reportResponse.StatusCode)
+	}
+
+	return nil
+}
+
+func pingPeerEndpoint(ctx context.Context, client *http.Client, url string) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
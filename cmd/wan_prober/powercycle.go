@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Kinds of smart plugs and PDUs which can power-cycle a modem
+const (
+	powerCycleTasmota = "tasmota"
+	powerCycleShelly  = "shelly"
+	powerCycleSNMP    = "snmp"
+)
+
+const (
+	actionPowerCycle = "power_cycle"
+
+	defaultPowerCycleOffTime  = 10 * time.Second
+	defaultPowerCycleCooldown = 30 * time.Minute
+
+	// Longest time a smart plug or PDU may take to answer
+	powerCycleRequestTimeout = 10 * time.Second
+)
+
+// SNMP constants (RFC 3416)
+const (
+	snmpVersion2c = 1
+
+	berTypeInteger     = 0x02
+	berTypeOctetString = 0x04
+	berTypeOID         = 0x06
+	berTypeSequence    = 0x30
+
+	snmpPDUGetResponse = 0xa2
+	snmpPDUSetRequest  = 0xa3
+)
+
+// Check the power cycle configuration of an interface, filling in defaults
+func validatePowerCycle(config *PowerCycleConfig) error {
+	switch config.Type {
+	case powerCycleTasmota, powerCycleShelly:
+		if _, err := url.Parse(config.URL); err != nil || config.URL == "" {
+			return fmt.Errorf("power_cycle of type %s needs a valid url", config.Type)
+		}
+	case powerCycleSNMP:
+		if config.Address == "" || config.OID == "" {
+			return errors.New("power_cycle of type snmp needs an address and an oid")
+		}
+		if _, err := parseOID(config.OID); err != nil {
+			return err
+		}
+		if config.OnValue == config.OffValue {
+			return errors.New("power_cycle of type snmp needs different on_value and off_value")
+		}
+		if config.Community == "" {
+			config.Community = "private"
+		}
+	default:
+		return fmt.Errorf(
+			"invalid power_cycle type %s, must be %s, %s or %s",
+			config.Type,
+			powerCycleTasmota,
+			powerCycleShelly,
+			powerCycleSNMP,
+		)
+	}
+
+	if config.OffTime == 0 {
+		config.OffTime = defaultPowerCycleOffTime
+	}
+	if config.Cooldown == 0 {
+		config.Cooldown = defaultPowerCycleCooldown
+	}
+
+	return nil
+}
+
+// Switch the outlet of a modem off, wait and switch it back on
+func powerCycle(ctx context.Context, config PowerCycleConfig) error {
+	if err := setOutletPower(ctx, config, false); err != nil {
+		return fmt.Errorf("could not switch outlet off: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(config.OffTime):
+	}
+
+	// Switch the outlet back on even when shutting down, a modem left off
+	// needs someone on site
+	if err := setOutletPower(context.WithoutCancel(ctx), config, true); err != nil {
+		return fmt.Errorf("could not switch outlet on: %w", err)
+	}
+
+	return nil
+}
+
+// Switch an outlet of a smart plug or PDU on or off
+func setOutletPower(ctx context.Context, config PowerCycleConfig, on bool) error {
+	timeout, cancel := context.WithTimeout(ctx, powerCycleRequestTimeout)
+	defer cancel()
+
+	switch config.Type {
+	case powerCycleTasmota:
+		state := "Off"
+		if on {
+			state = "On"
+		}
+		command := "Power"
+		if config.Outlet > 0 {
+			command += strconv.Itoa(config.Outlet)
+		}
+		query := url.Values{"cmnd": {command + " " + state}}
+		if config.Username != "" {
+			query.Set("user", config.Username)
+			query.Set("password", config.Password)
+		}
+		return powerCycleRequest(timeout, config, "/cm?"+query.Encode())
+	case powerCycleShelly:
+		state := "off"
+		if on {
+			state = "on"
+		}
+		return powerCycleRequest(timeout, config, fmt.Sprintf("/relay/%d?turn=%s", config.Outlet, state))
+	case powerCycleSNMP:
+		value := config.OffValue
+		if on {
+			value = config.OnValue
+		}
+		return snmpSetInteger(timeout, config.Address, config.Community, config.OID, value)
+	}
+
+	return fmt.Errorf("invalid power_cycle type %s", config.Type)
+}
+
+// Send a request to the HTTP API of a smart plug
+func powerCycleRequest(ctx context.Context, config PowerCycleConfig, path string) error {
+	request, err := http.NewRequestWithContext(ctx, "GET", config.URL+path, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if config.Type == powerCycleShelly && config.Username != "" {
+		request.SetBasicAuth(config.Username, config.Password)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("%s responded with status %d", config.Type, response.StatusCode)
+	}
+
+	return nil
+}
+
+// Set an integer object with an SNMPv2c SetRequest, as PDUs expect to
+// control their outlets
+func snmpSetInteger(ctx context.Context, address string, community string, name string, value int) error {
+	o, err := parseOID(name)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, "161")
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	requestID := int(time.Now().UnixNano() & 0x7fffffff)
+	varbind := berEncode(berTypeSequence, append(berOID(o), berInteger(value)...))
+	pdu := berEncode(snmpPDUSetRequest, bytes.Join([][]byte{
+		berInteger(requestID),
+		berInteger(0),
+		berInteger(0),
+		berEncode(berTypeSequence, varbind),
+	}, nil))
+	message := berEncode(berTypeSequence, bytes.Join([][]byte{
+		berInteger(snmpVersion2c),
+		berEncode(berTypeOctetString, []byte(community)),
+		pdu,
+	}, nil))
+
+	if _, err := conn.Write(message); err != nil {
+		return err
+	}
+
+	response := make([]byte, 1500)
+	n, err := conn.Read(response)
+	if err != nil {
+		return err
+	}
+
+	errorStatus, err := snmpErrorStatus(response[:n], requestID)
+	if err != nil {
+		return fmt.Errorf("invalid SNMP response: %w", err)
+	}
+	if errorStatus != 0 {
+		return fmt.Errorf("SNMP agent responded with error status %d", errorStatus)
+	}
+
+	return nil
+}
+
+// Read the error status of an SNMP GetResponse to a request
+func snmpErrorStatus(message []byte, requestID int) (int, error) {
+	tag, body, _, err := berDecode(message)
+	if err != nil || tag != berTypeSequence {
+		return 0, errors.New("not an SNMP message")
+	}
+
+	// Skip the version and community
+	for range 2 {
+		if _, _, body, err = berDecode(body); err != nil {
+			return 0, err
+		}
+	}
+
+	tag, pdu, _, err := berDecode(body)
+	if err != nil || tag != snmpPDUGetResponse {
+		return 0, errors.New("not a response")
+	}
+
+	fields := [2]int{}
+	for i := range fields {
+		var value []byte
+		if tag, value, pdu, err = berDecode(pdu); err != nil || tag != berTypeInteger {
+			return 0, errors.New("missing request ID or error status")
+		}
+		for _, b := range value {
+			fields[i] = fields[i]<<8 | int(b)
+		}
+	}
+	if fields[0] != requestID {
+		return 0, errors.New("response to another request")
+	}
+
+	return fields[1], nil
+}
+
+// Encode a BER type, length and value
+func berEncode(tag byte, value []byte) []byte {
+	buf := []byte{tag}
+	switch length := len(value); {
+	case length < 0x80:
+		buf = append(buf, byte(length))
+	case length <= 0xff:
+		buf = append(buf, 0x81, byte(length))
+	default:
+		buf = append(buf, 0x82, byte(length>>8), byte(length))
+	}
+
+	return append(buf, value...)
+}
+
+// Decode the first BER type, length and value of some data
+func berDecode(data []byte) (byte, []byte, []byte, error) {
+	if len(data) < 2 {
+		return 0, nil, nil, errors.New("truncated")
+	}
+	tag, length, data := data[0], int(data[1]), data[2:]
+	if length&0x80 != 0 {
+		size := length & 0x7f
+		if size == 0 || size > 2 || len(data) < size {
+			return 0, nil, nil, errors.New("invalid length")
+		}
+		length = 0
+		for _, b := range data[:size] {
+			length = length<<8 | int(b)
+		}
+		data = data[size:]
+	}
+	if len(data) < length {
+		return 0, nil, nil, errors.New("truncated")
+	}
+
+	return tag, data[:length], data[length:], nil
+}
+
+// Encode a BER integer in as few bytes as its two's complement needs
+func berInteger(value int) []byte {
+	buf := []byte{}
+	for {
+		buf = append([]byte{byte(value)}, buf...)
+		if value >= -128 && value <= 127 {
+			break
+		}
+		value >>= 8
+	}
+
+	return berEncode(berTypeInteger, buf)
+}
+
+// Encode a BER object identifier
+func berOID(o oid) []byte {
+	buf := []byte{}
+	for i, n := range o {
+		if i == 0 {
+			continue
+		}
+		if i == 1 {
+			n += 40 * o[0]
+		}
+		chunk := []byte{byte(n & 0x7f)}
+		for n >>= 7; n > 0; n >>= 7 {
+			chunk = append([]byte{byte(n&0x7f | 0x80)}, chunk...)
+		}
+		buf = append(buf, chunk...)
+	}
+
+	return berEncode(berTypeOID, buf)
+}
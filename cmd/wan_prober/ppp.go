@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/internal/health"
+)
+
+const (
+	pppPollInterval = time.Second
+)
+
+var (
+	pppPIDFileDirs = []string{"/run", "/var/run"}
+
+	// PPP session state of monitored interfaces
+	pppStatusMap sync.Map
+)
+
+// Check whether a PPP interface exists and its link is up
+func pppSessionUp(iface string) bool {
+	return linkUp(iface)
+}
+
+// Find when the PPP session of an interface started from the pid file
+// which pppd writes once the interface is up
+func pppSessionStart(iface string, pidFile string) (time.Time, bool) {
+	pidFiles := []string{pidFile}
+	if pidFile == "" {
+		pidFiles = []string{}
+		for _, dir := range pppPIDFileDirs {
+			pidFiles = append(pidFiles, filepath.Join(dir, iface+".pid"))
+		}
+	}
+
+	for _, path := range pidFiles {
+		if info, err := os.Stat(path); err == nil {
+			return info.ModTime(), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Watch the PPP session of an interface, the interface is reported
+// unhealthy as soon as its session drops
+func runPPPMonitor(ctx context.Context, channel chan<- InterfaceStatus, iface Interface) {
+	status := api.PPPStatus{}
+
+	ticker := time.NewTicker(pppPollInterval)
+	defer ticker.Stop()
+
+	first := true
+	for {
+		up := pppSessionUp(iface.Name)
+
+		if up && (first || !status.Up) {
+			sessionStart := time.Now()
+			if start, exists := pppSessionStart(iface.Name, iface.PPP.PIDFile); exists {
+				sessionStart = start
+			}
+			status.SessionStart = sessionStart.Unix()
+
+			logger.Info(
+				"PPP session is up",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+			)
+		} else if !up && (first || status.Up) {
+			status.SessionStart = 0
+
+			if !first {
+				status.LastDrop = time.Now().Unix()
+				status.Drops += 1
+			}
+
+			logger.Warn(
+				"PPP session is down",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+			)
+		}
+
+		dropped := !first && status.Up && !up
+		status.Up = up
+		pppStatusMap.Store(iface.Name, status)
+		first = false
+
+		if dropped {
+			// Don't wait for the next probe round to report the drop
+			select {
+			case <-ctx.Done():
+				return
+			case channel <- InterfaceStatus{
+				Name:        iface.Name,
+				Description: iface.Description,
+				DisplayName: iface.DisplayName,
+				Healthy:     false,
+				Reason:      health.ReasonPPPSessionDown,
+			}:
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Look up the PPP session state of an interface, returns nil if it isn't monitored
+func pppStatus(iface string) *api.PPPStatus {
+	val, exists := pppStatusMap.Load(iface)
+	if !exists {
+		return nil
+	}
+
+	switch v := val.(type) {
+	case api.PPPStatus:
+		if v.Up {
+			v.Uptime = time.Now().Unix() - v.SessionStart
+		}
+		return &v
+	}
+
+	return nil
+}
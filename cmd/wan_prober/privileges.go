@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+// Linux capabilities used by wan-prober
+const (
+	capNetBindService = 10
+	capNetAdmin       = 12
+	capNetRaw         = 13
+)
+
+var (
+	capabilityNames = map[int]string{
+		capNetBindService: "CAP_NET_BIND_SERVICE",
+		capNetAdmin:       "CAP_NET_ADMIN",
+		capNetRaw:         "CAP_NET_RAW",
+	}
+
+	// Capabilities kept when dropping to an unprivileged user, so probes
+	// can still open raw sockets and bind to interfaces, and actions can
+	// change routes
+	keptCapabilities = []int{capNetBindService, capNetAdmin, capNetRaw}
+)
+
+// Check the process has the privileges the configuration needs, returns
+// an error explaining what is missing
+func checkPrivileges(ctx context.Context, config Config) error {
+	capabilities, err := effectiveCapabilities()
+	if err != nil {
+		return fmt.Errorf("could not read capabilities: %w", err)
+	}
+
+	// ICMP probes need raw sockets
+	for _, target := range config.Targets {
+		if (target.Probe == "icmp" || target.Probe == "mtu") && capabilities&(1<<capNetRaw) == 0 {
+			return fmt.Errorf(
+				"%s is needed for %s probes of target %s",
+				capabilityNames[capNetRaw],
+				target.Probe,
+				target.Host,
+			)
+		}
+	}
+
+	// Older kernels need CAP_NET_RAW for SO_BINDTODEVICE, so check it works
+	// rather than checking for the capability
+	for _, iface := range config.Interfaces {
+		if _, err := net.InterfaceByName(iface.Name); err != nil {
+			// Missing interfaces are reported by the self-test
+			continue
+		}
+
+		listenConfig := net.ListenConfig{
+			Control: netbind.BindToDevice(iface.Name),
+		}
+		conn, err := listenConfig.ListenPacket(ctx, "udp", ":0")
+		if errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf(
+				"%s is needed to bind probes to interface %s",
+				capabilityNames[capNetRaw],
+				iface.Name,
+			)
+		}
+		if err == nil {
+			conn.Close()
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	linuxCapabilityVersion3 = 0x20080522
+	prSetKeepCaps           = 8
+	prCapAmbient            = 47
+	prCapAmbientRaise       = 2
+)
+
+// Read the effective capabilities of the process
+func effectiveCapabilities() (uint64, error) {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), "CapEff:"); found {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+
+	return 0, errors.New("no CapEff in /proc/self/status")
+}
+
+// User and group IDs of a user, given by name or ID
+func lookupUser(username string) (int, int, error) {
+	account, err := user.Lookup(username)
+	if err != nil {
+		account, err = user.LookupId(username)
+		if err != nil {
+			return 0, 0, fmt.Errorf("unknown user: %s", username)
+		}
+	}
+	uid, _ := strconv.Atoi(account.Uid)
+	gid, _ := strconv.Atoi(account.Gid)
+
+	return uid, gid, nil
+}
+
+// Give a directory and everything in it to the user privileges are dropped
+// to, so state created while privileged can still be updated afterwards
+func chownToUser(path string, username string) error {
+	uid, gid, err := lookupUser(username)
+	if err != nil {
+		return err
+	}
+
+	return filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		return os.Lchown(path, uid, gid)
+	})
+}
+
+// Switch to an unprivileged user, keeping the network capabilities which
+// the process holds so probing keeps working
+func dropPrivileges(username string) error {
+	uid, gid, err := lookupUser(username)
+	if err != nil {
+		return err
+	}
+
+	if os.Getuid() == uid {
+		// Already running as the user, e.g. after a configuration restart
+		return nil
+	}
+
+	capabilities, err := effectiveCapabilities()
+	if err != nil {
+		return fmt.Errorf("could not read capabilities: %w", err)
+	}
+	var kept uint32
+	for _, capability := range keptCapabilities {
+		if capabilities&(1<<capability) != 0 {
+			kept |= 1 << capability
+		}
+	}
+
+	// Capabilities are per thread, so every change must be made on all threads
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_PRCTL, prSetKeepCaps, 1, 0); errno != 0 {
+		if errno == syscall.ENOTSUP {
+			return errors.New("dropping privileges needs a binary built with CGO_ENABLED=0")
+		}
+		return fmt.Errorf("could not keep capabilities: %w", errno)
+	}
+
+	if err := syscall.Setgroups([]int{}); err != nil {
+		return fmt.Errorf("could not clear groups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("could not set group: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("could not set user: %w", err)
+	}
+
+	header := struct {
+		version uint32
+		pid     int32
+	}{
+		version: linuxCapabilityVersion3,
+	}
+	data := [2]struct {
+		effective   uint32
+		permitted   uint32
+		inheritable uint32
+	}{
+		{effective: kept, permitted: kept, inheritable: kept},
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(
+		syscall.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&header)),
+		uintptr(unsafe.Pointer(&data)),
+		0,
+	); errno != 0 {
+		return fmt.Errorf("could not set capabilities: %w", errno)
+	}
+
+	// Ambient capabilities survive the restart which applies a changed
+	// remote configuration
+	for _, capability := range keptCapabilities {
+		if kept&(1<<capability) == 0 {
+			continue
+		}
+		if _, _, errno := syscall.AllThreadsSyscall(
+			syscall.SYS_PRCTL,
+			prCapAmbient,
+			prCapAmbientRaise,
+			uintptr(capability),
+		); errno != 0 {
+			return fmt.Errorf("could not keep %s: %w", capabilityNames[capability], errno)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// Everything under the directory is given to the user, so it can still
+// be written once privileges are dropped
+func TestChownToUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+	uid, gid, err := lookupUser("nobody")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+	paths := []string{dir, filepath.Join(dir, "account.key"), filepath.Join(dir, "sub")}
+	if err := os.WriteFile(paths[1], nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(paths[2], 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := chownToUser(dir, "nobody"); err != nil {
+		t.Fatalf("chownToUser() error = %v", err)
+	}
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := info.Sys().(*syscall.Stat_t)
+		if int(stat.Uid) != uid || int(stat.Gid) != gid {
+			t.Errorf("%s is owned by %d:%d, want %d:%d", path, stat.Uid, stat.Gid, uid, gid)
+		}
+	}
+}
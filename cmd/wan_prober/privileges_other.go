@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+)
+
+// Capabilities are Linux specific, elsewhere whether sockets can be opened
+// is only known when probes open them
+func effectiveCapabilities() (uint64, error) {
+	return ^uint64(0), nil
+}
+
+// Switch to an unprivileged user, which needs Linux capabilities to keep
+// probing working
+func dropPrivileges(username string) error {
+	return errors.New("dropping privileges is only supported on Linux")
+}
+
+// Give a directory to the user privileges are dropped to, which is only
+// supported on Linux
+func chownToUser(path string, username string) error {
+	return errors.New("dropping privileges is only supported on Linux")
+}
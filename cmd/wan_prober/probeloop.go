@@ -0,0 +1,608 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/clock"
+	"github.com/adaricorp/wan-prober/internal/health"
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+// State an interface's probe loop keeps between rounds
+type interfaceProber struct {
+	config        Config
+	iface         Interface
+	probeConfig   probe.Config
+	healthOptions health.Options
+	settler       *roundSettler
+	scheduler     *roundScheduler
+
+	round int
+	// Index of the target which last succeeded, -1 when unknown
+	lastGoodTarget int
+	// Targets which aren't probed until a time after they failed with errors
+	excludedUntil map[int]time.Time
+
+	// Reused by every round, so rounds don't allocate them
+	latencies []time.Duration
+	results   []health.TargetResult
+	// Whether each probed target succeeded
+	targetSuccesses map[int]bool
+}
+
+func newInterfaceProber(config Config, iface Interface, c clock.Clock) *interfaceProber {
+	fallbackResolvers := []string{}
+	for _, fallbackResolver := range config.FallbackResolvers {
+		fallbackResolvers = append(fallbackResolvers, fallbackResolver.String())
+	}
+
+	probeConfig := probe.Config{
+		BindInterface:     iface.Name,
+		FallbackResolvers: fallbackResolvers,
+		Timeout:           config.ProbeConfiguration.Timeout,
+		Budget:            config.ProbeConfiguration.AttemptBudget.probeBudget(config.ProbeConfiguration.Timeout),
+		UserAgent:         config.ProbeConfiguration.UserAgent,
+		Headers:           config.ProbeConfiguration.Headers,
+		FallbackEDNS:      config.FallbackEDNS.probeEDNS(),
+		IPv6Only:          iface.IPv6Only,
+		NAT64Prefix:       iface.NAT64Prefix,
+		CompareResolvers:  config.ProbeConfiguration.CompareResolvers,
+		HTTPKeepAlive:     config.ProbeConfiguration.HTTPKeepAlive,
+		HTTPIdleTimeout:   config.ProbeConfiguration.HTTPIdleTimeout,
+		MaxCacheEntries:   footprint.dnsCacheEntries,
+	}
+
+	if len(config.StaticHosts) > 0 {
+		probeConfig.StaticHosts = map[string][]netip.Addr{}
+		for hostname, addrs := range config.StaticHosts {
+			probeConfig.StaticHosts[strings.ToLower(strings.TrimSuffix(hostname, "."))] = addrs
+		}
+	}
+
+	if config.HostResolver != nil {
+		probeConfig.HostResolver = config.HostResolver.String()
+	}
+
+	healthPolicy := config.ProbeConfiguration.HealthPolicy
+	if iface.HealthPolicy != "" {
+		healthPolicy = iface.HealthPolicy
+	}
+	healthOptions := config.ProbeConfiguration.healthOptions()
+	if healthPolicy != "" {
+		// Policy was validated at startup
+		healthOptions.Policy, _ = health.CompilePolicy(healthPolicy)
+	}
+
+	return &interfaceProber{
+		config:          config,
+		iface:           iface,
+		probeConfig:     probeConfig,
+		healthOptions:   healthOptions,
+		settler:         newRoundSettler(iface),
+		scheduler:       newRoundScheduler(iface, config.ProbeConfiguration.MinInterval, c),
+		lastGoodTarget:  -1,
+		excludedUntil:   map[int]time.Time{},
+		latencies:       []time.Duration{},
+		results:         []health.TargetResult{},
+		targetSuccesses: map[int]bool{},
+	}
+}
+
+// Probe an interface in rounds until a context is done, sending its status
+// after every round
+func probeInterface(
+	ctx context.Context,
+	channel chan<- InterfaceStatus,
+	config Config,
+	iface Interface,
+) {
+	prober := newInterfaceProber(config, iface, clock.Real)
+
+	for ctx.Err() == nil {
+		status := prober.probeRound(ctx)
+
+		select {
+		case channel <- status:
+		case <-ctx.Done():
+			// Replaced by the watchdog or shutting down
+			return
+		}
+
+		prober.scheduler.wait(ctx)
+	}
+}
+
+// Run a round, checking the interface's routes and probing its targets,
+// and return the settled status of the interface
+func (p *interfaceProber) probeRound(ctx context.Context) InterfaceStatus {
+	iface := p.iface
+	config := p.config
+
+	p.round += 1
+	p.scheduler.begin()
+
+	p.latencies = p.latencies[:0]
+	p.results = p.results[:0]
+	clear(p.targetSuccesses)
+
+	logger.Info(
+		"Checking interface health",
+		"interface",
+		iface.Name,
+		"description",
+		iface.Description,
+	)
+
+	if iface.RouteCheck != nil {
+		reason, err := routeCheck(iface)
+		if err != nil {
+			logger.Warn(
+				"Error checking interface routes",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"error",
+				err.Error(),
+			)
+		} else if reason != "" {
+			// Probes would only fail with ENETUNREACH, so report why
+			logger.Warn(
+				"Interface is unhealthy",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"reason",
+				reason,
+			)
+
+			return iface.status(p.settler.settle(ctx, false, reason, p.scheduler.now()))
+		}
+	}
+
+	// Try probes in a random order, favouring targets with more weight
+	order := weightedTargetOrder(config.Targets)
+	if config.ProbeConfiguration.LastGoodTargetFirst && p.healthOptions.Policy == nil {
+		order = targetOrder(order, p.lastGoodTarget)
+	}
+	for _, i := range order {
+		if until, exists := p.excludedUntil[i]; exists {
+			if p.scheduler.now().Before(until) {
+				// Not probed, so not valid
+				p.results = append(p.results, health.TargetResult{})
+				continue
+			}
+			delete(p.excludedUntil, i)
+		}
+		if targetOutages.excluded(config.Targets[i].Host, p.scheduler.now()) {
+			// Target is down, not the link
+			p.results = append(p.results, health.TargetResult{Outage: true})
+			continue
+		}
+
+		target, err := expandTargetMacros(config.Targets[i], iface.Name)
+		if err != nil {
+			logger.Warn(
+				"Could not expand target macro",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"target",
+				config.Targets[i].Host,
+				"error",
+				err.Error(),
+			)
+
+			p.results = append(p.results, health.TargetResult{})
+			continue
+		}
+
+		result := p.probeTarget(ctx, target)
+		success := result.Success
+
+		p.targetSuccesses[i] = success
+		if success {
+			targetOutages.observe(config.Targets[i].Host, iface.Name, true, p.scheduler.now())
+			p.lastGoodTarget = i
+		} else if i == p.lastGoodTarget {
+			p.lastGoodTarget = -1
+		}
+
+		if !success && result.Errors < result.Attempts {
+			targetOutages.observe(config.Targets[i].Host, iface.Name, false, p.scheduler.now())
+			result.Outage = targetOutages.inOutage(config.Targets[i].Host)
+		}
+		p.results = append(p.results, result)
+		targetViews.record(config.Targets[i].Host, iface.Name, health.ClassifyTarget(result), p.scheduler.now())
+
+		if success {
+			// At least one successful probe
+			if p.healthOptions.Policy == nil {
+				break
+			}
+			// Policy needs the results of every target
+			continue
+		}
+
+		if health.ClassifyTarget(result) == health.VerdictInvalid && config.ProbeConfiguration.ErrorExclusion > 0 {
+			// All attempts resulted in an error
+			p.excludedUntil[i] = p.scheduler.now().Add(config.ProbeConfiguration.ErrorExclusion)
+			logger.Info(
+				"Excluding target after errors",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"target",
+				target.Host,
+				"until",
+				p.excludedUntil[i],
+			)
+		}
+	}
+
+	decision, err := health.Evaluate(
+		health.Round{
+			Targets:   len(config.Targets),
+			Results:   p.results,
+			Latencies: p.latencies,
+		},
+		p.healthOptions,
+	)
+	if err != nil {
+		logger.Error(
+			"Error evaluating health policy",
+			"interface",
+			iface.Name,
+			"description",
+			iface.Description,
+			"error",
+			err.Error(),
+		)
+	}
+
+	if decision.Successes > 0 {
+		// Another target proved the link works, so failures are the
+		// target's fault
+		for i, success := range p.targetSuccesses {
+			recordTargetQuality(config.Targets[i].Host, iface.Name, success)
+		}
+	} else {
+		// None of the targets could be probed successfully, so the
+		// heuristic decided from the targets which failed
+		logger.Info(
+			health.DefaultMessages[decision.HeuristicReason],
+			"interface",
+			iface.Name,
+			"description",
+			iface.Description,
+			"targets",
+			len(config.Targets),
+			"valid",
+			decision.Valid,
+			"unreachable",
+			decision.Unreachable,
+		)
+	}
+
+	healthy, reason := p.checkLink(ctx, decision.Healthy, decision.Reason)
+	healthy, reason = p.settler.settle(ctx, healthy, reason, p.scheduler.now())
+
+	if healthy {
+		logger.Info(
+			"Interface is healthy",
+			"interface",
+			iface.Name,
+			"description",
+			iface.Description,
+		)
+	} else {
+		logger.Warn(
+			"Interface is unhealthy",
+			"interface",
+			iface.Name,
+			"description",
+			iface.Description,
+		)
+	}
+
+	output.write(OutputLine{
+		Type:        outputRound,
+		Time:        p.scheduler.now(),
+		Interface:   iface.Name,
+		DisplayName: iface.DisplayName,
+		Healthy:     healthy,
+		Reason:      reason,
+		Round:       p.round,
+		Targets:     len(config.Targets),
+		Successes:   decision.Successes,
+		Valid:       decision.Valid,
+		Unreachable: decision.Unreachable,
+		Outages:     decision.Outages,
+	})
+
+	return iface.status(healthy, reason)
+}
+
+// Probe a target over the interface until an attempt succeeds, or it runs
+// out of attempts
+func (p *interfaceProber) probeTarget(ctx context.Context, target Target) health.TargetResult {
+	iface := p.iface
+	config := p.config
+
+	logger.Info(
+		"Probing target",
+		"interface",
+		iface.Name,
+		"description",
+		iface.Description,
+		"target",
+		target.Host,
+		"type",
+		target.Probe,
+	)
+
+	result := health.TargetResult{}
+
+	targetConfig := p.probeConfig
+	targetConfig.Addresses = target.Addresses
+	targetConfig.IPProtocol = target.IPProtocol
+	targetConfig.HTTP = probe.HTTPProbe{
+		Method:         target.HTTP.Method,
+		Protocol:       target.HTTP.Protocol,
+		MaxBodyBytes:   target.HTTP.MaxBodyBytes,
+		ExpectedSHA256: target.HTTP.ExpectedSHA256,
+		ExpectedStatus: target.HTTP.ExpectedStatus,
+	}
+	if targetConfig.HTTP.MaxBodyBytes == 0 {
+		targetConfig.HTTP.MaxBodyBytes = footprint.maxBodyBytes
+	}
+	targetConfig.DNS = probe.DNSProbe{
+		Server: target.DNS.Server,
+		Type:   target.DNS.Type,
+		DNSSEC: target.DNS.DNSSEC,
+		EDNS:   target.DNS.EDNS.probeEDNS(),
+	}
+	targetConfig.MTU = probe.MTUProbe{
+		Floor: target.MTU.Floor,
+		Max:   target.MTU.Max,
+	}
+	targetConfig.TWAMP = probe.TWAMPProbe{
+		Count:    target.TWAMP.Count,
+		Interval: target.TWAMP.Interval,
+		MaxLoss:  target.TWAMP.MaxLoss,
+	}
+
+	for !result.Success && result.Attempts < config.ProbeConfiguration.Attempts {
+		result.Attempts += 1
+		watchdog.alive(iface.Name)
+
+		prober, exists := probers[target.Probe]
+		if !exists {
+			logger.Error("Invalid prober type", "prober", target.Probe)
+			continue
+		}
+
+		attemptConfig := targetConfig
+		trace := probeTrace{}
+		if *probeTraceIDs {
+			trace = newProbeTrace()
+			attemptConfig.Headers = trace.headers(targetConfig.Headers)
+		}
+
+		start := time.Now()
+		probeResult, err := simulatedProber(prober, iface.Name, target.Host)(
+			ctx,
+			target.Host,
+			attemptConfig,
+			&dnsCache,
+			logger,
+		)
+		duration := time.Since(start)
+		probeCounts.Add(probeOutcome(err), 1)
+
+		p.observeResult(target, probeResult, err, duration, trace)
+
+		if resultLog != nil {
+			record := ProbeResultRecord{
+				Time:            start,
+				Interface:       iface.Name,
+				Target:          target.Host,
+				Probe:           target.Probe,
+				Round:           p.round,
+				Attempt:         result.Attempts,
+				Outcome:         probeOutcome(err),
+				Duration:        duration.Seconds(),
+				Resolver:        probeResult.Resolver,
+				ResolverAddress: probeResult.ResolverAddress,
+				Timings:         newTimings(probeResult.Timings),
+				BodyBytes:       probeResult.BodyBytes,
+				BodySHA256:      probeResult.BodySHA256,
+				PathMTU:         probeResult.PathMTU,
+				Protocol:        probeResult.Protocol,
+				TraceID:         trace.traceID,
+			}
+			if !probeResult.CertificateNotAfter.IsZero() {
+				record.CertNotAfter = &probeResult.CertificateNotAfter
+			}
+			if err != nil {
+				record.ErrorClass = string(probe.ErrorClass(err))
+				record.Error = err.Error()
+			}
+
+			if err := resultLog.Write(record); err != nil {
+				logger.Error("Error writing probe result log", "error", err.Error())
+			}
+		}
+
+		if err != nil {
+			class, handling := classifyFailure(err)
+			if handling.unreachable {
+				result.Timeouts += 1
+			} else {
+				result.Errors += 1
+			}
+
+			logger.Log(
+				ctx,
+				handling.level,
+				handling.message,
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"target",
+				target.Host,
+				"class",
+				class,
+				"error",
+				err.Error(),
+			)
+
+			if handling.final {
+				break
+			}
+
+			if handling.backoff {
+				// Wait before trying again, in case this
+				// is a temporary error which will clear
+				p.scheduler.backoff(ctx, config.ProbeConfiguration.Timeout)
+			}
+		} else {
+			result.Success = true
+			p.latencies = append(p.latencies, duration)
+
+			logger.Info(
+				"Probe target is healthy",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"target",
+				target.Host,
+			)
+		}
+	}
+
+	return result
+}
+
+// Record the metrics of a probe attempt
+func (p *interfaceProber) observeResult(
+	target Target,
+	result probe.Result,
+	err error,
+	duration time.Duration,
+	trace probeTrace,
+) {
+	iface := p.iface
+	probeConfig := p.config.ProbeConfiguration
+
+	if err == nil {
+		observeProbeTimings(iface.displayName(), target.Host, result.Timings, duration, trace)
+	}
+	if result.Starlink != nil {
+		observeStarlinkStatus(iface.displayName(), *result.Starlink)
+	}
+	observeResolution(iface.displayName(), result)
+	if result.ResolverComparison != nil {
+		dnsDivergences.observe(iface, target.Host, *result.ResolverComparison, p.scheduler.now())
+	}
+	if result.PathMTU > 0 {
+		pathMTU.WithLabelValues(iface.displayName(), target.Host).Set(float64(result.PathMTU))
+	}
+	if result.TWAMP != nil {
+		observeTWAMPStats(iface.displayName(), target.Host, *result.TWAMP)
+	}
+	if !result.CertificateNotAfter.IsZero() {
+		observeCertificateExpiry(
+			iface.displayName(),
+			target.Host,
+			result.CertificateNotAfter,
+			probeConfig.CertificateExpiryWarningDays,
+		)
+	}
+	if result.ClockSkew != nil {
+		observeClockSkew(
+			iface,
+			target.Host,
+			*result.ClockSkew,
+			probeConfig.MaxClockSkew,
+		)
+	}
+}
+
+// Fail an interface whose probes succeeded when its PPP session is down,
+// it lost its DHCP lease or its traffic leaves through another path
+func (p *interfaceProber) checkLink(ctx context.Context, healthy bool, reason string) (bool, string) {
+	iface := p.iface
+
+	if healthy && iface.PPP != nil && !pppSessionUp(iface.Name) {
+		// Probes can't run over a missing PPP interface, which
+		// would otherwise leave no valid targets
+		logger.Warn(
+			"Interface PPP session is down",
+			"interface",
+			iface.Name,
+			"description",
+			iface.Description,
+		)
+
+		return false, health.ReasonPPPSessionDown
+	}
+
+	if healthy && iface.DHCP != nil && iface.DHCP.FailOnLeaseLoss {
+		if status := dhcpStatus(iface.Name); status != nil && status.Lost {
+			logger.Warn(
+				"Interface has lost its DHCP lease",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+			)
+
+			return false, health.ReasonDHCPLeaseLost
+		}
+	}
+
+	if healthy && iface.EgressCheck != nil {
+		addr, err := checkEgress(ctx, iface.Name, *iface.EgressCheck, p.config.ProbeConfiguration.Timeout)
+		if err != nil {
+			// Says nothing about the path probes took
+			logger.Warn(
+				"Error checking interface egress",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"error",
+				err.Error(),
+			)
+		} else if expected, other := egressExpected(iface.Name, *iface.EgressCheck, addr); !expected {
+			// Probes bound to the interface were policy routed out of
+			// another one, so their success proves nothing
+			logger.Warn(
+				"Interface traffic is leaving through the wrong path",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"source_address",
+				addr.String(),
+				"other_interface",
+				other,
+			)
+
+			return false, health.ReasonWrongEgress
+		} else {
+			egressAddressMap.Store(iface.Name, addr)
+		}
+	}
+
+	return healthy, reason
+}
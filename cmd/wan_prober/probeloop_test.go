@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/clock"
+	"github.com/adaricorp/wan-prober/internal/health"
+)
+
+func newTestInterfaceProber(t *testing.T, config Config, iface Interface) *interfaceProber {
+	t.Helper()
+
+	config.Interfaces = []Interface{iface}
+	if err := prepareConfig(&config); err != nil {
+		t.Fatalf("prepareConfig() error = %v", err)
+	}
+
+	return newInterfaceProber(config, iface, clock.Real)
+}
+
+// Simulate the outcome of probes of a target over an interface, until the
+// test ends
+func simulateOutcome(t *testing.T, iface string, target string, outcome string) {
+	t.Helper()
+
+	if err := setSimulation(Simulation{Interface: iface, Target: target, Outcome: outcome}); err != nil {
+		t.Fatalf("setSimulation() error = %v", err)
+	}
+	t.Cleanup(func() {
+		simulations.Delete(simulationKey(iface, target))
+	})
+}
+
+// Rounds of an interface which doesn't exist report it has no address,
+// under its display name, without probing targets
+func TestProbeRoundRouteCheckNoAddress(t *testing.T) {
+	iface := Interface{
+		Name:        "round-missing0",
+		DisplayName: "Missing WAN",
+		RouteCheck:  &RouteCheckConfig{},
+	}
+	prober := newTestInterfaceProber(t, Config{Targets: []Target{{Host: "example.com", Probe: "tcp"}}}, iface)
+
+	status := prober.probeRound(context.Background())
+	want := InterfaceStatus{
+		Name:        iface.Name,
+		DisplayName: iface.DisplayName,
+		Healthy:     false,
+		Reason:      health.ReasonNoAddress,
+	}
+	if status != want {
+		t.Errorf("probeRound() = %+v, want %+v", status, want)
+	}
+	if len(prober.results) != 0 {
+		t.Errorf("probeRound() probed %d targets, want none", len(prober.results))
+	}
+}
+
+func TestProbeRoundTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		outcomes map[string]string
+		healthy  bool
+		reason   string
+	}{
+		{
+			"one target reachable",
+			map[string]string{"down.example": simulateDown, "up.example": simulateUp},
+			true,
+			health.ReasonTargetReachable,
+		},
+		{
+			"all targets unreachable",
+			map[string]string{"down.example": simulateDown, "up.example": simulateDown},
+			false,
+			health.ReasonAllUnreachable,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			iface := Interface{Name: fmt.Sprintf("round-targets%d", i)}
+			config := Config{}
+			for host, outcome := range test.outcomes {
+				simulateOutcome(t, iface.Name, host, outcome)
+				config.Targets = append(config.Targets, Target{Host: host, Probe: "tcp"})
+			}
+			prober := newTestInterfaceProber(t, config, iface)
+
+			status := prober.probeRound(context.Background())
+			if status.Healthy != test.healthy || status.Reason != test.reason {
+				t.Errorf(
+					"probeRound() = (%t, %q), want (%t, %q)",
+					status.Healthy,
+					status.Reason,
+					test.healthy,
+					test.reason,
+				)
+			}
+		})
+	}
+}
+
+// Targets whose attempts all failed with errors aren't probed again until
+// the error exclusion ends
+func TestProbeRoundErrorExclusion(t *testing.T) {
+	iface := Interface{Name: "round-exclusion0"}
+	simulateOutcome(t, iface.Name, "error.example", simulateError)
+	simulateOutcome(t, iface.Name, "up.example", simulateUp)
+
+	config := Config{
+		ProbeConfiguration: ProbeConfiguration{
+			Attempts:       1,
+			ErrorExclusion: time.Hour,
+			// Errors back off for a timeout before the next attempt
+			Timeout: time.Millisecond,
+		},
+		Targets: []Target{
+			{Host: "error.example", Probe: "tcp"},
+			{Host: "up.example", Probe: "tcp", Weight: 0.001},
+		},
+	}
+	prober := newTestInterfaceProber(t, config, iface)
+
+	for round := range 20 {
+		prober.probeRound(context.Background())
+		if _, excluded := prober.excludedUntil[0]; excluded {
+			return
+		}
+		if round == 19 {
+			t.Fatalf("error.example wasn't excluded after %d rounds", round+1)
+		}
+	}
+}
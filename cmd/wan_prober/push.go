@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+)
+
+// HTTP client pushing to the aggregator, presenting the client
+// certificate and verifying the aggregator with the CA when configured
+func pushClient(config PushConfig, timeout time.Duration) (*http.Client, error) {
+	client := &http.Client{
+		Timeout: timeout,
+	}
+	if config.TLS == nil {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if config.TLS.CAFile != "" {
+		pem, err := os.ReadFile(config.TLS.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", config.TLS.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.TLS.CertFile != "" {
+		// Fail early rather than on the first push
+		if _, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile); err != nil {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			certificate, err := tls.LoadX509KeyPair(config.TLS.CertFile, config.TLS.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &certificate, nil
+		}
+	}
+
+	client.Transport = &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+
+	return client, nil
+}
+
+// Periodically push the status of all interfaces to an aggregator
+func runPush(ctx context.Context, client *http.Client, config PushConfig) {
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := pushStatus(ctx, client, config); err != nil {
+			logger.Warn(
+				"Error pushing status to aggregator",
+				"url",
+				config.URL,
+				"error",
+				err.Error(),
+			)
+		}
+	}
+}
+
+func pushStatus(ctx context.Context, client *http.Client, config PushConfig) error {
+	body, err := json.Marshal(api.SiteStatusPush{
+		Site:       config.Site,
+		Interfaces: interfaceStatuses(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode status: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, "POST", config.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if config.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+config.Token)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("aggregator responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
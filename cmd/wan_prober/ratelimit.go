@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Clients idle for this long are forgotten
+	rateLimitIdle = 10 * time.Minute
+)
+
+var (
+	// Limits requests to status endpoints from each client, nil when
+	// unlimited
+	statusRateLimiter *clientRateLimiter
+)
+
+// Token bucket of a client
+type rateLimitBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limits the rate of requests from each client with a token bucket
+type clientRateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	clients map[string]*rateLimitBucket
+	swept   time.Time
+}
+
+func newClientRateLimiter(config RateLimitConfig) (*clientRateLimiter, error) {
+	if config.RequestsPerSecond <= 0 {
+		return nil, errors.New("requests_per_second must be positive")
+	}
+	if config.Burst < 0 {
+		return nil, errors.New("burst can't be negative")
+	}
+
+	return &clientRateLimiter{
+		rate:    config.RequestsPerSecond,
+		burst:   float64(max(config.Burst, 1)),
+		clients: map[string]*rateLimitBucket{},
+	}, nil
+}
+
+// Take a token for a request from a client, returning how long it must
+// wait when there are none
+func (l *clientRateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) > rateLimitIdle {
+		for key, bucket := range l.clients {
+			if now.Sub(bucket.last) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.swept = now
+	}
+
+	bucket, exists := l.clients[client]
+	if !exists {
+		bucket = &rateLimitBucket{tokens: l.burst, last: now}
+		l.clients[client] = bucket
+	}
+
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens -= 1
+
+	return true, 0
+}
+
+// Wrap a handler so requests from clients over the rate limit are refused
+// with 429 Too Many Requests, clients are told apart by their address
+func rateLimitHandler(limiter *clientRateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		if allowed, wait := limiter.allow(client, time.Now()); !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
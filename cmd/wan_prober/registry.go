@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Service registry which is told about the status of every probed interface
+type StatusFeed interface {
+	Name() string
+	Update(ctx context.Context, status InterfaceStatus) error
+}
+
+// Deliver statuses to a feed without blocking the caller,
+// statuses are dropped if the feed falls behind
+type statusFeedQueue struct {
+	feed     StatusFeed
+	statuses chan InterfaceStatus
+}
+
+func newStatusFeedQueue(ctx context.Context, feed StatusFeed) *statusFeedQueue {
+	q := &statusFeedQueue{
+		feed:     feed,
+		statuses: make(chan InterfaceStatus, 16),
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case status := <-q.statuses:
+				if err := q.feed.Update(ctx, status); err != nil {
+					logger.Warn(
+						"Error updating status feed",
+						"feed",
+						q.feed.Name(),
+						"interface",
+						status.Name,
+						"error",
+						err.Error(),
+					)
+				}
+			}
+		}
+	}()
+
+	return q
+}
+
+func (q *statusFeedQueue) push(status InterfaceStatus) {
+	select {
+	case q.statuses <- status:
+	default:
+		logger.Warn("Status feed is falling behind", "feed", q.feed.Name())
+	}
+}
+
+// Send a JSON request to a registry API
+func registryRequest(
+	ctx context.Context,
+	client *http.Client,
+	method string,
+	url string,
+	body interface{},
+	headers map[string]string,
+) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("could not encode request: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("registry responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
+
+// Registers each interface as a Consul service with a TTL health check
+// which is passed or failed on every probe round
+type ConsulFeed struct {
+	config     ConsulConfig
+	client     *http.Client
+	registered map[string]bool
+}
+
+func NewConsulFeed(config ConsulConfig, timeout time.Duration) *ConsulFeed {
+	return &ConsulFeed{
+		config:     config,
+		client:     &http.Client{Timeout: timeout},
+		registered: map[string]bool{},
+	}
+}
+
+func (f *ConsulFeed) Name() string {
+	return "consul"
+}
+
+func (f *ConsulFeed) headers() map[string]string {
+	if f.config.Token == "" {
+		return nil
+	}
+	return map[string]string{"X-Consul-Token": f.config.Token}
+}
+
+func (f *ConsulFeed) Update(ctx context.Context, status InterfaceStatus) error {
+	serviceID := f.config.Service + "-" + status.Name
+	checkID := serviceID + "-health"
+
+	if !f.registered[status.Name] {
+		err := registryRequest(
+			ctx,
+			f.client,
+			"PUT",
+			f.config.Address+"/v1/agent/service/register",
+			map[string]interface{}{
+				"ID":   serviceID,
+				"Name": f.config.Service,
+				"Tags": []string{status.Name},
+				"Meta": map[string]string{
+					"interface":   status.Name,
+					"description": status.Description,
+				},
+				"Check": map[string]string{
+					"CheckID": checkID,
+					"Name":    "WAN health of " + status.Name,
+					"TTL":     f.config.TTL.String(),
+				},
+			},
+			f.headers(),
+		)
+		if err != nil {
+			return fmt.Errorf("could not register service: %w", err)
+		}
+		f.registered[status.Name] = true
+	}
+
+	checkStatus := "critical"
+	output := "Interface is unhealthy"
+	if status.Healthy {
+		checkStatus = "passing"
+		output = "Interface is healthy"
+	}
+
+	return registryRequest(
+		ctx,
+		f.client,
+		"PUT",
+		f.config.Address+"/v1/agent/check/update/"+checkID,
+		map[string]string{
+			"Status": checkStatus,
+			"Output": output,
+		},
+		f.headers(),
+	)
+}
+
+// Writes the status of each interface to an etcd key
+// using the etcd v3 JSON gateway
+type EtcdFeed struct {
+	config EtcdConfig
+	client *http.Client
+}
+
+func NewEtcdFeed(config EtcdConfig, timeout time.Duration) *EtcdFeed {
+	return &EtcdFeed{
+		config: config,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (f *EtcdFeed) Name() string {
+	return "etcd"
+}
+
+func (f *EtcdFeed) Update(ctx context.Context, status InterfaceStatus) error {
+	value, err := json.Marshal(map[string]interface{}{
+		"name":    status.Name,
+		"healthy": status.Healthy,
+		"time":    time.Now().Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not encode status: %w", err)
+	}
+
+	key := strings.TrimSuffix(f.config.Prefix, "/") + "/" + status.Name
+
+	return registryRequest(
+		ctx,
+		f.client,
+		"POST",
+		f.config.Endpoint+"/v3/kv/put",
+		map[string]string{
+			"key":   base64.StdEncoding.EncodeToString([]byte(key)),
+			"value": base64.StdEncoding.EncodeToString(value),
+		},
+		nil,
+	)
+}
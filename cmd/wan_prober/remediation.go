@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+// Kinds of remediation actions performed on unhealthy interfaces
+const (
+	actionDHCPRenew   = "dhcp_renew"
+	actionBounce      = "bounce"
+	actionRestartPPPD = "restart_pppd"
+)
+
+const (
+	defaultRemediationAfterRounds = 3
+	defaultRemediationInterval    = 5 * time.Minute
+	defaultRemediationMaxAttempts = 3
+
+	// How long a bounced interface is left down
+	bounceDownTime = 2 * time.Second
+)
+
+var (
+	remediationActions = []string{
+		actionDHCPRenew,
+		actionBounce,
+		actionRestartPPPD,
+		actionPowerCycle,
+		actionHook,
+	}
+
+	// DHCP clients tried in order when no renew command is configured,
+	// the interface name is appended
+	dhcpRenewCommands = [][]string{
+		{"networkctl", "renew"},
+		{"dhcpcd", "--rebind"},
+		{"dhclient", "-1"},
+	}
+)
+
+// Tries to fix an unhealthy interface by performing its remediation
+// actions after enough consecutive unhealthy rounds
+type remediator struct {
+	iface  Interface
+	config RemediationConfig
+	// Consecutive unhealthy rounds
+	unhealthyRounds int
+	// Remediation attempts since the interface was last healthy
+	attempts    int
+	lastAttempt time.Time
+	// Kept across outages, so a modem which keeps failing isn't power
+	// cycled more often than its cooldown
+	lastPowerCycle time.Time
+	running        atomic.Bool
+}
+
+// Check the remediation configuration of an interface, filling in defaults
+func validateRemediation(config *RemediationConfig) error {
+	if len(config.Actions) == 0 {
+		return errors.New("remediation has no actions")
+	}
+	for _, action := range config.Actions {
+		if !slices.Contains(remediationActions, action) {
+			return fmt.Errorf(
+				"invalid remediation action %s, must be %s",
+				action,
+				strings.Join(remediationActions, ", "),
+			)
+		}
+	}
+	if slices.Contains(config.Actions, actionHook) && len(config.Hook) == 0 {
+		return errors.New("remediation action hook needs a hook command")
+	}
+
+	if slices.Contains(config.Actions, actionPowerCycle) {
+		if config.PowerCycle == nil {
+			return errors.New("remediation action power_cycle needs a power_cycle configuration")
+		}
+		if err := validatePowerCycle(config.PowerCycle); err != nil {
+			return err
+		}
+	}
+
+	if config.AfterRounds == 0 {
+		config.AfterRounds = defaultRemediationAfterRounds
+	}
+	if config.Interval == 0 {
+		config.Interval = defaultRemediationInterval
+	}
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = defaultRemediationMaxAttempts
+	}
+
+	return nil
+}
+
+// Create the remediator of an interface, nil when it has no remediation
+func newRemediator(iface Interface) *remediator {
+	if iface.Remediation == nil {
+		return nil
+	}
+
+	return &remediator{iface: iface, config: *iface.Remediation}
+}
+
+// Record the health found by a probe round, and start a remediation
+// attempt when the interface has been unhealthy for long enough. Attempts
+// escalate through the actions in order, are spaced by the interval and
+// stop after the maximum until the interface is healthy again, so a link
+// which can't be fixed isn't bounced forever.
+func (r *remediator) observe(ctx context.Context, healthy bool, now time.Time) {
+	if r == nil {
+		return
+	}
+
+	if healthy {
+		if r.attempts > 0 {
+			logger.Info(
+				"Interface is healthy again after remediation",
+				"interface",
+				r.iface.Name,
+				"description",
+				r.iface.Description,
+				"attempts",
+				r.attempts,
+			)
+		}
+		r.unhealthyRounds = 0
+		r.attempts = 0
+		return
+	}
+
+	r.unhealthyRounds += 1
+	if r.unhealthyRounds < r.config.AfterRounds ||
+		r.attempts >= r.config.MaxAttempts ||
+		(r.attempts > 0 && now.Sub(r.lastAttempt) < r.config.Interval) {
+		return
+	}
+	if !r.running.CompareAndSwap(false, true) {
+		// Previous attempt is still running
+		return
+	}
+
+	action := r.config.Actions[min(r.attempts, len(r.config.Actions)-1)]
+	if action == actionPowerCycle {
+		if !r.lastPowerCycle.IsZero() && now.Sub(r.lastPowerCycle) < r.config.PowerCycle.Cooldown {
+			r.running.Store(false)
+			logger.Debug(
+				"Not power cycling interface during cooldown",
+				"interface",
+				r.iface.Name,
+				"description",
+				r.iface.Description,
+				"last_power_cycle",
+				r.lastPowerCycle,
+			)
+			return
+		}
+		r.lastPowerCycle = now
+	}
+	r.attempts += 1
+	r.lastAttempt = now
+
+	if r.attempts == r.config.MaxAttempts {
+		logger.Warn(
+			"Last remediation attempt for interface, no more until it is healthy",
+			"interface",
+			r.iface.Name,
+			"description",
+			r.iface.Description,
+			"action",
+			action,
+		)
+	}
+
+	go func() {
+		defer r.running.Store(false)
+
+		// Failures are logged by performAction
+		_ = remediate(ctx, r.iface, r.config, action)
+	}()
+}
+
+// Perform a remediation action on an interface
+func remediate(ctx context.Context, iface Interface, config RemediationConfig, action string) error {
+	switch action {
+	case actionDHCPRenew:
+		command := config.DHCPRenewCommand
+		if len(command) == 0 {
+			command = defaultDHCPRenewCommand(iface.Name)
+		}
+		if len(command) == 0 {
+			logger.Warn(
+				"No DHCP client found to renew the lease of interface",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+			)
+			return errors.New("no DHCP client found")
+		}
+		return runCommandAction(ctx, actionDHCPRenew, iface.Name, command, nil)
+	case actionBounce:
+		return performAction(ctx, actionBounce, iface.Name, "set link down and up", func(ctx context.Context) error {
+			return bounceInterface(ctx, iface.Name)
+		})
+	case actionRestartPPPD:
+		pidFile := ""
+		if iface.PPP != nil {
+			pidFile = iface.PPP.PIDFile
+		}
+		return performAction(ctx, actionRestartPPPD, iface.Name, "send SIGHUP to pppd", func(ctx context.Context) error {
+			return restartPPPD(iface.Name, pidFile)
+		})
+	case actionPowerCycle:
+		description := fmt.Sprintf("switch %s outlet off for %s", config.PowerCycle.Type, config.PowerCycle.OffTime)
+		return performAction(ctx, actionPowerCycle, iface.Name, description, func(ctx context.Context) error {
+			return powerCycle(ctx, *config.PowerCycle)
+		})
+	case actionHook:
+		return runCommandAction(
+			ctx,
+			actionHook,
+			iface.Name,
+			config.Hook,
+			[]string{"WAN_PROBER_INTERFACE=" + iface.Name},
+		)
+	}
+
+	return fmt.Errorf("invalid remediation action %s", action)
+}
+
+// Find the command renewing the DHCP lease of an interface with the
+// first DHCP client which is installed
+func defaultDHCPRenewCommand(iface string) []string {
+	for _, command := range dhcpRenewCommands {
+		if _, err := exec.LookPath(command[0]); err == nil {
+			return append(slices.Clone(command), iface)
+		}
+	}
+
+	return nil
+}
+
+// Set an interface down and back up, which restarts link negotiation and
+// makes most DHCP clients and PPP daemons start over
+func bounceInterface(ctx context.Context, iface string) error {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+
+	if err := netbind.SetLinkUp(link.Index, false); err != nil {
+		return fmt.Errorf("could not set link down: %w", err)
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(bounceDownTime):
+	}
+
+	if err := netbind.SetLinkUp(link.Index, true); err != nil {
+		return fmt.Errorf("could not set link up: %w", err)
+	}
+
+	return nil
+}
+
+// Make pppd drop and re-establish the session of an interface by sending
+// it SIGHUP, which needs pppd to run with the persist option
+func restartPPPD(iface string, pidFile string) error {
+	pidFiles := []string{pidFile}
+	if pidFile == "" {
+		pidFiles = []string{}
+		for _, dir := range pppPIDFileDirs {
+			pidFiles = append(pidFiles, filepath.Join(dir, iface+".pid"))
+		}
+	}
+
+	for _, path := range pidFiles {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// pppd writes the pid followed by the interface name
+		fields := strings.Fields(string(contents))
+		if len(fields) == 0 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return fmt.Errorf("invalid pid file %s: %w", path, err)
+		}
+
+		process, err := os.FindProcess(pid)
+		if err != nil {
+			return err
+		}
+		return process.Signal(syscall.SIGHUP)
+	}
+
+	return fmt.Errorf("no pppd pid file found for %s", iface)
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	remoteConfigTimeout = 10 * time.Second
+	// Largest remote configuration which will be read
+	maxRemoteConfigBytes = 4 * 1024 * 1024
+)
+
+var (
+	remoteConfigClient = &http.Client{Timeout: remoteConfigTimeout}
+)
+
+// Check a configuration URL is fetched over HTTPS, configurations run hook
+// commands so plain HTTP needs --config-url-insecure
+func checkConfigURL(configURL string) error {
+	parsed, err := url.Parse(configURL)
+	if err != nil {
+		return fmt.Errorf("could not parse config URL: %w", err)
+	}
+
+	scheme := parsed.Scheme
+	if _, transport, found := strings.Cut(scheme, "+"); found {
+		scheme = transport
+	}
+	if scheme == "http" && !*configURLInsecure {
+		return fmt.Errorf("config URL scheme %s isn't encrypted, use https or --config-url-insecure", parsed.Scheme)
+	}
+
+	return nil
+}
+
+// Fetch a configuration from a URL, http and https URLs are fetched as they
+// are, etcd+http(s) URLs read a key with the etcd v3 JSON gateway and
+// consul+http(s) URLs read a key from the Consul KV store
+func fetchRemoteConfig(ctx context.Context, configURL string) ([]byte, error) {
+	if err := checkConfigURL(configURL); err != nil {
+		return nil, err
+	}
+	parsed, err := url.Parse(configURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse config URL: %w", err)
+	}
+
+	scheme, store, _ := strings.Cut(parsed.Scheme, "+")
+	if store != "" {
+		scheme, store = store, scheme
+	}
+	key := strings.TrimPrefix(parsed.Path, "/")
+	base := url.URL{Scheme: scheme, Host: parsed.Host}
+
+	var request *http.Request
+	switch store {
+	case "":
+		request, err = http.NewRequestWithContext(ctx, "GET", configURL, nil)
+	case "etcd":
+		payload, _ := json.Marshal(map[string]string{
+			"key": base64.StdEncoding.EncodeToString([]byte(key)),
+		})
+		request, err = http.NewRequestWithContext(
+			ctx,
+			"POST",
+			base.String()+"/v3/kv/range",
+			bytes.NewReader(payload),
+		)
+		if err == nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+	case "consul":
+		request, err = http.NewRequestWithContext(ctx, "GET", base.String()+"/v1/kv/"+key+"?raw", nil)
+		if err == nil && os.Getenv("CONSUL_HTTP_TOKEN") != "" {
+			request.Header.Set("X-Consul-Token", os.Getenv("CONSUL_HTTP_TOKEN"))
+		}
+	default:
+		return nil, fmt.Errorf("unsupported config URL scheme: %s", parsed.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	response, err := remoteConfigClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return nil, fmt.Errorf("config server responded with status %d", response.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxRemoteConfigBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	if store != "etcd" {
+		return body, nil
+	}
+
+	rangeResponse := struct {
+		KVs []struct {
+			Value string `json:"value"`
+		} `json:"kvs"`
+	}{}
+	if err := json.Unmarshal(body, &rangeResponse); err != nil {
+		return nil, fmt.Errorf("could not decode etcd response: %w", err)
+	}
+	if len(rangeResponse.KVs) == 0 {
+		return nil, fmt.Errorf("etcd key doesn't exist: %s", key)
+	}
+
+	return base64.StdEncoding.DecodeString(rangeResponse.KVs[0].Value)
+}
+
+// Write a file by replacing it atomically, so a crash never leaves a
+// partial file behind and readers never see one
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Check a file can be replaced by writeFileAtomic, which needs its
+// directory to be writable
+func checkConfigCacheWritable(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+
+	return os.Remove(tmp.Name())
+}
+
+// Fetch the remote configuration and cache it in the configuration file,
+// returns whether the cached configuration changed. Configurations which
+// fail the checks made at startup are never cached, so a bad push can't
+// stop the prober from starting
+func refreshConfigCache(ctx context.Context, configURL string, path string) (bool, error) {
+	data, err := fetchRemoteConfig(ctx, configURL)
+	if err != nil {
+		return false, err
+	}
+
+	if cached, err := os.ReadFile(path); err == nil && bytes.Equal(cached, data) {
+		return false, nil
+	}
+
+	config := Config{}
+	if err := unmarshalConfig(path, data, &config); err != nil {
+		return false, fmt.Errorf("remote configuration is invalid: %w", err)
+	}
+	if err := prepareConfig(&config); err != nil {
+		return false, fmt.Errorf("remote configuration is invalid: %w", err)
+	}
+
+	if err := writeFileAtomic(path, data); err != nil {
+		return false, fmt.Errorf("could not cache remote configuration: %w", err)
+	}
+
+	return true, nil
+}
+
+// Poll the remote configuration for changes, a changed configuration is
+// applied by re-executing the process with it, which starts probing and
+// every integration again from scratch
+func watchRemoteConfig(ctx context.Context, configURL string, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		changed, err := refreshConfigCache(ctx, configURL, path)
+		if err != nil {
+			logger.Warn(
+				"Couldn't refresh remote configuration",
+				"config_url",
+				configURL,
+				"error",
+				err.Error(),
+			)
+			continue
+		}
+		if !changed {
+			continue
+		}
+
+		logger.Info(
+			"Remote configuration changed, restarting to apply it",
+			"config_url",
+			configURL,
+			"config_file",
+			path,
+		)
+
+		executable, err := os.Executable()
+		if err != nil {
+			logger.Error("Couldn't find executable to restart", "error", err.Error())
+			continue
+		}
+		if err := syscall.Exec(executable, os.Args, os.Environ()); err != nil {
+			logger.Error("Couldn't restart to apply configuration", "error", err.Error())
+		}
+	}
+}
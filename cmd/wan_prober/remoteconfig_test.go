@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Configurations which parse but fail the checks made at startup are
+// never cached, so the prober can still start from the cache
+func TestRefreshConfigCache(t *testing.T) {
+	remote := ""
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(remote))
+	}))
+	defer server.Close()
+
+	client := remoteConfigClient
+	remoteConfigClient = server.Client()
+	t.Cleanup(func() {
+		remoteConfigClient = client
+	})
+
+	path := filepath.Join(t.TempDir(), "wan-prober.yml")
+	targets := "targets:\n  - host: example.com\n    probe: http\n"
+	valid := "interfaces:\n  - name: eth0\n" + targets
+
+	tests := []struct {
+		name    string
+		remote  string
+		changed bool
+		err     bool
+		cached  string
+	}{
+		{"valid", valid, true, false, valid},
+		{"unchanged", valid, false, false, valid},
+		{"unparseable", "interfaces: [", false, true, valid},
+		{"HTTP/3 target without https", valid + "    http:\n      protocol: h3\n", false, true, valid},
+		{"duplicate interface", "interfaces:\n  - name: eth0\n  - name: eth0\n" + targets, false, true, valid},
+	}
+
+	for _, test := range tests {
+		remote = test.remote
+
+		changed, err := refreshConfigCache(context.Background(), server.URL, path)
+		if changed != test.changed || (err != nil) != test.err {
+			t.Errorf("%s: refreshConfigCache() = (%t, %v), want changed %t", test.name, changed, err, test.changed)
+		}
+
+		cached, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("%s: reading cache: %v", test.name, err)
+		}
+		if string(cached) != test.cached {
+			t.Errorf("%s: cached configuration = %q, want %q", test.name, cached, test.cached)
+		}
+	}
+}
+
+// Configurations run hook commands, so they are only fetched in the clear
+// when asked to
+func TestCheckConfigURL(t *testing.T) {
+	insecure := *configURLInsecure
+	t.Cleanup(func() {
+		*configURLInsecure = insecure
+	})
+
+	tests := []struct {
+		url      string
+		insecure bool
+		err      bool
+	}{
+		{"https://config.example/wan-prober.yml", false, false},
+		{"etcd+https://etcd.example:2379/wan-prober", false, false},
+		{"consul+https://consul.example:8500/wan-prober", false, false},
+		{"http://config.example/wan-prober.yml", false, true},
+		{"etcd+http://etcd.example:2379/wan-prober", false, true},
+		{"consul+http://consul.example:8500/wan-prober", false, true},
+		{"http://config.example/wan-prober.yml", true, false},
+		{"consul+http://consul.example:8500/wan-prober", true, false},
+	}
+
+	for _, test := range tests {
+		*configURLInsecure = test.insecure
+
+		if err := checkConfigURL(test.url); (err != nil) != test.err {
+			t.Errorf("checkConfigURL(%q) with insecure %t = %v, want error %t", test.url, test.insecure, err, test.err)
+		}
+	}
+}
+
+func TestCheckConfigCacheWritable(t *testing.T) {
+	dir := t.TempDir()
+
+	if err := checkConfigCacheWritable(filepath.Join(dir, "wan-prober.yml")); err != nil {
+		t.Errorf("checkConfigCacheWritable() error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("checkConfigCacheWritable() left %d files behind", len(entries))
+	}
+	if err := checkConfigCacheWritable(filepath.Join(dir, "missing", "wan-prober.yml")); err == nil {
+		t.Error("checkConfigCacheWritable() found a missing directory writable")
+	}
+}
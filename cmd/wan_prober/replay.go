@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/health"
+	"github.com/adaricorp/wan-prober/internal/notify"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Kinds of lines written by a replay
+const (
+	replayTransition   = "transition"
+	replayNotification = "notification"
+)
+
+type ReplayLine struct {
+	Type      string        `json:"type"`
+	Time      time.Time     `json:"time"`
+	Interface string        `json:"interface"`
+	Round     int           `json:"round,omitempty"`
+	Healthy   bool          `json:"healthy"`
+	Reason    string        `json:"reason,omitempty"`
+	Sink      string        `json:"sink,omitempty"`
+	Event     *notify.Event `json:"event,omitempty"`
+}
+
+// Probe results of one round of an interface read from a result log
+type replayRound struct {
+	round   int
+	end     time.Time
+	order   []string
+	targets map[string][]ProbeResultRecord
+}
+
+// Evaluates the health of rounds read from result logs with the health
+// evaluation of a configuration, as probes found it
+type roundEvaluator struct {
+	targets  int
+	options  health.Options
+	programs map[string]*vm.Program
+}
+
+func newRoundEvaluator(config Config) *roundEvaluator {
+	e := &roundEvaluator{
+		targets:  len(config.Targets),
+		options:  config.ProbeConfiguration.healthOptions(),
+		programs: map[string]*vm.Program{},
+	}
+
+	for _, iface := range config.Interfaces {
+		healthPolicy := config.ProbeConfiguration.HealthPolicy
+		if iface.HealthPolicy != "" {
+			healthPolicy = iface.HealthPolicy
+		}
+		if healthPolicy != "" {
+			// Policy was validated at startup
+			e.programs[iface.Name], _ = health.CompilePolicy(healthPolicy)
+		}
+	}
+
+	return e
+}
+
+// Health of a round of an interface and its reason
+func (e *roundEvaluator) evaluate(ifaceName string, round *replayRound) (bool, string) {
+	results := []health.TargetResult{}
+	latencies := []time.Duration{}
+	for _, target := range round.order {
+		result := health.TargetResult{}
+		for _, record := range round.targets[target] {
+			result.Attempts += 1
+			switch record.Outcome {
+			case outcomeSuccess:
+				result.Success = true
+				latencies = append(latencies, time.Duration(record.Duration*float64(time.Second)))
+			case outcomeError, outcomeNXDomain:
+				result.Errors += 1
+			default:
+				result.Timeouts += 1
+			}
+		}
+		results = append(results, result)
+	}
+
+	options := e.options
+	options.Policy = e.programs[ifaceName]
+	// A policy which fails to evaluate leaves the heuristic's decision
+	decision, _ := health.Evaluate(
+		health.Round{
+			Targets:   e.targets,
+			Results:   results,
+			Latencies: latencies,
+		},
+		options,
+	)
+
+	return decision.Healthy, decision.Reason
+}
+
+// Read result logs, oldest first, calling a function with each record and
+// with each round of an interface once all its records are read
+func readResultLogs(
+	paths []string,
+	onRecord func(record ProbeResultRecord) error,
+	onRound func(ifaceName string, round *replayRound) error,
+) error {
+	rounds := map[string]*replayRound{}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line += 1
+
+			record := ProbeResultRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				file.Close()
+				return fmt.Errorf("%s line %d: %w", path, line, err)
+			}
+			if onRecord != nil {
+				if err := onRecord(record); err != nil {
+					file.Close()
+					return err
+				}
+			}
+
+			round := rounds[record.Interface]
+			if round != nil && round.round != record.Round {
+				if err := onRound(record.Interface, round); err != nil {
+					file.Close()
+					return err
+				}
+				round = nil
+			}
+			if round == nil {
+				round = &replayRound{
+					round:   record.Round,
+					targets: map[string][]ProbeResultRecord{},
+				}
+				rounds[record.Interface] = round
+			}
+
+			if _, exists := round.targets[record.Target]; !exists {
+				round.order = append(round.order, record.Target)
+			}
+			round.targets[record.Target] = append(round.targets[record.Target], record)
+			round.end = record.Time.Add(time.Duration(record.Duration * float64(time.Second)))
+		}
+		file.Close()
+
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	for _, ifaceName := range slices.Sorted(maps.Keys(rounds)) {
+		if err := onRound(ifaceName, rounds[ifaceName]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replay probe result logs through the health evaluation and notification
+// rules of a configuration, writing the resulting state transitions and
+// notifications to out instead of acting on them
+func runReplay(config Config, notifier *Notifier, paths []string, out io.Writer) error {
+	encoder := json.NewEncoder(out)
+
+	interfaces := map[string]Interface{}
+	for _, iface := range config.Interfaces {
+		interfaces[iface.Name] = iface
+	}
+	evaluator := newRoundEvaluator(config)
+
+	var writeErr error
+	notifier.onEvent = func(sink string, event notify.Event) {
+		if writeErr != nil {
+			return
+		}
+		writeErr = encoder.Encode(ReplayLine{
+			Type:      replayNotification,
+			Time:      reportTime(time.Unix(event.Time, 0)),
+			Interface: event.Interface,
+			Healthy:   event.Healthy,
+			Sink:      sink,
+			Event:     &event,
+		})
+	}
+	// Replays show what the active member of an HA pair would send
+	haActive.Store(true)
+
+	lastHealthy := map[string]bool{}
+
+	return readResultLogs(paths, nil, func(ifaceName string, round *replayRound) error {
+		iface := interfaces[ifaceName]
+		healthy, reason := evaluator.evaluate(ifaceName, round)
+
+		if previous, exists := lastHealthy[ifaceName]; !exists || previous != healthy {
+			lastHealthy[ifaceName] = healthy
+			if err := encoder.Encode(ReplayLine{
+				Type:      replayTransition,
+				Time:      reportTime(round.end),
+				Interface: ifaceName,
+				Round:     round.round,
+				Healthy:   healthy,
+				Reason:    reason,
+			}); err != nil {
+				return err
+			}
+		}
+
+		notifier.Update(InterfaceStatus{
+			Name:        ifaceName,
+			Description: iface.Description,
+			DisplayName: iface.DisplayName,
+			Healthy:     healthy,
+			Reason:      reason,
+		}, round.end)
+
+		return writeErr
+	})
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Responses smaller than this aren't worth compressing
+	minGzipSize = 1024
+)
+
+// Body of a response compressed with gzip, nil when it is too small to be
+// worth compressing
+func gzipBody(body []byte) []byte {
+	if len(body) < minGzipSize {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(body); err != nil {
+		return nil
+	}
+	if err := gz.Close(); err != nil {
+		return nil
+	}
+
+	return buf.Bytes()
+}
+
+// Whether a client accepts responses compressed with gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(coding, ";")
+			name = strings.TrimSpace(name)
+			if name != "gzip" && name != "*" {
+				continue
+			}
+
+			q := 1.0
+			if qValue, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+				if parsed, err := strconv.ParseFloat(qValue, 64); err == nil {
+					q = parsed
+				}
+			}
+			return q > 0
+		}
+	}
+
+	return false
+}
+
+// Send a fully rendered response body with its Content-Length, the gzipped
+// body is sent instead to clients which accept it
+func sendBody(w http.ResponseWriter, r *http.Request, status int, body []byte, gzipped []byte) {
+	if gzipped != nil {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			w.Header().Set("Content-Encoding", "gzip")
+			body = gzipped
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+
+	if _, err := w.Write(body); err != nil {
+		// Status was already sent, so the client can only see the
+		// response was cut short
+		logger.Debug("Error writing HTTP response", "error", err.Error())
+	}
+}
+
+// Render a response as JSON before sending any of it, so a response which
+// fails to render is an error rather than truncated JSON
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, resp any) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(resp); err != nil {
+		logger.Error("Error rendering HTTP response", "error", err.Error())
+		http.Error(w, "Failed to render data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	sendBody(w, r, status, buf.Bytes(), gzipBody(buf.Bytes()))
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+// Outcomes of a single probe attempt
+const (
+	outcomeSuccess        = "success"
+	outcomeTimeout        = "timeout"
+	outcomeDNSUnreachable = "dns_unreachable"
+	outcomeNetDown        = "net_down"
+	outcomeNXDomain       = "nxdomain"
+	outcomeBodyMismatch   = "body_mismatch"
+	outcomeDNSSECFailure  = "dnssec_failure"
+	outcomeStarlinkOutage = "starlink_outage"
+	outcomeMTUBelowFloor  = "mtu_below_floor"
+	outcomePacketLoss     = "packet_loss"
+	outcomeError          = "error"
+)
+
+// Outcome of a probe, from the class of the error returned by the prober
+func probeOutcome(err error) string {
+	if err == nil {
+		return outcomeSuccess
+	}
+
+	_, handling := classifyFailure(err)
+	return handling.outcome
+}
+
+type ProbeResultRecord struct {
+	Time      time.Time `json:"time"`
+	Interface string    `json:"interface"`
+	Target    string    `json:"target"`
+	Probe     string    `json:"probe"`
+	// Probe round of the interface, counted from 1 at startup
+	Round           int        `json:"round"`
+	Attempt         int        `json:"attempt"`
+	Outcome         string     `json:"outcome"`
+	Duration        float64    `json:"duration_seconds"`
+	Resolver        string     `json:"resolver,omitempty"`
+	ResolverAddress string     `json:"resolver_address,omitempty"`
+	Timings         *Timings   `json:"timings,omitempty"`
+	Protocol        string     `json:"protocol,omitempty"`
+	BodyBytes       int64      `json:"body_bytes,omitempty"`
+	BodySHA256      string     `json:"body_sha256,omitempty"`
+	PathMTU         int        `json:"path_mtu,omitempty"`
+	CertNotAfter    *time.Time `json:"cert_not_after,omitempty"`
+	ErrorClass      string     `json:"error_class,omitempty"`
+	Error           string     `json:"error,omitempty"`
+	// Trace ID of the attempt, with --probe-trace-ids
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+type Timings struct {
+	DNS     float64 `json:"dns_seconds"`
+	Connect float64 `json:"connect_seconds"`
+	TLS     float64 `json:"tls_seconds"`
+	TTFB    float64 `json:"ttfb_seconds"`
+}
+
+func newTimings(timings probe.Timings) *Timings {
+	return &Timings{
+		DNS:     timings.DNS.Seconds(),
+		Connect: timings.Connect.Seconds(),
+		TLS:     timings.TLS.Seconds(),
+		TTFB:    timings.TTFB.Seconds(),
+	}
+}
+
+// Writes probe result records as JSON lines to a file,
+// rotating the file when it grows beyond a maximum size
+type ResultLog struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func NewResultLog(path string, maxSize int64, maxBackups int) (*ResultLog, error) {
+	r := &ResultLog{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *ResultLog) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open result log: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not stat result log: %w", err)
+	}
+
+	r.file = file
+	r.size = info.Size()
+
+	return nil
+}
+
+// Shift existing backups along by one and start a new file. When the log
+// can't be rotated it is reopened, so records keep being appended to it.
+func (r *ResultLog) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return errors.Join(fmt.Errorf("could not close result log: %w", err), r.open())
+	}
+
+	if err := r.shiftBackups(); err != nil {
+		return errors.Join(err, r.open())
+	}
+
+	return r.open()
+}
+
+// Rename the log and its backups to the next backup, or remove the log
+// when there are no backups
+func (r *ResultLog) shiftBackups() error {
+	if r.maxBackups == 0 {
+		if err := os.Remove(r.path); err != nil {
+			return fmt.Errorf("could not truncate result log: %w", err)
+		}
+		return nil
+	}
+
+	for i := r.maxBackups - 1; i > 0; i-- {
+		err := os.Rename(
+			fmt.Sprintf("%s.%d", r.path, i),
+			fmt.Sprintf("%s.%d", r.path, i+1),
+		)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not rotate result log backup: %w", err)
+		}
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return fmt.Errorf("could not rotate result log: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ResultLog) Write(record ProbeResultRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("could not encode probe result: %w", err)
+	}
+	line = append(line, '\n')
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The record is still written when rotating fails
+	var rotateErr error
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(line)) > r.maxSize {
+		rotateErr = r.rotate()
+	}
+
+	n, err := r.file.Write(line)
+	r.size += int64(n)
+	if err != nil {
+		return errors.Join(rotateErr, fmt.Errorf("could not write result log: %w", err))
+	}
+
+	return rotateErr
+}
+
+// Call a function with the paths of the log and its backups which exist,
+// oldest first. The log isn't written or rotated until it returns.
+func (r *ResultLog) read(f func(paths []string) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	paths := []string{}
+	for i := r.maxBackups; i > 0; i-- {
+		backup := fmt.Sprintf("%s.%d", r.path, i)
+		if _, err := os.Stat(backup); err == nil {
+			paths = append(paths, backup)
+		}
+	}
+	paths = append(paths, r.path)
+
+	return f(paths)
+}
+
+func (r *ResultLog) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Count the records in a result log file
+func countRecords(t *testing.T, path string) int {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	records := 0
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		records += 1
+	}
+
+	return records
+}
+
+func TestResultLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	// Room for one record per file
+	resultLog, err := NewResultLog(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resultLog.Close() })
+
+	for range 4 {
+		if err := resultLog.Write(ProbeResultRecord{Interface: "wan", Outcome: outcomeSuccess}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		if records := countRecords(t, name); records != 1 {
+			t.Errorf("%s has %d records, want 1", name, records)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("more backups kept than the maximum")
+	}
+}
+
+// Records keep being written when the backups can't be shifted
+func TestResultLogRotateFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	resultLog, err := NewResultLog(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resultLog.Close() })
+
+	// The first backup can't be renamed onto a directory which isn't empty
+	if err := os.WriteFile(path+".1", nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(path+".2", "busy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	record := ProbeResultRecord{Interface: "wan", Outcome: outcomeSuccess}
+	if err := resultLog.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := resultLog.Write(record); err == nil {
+		t.Error("failing to rotate wasn't reported")
+	}
+	if err := resultLog.Write(record); err == nil {
+		t.Error("failing to rotate again wasn't reported")
+	}
+
+	if records := countRecords(t, path); records != 3 {
+		t.Errorf("log has %d records, want 3", records)
+	}
+}
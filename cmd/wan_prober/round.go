@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/health"
+)
+
+// State an interface's probe loop keeps between rounds to settle the health
+// they found
+type roundSettler struct {
+	iface      Interface
+	grace      health.GracePeriod
+	remediator *remediator
+}
+
+func newRoundSettler(iface Interface) *roundSettler {
+	return &roundSettler{
+		iface:      iface,
+		grace:      health.GracePeriod{Period: iface.GracePeriod},
+		remediator: newRemediator(iface),
+	}
+}
+
+// Settle the health found by a round, whether from probing targets or from
+// a route check, into the health reported for the interface. Healthy
+// verdicts are held back during the interface's grace period, and until it
+// can be failed back to after it failed. Interfaces which stay unhealthy
+// are remediated.
+func (s *roundSettler) settle(ctx context.Context, healthy bool, reason string, now time.Time) (bool, string) {
+	iface := s.iface
+	probedHealthy := healthy
+
+	if iface.GracePeriod > 0 {
+		if remaining := s.grace.Observe(linkUp(iface.Name), healthy, now); healthy && remaining > 0 {
+			logger.Info(
+				"Interface is in grace period",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"remaining",
+				remaining,
+			)
+
+			healthy = false
+			reason = health.ReasonGracePeriod
+		}
+	}
+
+	if iface.FailbackDelay > 0 || iface.StickyFailback {
+		if held, remaining := failbacks.observe(iface, probedHealthy, now); held && healthy {
+			logger.Info(
+				"Interface is waiting for failback",
+				"interface",
+				iface.Name,
+				"description",
+				iface.Description,
+				"remaining",
+				remaining,
+			)
+
+			healthy = false
+			reason = health.ReasonFailbackDelay
+			if remaining == 0 {
+				reason = health.ReasonFailbackApproval
+			}
+		}
+	}
+
+	// Remediation only cares whether probes work
+	s.remediator.observe(ctx, probedHealthy, now)
+
+	return healthy, reason
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/health"
+)
+
+// Interface whose link is always up, so only health moves the grace period
+const loopback = "lo"
+
+type settledRound struct {
+	after   time.Duration
+	healthy bool
+	reason  string
+	// Health reported for the interface
+	settledHealthy bool
+	settledReason  string
+}
+
+func checkSettledRounds(t *testing.T, settler *roundSettler, rounds []settledRound) {
+	t.Helper()
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, round := range rounds {
+		healthy, reason := settler.settle(context.Background(), round.healthy, round.reason, start.Add(round.after))
+		if healthy != round.settledHealthy || reason != round.settledReason {
+			t.Errorf(
+				"round %d settled as (%t, %q), want (%t, %q)",
+				i,
+				healthy,
+				reason,
+				round.settledHealthy,
+				round.settledReason,
+			)
+		}
+	}
+}
+
+// Rounds which found the interface had no route restart the grace period,
+// like rounds whose probes failed
+func TestSettleRouteCheckGracePeriod(t *testing.T) {
+	settler := newRoundSettler(Interface{Name: loopback, GracePeriod: time.Minute})
+
+	checkSettledRounds(t, settler, []settledRound{
+		{0, true, health.ReasonTargetReachable, false, health.ReasonGracePeriod},
+		{2 * time.Minute, true, health.ReasonTargetReachable, true, health.ReasonTargetReachable},
+		{3 * time.Minute, false, health.ReasonNoRoute, false, health.ReasonNoRoute},
+		{4 * time.Minute, true, health.ReasonTargetReachable, false, health.ReasonGracePeriod},
+		{5 * time.Minute, true, health.ReasonTargetReachable, true, health.ReasonTargetReachable},
+	})
+}
+
+// Rounds which found the interface had no address fail it, so traffic only
+// returns to it after the failback delay
+func TestSettleRouteCheckFailback(t *testing.T) {
+	iface := Interface{Name: "settle-failback", FailbackDelay: time.Minute}
+	settler := newRoundSettler(iface)
+
+	checkSettledRounds(t, settler, []settledRound{
+		{0, true, health.ReasonTargetReachable, true, health.ReasonTargetReachable},
+		{time.Minute, false, health.ReasonNoAddress, false, health.ReasonNoAddress},
+		{2 * time.Minute, true, health.ReasonTargetReachable, false, health.ReasonFailbackDelay},
+		{3 * time.Minute, true, health.ReasonTargetReachable, true, health.ReasonTargetReachable},
+	})
+}
+
+// Rounds which found the interface had no route count towards remediating
+// it, like rounds whose probes failed
+func TestSettleRouteCheckRemediation(t *testing.T) {
+	iface := Interface{
+		Name: "settle-remediation",
+		Remediation: &RemediationConfig{
+			Actions:     []string{actionHook},
+			Hook:        []string{"true"},
+			AfterRounds: 2,
+			Interval:    time.Minute,
+			MaxAttempts: 1,
+		},
+	}
+	settler := newRoundSettler(iface)
+
+	checkSettledRounds(t, settler, []settledRound{
+		{0, false, health.ReasonNoRoute, false, health.ReasonNoRoute},
+		{time.Minute, false, health.ReasonNoRoute, false, health.ReasonNoRoute},
+	})
+
+	if attempts := settler.remediator.attempts; attempts != 1 {
+		t.Errorf("remediation attempts = %d, want 1", attempts)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/adaricorp/wan-prober/internal/health"
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+// Check whether an interface exists and its link is up
+func linkUp(iface string) bool {
+	link, err := net.InterfaceByName(iface)
+	if err != nil {
+		return false
+	}
+
+	return link.Flags&net.FlagUp != 0 && link.Flags&net.FlagRunning != 0
+}
+
+// Check whether an interface has an address which probes can be sourced from
+func hasSourceAddress(link *net.Interface) (bool, error) {
+	addrs, err := link.Addrs()
+	if err != nil {
+		return false, err
+	}
+
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().IsGlobalUnicast() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Find why an interface can't be used for probing, returns an empty
+// reason when it has both a source address and a default route
+func routeCheck(iface Interface) (string, error) {
+	link, err := net.InterfaceByName(iface.Name)
+	if err != nil {
+		return health.ReasonNoAddress, nil
+	}
+
+	hasAddress, err := hasSourceAddress(link)
+	if err != nil {
+		return "", err
+	}
+	if !hasAddress {
+		return health.ReasonNoAddress, nil
+	}
+
+	hasRoute, err := netbind.HasDefaultRoute(link.Index, iface.RouteCheck.Table)
+	if err != nil {
+		return "", err
+	}
+	if !hasRoute {
+		return health.ReasonNoRoute, nil
+	}
+
+	return "", nil
+}
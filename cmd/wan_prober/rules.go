@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/notify"
+)
+
+const (
+	// Window in which state changes are counted to detect flapping
+	flapWindow = 10 * time.Minute
+	// Number of state changes within the window which counts as flapping
+	flapThreshold = 3
+)
+
+// Rule which decides which notification sinks are told about
+// an interface state or other event, and with what severity
+type NotificationRule struct {
+	// Event types the rule applies to, state changes when empty. The other
+	// conditions only apply to state changes.
+	Events      []string          `yaml:"events"`
+	Interfaces  []string          `yaml:"interfaces"`
+	Labels      map[string]string `yaml:"labels"`
+	State       string            `yaml:"state"`
+	MinDuration time.Duration     `yaml:"min_duration"`
+	Flapping    *bool             `yaml:"flapping"`
+	Sinks       []string          `yaml:"sinks"`
+	Severity    string            `yaml:"severity"`
+}
+
+func (r NotificationRule) validate(sinks []string) error {
+	switch r.State {
+	case "", "healthy", "unhealthy":
+	default:
+		return fmt.Errorf("invalid state: %s", r.State)
+	}
+
+	for _, event := range r.Events {
+		if !slices.Contains(notify.EventTypes, event) {
+			return fmt.Errorf("invalid event type: %s", event)
+		}
+	}
+
+	for _, sink := range r.Sinks {
+		if !slices.Contains(sinks, sink) {
+			return fmt.Errorf("unknown notification sink: %s", sink)
+		}
+	}
+
+	return nil
+}
+
+// Whether the rule applies to events of a type
+func (r NotificationRule) appliesTo(eventType string) bool {
+	if len(r.Events) == 0 {
+		return eventType == notify.EventStateChange
+	}
+
+	return slices.Contains(r.Events, eventType)
+}
+
+func (r NotificationRule) matches(iface Interface, state *notifyState, now time.Time) bool {
+	if len(r.Interfaces) > 0 && !slices.Contains(r.Interfaces, iface.Name) {
+		return false
+	}
+
+	for key, value := range r.Labels {
+		if iface.Labels[key] != value {
+			return false
+		}
+	}
+
+	switch r.State {
+	case "healthy":
+		if !state.healthy {
+			return false
+		}
+	case "unhealthy":
+		if state.healthy {
+			return false
+		}
+	}
+
+	if now.Sub(state.since) < r.MinDuration {
+		return false
+	}
+
+	if r.Flapping != nil && *r.Flapping != (len(state.changes) >= flapThreshold) {
+		return false
+	}
+
+	return true
+}
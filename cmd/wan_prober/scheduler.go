@@ -0,0 +1,165 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/internal/clock"
+	"github.com/adaricorp/wan-prober/internal/scheduler"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	roundDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "wan_prober_round_duration_seconds",
+			Help:    "Time taken by probe rounds of each interface.",
+			Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+		},
+		[]string{"interface"},
+	)
+	roundsSkipped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wan_prober_rounds_skipped_total",
+			Help: "Probe rounds skipped because earlier rounds took longer than the interval.",
+		},
+		[]string{"interface"},
+	)
+
+	// Round schedulers of interfaces, by interface
+	roundSchedulers sync.Map
+)
+
+func init() {
+	prometheus.MustRegister(roundDuration)
+	prometheus.MustRegister(roundsSkipped)
+}
+
+// Schedules the probe rounds of an interface, recording their durations
+// and keeping the watchdog informed
+type roundScheduler struct {
+	iface  Interface
+	rounds *scheduler.Rounds
+	clock  clock.Clock
+
+	// What the scheduler is doing, served by the scheduler API
+	mu       sync.Mutex
+	schedule api.RoundSchedule
+}
+
+// Scheduler of an interface's rounds, replacing the scheduler of an earlier
+// probe loop of the interface in the scheduler API
+func newRoundScheduler(iface Interface, interval time.Duration, c clock.Clock) *roundScheduler {
+	s := &roundScheduler{
+		iface:  iface,
+		rounds: scheduler.NewRounds(interval, maxRoundJitter),
+		clock:  c,
+		schedule: api.RoundSchedule{
+			Interface: iface.Name,
+			Interval:  interval.Seconds(),
+			MaxJitter: min(maxRoundJitter, interval/2).Seconds(),
+		},
+	}
+	roundSchedulers.Store(iface.Name, s)
+
+	return s
+}
+
+// What the scheduler of an interface is doing, nil before its first round
+func roundSchedule(iface string) *api.RoundSchedule {
+	s, exists := roundSchedulers.Load(iface)
+	if !exists {
+		return nil
+	}
+
+	s.(*roundScheduler).mu.Lock()
+	defer s.(*roundScheduler).mu.Unlock()
+
+	schedule := s.(*roundScheduler).schedule
+	if schedule.RoundStartedAt == 0 {
+		return nil
+	}
+
+	return &schedule
+}
+
+// Current time of the scheduler's clock
+func (s *roundScheduler) now() time.Time {
+	return s.clock.Now()
+}
+
+// Wait before trying a target again, or until a context is done
+func (s *roundScheduler) backoff(ctx context.Context, d time.Duration) {
+	clock.Sleep(ctx, s.clock, d)
+}
+
+// Record the start of a round
+func (s *roundScheduler) begin() {
+	now := s.now()
+	s.rounds.Begin(now)
+	watchdog.alive(s.iface.Name)
+
+	s.mu.Lock()
+	s.schedule.InRound = true
+	s.schedule.RoundStartedAt = now.Unix()
+	s.schedule.NextProbeAt = 0
+	s.mu.Unlock()
+}
+
+// Wait until the next round should start
+func (s *roundScheduler) wait(ctx context.Context) {
+	watchdog.roundDone(s.iface.Name)
+
+	now := s.now()
+	end := s.rounds.End(now)
+	roundDuration.WithLabelValues(s.iface.displayName()).Observe(end.Took.Seconds())
+
+	s.mu.Lock()
+	s.schedule.InRound = false
+	s.schedule.RoundDuration = end.Took.Seconds()
+	s.schedule.NextProbeAt = now.Add(end.Delay).Unix()
+	s.schedule.SkippedRounds += end.Skipped
+	s.mu.Unlock()
+
+	if end.Overran {
+		roundsSkipped.WithLabelValues(s.iface.displayName()).Add(float64(end.Skipped))
+		logger.Warn(
+			"Probe round took longer than the interval",
+			"interface",
+			s.iface.Name,
+			"description",
+			s.iface.Description,
+			"duration",
+			end.Took,
+			"interval",
+			s.rounds.Interval(),
+			"skipped_rounds",
+			end.Skipped,
+		)
+	}
+
+	clock.Sleep(ctx, s.clock, end.Delay)
+}
+
+// Serve what the scheduler of each interface is doing at /scheduler
+func registerSchedulerHandler() {
+	handleRoleFunc(roleStatus, "/scheduler", func(w http.ResponseWriter, r *http.Request) {
+		resp := []api.RoundSchedule{}
+		roundSchedulers.Range(func(key, _ any) bool {
+			if schedule := roundSchedule(key.(string)); schedule != nil {
+				resp = append(resp, *schedule)
+			}
+			return true
+		})
+		slices.SortFunc(resp, func(a, b api.RoundSchedule) int {
+			return cmp.Compare(a.Interface, b.Interface)
+		})
+
+		writeJSON(w, r, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+const (
+	// Name looked up to check resolvers answer, any answer including
+	// NXDOMAIN shows the resolver is reachable
+	selfTestLookupName = "example.com."
+)
+
+// Checks made by the startup self-test
+const (
+	selfTestInterfaceExists = "interface_exists"
+	selfTestBindToDevice    = "bind_to_device"
+	selfTestResolver        = "resolver_reachable"
+	selfTestTargetResolves  = "target_resolves"
+)
+
+type SelfTestCheck struct {
+	Check     string `json:"check"`
+	Interface string `json:"interface,omitempty"`
+	Resolver  string `json:"resolver,omitempty"`
+	Passed    bool   `json:"passed"`
+	Error     string `json:"error,omitempty"`
+}
+
+type SelfTestResult struct {
+	Ready  bool            `json:"ready"`
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+// Check the configuration can work on this host before probing starts:
+// interfaces exist and sockets can be bound to them, resolvers answer and
+// at least one target resolves
+func runSelfTest(ctx context.Context, config Config) SelfTestResult {
+	result := SelfTestResult{Ready: true}
+	add := func(check SelfTestCheck, err error) {
+		check.Passed = err == nil
+		if err != nil {
+			check.Error = err.Error()
+			result.Ready = false
+		}
+		result.Checks = append(result.Checks, check)
+	}
+
+	for _, iface := range config.Interfaces {
+		_, err := net.InterfaceByName(iface.Name)
+		add(SelfTestCheck{Check: selfTestInterfaceExists, Interface: iface.Name}, err)
+		if err != nil {
+			continue
+		}
+
+		// Binding needs CAP_NET_RAW, without it every probe fails
+		listenConfig := net.ListenConfig{
+			Control: netbind.BindToDevice(iface.Name),
+		}
+		conn, err := listenConfig.ListenPacket(ctx, "udp", ":0")
+		if err == nil {
+			conn.Close()
+		} else if errors.Is(err, syscall.EPERM) {
+			err = fmt.Errorf("%w, CAP_NET_RAW is needed to bind to interfaces", err)
+		}
+		add(SelfTestCheck{Check: selfTestBindToDevice, Interface: iface.Name}, err)
+	}
+
+	resolvers := []string{}
+	if config.HostResolver != nil {
+		resolvers = append(resolvers, config.HostResolver.String())
+	}
+	for _, resolver := range config.FallbackResolvers {
+		resolvers = append(resolvers, resolver.String())
+	}
+
+	resolverErrors := make([]error, len(resolvers))
+	var wg sync.WaitGroup
+	for i, resolver := range resolvers {
+		wg.Go(func() {
+			resolverErrors[i] = checkResolver(ctx, resolver, config.ProbeConfiguration.Timeout)
+		})
+	}
+	wg.Wait()
+	for i, resolver := range resolvers {
+		add(SelfTestCheck{Check: selfTestResolver, Resolver: resolver}, resolverErrors[i])
+	}
+
+	add(SelfTestCheck{Check: selfTestTargetResolves}, checkTargetsResolve(ctx, config))
+
+	return result
+}
+
+// Check a resolver answers queries
+func checkResolver(ctx context.Context, resolver string, timeout time.Duration) error {
+	dnsResolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialer := net.Dialer{}
+			return dialer.DialContext(ctx, "udp", resolver)
+		},
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := dnsResolver.LookupHost(lookupCtx, selfTestLookupName)
+	var dnsError *net.DNSError
+	if errors.As(err, &dnsError) && dnsError.IsNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// Whether a hostname has static addresses in the configuration
+func staticHost(config Config, hostname string) bool {
+	for name, addrs := range config.StaticHosts {
+		if len(addrs) > 0 && strings.EqualFold(strings.TrimSuffix(name, "."), strings.TrimSuffix(hostname, ".")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check at least one target hostname resolves, targets which don't need
+// resolving aren't checked
+func checkTargetsResolve(ctx context.Context, config Config) error {
+	hostnames := []string{}
+	for _, target := range config.Targets {
+		if len(target.Addresses) > 0 || strings.Contains(target.Host, "@") {
+			continue
+		}
+
+		host := target.Host
+		if parsed, err := url.Parse(host); err == nil && parsed.Host != "" {
+			host = parsed.Hostname()
+		} else if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if _, err := netip.ParseAddr(host); err == nil {
+			continue
+		}
+		if staticHost(config, host) {
+			continue
+		}
+
+		hostnames = append(hostnames, host)
+	}
+
+	if len(hostnames) == 0 {
+		return nil
+	}
+
+	var lastErr error
+	for _, hostname := range hostnames {
+		lookupCtx, cancel := context.WithTimeout(ctx, config.ProbeConfiguration.Timeout)
+		_, err := net.DefaultResolver.LookupHost(lookupCtx, hostname)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+
+	return fmt.Errorf("no target hostname resolves: %w", lastErr)
+}
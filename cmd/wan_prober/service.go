@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Actions of --service
+const (
+	serviceNone      = "none"
+	serviceInstall   = "install"
+	serviceUninstall = "uninstall"
+	serviceRun       = "run"
+)
+
+const (
+	// Name of the Windows service and of its Event Log source
+	serviceName        = "wan-prober"
+	serviceDisplayName = "WAN prober"
+	serviceDescription = "Probes the health of WAN connections"
+)
+
+// Arguments the installed service runs with, those of this process with
+// --service replaced by --service run. Services start in another working
+// directory, so the configuration file path is made absolute.
+func serviceArgs(args []string) []string {
+	runArgs := []string{"--service", serviceRun}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--service":
+			// Skip its value too
+			i += 1
+		case strings.HasPrefix(arg, "--service="):
+		case arg == "--config-file" && i+1 < len(args):
+			i += 1
+			runArgs = append(runArgs, arg, absolutePath(args[i]))
+		case strings.HasPrefix(arg, "--config-file="):
+			runArgs = append(runArgs, "--config-file="+absolutePath(strings.TrimPrefix(arg, "--config-file=")))
+		default:
+			runArgs = append(runArgs, arg)
+		}
+	}
+
+	return runArgs
+}
+
+// Absolute form of a path, or the path as it is when it can't be made
+// absolute
+func absolutePath(path string) string {
+	if absolute, err := filepath.Abs(path); err == nil {
+		return absolute
+	}
+
+	return path
+}
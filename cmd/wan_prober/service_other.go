@@ -0,0 +1,26 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+)
+
+var errServiceUnsupported = errors.New("services are only supported on Windows, use systemd or another init system elsewhere")
+
+// Install or uninstall the service, which is specific to Windows
+func manageService(action string, args []string) error {
+	return errServiceUnsupported
+}
+
+// Run as a service of the Windows service control manager
+func startService(stop chan<- os.Signal) error {
+	return errServiceUnsupported
+}
+
+// Handler writing logs to the Windows Event Log
+func newEventLogHandler(source string, level slog.Leveler) (slog.Handler, error) {
+	return nil, errServiceUnsupported
+}
@@ -0,0 +1,204 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+const (
+	// Event ID of every log message, the Event Log only shows the text
+	eventLogID = 1
+	// Wait before restarting the service after it fails
+	serviceRestartDelay = 10 * time.Second
+)
+
+// Install or uninstall the service with the service control manager, and
+// its Event Log source
+func manageService(action string, args []string) error {
+	manager, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("could not connect to service control manager: %w", err)
+	}
+	defer manager.Disconnect()
+
+	switch action {
+	case serviceInstall:
+		exe, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		service, err := manager.CreateService(
+			serviceName,
+			exe,
+			mgr.Config{
+				DisplayName: serviceDisplayName,
+				Description: serviceDescription,
+				StartType:   mgr.StartAutomatic,
+			},
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("could not create service: %w", err)
+		}
+		defer service.Close()
+
+		// Restart after failures, the failure count resets after a day
+		recovery := []mgr.RecoveryAction{
+			{Type: mgr.ServiceRestart, Delay: serviceRestartDelay},
+		}
+		if err := service.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+			service.Delete()
+			return fmt.Errorf("could not set service recovery actions: %w", err)
+		}
+
+		err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+		if err != nil {
+			service.Delete()
+			return fmt.Errorf("could not install Event Log source: %w", err)
+		}
+	case serviceUninstall:
+		service, err := manager.OpenService(serviceName)
+		if err != nil {
+			return fmt.Errorf("could not open service: %w", err)
+		}
+		defer service.Close()
+
+		if err := service.Delete(); err != nil {
+			return fmt.Errorf("could not delete service: %w", err)
+		}
+		if err := eventlog.Remove(serviceName); err != nil {
+			return fmt.Errorf("could not remove Event Log source: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Answers the service control manager, stopping the process like an
+// interrupt when the service is stopped or the system shuts down
+type serviceHandler struct {
+	stop chan<- os.Signal
+}
+
+func (h serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			interrupt(h.stop)
+			return false, 0
+		}
+	}
+
+	return false, 0
+}
+
+// Stop the process like an interrupt, unless it is already stopping
+func interrupt(stop chan<- os.Signal) {
+	select {
+	case stop <- os.Interrupt:
+	default:
+	}
+}
+
+// Run as a service of the service control manager, which stops the
+// process through stop
+func startService(stop chan<- os.Signal) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		return errors.New("not started by the service control manager")
+	}
+
+	go func() {
+		if err := svc.Run(serviceName, serviceHandler{stop: stop}); err != nil {
+			logger.Error("Service failed", "error", err.Error())
+			interrupt(stop)
+		}
+	}()
+
+	return nil
+}
+
+// Writes log records to the Event Log, formatted like the text log
+// without the time, which the Event Log records itself
+type eventLogHandler struct {
+	slog.Handler
+	writer *eventLogWriter
+}
+
+// Buffer records are formatted into, shared by the handlers derived from
+// a handler with attributes or groups
+type eventLogWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	log *eventlog.Log
+}
+
+func newEventLogHandler(source string, level slog.Leveler) (slog.Handler, error) {
+	log, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+
+	writer := &eventLogWriter{log: log}
+	text := slog.NewTextHandler(&writer.buf, &slog.HandlerOptions{
+		Level: level,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if len(groups) == 0 && attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+
+	return &eventLogHandler{Handler: text, writer: writer}, nil
+}
+
+func (h *eventLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	h.writer.mu.Lock()
+	defer h.writer.mu.Unlock()
+
+	h.writer.buf.Reset()
+	if err := h.Handler.Handle(ctx, record); err != nil {
+		return err
+	}
+	message := strings.TrimSuffix(h.writer.buf.String(), "\n")
+
+	switch {
+	case record.Level >= slog.LevelError:
+		return h.writer.log.Error(eventLogID, message)
+	case record.Level >= slog.LevelWarn:
+		return h.writer.log.Warning(eventLogID, message)
+	default:
+		return h.writer.log.Info(eventLogID, message)
+	}
+}
+
+func (h *eventLogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithAttrs(attrs), writer: h.writer}
+}
+
+func (h *eventLogHandler) WithGroup(name string) slog.Handler {
+	return &eventLogHandler{Handler: h.Handler.WithGroup(name), writer: h.writer}
+}
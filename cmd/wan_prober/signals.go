@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// Wake-on-LAN magic packets are broadcast to the discard port unless
+	// an address is given
+	defaultWakeOnLANAddress = "255.255.255.255:9"
+
+	// Longest time sending a signal to a device may take
+	signalTimeout = 10 * time.Second
+)
+
+var (
+	transitionActionTypes = []string{
+		actionWakeOnLAN,
+		actionUDP,
+		actionTCP,
+		actionNetworkdReconfigure,
+		actionNMActivate,
+		actionNMDeactivate,
+	}
+)
+
+// Check the actions performed on state transitions of an interface
+func validateTransitionActions(actions []TransitionAction) error {
+	for i, action := range actions {
+		switch action.On {
+		case "", "healthy", "unhealthy":
+		default:
+			return fmt.Errorf("action %d has invalid on: %s, must be healthy or unhealthy", i, action.On)
+		}
+
+		switch action.Type {
+		case actionWakeOnLAN:
+			if _, err := net.ParseMAC(action.MAC); err != nil {
+				return fmt.Errorf("action %d needs a valid mac: %w", i, err)
+			}
+		case actionUDP, actionTCP:
+			if _, _, err := net.SplitHostPort(action.Address); err != nil {
+				return fmt.Errorf("action %d needs an address with a port: %w", i, err)
+			}
+		case actionNetworkdReconfigure, actionNMActivate, actionNMDeactivate:
+		default:
+			return fmt.Errorf(
+				"action %d has invalid type %s, must be one of %s",
+				i,
+				action.Type,
+				strings.Join(transitionActionTypes, ", "),
+			)
+		}
+	}
+
+	return nil
+}
+
+// Perform the actions of an interface which apply to its new state, so
+// co-located devices such as a standby LTE router learn about failovers and
+// network managers can reconfigure the interface
+func runTransitionActions(ctx context.Context, iface string, actions []TransitionAction, healthy bool, reason string) {
+	state := "unhealthy"
+	if healthy {
+		state = "healthy"
+	}
+
+	for _, action := range actions {
+		if action.On != "" && action.On != state {
+			continue
+		}
+
+		switch action.Type {
+		case actionWakeOnLAN:
+			address := action.Address
+			if address == "" {
+				address = defaultWakeOnLANAddress
+			}
+			description := fmt.Sprintf("wake %s through %s", action.MAC, address)
+			// Failures are logged by performAction
+			_ = performAction(ctx, action.Type, iface, description, func(ctx context.Context) error {
+				return sendWakeOnLAN(ctx, action.MAC, address)
+			})
+		case actionUDP, actionTCP:
+			message := strings.NewReplacer(
+				"{interface}", iface,
+				"{state}", state,
+				"{reason}", reason,
+			).Replace(action.Message)
+			description := fmt.Sprintf("send %q to %s", message, action.Address)
+			_ = performAction(ctx, action.Type, iface, description, func(ctx context.Context) error {
+				return sendSignal(ctx, action.Type, action.Address, message)
+			})
+		case actionNetworkdReconfigure, actionNMActivate, actionNMDeactivate:
+			_ = runNetworkManagerAction(ctx, iface, action)
+		}
+	}
+}
+
+// Broadcast a Wake-on-LAN magic packet: 6 bytes of 0xff followed by the
+// MAC address 16 times
+func sendWakeOnLAN(ctx context.Context, mac string, address string) error {
+	hardwareAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+
+	packet := append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(hardwareAddr, 16)...)
+
+	return sendSignal(ctx, actionUDP, address, string(packet))
+}
+
+// Send a message to a device over UDP or TCP
+func sendSignal(ctx context.Context, network string, address string, message string) error {
+	timeout, cancel := context.WithTimeout(ctx, signalTimeout)
+	defer cancel()
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(timeout, network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := timeout.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte(message)); err != nil {
+		return err
+	}
+
+	return nil
+}
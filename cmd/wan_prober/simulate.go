@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+// Probe outcomes which can be simulated
+const (
+	simulateUp    = "up"
+	simulateDown  = "down"
+	simulateError = "error"
+)
+
+var (
+	// Simulated outcomes keyed by interface, or interface/target
+	simulations sync.Map
+
+	errSimulated = errors.New("simulated probe error")
+)
+
+type Simulation struct {
+	Interface string `json:"interface"`
+	Target    string `json:"target,omitempty"`
+	Outcome   string `json:"outcome"`
+}
+
+func simulationKey(iface string, target string) string {
+	if target == "" {
+		return iface
+	}
+
+	return iface + "/" + target
+}
+
+// Force the outcome of probes of an interface, or of one target of it,
+// an empty outcome stops the simulation
+func setSimulation(simulation Simulation) error {
+	key := simulationKey(simulation.Interface, simulation.Target)
+
+	switch simulation.Outcome {
+	case "":
+		simulations.Delete(key)
+		return nil
+	case simulateUp, simulateDown, simulateError:
+		simulations.Store(key, simulation)
+	default:
+		return fmt.Errorf("invalid outcome, must be %s, %s or %s", simulateUp, simulateDown, simulateError)
+	}
+
+	logger.Warn(
+		"Simulating probe outcome",
+		"interface",
+		simulation.Interface,
+		"target",
+		simulation.Target,
+		"outcome",
+		simulation.Outcome,
+	)
+
+	return nil
+}
+
+// Parse a --simulate flag of the form interface=outcome or
+// interface/target=outcome
+func parseSimulation(flag string) (Simulation, error) {
+	key, outcome, found := strings.Cut(flag, "=")
+	if !found || key == "" {
+		return Simulation{}, fmt.Errorf("simulation must be interface=outcome or interface/target=outcome: %s", flag)
+	}
+	iface, target, _ := strings.Cut(key, "/")
+
+	return Simulation{Interface: iface, Target: target, Outcome: outcome}, nil
+}
+
+// Return a prober which produces the simulated outcome for a target of an
+// interface, or the real prober when nothing is simulated
+func simulatedProber(prober probe.ProbeFn, iface string, target string) probe.ProbeFn {
+	val, exists := simulations.Load(simulationKey(iface, target))
+	if !exists {
+		val, exists = simulations.Load(simulationKey(iface, ""))
+	}
+	if !exists {
+		return prober
+	}
+
+	simulation, ok := val.(Simulation)
+	if !ok {
+		return prober
+	}
+
+	return func(
+		ctx context.Context,
+		target string,
+		config probe.Config,
+		dnsCache *sync.Map,
+		logger *slog.Logger,
+	) (probe.Result, error) {
+		switch simulation.Outcome {
+		case simulateDown:
+			return probe.Result{}, &probe.Error{
+				Class:  probe.ClassTimeout,
+				Target: target,
+				Cause:  errors.New("simulated"),
+			}
+		case simulateError:
+			return probe.Result{}, errSimulated
+		}
+
+		return probe.Result{}, nil
+	}
+}
+
+// Serve the simulation API, GET lists simulations and POST sets one from
+// the interface, target and outcome query parameters
+func registerSimulationHandler() {
+	handleRoleFunc(roleAdmin, "/debug/simulate", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			simulation := Simulation{
+				Interface: r.URL.Query().Get("interface"),
+				Target:    r.URL.Query().Get("target"),
+				Outcome:   r.URL.Query().Get("outcome"),
+			}
+			if simulation.Interface == "" {
+				http.Error(w, "Missing interface", http.StatusBadRequest)
+				return
+			}
+			if err := setSimulation(simulation); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp := []Simulation{}
+		simulations.Range(func(key, val interface{}) bool {
+			if simulation, ok := val.(Simulation); ok {
+				resp = append(resp, simulation)
+			}
+			return true
+		})
+
+		writeJSON(w, r, http.StatusOK, resp)
+	})
+}
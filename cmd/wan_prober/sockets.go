@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/adaricorp/wan-prober/probe"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collects the socket accounting of HTTP probes on every scrape
+type probeConnectionsCollector struct {
+	ifaces     []Interface
+	dials      *prometheus.Desc
+	dialErrors *prometheus.Desc
+	open       *prometheus.Desc
+	reused     *prometheus.Desc
+}
+
+func newProbeConnectionsCollector(ifaces []Interface) *probeConnectionsCollector {
+	return &probeConnectionsCollector{
+		ifaces: ifaces,
+		dials: prometheus.NewDesc(
+			"wan_prober_http_connections_dialed_total",
+			"Connections dialed by HTTP probes.",
+			[]string{"interface"},
+			nil,
+		),
+		dialErrors: prometheus.NewDesc(
+			"wan_prober_http_connection_dial_errors_total",
+			"Connections HTTP probes failed to dial.",
+			[]string{"interface"},
+			nil,
+		),
+		open: prometheus.NewDesc(
+			"wan_prober_http_connections_open",
+			"Connections of HTTP probes currently open.",
+			[]string{"interface"},
+			nil,
+		),
+		reused: prometheus.NewDesc(
+			"wan_prober_http_connections_reused_total",
+			"HTTP probe requests sent over a connection kept alive from an earlier probe.",
+			[]string{"interface"},
+			nil,
+		),
+	}
+}
+
+func (c *probeConnectionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.dials
+	ch <- c.dialErrors
+	ch <- c.open
+	ch <- c.reused
+}
+
+func (c *probeConnectionsCollector) Collect(ch chan<- prometheus.Metric) {
+	displayNames := map[string]string{}
+	for _, iface := range c.ifaces {
+		displayNames[iface.Name] = iface.displayName()
+	}
+
+	probe.RangeConnStats(func(bindInterface string, stats *probe.ConnStats) {
+		name, exists := displayNames[bindInterface]
+		if !exists {
+			return
+		}
+
+		ch <- prometheus.MustNewConstMetric(
+			c.dials,
+			prometheus.CounterValue,
+			float64(stats.Dials.Load()),
+			name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.dialErrors,
+			prometheus.CounterValue,
+			float64(stats.DialErrors.Load()),
+			name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.open,
+			prometheus.GaugeValue,
+			float64(stats.Open.Load()),
+			name,
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.reused,
+			prometheus.CounterValue,
+			float64(stats.Reused.Load()),
+			name,
+		)
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sysClassNetPath = "/sys/class/net"
+
+	// Kernel counters exported for each interface, by name in sysfs
+	interfaceStatisticsCounters = []string{
+		"rx_bytes",
+		"tx_bytes",
+		"rx_packets",
+		"tx_packets",
+		"rx_errors",
+		"tx_errors",
+		"rx_dropped",
+		"tx_dropped",
+	}
+)
+
+// Read the kernel statistics of an interface
+func readInterfaceStatistics(iface string) (*api.InterfaceStatistics, error) {
+	counters := map[string]uint64{}
+	for _, counter := range interfaceStatisticsCounters {
+		value, err := os.ReadFile(filepath.Join(sysClassNetPath, iface, "statistics", counter))
+		if err != nil {
+			return nil, err
+		}
+
+		counters[counter], err = strconv.ParseUint(strings.TrimSpace(string(value)), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &api.InterfaceStatistics{
+		RxBytes:   counters["rx_bytes"],
+		TxBytes:   counters["tx_bytes"],
+		RxPackets: counters["rx_packets"],
+		TxPackets: counters["tx_packets"],
+		RxErrors:  counters["rx_errors"],
+		TxErrors:  counters["tx_errors"],
+		RxDropped: counters["rx_dropped"],
+		TxDropped: counters["tx_dropped"],
+	}, nil
+}
+
+// Collects the kernel statistics of probed interfaces on every scrape
+type interfaceStatisticsCollector struct {
+	ifaces      []Interface
+	descriptors map[string]*prometheus.Desc
+}
+
+func newInterfaceStatisticsCollector(ifaces []Interface) *interfaceStatisticsCollector {
+	c := &interfaceStatisticsCollector{
+		ifaces:      ifaces,
+		descriptors: map[string]*prometheus.Desc{},
+	}
+
+	for _, counter := range interfaceStatisticsCounters {
+		c.descriptors[counter] = prometheus.NewDesc(
+			"wan_prober_interface_"+counter+"_total",
+			"Kernel "+strings.ReplaceAll(counter, "_", " ")+" counter of the interface.",
+			[]string{"interface"},
+			nil,
+		)
+	}
+
+	return c
+}
+
+func (c *interfaceStatisticsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, descriptor := range c.descriptors {
+		ch <- descriptor
+	}
+}
+
+func (c *interfaceStatisticsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, iface := range c.ifaces {
+		stats, err := readInterfaceStatistics(iface.Name)
+		if err != nil {
+			// Interface may not exist right now, e.g. a PPP interface
+			continue
+		}
+
+		values := map[string]uint64{
+			"rx_bytes":   stats.RxBytes,
+			"tx_bytes":   stats.TxBytes,
+			"rx_packets": stats.RxPackets,
+			"tx_packets": stats.TxPackets,
+			"rx_errors":  stats.RxErrors,
+			"tx_errors":  stats.TxErrors,
+			"rx_dropped": stats.RxDropped,
+			"tx_dropped": stats.TxDropped,
+		}
+		for counter, value := range values {
+			ch <- prometheus.MustNewConstMetric(
+				c.descriptors[counter],
+				prometheus.CounterValue,
+				float64(value),
+				iface.displayName(),
+			)
+		}
+	}
+}
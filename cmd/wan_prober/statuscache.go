@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// Age after which a cached status document is rebuilt even without a
+	// state change, for the counters and schedules read when rendering
+	maxStatusDocumentAge = time.Second
+)
+
+// Rendered status document, served to pollers until the state changes
+type statusDocument struct {
+	generation uint64
+	built      time.Time
+	body       []byte
+	gzipped    []byte
+	etag       string
+}
+
+// Status documents rendered for high-frequency pollers, so they don't each
+// range over every interface and encode the result
+type statusDocumentCache struct {
+	mu        sync.Mutex
+	documents map[string]*statusDocument
+}
+
+var (
+	statusDocuments = &statusDocumentCache{
+		documents: map[string]*statusDocument{},
+	}
+)
+
+// Document of an endpoint, rendered again when the state changed since it
+// was or it is too old
+func (c *statusDocumentCache) get(endpoint string, render func() (any, error)) (*statusDocument, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	generation := interfaceStates.Generation()
+	now := time.Now()
+	if document, exists := c.documents[endpoint]; exists &&
+		document.generation == generation &&
+		now.Sub(document.built) < maxStatusDocumentAge {
+		return document, nil
+	}
+
+	resp, err := render()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	document := &statusDocument{
+		generation: generation,
+		built:      now,
+		body:       append(body, '\n'),
+		// Weak, as the same document is also served compressed
+		etag: fmt.Sprintf(`W/"%d-%d"`, generation, now.UnixNano()),
+	}
+	document.gzipped = gzipBody(document.body)
+	c.documents[endpoint] = document
+
+	return document, nil
+}
+
+// Serve the cached document of an endpoint, answering pollers which
+// already have it with 304 Not Modified
+func serveStatusDocument(w http.ResponseWriter, r *http.Request, endpoint string, render func() (any, error)) {
+	document, err := statusDocuments.get(endpoint, render)
+	if err != nil {
+		logger.Error("Error rendering status", "endpoint", endpoint, "error", err.Error())
+		http.Error(w, "Failed to render data", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", document.etag)
+	if r.Header.Get("If-None-Match") == document.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	sendBody(w, r, http.StatusOK, document.body, document.gzipped)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Order targets randomly, with targets of more weight more likely to come
+// first, using weighted random sampling as described by Efraimidis and
+// Spirakis. The weight of suspect targets is reduced.
+func weightedTargetOrder(targets []Target) []int {
+	keys := make([]float64, len(targets))
+	order := make([]int, len(targets))
+	for i, target := range targets {
+		keys[i] = math.Pow(rand.Float64(), 1/(target.Weight*targetWeightFactor(target.Host)))
+		order[i] = i
+	}
+
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Compare(keys[b], keys[a])
+	})
+
+	return order
+}
+
+// Move a target to the front of a probe order, so a link which is healthy
+// only needs one probe per round
+func targetOrder(order []int, first int) []int {
+	if index := slices.Index(order, first); index > 0 {
+		order[0], order[index] = order[index], order[0]
+	}
+
+	return order
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// Weight of the latest round in a target's failure score
+	targetScoreAlpha = 0.05
+	// Rounds a target must be probed in before it can be suspect
+	minTargetScoreSamples = 20
+	// Failure score above which a target is suspect
+	suspectTargetScore = 0.5
+	// Factor applied to the weight of suspect targets
+	suspectTargetWeight = 0.1
+)
+
+var (
+	// Quality of targets keyed by target host
+	targetQualities sync.Map
+)
+
+type targetQuality struct {
+	mu sync.Mutex
+	// Rounds where the link worked, so the target's result reflects the
+	// target rather than the link
+	samples int
+	// Moving average of failures in those rounds
+	failureScore float64
+	suspect      bool
+	lastFailure  time.Time
+}
+
+type TargetQualityResponse struct {
+	Target string `json:"target"`
+	// Rounds where another target proved the link worked
+	Samples int `json:"samples"`
+	// Moving average of the target failing while others succeeded, from 0
+	// (always worked) to 1 (always failed)
+	FailureScore float64    `json:"failure_score"`
+	Suspect      bool       `json:"suspect"`
+	LastFailure  *time.Time `json:"last_failure,omitempty"`
+}
+
+// Record the result of a target in a round where the link worked, failures
+// of targets in rounds where every target failed are the link's fault and
+// aren't recorded
+func recordTargetQuality(target string, iface string, success bool) {
+	val, _ := targetQualities.LoadOrStore(target, &targetQuality{})
+	quality, ok := val.(*targetQuality)
+	if !ok {
+		return
+	}
+
+	quality.mu.Lock()
+	defer quality.mu.Unlock()
+
+	failure := 0.0
+	if !success {
+		failure = 1
+		quality.lastFailure = time.Now()
+	}
+	if quality.samples == 0 {
+		quality.failureScore = failure
+	} else {
+		quality.failureScore += targetScoreAlpha * (failure - quality.failureScore)
+	}
+	quality.samples += 1
+
+	suspect := quality.samples >= minTargetScoreSamples && quality.failureScore >= suspectTargetScore
+	if suspect != quality.suspect {
+		quality.suspect = suspect
+		if suspect {
+			logger.Warn(
+				"Target fails while other targets work, reducing its weight",
+				"interface",
+				iface,
+				"target",
+				target,
+				"failure_score",
+				quality.failureScore,
+			)
+		} else {
+			logger.Info(
+				"Target is no longer suspect",
+				"interface",
+				iface,
+				"target",
+				target,
+				"failure_score",
+				quality.failureScore,
+			)
+		}
+	}
+}
+
+// Factor applied to the weight of a target, suspect targets are probed
+// first less often
+func targetWeightFactor(target string) float64 {
+	val, exists := targetQualities.Load(target)
+	if !exists {
+		return 1
+	}
+	quality, ok := val.(*targetQuality)
+	if !ok {
+		return 1
+	}
+
+	quality.mu.Lock()
+	defer quality.mu.Unlock()
+
+	if quality.suspect {
+		return suspectTargetWeight
+	}
+
+	return 1
+}
+
+// Quality of every target which has been scored, worst first
+func targetQualityList(suspectOnly bool) []TargetQualityResponse {
+	resp := []TargetQualityResponse{}
+
+	targetQualities.Range(func(key, val interface{}) bool {
+		target, ok := key.(string)
+		quality, ok2 := val.(*targetQuality)
+		if !ok || !ok2 {
+			return true
+		}
+
+		quality.mu.Lock()
+		entry := TargetQualityResponse{
+			Target:       target,
+			Samples:      quality.samples,
+			FailureScore: quality.failureScore,
+			Suspect:      quality.suspect,
+		}
+		if !quality.lastFailure.IsZero() {
+			lastFailure := quality.lastFailure
+			entry.LastFailure = &lastFailure
+		}
+		quality.mu.Unlock()
+
+		if !suspectOnly || entry.Suspect {
+			resp = append(resp, entry)
+		}
+		return true
+	})
+
+	slices.SortFunc(resp, func(a, b TargetQualityResponse) int {
+		return cmp.Or(
+			-cmp.Compare(a.FailureScore, b.FailureScore),
+			cmp.Compare(a.Target, b.Target),
+		)
+	})
+
+	return resp
+}
+
+// Serve target quality at /targets/quality, ?suspect=true lists only the
+// suspect targets
+func registerTargetQualityHandler() {
+	handleRoleFunc(roleStatus, "/targets/quality", func(w http.ResponseWriter, r *http.Request) {
+		resp := targetQualityList(r.URL.Query().Get("suspect") == "true")
+
+		writeJSON(w, r, http.StatusOK, resp)
+	})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/internal/health"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Status of a target across interfaces
+const (
+	targetStatusReachable   = "reachable"
+	targetStatusPartial     = "partial"
+	targetStatusUnreachable = "unreachable"
+	// No interface has a recent result which says anything about the target
+	targetStatusUnknown = "unknown"
+)
+
+var (
+	targetViews = &targetView{
+		results: map[string]map[string]targetResult{},
+	}
+)
+
+// Latest verdict of a target from an interface
+type targetResult struct {
+	verdict     health.Verdict
+	time        time.Time
+	lastSuccess time.Time
+}
+
+// Results of each target from every interface, answering whether a failing
+// target is down or the links to it are
+type targetView struct {
+	mu sync.Mutex
+	// Results older than this don't count, as rounds stop probing targets
+	// once one succeeds
+	window  time.Duration
+	results map[string]map[string]targetResult
+}
+
+// Set how recent results must be to count
+func (v *targetView) configure(window time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.window = window
+}
+
+// Record the verdict of a target in a round of an interface
+func (v *targetView) record(target string, iface string, verdict health.Verdict, now time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if v.results[target] == nil {
+		v.results[target] = map[string]targetResult{}
+	}
+	result := v.results[target][iface]
+	result.verdict = verdict
+	result.time = now
+	if verdict == health.VerdictReachable {
+		result.lastSuccess = now
+	}
+	v.results[target][iface] = result
+}
+
+// Status of every target which has been probed, by target
+func (v *targetView) statuses(now time.Time) []api.TargetStatus {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	resp := []api.TargetStatus{}
+	for target, results := range v.results {
+		status := api.TargetStatus{
+			Target:     target,
+			Outage:     targetOutages.inOutage(target),
+			Interfaces: []api.TargetInterfaceStatus{},
+		}
+
+		for iface, result := range results {
+			stale := v.window > 0 && now.Sub(result.time) > v.window
+			entry := api.TargetInterfaceStatus{
+				Interface: iface,
+				Verdict:   string(result.verdict),
+				LastProbe: result.time.Unix(),
+				Stale:     stale,
+			}
+			if !result.lastSuccess.IsZero() {
+				entry.LastSuccess = result.lastSuccess.Unix()
+			}
+			status.Interfaces = append(status.Interfaces, entry)
+
+			if stale {
+				continue
+			}
+			switch result.verdict {
+			case health.VerdictReachable:
+				status.Reachable += 1
+				status.Valid += 1
+			case health.VerdictUnreachable, health.VerdictOutage:
+				status.Valid += 1
+			}
+		}
+		slices.SortFunc(status.Interfaces, func(a, b api.TargetInterfaceStatus) int {
+			return cmp.Compare(a.Interface, b.Interface)
+		})
+
+		switch {
+		case status.Valid == 0:
+			status.Status = targetStatusUnknown
+		case status.Reachable == status.Valid:
+			status.Status = targetStatusReachable
+		case status.Reachable > 0:
+			status.Status = targetStatusPartial
+		default:
+			status.Status = targetStatusUnreachable
+		}
+
+		resp = append(resp, status)
+	}
+	slices.SortFunc(resp, func(a, b api.TargetStatus) int {
+		return cmp.Compare(a.Target, b.Target)
+	})
+
+	return resp
+}
+
+// Serve the status of each target across interfaces at /targets
+func registerTargetsHandler() {
+	handleRoleFunc(roleStatus, "/targets", func(w http.ResponseWriter, r *http.Request) {
+		resp := targetViews.statuses(time.Now())
+
+		writeJSON(w, r, http.StatusOK, resp)
+	})
+}
+
+// Exports the status of each target across interfaces
+type targetsCollector struct {
+	reachable *prometheus.Desc
+	valid     *prometheus.Desc
+	outage    *prometheus.Desc
+}
+
+func newTargetsCollector() *targetsCollector {
+	return &targetsCollector{
+		reachable: prometheus.NewDesc(
+			"wan_prober_target_reachable_interfaces",
+			"Interfaces which recently reached a target.",
+			[]string{"target"},
+			nil,
+		),
+		valid: prometheus.NewDesc(
+			"wan_prober_target_valid_interfaces",
+			"Interfaces whose recent result for a target was reachable or unreachable.",
+			[]string{"target"},
+			nil,
+		),
+		outage: prometheus.NewDesc(
+			"wan_prober_target_outage",
+			"Whether a target fails from every interface while other targets work.",
+			[]string{"target"},
+			nil,
+		),
+	}
+}
+
+func (c *targetsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.reachable
+	ch <- c.valid
+	ch <- c.outage
+}
+
+func (c *targetsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range targetViews.statuses(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(c.reachable, prometheus.GaugeValue, float64(status.Reachable), status.Target)
+		ch <- prometheus.MustNewConstMetric(c.valid, prometheus.GaugeValue, float64(status.Valid), status.Target)
+		ch <- prometheus.MustNewConstMetric(c.outage, prometheus.GaugeValue, boolGauge(status.Outage), status.Target)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// Tenant of an aggregator, such as a customer of an MSP. Its sites are
+// served under /tenants/<name>/, only to clients with its token.
+type AggregatorTenant struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// Read the tenants of an aggregator from a file
+func readAggregatorTenants(path string) ([]AggregatorTenant, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tenantsFile := struct {
+		Tenants []AggregatorTenant `yaml:"tenants"`
+	}{}
+	if err := yaml.Unmarshal(data, &tenantsFile); err != nil {
+		return nil, err
+	}
+
+	if len(tenantsFile.Tenants) == 0 {
+		return nil, errors.New("no tenants configured")
+	}
+
+	names := map[string]bool{}
+	tokens := map[string]bool{}
+	for _, tenant := range tenantsFile.Tenants {
+		if tenant.Name == "" || url.PathEscape(tenant.Name) != tenant.Name {
+			return nil, fmt.Errorf("invalid tenant name %q, must be usable in a URL path", tenant.Name)
+		}
+		if names[tenant.Name] {
+			return nil, fmt.Errorf("duplicate tenant %s", tenant.Name)
+		}
+		if tenant.Token == "" {
+			return nil, fmt.Errorf("tenant %s has no token", tenant.Name)
+		}
+		if tokens[tenant.Token] {
+			return nil, fmt.Errorf("tenant %s shares its token with another tenant", tenant.Name)
+		}
+		names[tenant.Name] = true
+		tokens[tenant.Token] = true
+	}
+
+	return tenantsFile.Tenants, nil
+}
+
+// Wrap a handler so it's only served to clients sending the token of a
+// tenant as a bearer token
+func tenantAuthHandler(tenant AggregatorTenant, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(tenant.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="wan-prober"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+	"github.com/adaricorp/wan-prober/internal/notify"
+)
+
+var (
+	// Timezone of times in RFC 3339 in the API, events and reports
+	reportLocation = time.Local
+)
+
+// Set the reporting timezone from its IANA name, the local timezone is
+// kept when empty
+func setReportTimezone(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return err
+	}
+	reportLocation = location
+
+	return nil
+}
+
+// Time in the reporting timezone
+func reportTime(t time.Time) time.Time {
+	return t.In(reportLocation)
+}
+
+// Unix time in RFC 3339 in the reporting timezone, empty when zero
+func reportUnix(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+
+	return reportTime(time.Unix(unix, 0)).Format(time.RFC3339)
+}
+
+// Add times in RFC 3339 to the Unix times of an interface's status
+func localizeStatus(status *api.InterfaceStatusResponse) {
+	status.LastProbeRFC3339 = reportUnix(status.LastProbe)
+	status.LastChangeRFC3339 = reportUnix(status.LastChange)
+	status.NextProbeAtRFC3339 = reportUnix(status.NextProbeAt)
+
+	// Copy so the history kept in the state store isn't modified
+	status.Transitions = slices.Clone(status.Transitions)
+	for i := range status.Transitions {
+		status.Transitions[i].TimeRFC3339 = reportUnix(status.Transitions[i].Time)
+	}
+
+	if status.Override != nil {
+		status.Override.SinceRFC3339 = reportUnix(status.Override.Since)
+		status.Override.ExpiresRFC3339 = reportUnix(status.Override.Expires)
+	}
+}
+
+// Add times in RFC 3339 to the Unix times of an event
+func localizeEvent(event notify.Event) notify.Event {
+	event.SinceRFC3339 = reportUnix(event.Since)
+	event.TimeRFC3339 = reportUnix(event.Time)
+
+	return event
+}
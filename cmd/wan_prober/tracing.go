@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Trace context of a probe attempt, in the W3C Trace Context format
+type probeTrace struct {
+	traceID string
+	spanID  string
+}
+
+// New trace context for a probe attempt
+func newProbeTrace() probeTrace {
+	traceID := make([]byte, 16)
+	spanID := make([]byte, 8)
+	_, _ = rand.Read(traceID)
+	_, _ = rand.Read(spanID)
+
+	return probeTrace{traceID: hex.EncodeToString(traceID), spanID: hex.EncodeToString(spanID)}
+}
+
+// Value of the traceparent header, sampled so targets record the trace
+func (t probeTrace) traceparent() string {
+	return fmt.Sprintf("00-%s-%s-01", t.traceID, t.spanID)
+}
+
+// Headers of a probe attempt with its trace context added, the headers of
+// the probe configuration are left alone as they are shared
+func (t probeTrace) headers(headers map[string]string) map[string]string {
+	headers = maps.Clone(headers)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers["traceparent"] = t.traceparent()
+
+	return headers
+}
+
+// Exemplar labels of a probe attempt's observations, none without a trace
+func (t probeTrace) exemplar() prometheus.Labels {
+	if t.traceID == "" {
+		return nil
+	}
+
+	return prometheus.Labels{"trace_id": t.traceID}
+}
+
+// Record an observation with the trace of the probe attempt it came from
+// as its exemplar, exemplars are only served in the OpenMetrics format
+func observeWithTrace(observer prometheus.Observer, value float64, trace probeTrace) {
+	if exemplar := trace.exemplar(); exemplar != nil {
+		if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+			exemplarObserver.ObserveWithExemplar(value, exemplar)
+			return
+		}
+	}
+
+	observer.Observe(value)
+}
+
+// Handler of the metrics endpoint, which negotiates OpenMetrics so scrapers
+// get exemplars when probe attempts are traced
+func metricsHandler() http.Handler {
+	if !*probeTraceIDs {
+		return promhttp.Handler()
+	}
+
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+}
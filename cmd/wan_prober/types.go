@@ -0,0 +1,598 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/health"
+	"github.com/adaricorp/wan-prober/probe"
+)
+
+type Config struct {
+	ProbeConfiguration ProbeConfiguration `yaml:"probe_config"`
+	Interfaces         []Interface        `yaml:"interfaces"`
+	Targets            []Target           `yaml:"targets"`
+	HostResolver       *AddrPort          `yaml:"host_resolver"`
+	FallbackResolvers  []AddrPort         `yaml:"fallback_resolvers"`
+	FallbackEDNS       EDNS               `yaml:"fallback_edns"`
+	Notifications      []NotificationSink `yaml:"notifications"`
+	NotificationRules  []NotificationRule `yaml:"notification_rules"`
+	Heartbeats         []Heartbeat        `yaml:"heartbeats"`
+	HA                 *HAConfig          `yaml:"ha"`
+	Push               *PushConfig        `yaml:"push"`
+	Peering            *PeeringConfig     `yaml:"peering"`
+	Consul             *ConsulConfig      `yaml:"consul"`
+	Etcd               *EtcdConfig        `yaml:"etcd"`
+	Kubernetes         *KubernetesConfig  `yaml:"kubernetes"`
+	Zabbix             *ZabbixConfig      `yaml:"zabbix"`
+	NSCA               *NSCAConfig        `yaml:"nsca"`
+	AgentX             *AgentXConfig      `yaml:"agentx"`
+	DBusService        *DBusServiceConfig `yaml:"dbus_service"`
+	GeoIP              *GeoIPConfig       `yaml:"geoip"`
+	// blackbox_exporter configuration whose modules targets can refer to
+	BlackboxModulesFile string `yaml:"blackbox_modules_file"`
+	// HTTP listeners, --http-listen-address serves every role when empty
+	Listeners []ListenerConfig `yaml:"listeners"`
+	// Certificate of listeners with acme TLS, obtained from an ACME CA
+	ACME *ACMEConfig `yaml:"acme"`
+	// Clients of the admin API and their roles, anyone reaching an admin
+	// listener can call it when empty
+	AdminAccess []AdminAccess `yaml:"admin_access"`
+	// Local BGP daemon announcing routes of healthy interfaces
+	BGP *BGPConfig `yaml:"bgp"`
+	// keepalived track file reflecting the health of the interfaces
+	VRRP *VRRPConfig `yaml:"vrrp"`
+	// DNS records pointed at the public IP of the active interface
+	DynamicDNS *DynamicDNSConfig `yaml:"dynamic_dns"`
+	// Addresses of hostnames used before any resolver, like a hosts file
+	StaticHosts map[string][]netip.Addr `yaml:"static_hosts"`
+	// Internal DNS cache kept on disk across restarts
+	DNSCache *DNSCacheConfig `yaml:"dns_cache"`
+	// Limit of requests to status endpoints from each client
+	StatusRateLimit *RateLimitConfig `yaml:"status_rate_limit"`
+	// IANA timezone of times in RFC 3339 in the API, events and reports,
+	// the local timezone when empty
+	Timezone string `yaml:"timezone"`
+}
+
+type DNSCacheConfig struct {
+	File         string        `yaml:"file"`
+	SaveInterval time.Duration `yaml:"save_interval"`
+	// Entries resolved longer ago than this aren't loaded, all are when 0
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+type DynamicDNSConfig struct {
+	DNSProviderConfig `yaml:",inline"`
+	Hostnames         []string `yaml:"hostnames"`
+	TTL               int      `yaml:"ttl"`
+	// Interfaces in order of preference, the first healthy one is active,
+	// all interfaces in configuration order when not given
+	Interfaces []string `yaml:"interfaces"`
+	// URL returning the public IP when requested through an interface,
+	// used for interfaces without a CPE check which found it
+	PublicIPURL string `yaml:"public_ip_url"`
+	// How often the public IP of the active interface is checked
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DNS provider whose records wan-prober changes
+type DNSProviderConfig struct {
+	// rfc2136, cloudflare, route53 or desec
+	Provider string `yaml:"provider"`
+	// Zone name for rfc2136 and desec, zone ID for cloudflare and hosted
+	// zone ID for route53
+	Zone string `yaml:"zone"`
+	// Name server accepting dynamic updates, for rfc2136
+	Server        string `yaml:"server"`
+	TSIGKey       string `yaml:"tsig_key"`
+	TSIGSecret    string `yaml:"tsig_secret"`
+	TSIGAlgorithm string `yaml:"tsig_algorithm"`
+	// API token, for cloudflare and desec
+	Token string `yaml:"token"`
+	// IAM credentials, for route53
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+}
+
+type VRRPConfig struct {
+	// File keepalived tracks with track_file, holding the sum of the
+	// weights of healthy interfaces
+	TrackFile string `yaml:"track_file"`
+	// Weight of each interface, 1 when not given
+	Weights map[string]int `yaml:"weights"`
+}
+
+type BGPConfig struct {
+	// bird or gobgp
+	Daemon string `yaml:"daemon"`
+	// BIRD control socket
+	Socket string `yaml:"socket"`
+	// gobgp command and its options, e.g. the address of gobgpd
+	Command []string `yaml:"command"`
+}
+
+type ListenerConfig struct {
+	Address string `yaml:"address"`
+	// Groups of endpoints served: status, metrics and admin
+	Roles     []string         `yaml:"roles"`
+	TLS       *ListenerTLS     `yaml:"tls"`
+	BasicAuth *BasicAuthConfig `yaml:"basic_auth"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// Requests a client can make at once after being idle, 1 when 0
+	Burst int `yaml:"burst"`
+}
+
+type ListenerTLS struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// Serve the certificate obtained with acme instead of cert_file and
+	// key_file
+	ACME bool `yaml:"acme"`
+	// Require client certificates signed by this CA
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+type ACMEConfig struct {
+	// Names of the certificate, wildcards need the dns-01 challenge
+	Domains []string `yaml:"domains"`
+	// Contact of the account, for expiry notices from the CA
+	Email string `yaml:"email"`
+	// ACME directory of the CA, Let's Encrypt when not given
+	DirectoryURL string `yaml:"directory_url"`
+	// Directory the account key and certificate are kept in
+	CacheDir string `yaml:"cache_dir"`
+	// http-01 or dns-01
+	Challenge string `yaml:"challenge"`
+	// Address serving http-01 challenges, which the CA reaches on port 80
+	HTTPAddress string `yaml:"http_address"`
+	// Provider of the TXT records of dns-01 challenges
+	DNS *DNSProviderConfig `yaml:"dns"`
+	// Time dns-01 records get to reach every name server of the zone
+	PropagationDelay time.Duration `yaml:"propagation_delay"`
+}
+
+type BasicAuthConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type ProbeConfiguration struct {
+	MinInterval time.Duration     `yaml:"min_interval"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	Attempts    int               `yaml:"attempts"`
+	UserAgent   string            `yaml:"user_agent"`
+	Headers     map[string]string `yaml:"headers"`
+	// Warn when a target's clock is further off than this
+	MaxClockSkew time.Duration `yaml:"max_clock_skew"`
+	// Warn when target certificates expire within this many days
+	CertificateExpiryWarningDays int `yaml:"certificate_expiry_warning_days"`
+	// Expression deciding whether an interface is healthy from probe results
+	HealthPolicy string `yaml:"health_policy"`
+	// Health of interfaces in rounds where no target is valid, and in
+	// rounds where some valid targets failed without being unreachable,
+	// healthy (default) or unhealthy
+	NoValidTargetsHealth string `yaml:"no_valid_targets_health"`
+	InconclusiveHealth   string `yaml:"inconclusive_health"`
+	// Probe the target which last succeeded first each round, the other
+	// targets are only probed when it fails
+	LastGoodTargetFirst bool `yaml:"last_good_target_first"`
+	// Stop probing targets whose probes all failed with errors, like
+	// NXDOMAIN, for this long
+	ErrorExclusion time.Duration `yaml:"error_exclusion"`
+	// Stop probing targets which fail from every interface while other
+	// targets work for this long, they are probed again afterwards
+	TargetOutageCooldown time.Duration `yaml:"target_outage_cooldown"`
+	// Every interface going down within this long of each other suggests
+	// a site outage rather than link failures
+	SiteOutageWindow time.Duration `yaml:"site_outage_window"`
+	// Resolve targets with a fallback resolver in parallel with the host
+	// resolver and compare the answers, to detect DNS manipulation
+	CompareResolvers bool `yaml:"compare_resolvers"`
+	// Reuse connections of HTTP probes between probes instead of making a
+	// new connection for every probe
+	HTTPKeepAlive bool `yaml:"http_keep_alive"`
+	// Close kept alive connections after they are idle for this long
+	HTTPIdleTimeout time.Duration `yaml:"http_idle_timeout"`
+	// Time each probe attempt may take in total and in its phases,
+	// defaults are derived from the timeout
+	AttemptBudget AttemptBudgetConfig `yaml:"attempt_budget"`
+}
+
+// Health values of the options deciding rounds which can't tell whether
+// the link is down
+const (
+	healthHealthy   = "healthy"
+	healthUnhealthy = "unhealthy"
+)
+
+// Options of the health evaluation, the policy is set for each interface
+func (c ProbeConfiguration) healthOptions() health.Options {
+	options := health.DefaultOptions()
+	if c.NoValidTargetsHealth != "" {
+		options.NoValidTargets = c.NoValidTargetsHealth == healthHealthy
+	}
+	if c.InconclusiveHealth != "" {
+		options.Inconclusive = c.InconclusiveHealth == healthHealthy
+	}
+
+	return options
+}
+
+type AttemptBudgetConfig struct {
+	Total time.Duration `yaml:"total"`
+	DNS   time.Duration `yaml:"dns"`
+	Dial  time.Duration `yaml:"dial"`
+}
+
+// Budget of probe attempts, with defaults for what isn't set
+func (b AttemptBudgetConfig) probeBudget(timeout time.Duration) probe.Budget {
+	return probe.Budget{Total: b.Total, DNS: b.DNS, Dial: b.Dial}.WithDefaults(timeout)
+}
+
+type Interface struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Name shown in notifications, the status API and metric labels
+	// instead of the kernel name
+	DisplayName string            `yaml:"display_name"`
+	Labels      map[string]string `yaml:"labels"`
+	// URL where peers can reach us through this interface
+	AdvertiseURL string             `yaml:"advertise_url"`
+	DHCP         *DHCPMonitorConfig `yaml:"dhcp"`
+	PPP          *PPPMonitorConfig  `yaml:"ppp"`
+	CPE          *CPECheckConfig    `yaml:"cpe"`
+	RouteCheck   *RouteCheckConfig  `yaml:"route_check"`
+	// Verify probe traffic leaves through this interface
+	EgressCheck *EgressCheckConfig `yaml:"egress_check"`
+	// Overrides the health policy of probe_config
+	HealthPolicy string `yaml:"health_policy"`
+	// Actions which try to fix the interface while it is unhealthy
+	Remediation *RemediationConfig `yaml:"remediation"`
+	// Time the interface must stay up and healthy after coming up before
+	// it is reported healthy
+	GracePeriod time.Duration `yaml:"grace_period"`
+	// Time the interface must stay healthy after failing before it is
+	// reported healthy again
+	FailbackDelay time.Duration `yaml:"failback_delay"`
+	// Report the interface unhealthy after it failed until an operator
+	// approves failing back to it
+	StickyFailback bool `yaml:"sticky_failback"`
+	// Actions performed when the interface changes state
+	Actions []TransitionAction `yaml:"actions"`
+	// Routes announced through the BGP daemon while the interface is healthy
+	BGP *InterfaceBGP `yaml:"bgp"`
+	// Uplink has no IPv4 connectivity, so probes and resolvers only use IPv6
+	IPv6Only bool `yaml:"ipv6_only"`
+	// /96 prefix used to reach IPv4-only targets through NAT64 on
+	// IPv6-only uplinks, e.g. 64:ff9b::/96
+	NAT64Prefix netip.Prefix `yaml:"nat64_prefix"`
+}
+
+// Name of the interface to show to people, defaults to the kernel name
+func (i Interface) displayName() string {
+	if i.DisplayName != "" {
+		return i.DisplayName
+	}
+
+	return i.Name
+}
+
+// Status of the interface reported by its probe loop
+func (i Interface) status(healthy bool, reason string) InterfaceStatus {
+	return InterfaceStatus{
+		Name:        i.Name,
+		Description: i.Description,
+		DisplayName: i.DisplayName,
+		Healthy:     healthy,
+		Reason:      reason,
+	}
+}
+
+type RouteCheckConfig struct {
+	// Routing table which must hold the default route, any table when 0
+	Table int `yaml:"table"`
+}
+
+type CPECheckConfig struct {
+	// nat-pmp or upnp, NAT-PMP is tried before UPnP when empty
+	Protocol string `yaml:"protocol"`
+	// CPE address, the default gateway of the interface is used when empty
+	Gateway netip.Addr `yaml:"gateway"`
+	// URL which responds with the public IP address of the client as text
+	PublicIPURL string        `yaml:"public_ip_url"`
+	Interval    time.Duration `yaml:"interval"`
+}
+
+type EgressCheckConfig struct {
+	// Echo service responding with the source address of the request
+	URL string `yaml:"url"`
+	// Read the address from this response header instead of the body
+	Header string `yaml:"header"`
+	// Read the address from this field of a JSON body
+	JSONField string `yaml:"json_field"`
+	// Prefixes the source address must be in, otherwise it only must
+	// differ from those seen through other interfaces
+	Expected []netip.Prefix `yaml:"expected"`
+}
+
+type PPPMonitorConfig struct {
+	// pppd pid file, /run/<interface>.pid is used when empty
+	PIDFile string `yaml:"pid_file"`
+}
+
+type InterfaceBGP struct {
+	// BIRD protocols enabled while the interface is healthy and disabled
+	// while it is unhealthy
+	Protocols []string `yaml:"protocols"`
+	// Prefixes GoBGP announces while the interface is healthy
+	Prefixes    []netip.Prefix `yaml:"prefixes"`
+	Communities []string       `yaml:"communities"`
+	MED         *uint32        `yaml:"med"`
+	// Attributes the prefixes are announced with while the interface is
+	// unhealthy, they are withdrawn when neither is set
+	UnhealthyCommunities []string `yaml:"unhealthy_communities"`
+	UnhealthyMED         *uint32  `yaml:"unhealthy_med"`
+}
+
+type TransitionAction struct {
+	// State which triggers the action, healthy or unhealthy, both when empty
+	On string `yaml:"on"`
+	// wake_on_lan, udp, tcp, networkd_reconfigure, nm_activate or
+	// nm_deactivate
+	Type string `yaml:"type"`
+	// Device woken by wake_on_lan
+	MAC string `yaml:"mac"`
+	// Host and port the packet or message is sent to
+	Address string `yaml:"address"`
+	// Sent by udp and tcp, {interface}, {state} and {reason} are replaced
+	Message string `yaml:"message"`
+	// UUID of the NetworkManager connection nm_activate activates, the
+	// best available connection when empty
+	Connection string `yaml:"connection"`
+}
+
+type RemediationConfig struct {
+	// Actions tried in order on successive attempts, the last one is
+	// repeated: dhcp_renew, bounce, restart_pppd, power_cycle or hook
+	Actions []string `yaml:"actions"`
+	// Consecutive unhealthy rounds before the first attempt
+	AfterRounds int `yaml:"after_rounds"`
+	// Time between attempts while the interface stays unhealthy
+	Interval time.Duration `yaml:"interval"`
+	// Attempts before giving up until the interface is healthy again
+	MaxAttempts int `yaml:"max_attempts"`
+	// Command renewing the DHCP lease, the installed DHCP client is used
+	// when empty
+	DHCPRenewCommand []string `yaml:"dhcp_renew_command"`
+	// Command run by the hook action
+	Hook []string `yaml:"hook"`
+	// Smart plug or PDU which power-cycles the modem
+	PowerCycle *PowerCycleConfig `yaml:"power_cycle"`
+}
+
+type PowerCycleConfig struct {
+	// tasmota, shelly or snmp
+	Type string `yaml:"type"`
+	// Base URL of a Tasmota or Shelly plug
+	URL      string `yaml:"url"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// Relay of the plug, as numbered by the plug
+	Outlet int `yaml:"outlet"`
+	// SNMP agent of a PDU, the outlet control object and the values
+	// switching it on and off
+	Address   string `yaml:"address"`
+	Community string `yaml:"community"`
+	OID       string `yaml:"oid"`
+	OnValue   int    `yaml:"on_value"`
+	OffValue  int    `yaml:"off_value"`
+	// How long the modem is left off
+	OffTime time.Duration `yaml:"off_time"`
+	// Shortest time between power cycles, even across outages
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+type DHCPMonitorConfig struct {
+	// Lease file to read, standard locations are searched when empty
+	LeaseFile string `yaml:"lease_file"`
+	// Mark the interface unhealthy while it doesn't hold a lease
+	FailOnLeaseLoss bool `yaml:"fail_on_lease_loss"`
+}
+
+type Target struct {
+	Host      string       `yaml:"host"`
+	Probe     string       `yaml:"probe"`
+	Addresses []netip.Addr `yaml:"addresses"`
+	HTTP      TargetHTTP   `yaml:"http"`
+	DNS       TargetDNS    `yaml:"dns"`
+	MTU       TargetMTU    `yaml:"mtu"`
+	TWAMP     TargetTWAMP  `yaml:"twamp"`
+	// IP protocol preferred by tcp and icmp probes, ip4 or ip6
+	IPProtocol string `yaml:"ip_protocol"`
+	// blackbox_exporter module which sets the probe of the target
+	Module string `yaml:"module"`
+	// Target template whose fields are merged into the target
+	Template string `yaml:"template"`
+	// Relative chance of the target being probed before others, defaults to 1
+	Weight float64 `yaml:"weight"`
+}
+
+type TargetHTTP struct {
+	Method         string `yaml:"method"`
+	Protocol       string `yaml:"protocol"`
+	MaxBodyBytes   int64  `yaml:"max_body_bytes"`
+	ExpectedSHA256 string `yaml:"expected_sha256"`
+	ExpectedStatus []int  `yaml:"expected_status"`
+}
+
+type NotificationSink struct {
+	Name             string        `yaml:"name"`
+	Type             string        `yaml:"type"`
+	URL              string        `yaml:"url"`
+	MaxQueued        int           `yaml:"max_queued"`
+	MaxAge           time.Duration `yaml:"max_age"`
+	MinStateDuration time.Duration `yaml:"min_state_duration"`
+}
+
+type Heartbeat struct {
+	URL       string        `yaml:"url"`
+	Interface string        `yaml:"interface"`
+	Interval  time.Duration `yaml:"interval"`
+}
+
+type HAConfig struct {
+	StateFile   string        `yaml:"state_file"`
+	ID          string        `yaml:"id"`
+	Listen      string        `yaml:"listen"`
+	Peer        string        `yaml:"peer"`
+	Priority    int           `yaml:"priority"`
+	PeerTimeout time.Duration `yaml:"peer_timeout"`
+	// Shared by the pair to authenticate their advertisements
+	Secret string `yaml:"secret"`
+}
+
+type PushConfig struct {
+	URL      string        `yaml:"url"`
+	Site     string        `yaml:"site"`
+	Interval time.Duration `yaml:"interval"`
+	// Bearer token of the site's tenant, for aggregators with tenants
+	Token string   `yaml:"token"`
+	TLS   *PushTLS `yaml:"tls"`
+}
+
+type PushTLS struct {
+	// Client certificate identifying this prober to the aggregator, read
+	// again for every connection so renewed certificates are picked up
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CA verifying the aggregator's certificate, the system roots when not
+	// given
+	CAFile string `yaml:"ca_file"`
+}
+
+type PeeringConfig struct {
+	Site     string        `yaml:"site"`
+	Interval time.Duration `yaml:"interval"`
+	Peers    []Peer        `yaml:"peers"`
+}
+
+type Peer struct {
+	URL string `yaml:"url"`
+}
+
+type ConsulConfig struct {
+	Address string        `yaml:"address"`
+	Token   string        `yaml:"token"`
+	Service string        `yaml:"service"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+type EtcdConfig struct {
+	Endpoint string `yaml:"endpoint"`
+	Prefix   string `yaml:"prefix"`
+}
+
+type KubernetesConfig struct {
+	NodeName        string `yaml:"node_name"`
+	ConditionPrefix string `yaml:"condition_prefix"`
+}
+
+type ZabbixConfig struct {
+	Server string `yaml:"server"`
+	Host   string `yaml:"host"`
+	Key    string `yaml:"key"`
+}
+
+type NSCAConfig struct {
+	Server       string `yaml:"server"`
+	Host         string `yaml:"host"`
+	Service      string `yaml:"service"`
+	Encryption   string `yaml:"encryption"`
+	Password     string `yaml:"password"`
+	LegacyPacket bool   `yaml:"legacy_packet"`
+}
+
+type AgentXConfig struct {
+	Address string `yaml:"address"`
+	BaseOID string `yaml:"base_oid"`
+}
+
+type DBusServiceConfig struct {
+	// Address of the message bus, the system bus when empty
+	Address string `yaml:"address"`
+	// Well-known name the service owns on the bus
+	Name string `yaml:"name"`
+}
+
+type TargetDNS struct {
+	Server string `yaml:"server"`
+	Type   string `yaml:"type"`
+	DNSSEC bool   `yaml:"dnssec"`
+	EDNS   EDNS   `yaml:"edns"`
+}
+
+type TargetMTU struct {
+	Floor int `yaml:"floor"`
+	Max   int `yaml:"max"`
+}
+
+type TargetTWAMP struct {
+	Count    int           `yaml:"count"`
+	Interval time.Duration `yaml:"interval"`
+	MaxLoss  float64       `yaml:"max_loss"`
+}
+
+type EDNS struct {
+	ClientSubnet netip.Prefix `yaml:"client_subnet"`
+	DO           bool         `yaml:"do"`
+	UDPSize      uint16       `yaml:"udp_size"`
+	TCPFallback  bool         `yaml:"tcp_fallback"`
+}
+
+func (e EDNS) probeEDNS() probe.EDNS {
+	return probe.EDNS{
+		ClientSubnet: e.ClientSubnet,
+		DO:           e.DO,
+		UDPSize:      e.UDPSize,
+		TCPFallback:  e.TCPFallback,
+	}
+}
+
+type AddrPort struct {
+	netip.AddrPort
+}
+
+func (a *AddrPort) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	addrPort, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return fmt.Errorf("invalid address and port `%s`, expected e.g. 192.0.2.1:53 or [2001:db8::1]:53", s)
+	}
+	*a = AddrPort{addrPort}
+	return nil
+}
+
+type InterfaceStatus struct {
+	Name        string
+	Description string
+	DisplayName string
+	Healthy     bool
+	// Why the interface is in this state
+	Reason string
+	// Action of the operator override which forced the state, if any
+	Override string
+}
+
+type GeoIPConfig struct {
+	// MMDB files in the GeoLite2 ASN and country formats
+	ASNDatabase     string `yaml:"asn_database"`
+	CountryDatabase string `yaml:"country_database"`
+}
@@ -0,0 +1,4 @@
+package main
+
+// Windows has no timezone database for the reporting timezone
+import _ "time/tzdata"
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+const (
+	actionVRRP = "vrrp"
+)
+
+// Publishes the aggregate WAN health in a file tracked by keepalived, so
+// the VRRP priority of the router with working uplinks is raised
+type vrrpTracker struct {
+	mu      sync.Mutex
+	config  VRRPConfig
+	healthy map[string]bool
+	// Value last written, -1 before the first write
+	written int
+}
+
+// Check the VRRP configuration, interfaces must exist
+func validateVRRP(config VRRPConfig, interfaces []string) error {
+	if config.TrackFile == "" {
+		return errors.New("vrrp needs a track_file")
+	}
+	for iface := range config.Weights {
+		if !slices.Contains(interfaces, iface) {
+			return fmt.Errorf("vrrp weight for unknown interface %s", iface)
+		}
+	}
+
+	return nil
+}
+
+func newVRRPTracker(config VRRPConfig) *vrrpTracker {
+	return &vrrpTracker{
+		config:  config,
+		healthy: map[string]bool{},
+		written: -1,
+	}
+}
+
+// Record the health of an interface, and write the sum of the weights of
+// healthy interfaces to the track file when it changed
+func (t *vrrpTracker) update(ctx context.Context, iface string, healthy bool) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.healthy[iface] = healthy
+
+	value := 0
+	for name, up := range t.healthy {
+		if !up {
+			continue
+		}
+		weight, exists := t.config.Weights[name]
+		if !exists {
+			weight = 1
+		}
+		value += weight
+	}
+	if value == t.written {
+		return
+	}
+
+	description := fmt.Sprintf("write %d to %s", value, t.config.TrackFile)
+	// Standby instances write it too, it decides which instance keepalived
+	// makes active
+	err := performInstanceAction(ctx, actionVRRP, iface, description, func(ctx context.Context) error {
+		return writeFileAtomic(t.config.TrackFile, []byte(strconv.Itoa(value)+"\n"))
+	})
+	if err == nil {
+		t.written = value
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// Probe loops which haven't made progress for this many times their
+	// longest expected round are restarted
+	watchdogStallMultiple = 3
+)
+
+var (
+	watchdog = &probeWatchdog{
+		progress: map[string]time.Time{},
+		stalled:  map[string]bool{},
+	}
+
+	probeLoopRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "wan_prober_probe_loop_restarts_total",
+			Help: "Probe loops restarted by the watchdog after they stopped making progress.",
+		},
+		[]string{"interface"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(probeLoopRestarts)
+}
+
+// Tracks the progress of the probe loop of each interface, so a loop stuck
+// in a call which ignores its timeout doesn't silently stop monitoring
+type probeWatchdog struct {
+	mu       sync.Mutex
+	progress map[string]time.Time
+	// Loops which were restarted and haven't finished a round since
+	stalled map[string]bool
+}
+
+// Record that the probe loop of an interface is making progress
+func (w *probeWatchdog) alive(iface string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.progress[iface] = time.Now()
+}
+
+// Record that the probe loop of an interface finished a round
+func (w *probeWatchdog) roundDone(iface string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.progress[iface] = time.Now()
+	delete(w.stalled, iface)
+}
+
+// Time since the probe loop of an interface last made progress
+func (w *probeWatchdog) sinceProgress(iface string, now time.Time) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return now.Sub(w.progress[iface])
+}
+
+func (w *probeWatchdog) markStalled(iface string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stalled[iface] = true
+	// Give the restarted loop a full period before checking it again
+	w.progress[iface] = time.Now()
+}
+
+// Whether the probe loop of an interface was restarted after stalling and
+// hasn't finished a round since
+func (w *probeWatchdog) isStalled(iface string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.stalled[iface]
+}
+
+// Run the probe loop of an interface, restarting it when it stops making
+// progress. The stuck goroutine can't be stopped, but its context is
+// cancelled so it exits if it ever returns from the call it is stuck in.
+func superviseProbeLoop(
+	ctx context.Context,
+	channel chan<- InterfaceStatus,
+	config Config,
+	iface Interface,
+) {
+	probeConfig := config.ProbeConfiguration
+	// Longest a round should take between reports: waiting for its slot,
+	// then an attempt spending its whole budget
+	budget := probeConfig.AttemptBudget.probeBudget(probeConfig.Timeout)
+	stallAfter := watchdogStallMultiple * (probeConfig.MinInterval + maxRoundJitter + budget.Total)
+
+	ticker := time.NewTicker(stallAfter / 4)
+	defer ticker.Stop()
+
+	for {
+		loopCtx, cancel := context.WithCancel(ctx)
+		watchdog.alive(iface.Name)
+		go probeInterface(loopCtx, channel, config, iface)
+
+		for watchdog.sinceProgress(iface.Name, time.Now()) < stallAfter {
+			select {
+			case <-ctx.Done():
+				cancel()
+				return
+			case <-ticker.C:
+			}
+		}
+
+		cancel()
+		watchdog.markStalled(iface.Name)
+		probeLoopRestarts.WithLabelValues(iface.displayName()).Inc()
+
+		stacks := bytes.Buffer{}
+		if err := pprof.Lookup("goroutine").WriteTo(&stacks, 2); err != nil {
+			stacks.WriteString(err.Error())
+		}
+		logger.Error(
+			"Probe loop stopped making progress, restarting it",
+			"interface",
+			iface.Name,
+			"description",
+			iface.Description,
+			"stalled_for",
+			stallAfter,
+			"goroutines",
+			stacks.String(),
+		)
+	}
+}
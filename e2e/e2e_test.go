@@ -0,0 +1,156 @@
+//go:build e2e
+
+package e2e
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const waitTimeout = 30 * time.Second
+
+// Build a configuration probing targets from interfaces
+func config(interfaces []string, targets []string, extra string) string {
+	builder := strings.Builder{}
+	builder.WriteString("probe_config:\n  min_interval: 1s\n  timeout: 1s\n  attempts: 1\n")
+	builder.WriteString(extra)
+	builder.WriteString("interfaces:\n")
+	for _, iface := range interfaces {
+		fmt.Fprintf(&builder, "  - name: %s\n", iface)
+	}
+	builder.WriteString("targets:\n")
+	for _, target := range targets {
+		fmt.Fprintf(&builder, "  - host: %s\n    probe: http\n", target)
+	}
+
+	return builder.String()
+}
+
+func TestBindToDevice(t *testing.T) {
+	lab := NewLab(t)
+	primary := lab.AddUplink("wan0")
+	lab.AddUplink("wan1")
+
+	// Unbound probes would leave through wan0 and fail
+	primary.SetLink(false)
+
+	prober := lab.Start(config([]string{"wan0", "wan1"}, []string{"http://" + TargetAddr + "/"}, ""))
+	prober.WaitFor("wan0", false, "all_targets_unreachable", waitTimeout)
+	prober.WaitFor("wan1", true, "target_reachable", waitTimeout)
+
+	primary.SetLink(true)
+	prober.WaitFor("wan0", true, "target_reachable", waitTimeout)
+}
+
+func TestTimeoutRecovery(t *testing.T) {
+	lab := NewLab(t)
+	uplink := lab.AddUplink("wan0")
+
+	uplink.Impair(Impairment{Delay: 2 * time.Second})
+
+	prober := lab.Start(config([]string{"wan0"}, []string{"http://" + TargetAddr + "/"}, ""))
+	prober.WaitFor("wan0", false, "all_targets_unreachable", waitTimeout)
+
+	uplink.Impair(Impairment{})
+	prober.WaitFor("wan0", true, "target_reachable", waitTimeout)
+}
+
+func TestDNSNXDomain(t *testing.T) {
+	lab := NewLab(t)
+	uplink := lab.AddUplink("wan0")
+
+	uplink.SetDNS(DNSNXDomain)
+
+	prober := lab.Start(config([]string{"wan0"}, []string{"http://" + TargetName + "/"}, ""))
+	// A target which doesn't exist says nothing about the uplink
+	prober.WaitFor("wan0", true, "no_valid_targets", waitTimeout)
+
+	uplink.SetDNS(DNSAnswer)
+	prober.WaitFor("wan0", true, "target_reachable", waitTimeout)
+}
+
+func TestDNSUnreachable(t *testing.T) {
+	lab := NewLab(t)
+	uplink := lab.AddUplink("wan0")
+
+	uplink.SetDNS(DNSDrop)
+
+	prober := lab.Start(config(
+		[]string{"wan0"},
+		[]string{"http://" + TargetName + "/"},
+		"fallback_resolvers:\n  - "+ResolverAddr+":53\n",
+	))
+	prober.WaitFor("wan0", false, "all_targets_unreachable", waitTimeout)
+}
+
+func TestDNSCacheWhenResolverFails(t *testing.T) {
+	lab := NewLab(t)
+	uplink := lab.AddUplink("wan0")
+
+	prober := lab.Start(config(
+		[]string{"wan0"},
+		[]string{"http://" + TargetName + "/"},
+		"fallback_resolvers:\n  - "+ResolverAddr+":53\n",
+	))
+	prober.WaitFor("wan0", true, "target_reachable", waitTimeout)
+
+	// Probes keep reaching the target with the address cached when the
+	// resolvers answered
+	uplink.SetDNS(DNSServFail)
+	time.Sleep(5 * time.Second)
+	if status := prober.Interface("wan0"); !status.Healthy {
+		t.Fatalf("wan0 unhealthy with a cached target address: %+v", status)
+	}
+	if !strings.Contains(prober.Output(), "Cache hit for target in internal DNS cache") {
+		t.Fatal("target wasn't resolved from the internal DNS cache")
+	}
+}
+
+func TestTargetOutage(t *testing.T) {
+	lab := NewLab(t)
+	lab.AddUplink("wan0")
+	lab.AddUplink("wan1")
+
+	prober := lab.Start(
+		"probe_config:\n  min_interval: 1s\n  timeout: 1s\n  attempts: 1\n  target_outage_cooldown: 1m\n" +
+			"interfaces:\n  - name: wan0\n  - name: wan1\n" +
+			"targets:\n" +
+			// Probe the target which is down first, so it is probed every round
+			"  - host: http://" + DownTargetAddr + "/\n    probe: http\n    weight: 1000\n" +
+			"  - host: http://" + TargetAddr + "/\n    probe: http\n",
+	)
+
+	prober.WaitForOutput("classifying as target-side outage", waitTimeout)
+
+	// Target isn't probed during the cooldown, so only the working target
+	// counts
+	prober.WaitFor("wan0", true, "target_reachable", waitTimeout)
+	prober.WaitFor("wan1", true, "target_reachable", waitTimeout)
+	probes := strings.Count(prober.Output(), "target=http://"+DownTargetAddr+"/")
+	time.Sleep(5 * time.Second)
+	if after := strings.Count(prober.Output(), "target=http://"+DownTargetAddr+"/"); after != probes {
+		t.Fatalf("target in an outage was probed %d times during the cooldown", after-probes)
+	}
+}
+
+func TestSiteOutage(t *testing.T) {
+	lab := NewLab(t)
+	wan0 := lab.AddUplink("wan0")
+	wan1 := lab.AddUplink("wan1")
+
+	prober := lab.Start(config([]string{"wan0", "wan1"}, []string{"http://" + TargetAddr + "/"}, ""))
+	prober.WaitFor("wan0", true, "target_reachable", waitTimeout)
+	prober.WaitFor("wan1", true, "target_reachable", waitTimeout)
+
+	wan0.SetLink(false)
+	wan1.SetLink(false)
+	prober.WaitFor("wan0", false, "all_targets_unreachable", waitTimeout)
+	prober.WaitFor("wan1", false, "all_targets_unreachable", waitTimeout)
+	prober.WaitForOutput("suspecting a site outage", waitTimeout)
+
+	wan1.SetLink(true)
+	prober.WaitFor("wan1", true, "target_reachable", waitTimeout)
+	prober.WaitForOutput("site outage is over", waitTimeout)
+}
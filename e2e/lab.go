@@ -0,0 +1,500 @@
+// Package e2e runs wan-prober end to end against uplinks made of network
+// namespaces and veth pairs, with impairment added by tc netem.
+//
+// A lab is a namespace for wan-prober with one veth pair per uplink, the
+// far end of each uplink is a namespace which serves an HTTP target at
+// TargetAddr and a DNS resolver at ResolverAddr. Every uplink has a default
+// route in the prober namespace, with the first uplink preferred, so probes
+// which aren't bound to their interface leave through the first uplink.
+//
+// Labs need root and the ip and tc commands.
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+	"github.com/miekg/dns"
+)
+
+const (
+	// Address of the HTTP target behind every uplink
+	TargetAddr = "198.51.100.1"
+	// Address behind every uplink which never answers, like a target
+	// which is down
+	DownTargetAddr = "198.51.100.2"
+	// Address of the DNS resolver behind every uplink, it is the resolver
+	// of the prober namespace
+	ResolverAddr = "198.51.100.53"
+	// Name the resolvers answer with TargetAddr
+	TargetName = "target.e2e"
+
+	// Address of the wan-prober HTTP API in the prober namespace
+	apiAddr = "127.0.0.1:8020"
+)
+
+// Behaviours of the DNS resolver behind an uplink
+type DNSMode int32
+
+const (
+	DNSAnswer DNSMode = iota
+	DNSDrop
+	DNSServFail
+	DNSNXDomain
+)
+
+var (
+	binaryPath = flag.String("wan-prober", "", "wan-prober binary to test, built from the module when empty")
+
+	buildOnce   sync.Once
+	buildErr    error
+	labSequence atomic.Int32
+)
+
+// Network impairment added to the egress of an uplink
+type Impairment struct {
+	Delay time.Duration
+	// Percentage of packets dropped
+	Loss float64
+}
+
+type Lab struct {
+	t       testing.TB
+	name    string
+	uplinks []*Uplink
+}
+
+type Uplink struct {
+	lab       *Lab
+	Name      string
+	namespace string
+	dnsMode   atomic.Int32
+}
+
+// Create a lab with no uplinks, it is torn down when the test ends. The
+// test is skipped when labs can't be created.
+func NewLab(t testing.TB) *Lab {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("e2e tests need root")
+	}
+	for _, command := range []string{"ip", "tc"} {
+		if _, err := exec.LookPath(command); err != nil {
+			t.Skipf("e2e tests need %s", command)
+		}
+	}
+
+	lab := &Lab{
+		t:    t,
+		name: fmt.Sprintf("wpe2e-%d-%d", os.Getpid(), labSequence.Add(1)),
+	}
+
+	run(t, "ip", "netns", "add", lab.name)
+	t.Cleanup(func() {
+		for _, uplink := range lab.uplinks {
+			_ = exec.Command("ip", "netns", "del", uplink.namespace).Run()
+		}
+		_ = exec.Command("ip", "netns", "del", lab.name).Run()
+		_ = os.RemoveAll(filepath.Join("/etc/netns", lab.name))
+	})
+
+	run(t, "ip", "-n", lab.name, "link", "set", "lo", "up")
+	// Replies to probes bound to a backup uplink arrive on an interface
+	// which isn't the preferred route back to the target
+	for _, conf := range []string{"all", "default"} {
+		path := filepath.Join("/proc/sys/net/ipv4/conf", conf, "rp_filter")
+		if err := lab.do(lab.name, func() error { return os.WriteFile(path, []byte("0"), 0o644) }); err != nil {
+			t.Fatalf("disabling rp_filter: %v", err)
+		}
+	}
+
+	// ip netns exec bind mounts this over /etc/resolv.conf
+	resolvConf := filepath.Join("/etc/netns", lab.name, "resolv.conf")
+	if err := os.MkdirAll(filepath.Dir(resolvConf), 0o755); err != nil {
+		t.Fatalf("creating resolv.conf: %v", err)
+	}
+	if err := os.WriteFile(resolvConf, []byte("nameserver "+ResolverAddr+"\noptions timeout:1 attempts:1\n"), 0o644); err != nil {
+		t.Fatalf("creating resolv.conf: %v", err)
+	}
+
+	return lab
+}
+
+// Add an uplink, which appears as an interface with the given name in the
+// prober namespace
+func (l *Lab) AddUplink(name string) *Uplink {
+	l.t.Helper()
+
+	index := len(l.uplinks)
+	uplink := &Uplink{
+		lab:       l,
+		Name:      name,
+		namespace: l.name + "-" + name,
+	}
+	l.uplinks = append(l.uplinks, uplink)
+
+	proberAddr := fmt.Sprintf("10.200.%d.2", index)
+	gatewayAddr := fmt.Sprintf("10.200.%d.1", index)
+
+	run(l.t, "ip", "netns", "add", uplink.namespace)
+	run(l.t, "ip", "-n", l.name, "link", "add", name, "type", "veth", "peer", "name", "uplink", "netns", uplink.namespace)
+
+	run(l.t, "ip", "-n", l.name, "addr", "add", proberAddr+"/24", "dev", name)
+	run(l.t, "ip", "-n", l.name, "link", "set", name, "up")
+	run(l.t, "ip", "-n", l.name, "route", "add", "default", "via", gatewayAddr, "dev", name, "metric", fmt.Sprint(100+index))
+
+	run(l.t, "ip", "-n", uplink.namespace, "link", "set", "lo", "up")
+	run(l.t, "ip", "-n", uplink.namespace, "addr", "add", TargetAddr+"/32", "dev", "lo")
+	run(l.t, "ip", "-n", uplink.namespace, "addr", "add", ResolverAddr+"/32", "dev", "lo")
+	run(l.t, "ip", "-n", uplink.namespace, "addr", "add", gatewayAddr+"/24", "dev", "uplink")
+	run(l.t, "ip", "-n", uplink.namespace, "link", "set", "uplink", "up")
+	run(l.t, "ip", "-n", uplink.namespace, "route", "add", "blackhole", DownTargetAddr+"/32")
+
+	uplink.serveHTTP()
+	uplink.serveDNS()
+
+	return uplink
+}
+
+// Replace the impairment of an uplink, a zero impairment removes it
+func (u *Uplink) Impair(impairment Impairment) {
+	u.lab.t.Helper()
+
+	if impairment == (Impairment{}) {
+		_ = exec.Command("tc", "-n", u.lab.name, "qdisc", "del", "dev", u.Name, "root").Run()
+		return
+	}
+
+	output, err := exec.Command(
+		"tc", "-n", u.lab.name, "qdisc", "replace", "dev", u.Name, "root", "netem",
+		"delay", fmt.Sprintf("%dms", impairment.Delay.Milliseconds()),
+		"loss", fmt.Sprintf("%g%%", impairment.Loss),
+	).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "qdisc kind is unknown") {
+			u.lab.t.Skip("impairment needs the sch_netem kernel module")
+		}
+		u.lab.t.Fatalf("impairing %s: %v: %s", u.Name, err, output)
+	}
+}
+
+// Bring the far end of an uplink down or up, like a cable being unplugged
+// and plugged back in at the modem
+func (u *Uplink) SetLink(up bool) {
+	u.lab.t.Helper()
+
+	state := "down"
+	if up {
+		state = "up"
+	}
+	run(u.lab.t, "ip", "-n", u.namespace, "link", "set", "uplink", state)
+}
+
+// Change how the DNS resolver behind an uplink answers
+func (u *Uplink) SetDNS(mode DNSMode) {
+	u.dnsMode.Store(int32(mode))
+}
+
+func (u *Uplink) serveHTTP() {
+	var listener net.Listener
+	err := u.lab.do(u.namespace, func() (err error) {
+		listener, err = net.Listen("tcp", net.JoinHostPort(TargetAddr, "80"))
+		return err
+	})
+	if err != nil {
+		u.lab.t.Fatalf("starting HTTP target of %s: %v", u.Name, err)
+	}
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}),
+	}
+	go func() { _ = server.Serve(listener) }()
+	u.lab.t.Cleanup(func() { _ = server.Close() })
+}
+
+func (u *Uplink) serveDNS() {
+	var conn net.PacketConn
+	err := u.lab.do(u.namespace, func() (err error) {
+		conn, err = net.ListenPacket("udp", net.JoinHostPort(ResolverAddr, "53"))
+		return err
+	})
+	if err != nil {
+		u.lab.t.Fatalf("starting DNS resolver of %s: %v", u.Name, err)
+	}
+
+	server := &dns.Server{
+		PacketConn: conn,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			reply := &dns.Msg{}
+			reply.SetReply(r)
+
+			switch DNSMode(u.dnsMode.Load()) {
+			case DNSDrop:
+				return
+			case DNSServFail:
+				reply.Rcode = dns.RcodeServerFailure
+			case DNSNXDomain:
+				reply.Rcode = dns.RcodeNameError
+			default:
+				question := r.Question[0]
+				if !strings.EqualFold(question.Name, dns.Fqdn(TargetName)) {
+					reply.Rcode = dns.RcodeNameError
+				} else if question.Qtype == dns.TypeA {
+					reply.Answer = append(reply.Answer, &dns.A{
+						Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+						A:   net.ParseIP(TargetAddr),
+					})
+				}
+			}
+
+			_ = w.WriteMsg(reply)
+		}),
+	}
+	go func() { _ = server.ActivateAndServe() }()
+	u.lab.t.Cleanup(func() { _ = server.Shutdown() })
+}
+
+// Run fn in a network namespace, sockets it opens stay in the namespace
+func (l *Lab) do(namespace string, fn func() error) error {
+	errs := make(chan error, 1)
+
+	go func() {
+		// The thread is never unlocked, so it exits with the goroutine
+		// rather than going back to the scheduler in the wrong namespace
+		runtime.LockOSThread()
+
+		if err := netbind.EnterNetns(filepath.Join("/run/netns", namespace)); err != nil {
+			errs <- err
+			return
+		}
+
+		errs <- fn()
+	}()
+
+	return <-errs
+}
+
+// Start wan-prober in the lab with a configuration, it is stopped when the
+// test ends
+func (l *Lab) Start(config string) *Prober {
+	l.t.Helper()
+
+	binary := *binaryPath
+	if binary == "" {
+		binary = buildProber(l.t)
+	}
+
+	configPath := filepath.Join(l.t.TempDir(), "wan-prober.yml")
+	if err := os.WriteFile(configPath, []byte(config), 0o644); err != nil {
+		l.t.Fatalf("writing configuration: %v", err)
+	}
+
+	prober := &Prober{lab: l}
+	prober.cmd = exec.Command(
+		"ip", "netns", "exec", l.name,
+		binary,
+		"--config-file", configPath,
+		"--http-listen-address", apiAddr,
+	)
+	prober.cmd.Stdout = &prober.output
+	prober.cmd.Stderr = &prober.output
+	if err := prober.cmd.Start(); err != nil {
+		l.t.Fatalf("starting wan-prober: %v", err)
+	}
+
+	l.t.Cleanup(func() {
+		_ = prober.cmd.Process.Kill()
+		_ = prober.cmd.Wait()
+		if l.t.Failed() {
+			l.t.Logf("wan-prober output:\n%s", prober.output.String())
+		}
+	})
+
+	return prober
+}
+
+// Build wan-prober once for all labs
+func buildProber(t testing.TB) string {
+	buildOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "wan-prober-e2e")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		*binaryPath = filepath.Join(dir, "wan-prober")
+
+		output, err := exec.Command("go", "build", "-o", *binaryPath, "github.com/adaricorp/wan-prober").CombinedOutput()
+		if err != nil {
+			buildErr = fmt.Errorf("%w: %s", err, output)
+		}
+	})
+	if buildErr != nil {
+		t.Fatalf("building wan-prober: %v", buildErr)
+	}
+
+	return *binaryPath
+}
+
+// Status of an interface reported by the wan-prober API
+type InterfaceStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Reason  string `json:"reason"`
+	// Unix time of the last probe, 0 before the first
+	LastProbe int64 `json:"last_probe"`
+}
+
+// A wan-prober process running in a lab
+type Prober struct {
+	lab    *Lab
+	cmd    *exec.Cmd
+	output syncBuffer
+}
+
+// Fetch the status of the interfaces from the wan-prober API
+func (p *Prober) Status() ([]InterfaceStatus, error) {
+	client := &http.Client{
+		Timeout: time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, network, addr string) (conn net.Conn, err error) {
+				err = p.lab.do(p.lab.name, func() error {
+					conn, err = (&net.Dialer{}).DialContext(ctx, network, addr)
+					return err
+				})
+				return conn, err
+			},
+		},
+	}
+
+	resp, err := client.Get("http://" + apiAddr + "/")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	statuses := []InterfaceStatus{}
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// Fetch the status of one interface, failing the test when it is unknown
+func (p *Prober) Interface(name string) InterfaceStatus {
+	p.lab.t.Helper()
+
+	statuses, err := p.Status()
+	if err != nil {
+		p.lab.t.Fatalf("fetching status: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Name == name {
+			return status
+		}
+	}
+
+	p.lab.t.Fatalf("no status for interface %s", name)
+	return InterfaceStatus{}
+}
+
+// Wait until an interface has been probed and has the given health, and
+// reason when one is given
+func (p *Prober) WaitFor(name string, healthy bool, reason string, timeout time.Duration) {
+	p.lab.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	var last InterfaceStatus
+	var lastErr error
+	for time.Now().Before(deadline) {
+		statuses, err := p.Status()
+		lastErr = err
+		for _, status := range statuses {
+			if status.Name != name || status.LastProbe == 0 {
+				continue
+			}
+			last = status
+			if status.Healthy == healthy && (reason == "" || status.Reason == reason) {
+				return
+			}
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+
+	if lastErr != nil {
+		p.lab.t.Logf("last status error: %v", lastErr)
+	}
+	p.lab.t.Fatalf(
+		"interface %s didn't become healthy=%t %s within %s, last status %+v",
+		name,
+		healthy,
+		reason,
+		timeout,
+		last,
+	)
+}
+
+// Output written by wan-prober so far
+func (p *Prober) Output() string {
+	return p.output.String()
+}
+
+// Wait for wan-prober to write some output, failing the test otherwise
+func (p *Prober) WaitForOutput(substr string, timeout time.Duration) {
+	p.lab.t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !strings.Contains(p.Output(), substr) {
+		if time.Now().After(deadline) {
+			p.lab.t.Fatalf("wan-prober didn't log %q within %s", substr, timeout)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func run(t testing.TB, args ...string) {
+	t.Helper()
+
+	if output, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v: %s", strings.Join(args, " "), err, output)
+	}
+}
+
+// Buffer safe to write from a process while being read
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
@@ -3,9 +3,31 @@ module github.com/adaricorp/wan-prober
 go 1.26.4
 
 require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/expr-lang/expr v1.17.8
+	github.com/miekg/dns v1.1.72
+	github.com/oschwald/maxminddb-golang/v2 v2.1.1
 	github.com/peterbourgon/ff/v4 v4.0.0-beta.1
+	github.com/prometheus/client_golang v1.23.2
 	github.com/prometheus/common v0.69.0
+	github.com/quic-go/quic-go v0.59.0
 	go.yaml.in/yaml/v3 v3.0.4
+	golang.org/x/crypto v0.51.0
+	golang.org/x/net v0.55.0
+	golang.org/x/sys v0.45.0
+	google.golang.org/protobuf v1.36.11
 )
 
-require github.com/kr/pretty v0.3.1 // indirect
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.2 // indirect
+	github.com/prometheus/procfs v0.16.1 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/mod v0.35.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
+	golang.org/x/text v0.37.0 // indirect
+	golang.org/x/tools v0.44.0 // indirect
+)
@@ -0,0 +1,177 @@
+// Package api holds the types served by the status API and pushed to
+// aggregators, which clients decode
+package api
+
+type InterfaceStatusResponse struct {
+	Name        string `json:"name,"`
+	DisplayName string `json:"display_name,omitempty"`
+	Healthy     bool   `json:"healthy,"`
+	Reason      string `json:"reason,omitempty"`
+	LastProbe   int64  `json:"last_probe,"`
+	LastChange  int64  `json:"last_change,"`
+	// Times of the last probe and change in RFC 3339, in the reporting
+	// timezone
+	LastProbeRFC3339  string `json:"last_probe_rfc3339,omitempty"`
+	LastChangeRFC3339 string `json:"last_change_rfc3339,omitempty"`
+	// Seconds the state before the last change lasted
+	PreviousStateDuration int64             `json:"previous_state_duration,omitempty"`
+	Transitions           []StateTransition `json:"transitions,omitempty"`
+	// Whether peers can reach this interface from the outside
+	InboundReachable *bool `json:"inbound_reachable,omitempty"`
+	// DHCP lease state, when monitored
+	DHCP *DHCPStatus `json:"dhcp,omitempty"`
+	// PPP session state, when monitored
+	PPP *PPPStatus `json:"ppp,omitempty"`
+	// External reachability through the CPE, when checked
+	CPE *CPEStatus `json:"cpe,omitempty"`
+	// Source address last verified by the egress check
+	EgressIP string `json:"egress_ip,omitempty"`
+	// Probe loop was restarted by the watchdog and hasn't finished a
+	// round since
+	Stalled bool `json:"stalled,omitempty"`
+	// Kernel counters of the interface
+	Statistics *InterfaceStatistics `json:"statistics,omitempty"`
+	// Latest offset of target clocks from the local clock
+	ClockSkew *float64 `json:"clock_skew_seconds,omitempty"`
+	// Health forced by an operator, which overrides the probes
+	Override *HealthOverride `json:"override,omitempty"`
+	// Traffic hasn't returned to the interface since it failed
+	Failback *PendingFailback `json:"failback,omitempty"`
+	// When the next probe round starts, the interval between rounds and
+	// the duration of the last round
+	NextProbeAt        int64   `json:"next_probe_at,omitempty"`
+	NextProbeAtRFC3339 string  `json:"next_probe_at_rfc3339,omitempty"`
+	Interval           float64 `json:"interval_seconds,omitempty"`
+	RoundDuration      float64 `json:"round_duration_seconds,omitempty"`
+}
+
+type HealthOverride struct {
+	Interface string `json:"interface"`
+	// force_down or force_up
+	Action string `json:"action"`
+	// Why the operator forced the health of the interface
+	Comment      string `json:"comment,omitempty"`
+	Since        int64  `json:"since"`
+	SinceRFC3339 string `json:"since_rfc3339,omitempty"`
+	// When the override ends by itself, never when zero
+	Expires        int64  `json:"expires,omitempty"`
+	ExpiresRFC3339 string `json:"expires_rfc3339,omitempty"`
+}
+
+type PendingFailback struct {
+	Interface string `json:"interface"`
+	// When the interface became healthy again, zero while it is unhealthy
+	HealthySince        int64  `json:"healthy_since,omitempty"`
+	HealthySinceRFC3339 string `json:"healthy_since_rfc3339,omitempty"`
+	// Whether an operator approved failing back to a sticky interface
+	Approved bool `json:"approved,omitempty"`
+}
+
+// What the scheduler of an interface's probe rounds is doing
+type RoundSchedule struct {
+	Interface string  `json:"interface"`
+	Interval  float64 `json:"interval_seconds"`
+	// Rounds start up to this much later than the interval, so interfaces
+	// don't probe in lockstep
+	MaxJitter float64 `json:"max_jitter_seconds"`
+	// A round is running, which started at round_started_at
+	InRound        bool  `json:"in_round"`
+	RoundStartedAt int64 `json:"round_started_at"`
+	// Duration of the last round which finished
+	RoundDuration float64 `json:"round_duration_seconds,omitempty"`
+	// When the next round starts, unknown while a round is running
+	NextProbeAt int64 `json:"next_probe_at,omitempty"`
+	// Rounds skipped because earlier rounds took longer than the interval
+	SkippedRounds int64 `json:"skipped_rounds"`
+}
+
+// Status of a target across every interface probing it
+type TargetStatus struct {
+	Target string `json:"target"`
+	// reachable from every interface with a recent valid result, partial,
+	// unreachable from all of them, or unknown
+	Status string `json:"status"`
+	// Interfaces whose recent result reached the target, and whose recent
+	// result was reachable or unreachable
+	Reachable int `json:"reachable"`
+	Valid     int `json:"valid"`
+	// Target fails from every interface while other targets work, so it
+	// is the target's fault rather than the links'
+	Outage     bool                    `json:"outage"`
+	Interfaces []TargetInterfaceStatus `json:"interfaces"`
+}
+
+type TargetInterfaceStatus struct {
+	Interface string `json:"interface"`
+	// reachable, unreachable, inconclusive, invalid or outage
+	Verdict     string `json:"verdict"`
+	LastProbe   int64  `json:"last_probe"`
+	LastSuccess int64  `json:"last_success,omitempty"`
+	// Result is too old to count, as rounds stop probing targets once one
+	// succeeds
+	Stale bool `json:"stale,omitempty"`
+}
+
+type StateTransition struct {
+	Time                  int64  `json:"time"`
+	TimeRFC3339           string `json:"time_rfc3339,omitempty"`
+	Healthy               bool   `json:"healthy"`
+	Reason                string `json:"reason,omitempty"`
+	PreviousStateDuration int64  `json:"previous_state_duration"`
+}
+
+type InterfaceStatistics struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxPackets uint64 `json:"tx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	TxErrors  uint64 `json:"tx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+type CPEStatus struct {
+	Protocol    string `json:"protocol"`
+	ExternalIP  string `json:"external_ip,omitempty"`
+	PublicIP    string `json:"public_ip,omitempty"`
+	PortMapping bool   `json:"port_mapping"`
+	CGNAT       bool   `json:"cgnat"`
+	// Network of the public IP, when GeoIP databases are configured
+	ASN            uint   `json:"asn,omitempty"`
+	ASOrganization string `json:"as_organization,omitempty"`
+	Country        string `json:"country,omitempty"`
+	LastCheck      int64  `json:"last_check"`
+	Error          string `json:"error,omitempty"`
+}
+
+type PPPStatus struct {
+	Up           bool  `json:"up"`
+	SessionStart int64 `json:"session_start,omitempty"`
+	Uptime       int64 `json:"uptime_seconds,omitempty"`
+	LastDrop     int64 `json:"last_drop,omitempty"`
+	Drops        int   `json:"drops"`
+}
+
+type DHCPStatus struct {
+	Address           string `json:"address"`
+	LeaseExpires      *int64 `json:"lease_expires,omitempty"`
+	LastRenewal       int64  `json:"last_renewal,omitempty"`
+	LastAddressChange int64  `json:"last_address_change"`
+	Lost              bool   `json:"lost"`
+}
+
+type SiteStatusPush struct {
+	Site       string                    `json:"site"`
+	Interfaces []InterfaceStatusResponse `json:"interfaces"`
+}
+
+type SiteStatusResponse struct {
+	Site     string `json:"site"`
+	LastPush int64  `json:"last_push"`
+	Stale    bool   `json:"stale"`
+	// Client certificate identity of the prober which last pushed, when
+	// the aggregator authenticates probers
+	Identity   string                    `json:"identity,omitempty"`
+	Interfaces []InterfaceStatusResponse `json:"interfaces"`
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Version of the schema of responses under /api/v1. Within a version,
+// fields are only added, never removed, renamed or changed in type or
+// meaning, so clients must ignore fields they don't know. Breaking changes
+// get a new version under a new path.
+const SchemaVersion = 1
+
+// Status of every interface served at /api/v1/status
+type StatusResponse struct {
+	SchemaVersion int                       `json:"schema_version"`
+	Interfaces    []InterfaceStatusResponse `json:"interfaces"`
+}
+
+var (
+	// Fields of interface statuses served at the legacy root endpoint,
+	// fields added to InterfaceStatusResponse since schema versioning are
+	// only served under /api/v1
+	legacyStatusFields = []string{
+		"name",
+		"display_name",
+		"healthy",
+		"reason",
+		"last_probe",
+		"last_change",
+		"last_probe_rfc3339",
+		"last_change_rfc3339",
+		"previous_state_duration",
+		"transitions",
+		"inbound_reachable",
+		"dhcp",
+		"ppp",
+		"cpe",
+		"egress_ip",
+		"stalled",
+		"statistics",
+		"clock_skew_seconds",
+		"override",
+		"failback",
+		"next_probe_at",
+		"next_probe_at_rfc3339",
+		"interval_seconds",
+		"round_duration_seconds",
+	}
+)
+
+// Status of every interface in the shape of the legacy root endpoint, a
+// bare list of interfaces with only the legacy fields, in their order
+func LegacyStatus(statuses []InterfaceStatusResponse) ([]json.RawMessage, error) {
+	legacy := []json.RawMessage{}
+	for _, status := range statuses {
+		data, err := json.Marshal(status)
+		if err != nil {
+			return nil, err
+		}
+
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, err
+		}
+
+		object := bytes.Buffer{}
+		object.WriteByte('{')
+		for _, field := range legacyStatusFields {
+			value, exists := fields[field]
+			if !exists {
+				continue
+			}
+			if object.Len() > 1 {
+				object.WriteByte(',')
+			}
+			name, _ := json.Marshal(field)
+			object.Write(name)
+			object.WriteByte(':')
+			object.Write(value)
+		}
+		object.WriteByte('}')
+
+		legacy = append(legacy, object.Bytes())
+	}
+
+	return legacy, nil
+}
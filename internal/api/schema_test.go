@@ -0,0 +1,192 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+)
+
+// Interface status with every field set, so none is left out by omitempty
+func fullStatus() InterfaceStatusResponse {
+	reachable := true
+	skew := 0.5
+	expires := int64(1700003600)
+
+	return InterfaceStatusResponse{
+		Name:                  "eth0",
+		DisplayName:           "Fibre",
+		Healthy:               true,
+		Reason:                "target_reachable",
+		LastProbe:             1700000000,
+		LastChange:            1699990000,
+		LastProbeRFC3339:      "2023-11-14T22:13:20Z",
+		LastChangeRFC3339:     "2023-11-14T19:26:40Z",
+		PreviousStateDuration: 60,
+		Transitions:           []StateTransition{{Time: 1699990000, Healthy: true, PreviousStateDuration: 60}},
+		InboundReachable:      &reachable,
+		DHCP:                  &DHCPStatus{Address: "192.0.2.10", LeaseExpires: &expires},
+		PPP:                   &PPPStatus{Up: true},
+		CPE:                   &CPEStatus{Protocol: "upnp"},
+		EgressIP:              "192.0.2.10",
+		Stalled:               true,
+		Statistics:            &InterfaceStatistics{RxBytes: 1},
+		ClockSkew:             &skew,
+		Override:              &HealthOverride{Interface: "eth0", Action: "force_up"},
+		Failback:              &PendingFailback{Interface: "eth0"},
+		NextProbeAt:           1700000030,
+		NextProbeAtRFC3339:    "2023-11-14T22:13:50Z",
+		Interval:              30,
+		RoundDuration:         0.2,
+	}
+}
+
+// Decode the fields of a JSON object and the JSON type of each
+func fieldTypes(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+
+	object := map[string]any{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+
+	types := map[string]string{}
+	for field, value := range object {
+		switch value.(type) {
+		case string:
+			types[field] = "string"
+		case float64:
+			types[field] = "number"
+		case bool:
+			types[field] = "boolean"
+		case []any:
+			types[field] = "array"
+		case map[string]any:
+			types[field] = "object"
+		default:
+			types[field] = "null"
+		}
+	}
+
+	return types
+}
+
+// Fields of a JSON object in the order they appear
+func objectFields(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+
+	fields := []string{}
+	for decoder.More() {
+		field, err := decoder.Token()
+		if err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+		fields = append(fields, field.(string))
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			t.Fatalf("decoding %s: %v", data, err)
+		}
+	}
+
+	return fields
+}
+
+// Fields of schema version 1 and their JSON types, which must never change.
+// Fields added to the schema are added here too.
+var schemaV1Fields = map[string]string{
+	"name":                    "string",
+	"display_name":            "string",
+	"healthy":                 "boolean",
+	"reason":                  "string",
+	"last_probe":              "number",
+	"last_change":             "number",
+	"last_probe_rfc3339":      "string",
+	"last_change_rfc3339":     "string",
+	"previous_state_duration": "number",
+	"transitions":             "array",
+	"inbound_reachable":       "boolean",
+	"dhcp":                    "object",
+	"ppp":                     "object",
+	"cpe":                     "object",
+	"egress_ip":               "string",
+	"stalled":                 "boolean",
+	"statistics":              "object",
+	"clock_skew_seconds":      "number",
+	"override":                "object",
+	"failback":                "object",
+	"next_probe_at":           "number",
+	"next_probe_at_rfc3339":   "string",
+	"interval_seconds":        "number",
+	"round_duration_seconds":  "number",
+}
+
+func TestSchemaV1Compatibility(t *testing.T) {
+	data, err := json.Marshal(fullStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := fieldTypes(t, data)
+
+	for field, want := range schemaV1Fields {
+		got, exists := types[field]
+		if !exists {
+			t.Errorf("field %s of schema version %d was removed or renamed", field, SchemaVersion)
+		} else if got != want {
+			t.Errorf("field %s of schema version %d changed from %s to %s", field, SchemaVersion, want, got)
+		}
+	}
+	for field := range types {
+		if _, exists := schemaV1Fields[field]; !exists {
+			t.Errorf("field %s isn't listed in the schema, add it to schemaV1Fields", field)
+		}
+	}
+}
+
+func TestStatusResponseEnvelope(t *testing.T) {
+	data, err := json.Marshal(StatusResponse{SchemaVersion: SchemaVersion, Interfaces: []InterfaceStatusResponse{fullStatus()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := fieldTypes(t, data)
+	want := map[string]string{"schema_version": "number", "interfaces": "array"}
+	if !maps.Equal(types, want) {
+		t.Errorf("status response has fields %v, want %v", types, want)
+	}
+}
+
+func TestLegacyStatusShape(t *testing.T) {
+	legacy, err := LegacyStatus([]InterfaceStatusResponse{fullStatus()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy) != 1 {
+		t.Fatalf("legacy status has %d interfaces, want 1", len(legacy))
+	}
+
+	// Fields keep their order in the struct
+	data, err := json.Marshal(fullStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := slices.DeleteFunc(objectFields(t, data), func(field string) bool {
+		return !slices.Contains(legacyStatusFields, field)
+	})
+	if fields := objectFields(t, legacy[0]); !slices.Equal(fields, want) {
+		t.Errorf("legacy status has fields %v, want %v", fields, want)
+	}
+
+	// Legacy fields keep their types too
+	for field, got := range fieldTypes(t, legacy[0]) {
+		if want := schemaV1Fields[field]; got != want {
+			t.Errorf("legacy field %s is a %s, want %s", field, got, want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+// Package clock abstracts time, so schedules and timers can be tested
+// without sleeping
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Source of the current time and of timers
+type Clock interface {
+	Now() time.Time
+	// Timer which sends the time on its channel once d has passed
+	NewTimer(d time.Duration) Timer
+	// Timer which calls f in its own goroutine once d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer of a clock
+type Timer interface {
+	// Channel the time is sent on when the timer fires, nil for timers
+	// which call a function
+	C() <-chan time.Time
+	// Stop the timer, reporting whether it was stopped before it fired
+	Stop() bool
+}
+
+// Clock of the system
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return realTimer{time.AfterFunc(d, f)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// Clock of the system when c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// Sleep for a duration of a clock, or until a context is done
+func Sleep(ctx context.Context, c Clock, d time.Duration) {
+	timer := c.NewTimer(d)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+	case <-timer.C():
+	}
+}
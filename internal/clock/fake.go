@@ -0,0 +1,123 @@
+package clock
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Clock which only moves when it is advanced, firing the timers which
+// became due. Timers which call a function call it in the goroutine
+// advancing the clock, so their effects are visible once Advance returns.
+// Timers are only fired by Advance, even those of zero durations.
+type Fake struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *Fake
+	when  time.Time
+	c     chan time.Time
+	f     func()
+}
+
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, make(chan time.Time, 1), nil)
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	return f.add(d, nil, fn)
+}
+
+func (f *Fake) add(d time.Duration, c chan time.Time, fn func()) *fakeTimer {
+	f.mu.Lock()
+	timer := &fakeTimer{clock: f, when: f.now.Add(d), c: c, f: fn}
+	f.timers = append(f.timers, timer)
+	f.cond.Broadcast()
+	f.mu.Unlock()
+
+	return timer
+}
+
+// Move the clock forward, firing the timers which become due in the order
+// they are due
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	now := f.now
+	due := []*fakeTimer{}
+	f.timers = slices.DeleteFunc(f.timers, func(t *fakeTimer) bool {
+		if t.when.After(now) {
+			return false
+		}
+		due = append(due, t)
+		return true
+	})
+	f.cond.Broadcast()
+	f.mu.Unlock()
+
+	slices.SortStableFunc(due, func(a, b *fakeTimer) int {
+		return a.when.Compare(b.when)
+	})
+	for _, t := range due {
+		if t.f != nil {
+			t.f()
+		} else {
+			t.c <- now
+		}
+	}
+}
+
+// Number of timers which haven't fired or been stopped
+func (f *Fake) Timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.timers)
+}
+
+// Wait until at least n timers are waiting to fire, so a test advances the
+// clock only once the goroutines it drives are asleep
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	pending := len(t.clock.timers)
+	t.clock.timers = slices.DeleteFunc(t.clock.timers, func(other *fakeTimer) bool {
+		return other == t
+	})
+	if len(t.clock.timers) != pending {
+		t.clock.cond.Broadcast()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFakeFiresDueTimersInOrder(t *testing.T) {
+	fake := NewFake(time.Unix(1000, 0))
+
+	fired := []string{}
+	fake.AfterFunc(2*time.Second, func() { fired = append(fired, "second") })
+	fake.AfterFunc(time.Second, func() { fired = append(fired, "first") })
+	late := fake.AfterFunc(time.Minute, func() { fired = append(fired, "late") })
+
+	fake.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 {
+		t.Fatalf("fired %v after 1.5s, want only first", fired)
+	}
+
+	fake.Advance(time.Second)
+	if len(fired) != 2 || fired[0] != "first" || fired[1] != "second" {
+		t.Errorf("fired %v after 2.5s, want first and second", fired)
+	}
+
+	if !late.Stop() {
+		t.Error("stopping a pending timer reported it had fired")
+	}
+	fake.Advance(time.Hour)
+	if len(fired) != 2 {
+		t.Errorf("stopped timer fired: %v", fired)
+	}
+	if late.Stop() {
+		t.Error("stopping a stopped timer reported it was pending")
+	}
+}
+
+func TestFakeTimerSendsTime(t *testing.T) {
+	start := time.Unix(1000, 0)
+	fake := NewFake(start)
+
+	timer := fake.NewTimer(time.Second)
+	fake.Advance(3 * time.Second)
+
+	select {
+	case now := <-timer.C():
+		if !now.Equal(start.Add(3 * time.Second)) {
+			t.Errorf("timer sent %v, want the time it was advanced to", now)
+		}
+	default:
+		t.Fatal("timer didn't fire")
+	}
+}
+
+func TestSleep(t *testing.T) {
+	fake := NewFake(time.Unix(1000, 0))
+
+	done := make(chan struct{})
+	go func() {
+		Sleep(context.Background(), fake, time.Minute)
+		close(done)
+	}()
+
+	fake.BlockUntil(1)
+	fake.Advance(59 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("woke before the duration passed")
+	default:
+	}
+
+	fake.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("didn't wake once the duration passed")
+	}
+}
+
+func TestSleepUntilContextDone(t *testing.T) {
+	fake := NewFake(time.Unix(1000, 0))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		Sleep(ctx, fake, time.Hour)
+		close(done)
+	}()
+
+	fake.BlockUntil(1)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("didn't wake when the context was done")
+	}
+	if timers := fake.Timers(); timers != 0 {
+		t.Errorf("%d timers left after waking, want 0", timers)
+	}
+}
@@ -0,0 +1,172 @@
+package health
+
+import (
+	"time"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+// What probing a target in a round says about the link
+type Verdict string
+
+const (
+	// A probe succeeded, so the link works
+	VerdictReachable Verdict = "reachable"
+	// Every valid attempt timed out or found the target unreachable
+	VerdictUnreachable Verdict = "unreachable"
+	// Attempts failed in ways which neither prove nor disprove the link
+	// works, e.g. the prober couldn't run
+	VerdictInconclusive Verdict = "inconclusive"
+	// Every attempt failed with an error which could be unrelated to the
+	// link, or the target wasn't probed
+	VerdictInvalid Verdict = "invalid"
+	// Target is down from every interface, so its failure is the target's
+	// fault rather than the link's
+	VerdictOutage Verdict = "outage"
+)
+
+// Result of probing a target in a round
+type TargetResult struct {
+	Success bool
+	// Attempts made, those which found the target unreachable, and those
+	// which failed with an error which could be unrelated to the link
+	Attempts int
+	Timeouts int
+	Errors   int
+	// Target is down from every interface
+	Outage bool
+}
+
+// Results of a probe round of an interface
+type Round struct {
+	// Configured targets, including any which weren't probed
+	Targets int
+	Results []TargetResult
+	// Duration of successful probes
+	Latencies []time.Duration
+}
+
+// How rounds which can't tell whether the link is down are decided, and
+// the policy which replaces the built-in heuristic
+type Options struct {
+	// Health when no target is valid
+	NoValidTargets bool
+	// Health when no target was reachable, but not every valid target was
+	// unreachable
+	Inconclusive bool
+	// Health policy, which decides instead of the heuristic when set
+	Policy *vm.Program
+}
+
+// Decision on the health of an interface
+type Decision struct {
+	Healthy bool
+	Reason  string
+	// Reason of the built-in heuristic, which a policy may have overridden
+	HeuristicReason string
+	// Targets by verdict, valid targets are those which were reachable,
+	// unreachable or inconclusive
+	Successes   int
+	Valid       int
+	Unreachable int
+	Outages     int
+}
+
+// Options of the built-in heuristic, which only declares an interface
+// unhealthy when every valid target was unreachable
+func DefaultOptions() Options {
+	return Options{NoValidTargets: true, Inconclusive: true}
+}
+
+// Decide what the result of probing a target says about the link
+//
+//	success  outage  errors == attempts  timeouts == attempts - errors  verdict
+//	yes      any     any                 any                            reachable
+//	no       yes     any                 any                            outage
+//	no       no      yes                 any                            invalid
+//	no       no      no                  yes                            unreachable
+//	no       no      no                  no                             inconclusive
+//
+// Targets which weren't probed made no attempts, so they are invalid.
+func ClassifyTarget(result TargetResult) Verdict {
+	switch {
+	case result.Success:
+		return VerdictReachable
+	case result.Outage:
+		return VerdictOutage
+	case result.Errors == result.Attempts:
+		return VerdictInvalid
+	case result.Timeouts == result.Attempts-result.Errors:
+		return VerdictUnreachable
+	default:
+		return VerdictInconclusive
+	}
+}
+
+// Decide whether an interface is healthy from the results of a probe round
+//
+//	successes  valid  unreachable  healthy                 reason
+//	> 0        any    any          yes                     target_reachable
+//	0          0      0            options.NoValidTargets  no_valid_targets, or target_outage when
+//	                                                       a target is down from every interface
+//	0          > 0    < valid      options.Inconclusive    not_all_targets_unreachable
+//	0          > 0    = valid      no                      all_targets_unreachable
+//
+// When a policy is set it decides instead, with the decision above as
+// default_healthy. If the policy fails to evaluate, the decision above is
+// returned along with the error.
+func Evaluate(round Round, options Options) (Decision, error) {
+	decision := Decision{}
+	for _, result := range round.Results {
+		switch ClassifyTarget(result) {
+		case VerdictReachable:
+			decision.Successes += 1
+			decision.Valid += 1
+		case VerdictUnreachable:
+			decision.Unreachable += 1
+			decision.Valid += 1
+		case VerdictInconclusive:
+			decision.Valid += 1
+		case VerdictOutage:
+			decision.Outages += 1
+		}
+	}
+
+	switch {
+	case decision.Successes > 0:
+		decision.Healthy, decision.Reason = true, ReasonTargetReachable
+	case decision.Valid == 0:
+		decision.Healthy, decision.Reason = options.NoValidTargets, ReasonNoValidTargets
+		if decision.Outages > 0 {
+			decision.Reason = ReasonTargetOutage
+		}
+	case decision.Unreachable < decision.Valid:
+		decision.Healthy, decision.Reason = options.Inconclusive, ReasonNotAllUnreachable
+	default:
+		decision.Healthy, decision.Reason = false, ReasonAllUnreachable
+	}
+
+	decision.HeuristicReason = decision.Reason
+
+	if options.Policy == nil {
+		return decision, nil
+	}
+
+	healthy, err := EvaluatePolicy(
+		options.Policy,
+		PolicyEnv{
+			Targets:        round.Targets,
+			Successes:      decision.Successes,
+			Valid:          decision.Valid,
+			Unreachable:    decision.Unreachable,
+			DefaultHealthy: decision.Healthy,
+		},
+		round.Latencies,
+	)
+	if err != nil {
+		return decision, err
+	}
+	decision.Healthy, decision.Reason = healthy, ReasonHealthPolicy
+
+	return decision, nil
+}
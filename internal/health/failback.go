@@ -0,0 +1,79 @@
+package health
+
+import (
+	"time"
+)
+
+// Holds back healthy verdicts of an interface after it failed, so traffic
+// doesn't return to it until it has been healthy for a delay, or until an
+// operator approves when it is sticky. Unlike a grace period it doesn't
+// apply to interfaces which haven't failed yet.
+type Failback struct {
+	Delay time.Duration
+	// Wait for approval, as well as the delay
+	Sticky bool
+
+	// Interface failed and hasn't been failed back to since
+	failed bool
+	// When the interface became healthy after failing, zero while unhealthy
+	since    time.Time
+	approved bool
+}
+
+// Record the health found by a round, returning whether a healthy verdict
+// is held back and how much of the delay remains
+func (f *Failback) Observe(healthy bool, now time.Time) (bool, time.Duration) {
+	if !healthy {
+		if !f.failed || !f.since.IsZero() {
+			// Approval given during an outage holds until it ends, not
+			// across failures
+			f.approved = false
+		}
+		f.failed = true
+		f.since = time.Time{}
+		return false, 0
+	}
+
+	if !f.failed {
+		return false, 0
+	}
+
+	if f.since.IsZero() {
+		f.since = now
+	}
+
+	remaining := max(f.Delay-now.Sub(f.since), 0)
+	if remaining > 0 || (f.Sticky && !f.approved) {
+		return true, remaining
+	}
+
+	f.failed = false
+	f.approved = false
+	return false, 0
+}
+
+// Allow failing back once the delay has passed, an interface which isn't
+// held back doesn't need approval
+func (f *Failback) Approve() bool {
+	if !f.failed {
+		return false
+	}
+
+	f.approved = true
+	return true
+}
+
+// Whether the interface failed and traffic hasn't returned to it yet
+func (f *Failback) Pending() bool {
+	return f.failed
+}
+
+// When the interface became healthy after failing, zero while unhealthy
+func (f *Failback) HealthySince() time.Time {
+	return f.since
+}
+
+// Whether an operator approved failing back
+func (f *Failback) Approved() bool {
+	return f.approved
+}
@@ -0,0 +1,40 @@
+package health
+
+import (
+	"time"
+)
+
+// Holds back healthy verdicts of an interface for a grace period after it
+// comes up, so links which flap up briefly aren't failed back onto. An
+// interface comes up when it appears, when its link comes up or when probes
+// find it healthy after it was unhealthy, including in the first round.
+type GracePeriod struct {
+	Period time.Duration
+
+	// When the interface came up, zero while it is down
+	since time.Time
+	// Link state and health found by the previous round
+	linkUp  bool
+	healthy bool
+}
+
+// Record the link state and health found by a round, returning how much of
+// the grace period remains. Healthy verdicts are held back while it is
+// positive.
+func (g *GracePeriod) Observe(linkUp bool, healthy bool, now time.Time) time.Duration {
+	up := linkUp && healthy
+	switch {
+	case !up:
+		// Start over the next time it comes up
+		g.since = time.Time{}
+	case !g.linkUp || !g.healthy:
+		g.since = now
+	}
+	g.linkUp, g.healthy = linkUp, healthy
+
+	if g.since.IsZero() {
+		return 0
+	}
+
+	return max(g.Period-now.Sub(g.since), 0)
+}
@@ -0,0 +1,36 @@
+// Package health decides whether an interface is healthy from the outcome
+// of a probe round
+package health
+
+// Reasons for the health of an interface
+const (
+	ReasonTargetReachable   = "target_reachable"
+	ReasonNoValidTargets    = "no_valid_targets"
+	ReasonNotAllUnreachable = "not_all_targets_unreachable"
+	ReasonAllUnreachable    = "all_targets_unreachable"
+	ReasonHealthPolicy      = "health_policy"
+	ReasonNoRoute           = "no_route"
+	ReasonNoAddress         = "no_address"
+	ReasonPPPSessionDown    = "ppp_session_down"
+	ReasonDHCPLeaseLost     = "dhcp_lease_lost"
+	ReasonTargetOutage      = "target_outage"
+	ReasonWrongEgress       = "wrong_egress"
+	// Interface came up too recently to be trusted
+	ReasonGracePeriod = "grace_period"
+	// Interface recovered, but traffic doesn't return to it yet
+	ReasonFailbackDelay    = "failback_delay"
+	ReasonFailbackApproval = "failback_approval"
+	// Operator forced the health of the interface
+	ReasonForcedDown = "forced_down"
+	ReasonForcedUp   = "forced_up"
+)
+
+var (
+	// Logged when the default heuristic decides the health of an interface
+	DefaultMessages = map[string]string{
+		ReasonNoValidTargets:    "No valid targets",
+		ReasonNotAllUnreachable: "All valid targets are not unreachable",
+		ReasonAllUnreachable:    "All valid targets are unreachable",
+		ReasonTargetOutage:      "Targets are down from every interface, not the link",
+	}
+)
@@ -0,0 +1,286 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClassifyTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  TargetResult
+		verdict Verdict
+	}{
+		{"succeeded", TargetResult{Success: true, Attempts: 2, Timeouts: 1}, VerdictReachable},
+		{"succeeded during outage", TargetResult{Success: true, Attempts: 1, Outage: true}, VerdictReachable},
+		{"down everywhere", TargetResult{Attempts: 2, Timeouts: 2, Outage: true}, VerdictOutage},
+		{"not probed", TargetResult{}, VerdictInvalid},
+		{"every attempt errored", TargetResult{Attempts: 2, Errors: 2}, VerdictInvalid},
+		{"every attempt timed out", TargetResult{Attempts: 2, Timeouts: 2}, VerdictUnreachable},
+		{"errors and timeouts", TargetResult{Attempts: 3, Timeouts: 1, Errors: 2}, VerdictUnreachable},
+		{"attempts without outcome", TargetResult{Attempts: 2, Timeouts: 1}, VerdictInconclusive},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if verdict := ClassifyTarget(test.result); verdict != test.verdict {
+				t.Errorf("ClassifyTarget(%+v) = %s, want %s", test.result, verdict, test.verdict)
+			}
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	reachable := TargetResult{Success: true, Attempts: 1}
+	unreachable := TargetResult{Attempts: 2, Timeouts: 2}
+	invalid := TargetResult{Attempts: 2, Errors: 2}
+	inconclusive := TargetResult{Attempts: 2, Timeouts: 1}
+	outage := TargetResult{Attempts: 2, Timeouts: 2, Outage: true}
+	strict := Options{NoValidTargets: false, Inconclusive: false}
+
+	tests := []struct {
+		name    string
+		results []TargetResult
+		options Options
+		healthy bool
+		reason  string
+	}{
+		{"one target reachable", []TargetResult{unreachable, reachable}, DefaultOptions(), true, ReasonTargetReachable},
+		{"reachable with strict options", []TargetResult{reachable, invalid}, strict, true, ReasonTargetReachable},
+		{"no targets", nil, DefaultOptions(), true, ReasonNoValidTargets},
+		{"no targets with strict options", nil, strict, false, ReasonNoValidTargets},
+		{"every target invalid", []TargetResult{invalid, invalid}, DefaultOptions(), true, ReasonNoValidTargets},
+		{"every target invalid with strict options", []TargetResult{invalid}, strict, false, ReasonNoValidTargets},
+		{"targets down everywhere", []TargetResult{outage, invalid}, DefaultOptions(), true, ReasonTargetOutage},
+		{"targets down everywhere with strict options", []TargetResult{outage}, strict, false, ReasonTargetOutage},
+		{"inconclusive", []TargetResult{unreachable, inconclusive}, DefaultOptions(), true, ReasonNotAllUnreachable},
+		{"inconclusive with strict options", []TargetResult{inconclusive}, strict, false, ReasonNotAllUnreachable},
+		{"every valid target unreachable", []TargetResult{unreachable, invalid}, DefaultOptions(), false, ReasonAllUnreachable},
+		{"unreachable and down everywhere", []TargetResult{unreachable, outage}, DefaultOptions(), false, ReasonAllUnreachable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			decision, err := Evaluate(Round{Targets: len(test.results), Results: test.results}, test.options)
+			if err != nil {
+				t.Fatalf("Evaluate: %v", err)
+			}
+			if decision.Healthy != test.healthy || decision.Reason != test.reason {
+				t.Errorf(
+					"Evaluate = %v, %s, want %v, %s",
+					decision.Healthy,
+					decision.Reason,
+					test.healthy,
+					test.reason,
+				)
+			}
+		})
+	}
+}
+
+func TestEvaluateWithPolicy(t *testing.T) {
+	program, err := CompilePolicy("success_ratio >= 0.5 && default_healthy")
+	if err != nil {
+		t.Fatalf("CompilePolicy: %v", err)
+	}
+	options := DefaultOptions()
+	options.Policy = program
+
+	round := Round{
+		Targets: 3,
+		Results: []TargetResult{
+			{Success: true, Attempts: 1},
+			{Attempts: 1, Timeouts: 1},
+			{Attempts: 1, Timeouts: 1},
+		},
+	}
+	decision, err := Evaluate(round, options)
+	if err != nil {
+		t.Fatalf("Evaluate: %v", err)
+	}
+	if decision.Healthy || decision.Reason != ReasonHealthPolicy || decision.HeuristicReason != ReasonTargetReachable {
+		t.Errorf(
+			"Evaluate = %v, %s (heuristic %s), want false, %s (heuristic %s)",
+			decision.Healthy,
+			decision.Reason,
+			decision.HeuristicReason,
+			ReasonHealthPolicy,
+			ReasonTargetReachable,
+		)
+	}
+	if decision.Successes != 1 || decision.Valid != 3 || decision.Unreachable != 2 {
+		t.Errorf(
+			"Evaluate counted %d successes, %d valid and %d unreachable, want 1, 3 and 2",
+			decision.Successes,
+			decision.Valid,
+			decision.Unreachable,
+		)
+	}
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	tests := []struct {
+		policy    string
+		env       PolicyEnv
+		latencies []time.Duration
+		healthy   bool
+	}{
+		{"success_ratio >= 0.5", PolicyEnv{Targets: 4, Successes: 2}, nil, true},
+		{"success_ratio >= 0.5", PolicyEnv{Targets: 4, Successes: 1}, nil, false},
+		{"success_ratio >= 0.5", PolicyEnv{}, nil, false},
+		{
+			"p95_latency < duration('100ms')",
+			PolicyEnv{Targets: 2, Successes: 2},
+			[]time.Duration{10 * time.Millisecond, 50 * time.Millisecond},
+			true,
+		},
+		{
+			"max_latency < duration('100ms')",
+			PolicyEnv{Targets: 2, Successes: 2},
+			[]time.Duration{10 * time.Millisecond, 200 * time.Millisecond},
+			false,
+		},
+		{"default_healthy && valid > 0", PolicyEnv{DefaultHealthy: true, Valid: 1}, nil, true},
+	}
+
+	for _, test := range tests {
+		program, err := CompilePolicy(test.policy)
+		if err != nil {
+			t.Fatalf("CompilePolicy(%q): %v", test.policy, err)
+		}
+
+		healthy, err := EvaluatePolicy(program, test.env, test.latencies)
+		if err != nil {
+			t.Fatalf("EvaluatePolicy(%q): %v", test.policy, err)
+		}
+		if healthy != test.healthy {
+			t.Errorf("EvaluatePolicy(%q) with %+v = %v, want %v", test.policy, test.env, healthy, test.healthy)
+		}
+	}
+}
+
+func TestCompilePolicyRejectsNonBool(t *testing.T) {
+	if _, err := CompilePolicy("successes + 1"); err == nil {
+		t.Error("CompilePolicy accepted a policy which isn't a bool")
+	}
+}
+
+func TestLatencyPercentile(t *testing.T) {
+	latencies := []time.Duration{40, 10, 30, 20, 50}
+
+	tests := []struct {
+		percentile float64
+		want       time.Duration
+	}{
+		{0, 10},
+		{0.5, 30},
+		{0.95, 40},
+		{1, 50},
+	}
+	for _, test := range tests {
+		if got := LatencyPercentile(latencies, test.percentile); got != test.want {
+			t.Errorf("LatencyPercentile(%v) = %v, want %v", test.percentile, got, test.want)
+		}
+	}
+
+	if got := LatencyPercentile(nil, 0.5); got != 0 {
+		t.Errorf("LatencyPercentile of no latencies = %v, want 0", got)
+	}
+}
+
+func TestGracePeriod(t *testing.T) {
+	start := time.Unix(1000, 0)
+	grace := GracePeriod{Period: time.Minute}
+
+	// Interfaces which are up in the first round wait too
+	if remaining := grace.Observe(true, true, start); remaining != time.Minute {
+		t.Errorf("first round has %v grace period remaining, want 1m", remaining)
+	}
+
+	// Probes fail, then succeed again
+	grace.Observe(true, false, start.Add(10*time.Second))
+	if remaining := grace.Observe(true, true, start.Add(20*time.Second)); remaining != time.Minute {
+		t.Errorf("recovered interface has %v remaining, want 1m", remaining)
+	}
+	if remaining := grace.Observe(true, true, start.Add(50*time.Second)); remaining != 30*time.Second {
+		t.Errorf("recovering interface has %v remaining, want 30s", remaining)
+	}
+
+	// A flap during the grace period starts it over
+	grace.Observe(false, false, start.Add(60*time.Second))
+	grace.Observe(true, true, start.Add(70*time.Second))
+	if remaining := grace.Observe(true, true, start.Add(100*time.Second)); remaining != 30*time.Second {
+		t.Errorf("interface which flapped has %v remaining, want 30s", remaining)
+	}
+	if remaining := grace.Observe(true, true, start.Add(130*time.Second)); remaining != 0 {
+		t.Errorf("interface up for the grace period has %v remaining, want none", remaining)
+	}
+
+	// The link coming up starts it, even when probes were healthy
+	grace.Observe(false, true, start.Add(140*time.Second))
+	if remaining := grace.Observe(true, true, start.Add(150*time.Second)); remaining != time.Minute {
+		t.Errorf("interface whose link came up has %v remaining, want 1m", remaining)
+	}
+}
+
+func TestFailback(t *testing.T) {
+	start := time.Unix(1000, 0)
+	failback := Failback{Delay: time.Minute}
+
+	// Interfaces which haven't failed aren't held back
+	if held, _ := failback.Observe(true, start); held {
+		t.Error("interface which never failed is held back")
+	}
+
+	failback.Observe(false, start.Add(10*time.Second))
+	if held, remaining := failback.Observe(true, start.Add(20*time.Second)); !held || remaining != time.Minute {
+		t.Errorf("recovered interface held back %v with %v remaining, want held with 1m", held, remaining)
+	}
+
+	// Failing again starts the delay over
+	failback.Observe(false, start.Add(30*time.Second))
+	failback.Observe(true, start.Add(40*time.Second))
+	if held, remaining := failback.Observe(true, start.Add(70*time.Second)); !held || remaining != 30*time.Second {
+		t.Errorf("interface which failed again held back %v with %v remaining, want held with 30s", held, remaining)
+	}
+	if held, _ := failback.Observe(true, start.Add(100*time.Second)); held {
+		t.Error("interface healthy for the delay is still held back")
+	}
+	if failback.Pending() {
+		t.Error("interface which was failed back to is still pending")
+	}
+}
+
+func TestFailbackSticky(t *testing.T) {
+	start := time.Unix(1000, 0)
+	failback := Failback{Sticky: true}
+
+	if failback.Approve() {
+		t.Error("interface which never failed needs approval")
+	}
+
+	failback.Observe(false, start)
+	if held, _ := failback.Observe(true, start.Add(time.Hour)); !held {
+		t.Error("sticky interface failed back to without approval")
+	}
+	if !failback.Approve() {
+		t.Error("approval of a pending failback was refused")
+	}
+	if held, _ := failback.Observe(true, start.Add(2*time.Hour)); held {
+		t.Error("sticky interface held back after approval")
+	}
+
+	// Approval doesn't carry over to the next failure
+	failback.Observe(false, start.Add(3*time.Hour))
+	if held, _ := failback.Observe(true, start.Add(4*time.Hour)); !held {
+		t.Error("sticky interface failed back to with an earlier approval")
+	}
+
+	// Approval during an outage holds until it ends
+	failback.Observe(false, start.Add(5*time.Hour))
+	failback.Approve()
+	failback.Observe(false, start.Add(6*time.Hour))
+	if held, _ := failback.Observe(true, start.Add(7*time.Hour)); held {
+		t.Error("sticky interface held back after approval during the outage")
+	}
+}
@@ -0,0 +1,61 @@
+package health
+
+import (
+	"slices"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Variables available to health policy expressions, describing a probe round
+type PolicyEnv struct {
+	// Number of configured targets
+	Targets int `expr:"targets"`
+	// Targets which were probed successfully
+	Successes    int     `expr:"successes"`
+	SuccessRatio float64 `expr:"success_ratio"`
+	// Targets which didn't fail with an error, and those which timed out
+	Valid       int `expr:"valid"`
+	Unreachable int `expr:"unreachable"`
+	// Duration of successful probes
+	P50Latency time.Duration `expr:"p50_latency"`
+	P95Latency time.Duration `expr:"p95_latency"`
+	MaxLatency time.Duration `expr:"max_latency"`
+	// Decision of the built-in heuristic
+	DefaultHealthy bool `expr:"default_healthy"`
+}
+
+// Compile a health policy expression, which must evaluate to a bool
+func CompilePolicy(policy string) (*vm.Program, error) {
+	return expr.Compile(policy, expr.Env(PolicyEnv{}), expr.AsBool())
+}
+
+// Find a percentile of probe latencies, 0 when there are none
+func LatencyPercentile(latencies []time.Duration, percentile float64) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+
+	return sorted[int(float64(len(sorted)-1)*percentile)]
+}
+
+// Decide whether an interface is healthy with a health policy
+func EvaluatePolicy(program *vm.Program, env PolicyEnv, latencies []time.Duration) (bool, error) {
+	if env.Targets > 0 {
+		env.SuccessRatio = float64(env.Successes) / float64(env.Targets)
+	}
+	env.P50Latency = LatencyPercentile(latencies, 0.5)
+	env.P95Latency = LatencyPercentile(latencies, 0.95)
+	env.MaxLatency = LatencyPercentile(latencies, 1)
+
+	healthy, err := expr.Run(program, env)
+	if err != nil {
+		return false, err
+	}
+
+	return healthy.(bool), nil
+}
@@ -0,0 +1,110 @@
+// Package notify delivers events about interfaces to notification sinks
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Event types sent to notification sinks
+const (
+	EventStateChange = "state_change"
+	// A target fails from every interface while other targets work
+	EventTargetOutage    = "target_outage"
+	EventTargetRecovered = "target_recovered"
+	// Every interface went down at once, e.g. a power cut at the site
+	EventSiteOutage    = "site_outage_suspected"
+	EventSiteRecovered = "site_recovered"
+	// Host and fallback resolvers answer with different addresses
+	EventDNSDivergence = "dns_divergence"
+)
+
+var (
+	// Every event type, which notification rules apply to by default
+	EventTypes = []string{
+		EventStateChange,
+		EventTargetOutage,
+		EventTargetRecovered,
+		EventSiteOutage,
+		EventSiteRecovered,
+		EventDNSDivergence,
+	}
+)
+
+// Event sent to notification sinks. Events are compared to find them in
+// queues, so they must stay comparable.
+type Event struct {
+	Type        string `json:"type"`
+	Interface   string `json:"interface"`
+	Description string `json:"description,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Healthy     bool   `json:"healthy"`
+	// Why the interface is in this state
+	Reason   string `json:"reason,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	// Action of the operator override which forced the state, if any
+	Override string `json:"override,omitempty"`
+	// Target of target outage and DNS divergence events
+	Target string `json:"target,omitempty"`
+	// Comma separated answers of the resolvers of DNS divergence events
+	HostAddresses     string `json:"host_addresses,omitempty"`
+	FallbackResolver  string `json:"fallback_resolver,omitempty"`
+	FallbackAddresses string `json:"fallback_addresses,omitempty"`
+	Since             int64  `json:"since"`
+	Time              int64  `json:"time"`
+	// Since and time in RFC 3339, in the reporting timezone
+	SinceRFC3339 string `json:"since_rfc3339,omitempty"`
+	TimeRFC3339  string `json:"time_rfc3339,omitempty"`
+	// Public IP of the interface and its network, when known
+	PublicIP       string `json:"public_ip,omitempty"`
+	ASN            uint   `json:"asn,omitempty"`
+	ASOrganization string `json:"as_organization,omitempty"`
+	Country        string `json:"country,omitempty"`
+}
+
+// Destination of notifications
+type Sink interface {
+	Send(ctx context.Context, event Event) error
+}
+
+// Sink which posts events as JSON to an HTTP endpoint
+type WebhookSink struct {
+	url     string
+	timeout time.Duration
+}
+
+func NewWebhookSink(url string, timeout time.Duration) WebhookSink {
+	return WebhookSink{url: url, timeout: timeout}
+}
+
+func (s WebhookSink) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not encode event: %w", err)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(timeout, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", response.StatusCode)
+	}
+
+	return nil
+}
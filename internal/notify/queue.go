@@ -0,0 +1,252 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/clock"
+)
+
+const (
+	// How long to wait before retrying delivery of queued notifications
+	notificationRetryInterval = 30 * time.Second
+)
+
+// Queue of events waiting to be delivered to a sink, events are delivered
+// in order and kept until delivery succeeds or retention limits are exceeded
+type Queue struct {
+	mu          sync.Mutex
+	config      QueueConfig
+	clock       clock.Clock
+	sink        Sink
+	logger      *slog.Logger
+	events      []Event
+	pending     map[string][]*pendingEvent
+	lastHealthy map[string]bool
+	wake        chan struct{}
+}
+
+// Settings of a queue
+type QueueConfig struct {
+	// Name of the sink the queue delivers to
+	Name string
+	// Most events kept, the oldest are dropped beyond it, and the longest
+	// they are kept for. Both are unlimited when zero.
+	MaxQueued int
+	MaxAge    time.Duration
+	// How long an interface state must persist before the sink is told
+	// about it
+	MinStateDuration time.Duration
+	// Called for each event delivered
+	Delivered func()
+	// Clock timing retries, expiry and held back events, the system clock
+	// when nil
+	Clock clock.Clock
+}
+
+func NewQueue(config QueueConfig, sink Sink, logger *slog.Logger) *Queue {
+	return &Queue{
+		config:      config,
+		clock:       clock.OrReal(config.Clock),
+		sink:        sink,
+		logger:      logger,
+		pending:     map[string][]*pendingEvent{},
+		lastHealthy: map[string]bool{},
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+func (q *Queue) Name() string {
+	return q.config.Name
+}
+
+// Event held back until the interface state it describes
+// has persisted for long enough
+type pendingEvent struct {
+	event Event
+	timer clock.Timer
+}
+
+// Record the state of an interface found before any change was pushed, the
+// state the sink starts out knowing about. A flap away from it which
+// reverts before persisting is then never delivered.
+func (q *Queue) Seed(iface string, healthy bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, exists := q.lastHealthy[iface]; !exists {
+		q.lastHealthy[iface] = healthy
+	}
+}
+
+// Queue an interface state change, held back until the state has persisted
+// for the minimum state duration. Changes which revert sooner cancel the
+// event they revert, so the sink never hears about short-lived states.
+func (q *Queue) Push(event Event) {
+	if q.config.MinStateDuration == 0 {
+		q.Enqueue(event)
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cancelled := false
+	for _, pending := range q.pending[event.Interface] {
+		if pending.event.Healthy != event.Healthy {
+			// State reverted before it persisted long enough
+			pending.timer.Stop()
+			cancelled = true
+		}
+	}
+	if cancelled {
+		delete(q.pending, event.Interface)
+
+		if lastHealthy, exists := q.lastHealthy[event.Interface]; exists && lastHealthy == event.Healthy {
+			// Sink was never told about the state which was reverted
+			q.logger.Debug(
+				"Cancelled notification for reverted state",
+				"sink",
+				q.config.Name,
+				"interface",
+				event.Interface,
+			)
+			return
+		}
+	}
+
+	delay := q.config.MinStateDuration - time.Unix(event.Time, 0).Sub(time.Unix(event.Since, 0))
+	pending := &pendingEvent{event: event}
+	pending.timer = q.clock.AfterFunc(delay, func() {
+		q.mu.Lock()
+		if !slices.Contains(q.pending[event.Interface], pending) {
+			// Cancelled while the timer was firing
+			q.mu.Unlock()
+			return
+		}
+		q.pending[event.Interface] = slices.DeleteFunc(
+			q.pending[event.Interface],
+			func(p *pendingEvent) bool { return p == pending },
+		)
+		q.lastHealthy[event.Interface] = event.Healthy
+		q.mu.Unlock()
+
+		q.Enqueue(event)
+	})
+	q.pending[event.Interface] = append(q.pending[event.Interface], pending)
+}
+
+// Queue an event for delivery right away
+func (q *Queue) Enqueue(event Event) {
+	q.mu.Lock()
+	q.events = append(q.events, event)
+	if q.config.MaxQueued > 0 && len(q.events) > q.config.MaxQueued {
+		dropped := len(q.events) - q.config.MaxQueued
+		q.events = q.events[dropped:]
+		q.logger.Warn(
+			"Dropped queued notifications",
+			"sink",
+			q.config.Name,
+			"dropped",
+			dropped,
+		)
+	}
+	q.mu.Unlock()
+
+	q.Signal()
+}
+
+// Retry delivery of queued events immediately
+func (q *Queue) Signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Remove events which are older than the retention period
+func (q *Queue) expire(now time.Time) {
+	if q.config.MaxAge == 0 {
+		return
+	}
+
+	expired := 0
+	for _, event := range q.events {
+		if now.Sub(time.Unix(event.Time, 0)) <= q.config.MaxAge {
+			break
+		}
+		expired += 1
+	}
+
+	if expired > 0 {
+		q.events = q.events[expired:]
+		q.logger.Warn(
+			"Expired queued notifications",
+			"sink",
+			q.config.Name,
+			"expired",
+			expired,
+		)
+	}
+}
+
+// Deliver queued events in order, stopping at the first failure
+func (q *Queue) deliver(ctx context.Context) bool {
+	for {
+		q.mu.Lock()
+		q.expire(q.clock.Now())
+		if len(q.events) == 0 {
+			q.mu.Unlock()
+			return true
+		}
+		event := q.events[0]
+		q.mu.Unlock()
+
+		if err := q.sink.Send(ctx, event); err != nil {
+			q.logger.Warn(
+				"Error sending notification, will retry",
+				"sink",
+				q.config.Name,
+				"interface",
+				event.Interface,
+				"error",
+				err.Error(),
+			)
+			return false
+		}
+		if q.config.Delivered != nil {
+			q.config.Delivered()
+		}
+
+		q.mu.Lock()
+		if len(q.events) > 0 && q.events[0] == event {
+			q.events = q.events[1:]
+		}
+		q.mu.Unlock()
+	}
+}
+
+// Deliver queued events until a context is done
+func (q *Queue) Run(ctx context.Context) {
+	for {
+		var retry <-chan time.Time
+		var timer clock.Timer
+		if !q.deliver(ctx) {
+			timer = q.clock.NewTimer(notificationRetryInterval)
+			retry = timer.C()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/adaricorp/wan-prober/internal/clock"
+)
+
+// Sink which hands delivered events to the test
+type channelSink chan Event
+
+func (s channelSink) Send(ctx context.Context, event Event) error {
+	s <- event
+	return nil
+}
+
+// Sink which fails until it is told to accept events
+type flakySink struct {
+	channelSink
+	up atomic.Bool
+}
+
+func (s *flakySink) Send(ctx context.Context, event Event) error {
+	if !s.up.Load() {
+		return errors.New("sink is down")
+	}
+	return s.channelSink.Send(ctx, event)
+}
+
+var start = time.Unix(1000, 0)
+
+func newTestQueue(sink Sink, config QueueConfig) (*Queue, *clock.Fake) {
+	fake := clock.NewFake(start)
+	config.Name = "test"
+	config.Clock = fake
+
+	return NewQueue(config, sink, slog.New(slog.NewTextHandler(io.Discard, nil))), fake
+}
+
+func run(t *testing.T, queue *Queue) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go queue.Run(ctx)
+}
+
+// Events queued for delivery
+func queued(queue *Queue) []Event {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	return append([]Event{}, queue.events...)
+}
+
+func stateChange(healthy bool, since time.Time, now time.Time) Event {
+	return Event{
+		Type:      EventStateChange,
+		Interface: "eth0",
+		Healthy:   healthy,
+		Since:     since.Unix(),
+		Time:      now.Unix(),
+	}
+}
+
+func TestQueueDeliversWithoutMinStateDuration(t *testing.T) {
+	sink := make(channelSink, 10)
+	queue, _ := newTestQueue(sink, QueueConfig{})
+	run(t, queue)
+
+	queue.Push(stateChange(false, start, start))
+
+	select {
+	case event := <-sink:
+		if event.Healthy {
+			t.Errorf("delivered healthy event, want unhealthy")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event wasn't delivered")
+	}
+}
+
+func TestQueueHoldsBackStateUntilItPersists(t *testing.T) {
+	queue, fake := newTestQueue(make(channelSink, 10), QueueConfig{MinStateDuration: 10 * time.Second})
+
+	// State has already lasted for some of the minimum duration
+	queue.Push(stateChange(false, start.Add(-4*time.Second), start))
+
+	fake.Advance(5 * time.Second)
+	if events := queued(queue); len(events) != 0 {
+		t.Fatalf("queued %+v before the state persisted", events)
+	}
+
+	fake.Advance(time.Second)
+	if events := queued(queue); len(events) != 1 {
+		t.Fatalf("queued %d events once the state persisted, want 1", len(events))
+	}
+}
+
+func TestQueueCancelsRevertedState(t *testing.T) {
+	queue, fake := newTestQueue(make(channelSink, 10), QueueConfig{MinStateDuration: time.Minute})
+
+	// First state persists and is queued
+	queue.Push(stateChange(true, start, start))
+	fake.Advance(time.Minute)
+	if events := queued(queue); len(events) != 1 {
+		t.Fatalf("queued %d events once the state persisted, want 1", len(events))
+	}
+
+	// A flap which reverts before persisting is never queued
+	now := fake.Now()
+	queue.Push(stateChange(false, now, now))
+	fake.Advance(30 * time.Second)
+	now = fake.Now()
+	queue.Push(stateChange(true, now, now))
+	fake.Advance(time.Hour)
+
+	if events := queued(queue); len(events) != 1 {
+		t.Errorf("queued %+v, want only the first state", events)
+	}
+	if timers := fake.Timers(); timers != 0 {
+		t.Errorf("%d timers left after the flap reverted, want 0", timers)
+	}
+}
+
+// The first state of an interface is seeded rather than pushed, so a flap
+// away from it which reverts is never delivered
+func TestQueueCancelsRevertedSeededState(t *testing.T) {
+	queue, fake := newTestQueue(make(channelSink, 10), QueueConfig{MinStateDuration: time.Minute})
+	queue.Seed("eth0", true)
+
+	queue.Push(stateChange(false, start, start))
+	fake.Advance(30 * time.Second)
+	now := fake.Now()
+	queue.Push(stateChange(true, now, now))
+	fake.Advance(time.Hour)
+
+	if events := queued(queue); len(events) != 0 {
+		t.Errorf("queued %+v, want nothing for a flap which reverted", events)
+	}
+	if timers := fake.Timers(); timers != 0 {
+		t.Errorf("%d timers left after the flap reverted, want 0", timers)
+	}
+}
+
+func TestQueueExpiresOldEvents(t *testing.T) {
+	sink := &flakySink{channelSink: make(channelSink, 10)}
+	queue, fake := newTestQueue(sink, QueueConfig{MaxAge: time.Minute})
+	run(t, queue)
+
+	queue.Enqueue(stateChange(false, start, start))
+	// Delivery failed, so the queue waits to retry
+	fake.BlockUntil(1)
+
+	fake.Advance(2 * time.Minute)
+	sink.up.Store(true)
+	now := fake.Now()
+	queue.Enqueue(stateChange(true, now, now))
+
+	select {
+	case event := <-sink.channelSink:
+		if !event.Healthy {
+			t.Errorf("delivered expired event %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event wasn't delivered")
+	}
+}
+
+func TestQueueRetriesFailedDelivery(t *testing.T) {
+	sink := &flakySink{channelSink: make(channelSink, 10)}
+	queue, fake := newTestQueue(sink, QueueConfig{})
+	run(t, queue)
+
+	queue.Enqueue(stateChange(false, start, start))
+	fake.BlockUntil(1)
+
+	sink.up.Store(true)
+	fake.Advance(notificationRetryInterval)
+
+	select {
+	case <-sink.channelSink:
+	case <-time.After(time.Second):
+		t.Fatal("event wasn't delivered when retried")
+	}
+}
@@ -0,0 +1,75 @@
+// Package scheduler spaces the probe rounds of an interface
+package scheduler
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Schedules rounds every interval, measured from the start of one round to
+// the start of the next so long rounds don't stretch the cadence. Rounds
+// which overrun start the next round right away, and the slots they overran
+// are skipped rather than caught up on.
+type Rounds struct {
+	interval time.Duration
+	// Most a round is delayed from its slot, rounds are jittered from
+	// their slot so jitter doesn't accumulate
+	maxJitter time.Duration
+	// Random jitter below n, replaced by tests
+	jitter func(n int64) int64
+	// Slot the current round was scheduled for
+	slot       time.Time
+	roundStart time.Time
+}
+
+func NewRounds(interval time.Duration, maxJitter time.Duration) *Rounds {
+	return &Rounds{interval: interval, maxJitter: maxJitter, jitter: rand.Int64N}
+}
+
+func (r *Rounds) Interval() time.Duration {
+	return r.interval
+}
+
+// Record the start of a round
+func (r *Rounds) Begin(now time.Time) {
+	if r.slot.IsZero() {
+		r.slot = now
+	}
+	r.roundStart = now
+}
+
+// End of a round
+type RoundEnd struct {
+	// How long the round took
+	Took time.Duration
+	// How long to wait until the next round should start
+	Delay time.Duration
+	// Whether the round took longer than the interval, and how many
+	// slots after its own it overran
+	Overran bool
+	Skipped int64
+}
+
+// Record the end of a round, finding when the next round should start
+func (r *Rounds) End(now time.Time) RoundEnd {
+	end := RoundEnd{Took: now.Sub(r.roundStart)}
+
+	next := r.slot.Add(r.interval)
+	end.Delay = next.Sub(now)
+	// Jitter spreads probes of interfaces and sites without making rounds
+	// of short intervals overrun
+	if jitter := min(r.maxJitter, r.interval/2); jitter > 0 {
+		end.Delay += time.Duration(r.jitter(int64(jitter)))
+	}
+
+	if !now.Before(next) {
+		// Start a late round now in the latest slot which has passed
+		end.Overran = true
+		end.Skipped = int64(now.Sub(next) / r.interval)
+		next = next.Add(time.Duration(end.Skipped) * r.interval)
+		end.Delay = 0
+	}
+	r.slot = next
+
+	return end
+}
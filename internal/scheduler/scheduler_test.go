@@ -0,0 +1,76 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoundsKeepCadence(t *testing.T) {
+	start := time.Unix(1000, 0)
+	rounds := NewRounds(10*time.Second, 0)
+
+	rounds.Begin(start)
+	end := rounds.End(start.Add(3 * time.Second))
+	if end.Took != 3*time.Second || end.Delay != 7*time.Second || end.Overran {
+		t.Errorf("round of 3s ended with %+v, want to wait 7s", end)
+	}
+
+	// Starting late doesn't shift the slots of later rounds
+	rounds.Begin(start.Add(11 * time.Second))
+	end = rounds.End(start.Add(12 * time.Second))
+	if end.Delay != 8*time.Second {
+		t.Errorf("second round ended with %+v, want to wait 8s", end)
+	}
+}
+
+func TestRoundsSkipOverrunSlots(t *testing.T) {
+	start := time.Unix(1000, 0)
+	rounds := NewRounds(10*time.Second, 0)
+
+	rounds.Begin(start)
+	end := rounds.End(start.Add(25 * time.Second))
+	if !end.Overran || end.Skipped != 1 || end.Delay != 0 {
+		t.Errorf("round of 25s ended with %+v, want to skip 1 slot and start now", end)
+	}
+
+	// Next round is in the latest slot which has passed
+	rounds.Begin(start.Add(25 * time.Second))
+	end = rounds.End(start.Add(26 * time.Second))
+	if end.Overran || end.Delay != 4*time.Second {
+		t.Errorf("round after overrun ended with %+v, want to wait 4s", end)
+	}
+}
+
+func TestRoundsJitter(t *testing.T) {
+	start := time.Unix(1000, 0)
+	rounds := NewRounds(4*time.Second, 5*time.Second)
+
+	for i := range 100 {
+		slot := start.Add(time.Duration(i) * 4 * time.Second)
+		rounds.Begin(slot)
+		end := rounds.End(slot)
+		// Jitter is at most half the interval
+		if end.Delay < 4*time.Second || end.Delay >= 6*time.Second {
+			t.Fatalf("round ended with delay %v, want within [4s, 6s)", end.Delay)
+		}
+	}
+}
+
+func TestRoundsJitterFromSlot(t *testing.T) {
+	start := time.Unix(1000, 0)
+	rounds := NewRounds(10*time.Second, time.Second)
+	rounds.jitter = func(n int64) int64 { return n - 1 }
+
+	rounds.Begin(start)
+	end := rounds.End(start.Add(2 * time.Second))
+	if want := 9*time.Second - time.Nanosecond; end.Delay != want {
+		t.Errorf("round ended with delay %v, want %v", end.Delay, want)
+	}
+
+	// Next round starts late by the jitter, but its slot doesn't move
+	rounds.Begin(start.Add(end.Delay + 2*time.Second))
+	end = rounds.End(start.Add(13 * time.Second))
+	if want := 8*time.Second - time.Nanosecond; end.Delay != want {
+		t.Errorf("second round ended with delay %v, want %v", end.Delay, want)
+	}
+}
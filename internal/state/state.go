@@ -0,0 +1,111 @@
+// Package state keeps the latest status of each interface, and the history
+// of its state transitions
+package state
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/adaricorp/wan-prober/internal/api"
+)
+
+const (
+	DefaultMaxTransitions = 10
+)
+
+// Latest status of each interface which has been probed
+type Store struct {
+	// Recent state transitions kept for each interface, the default is
+	// used when zero
+	MaxTransitions int
+
+	statuses sync.Map
+	// Counts updates, so renderings of the statuses can be reused until
+	// the next one
+	generation atomic.Uint64
+}
+
+// Record the health of an interface found by a probe round, reporting
+// whether it changed from the previous round. The first round of an
+// interface sets its state rather than changing it.
+func (s *Store) Update(name string, displayName string, healthy bool, reason string, now int64) bool {
+	defer s.generation.Add(1)
+
+	status, exists := s.Load(name)
+	if !exists {
+		s.statuses.Store(
+			name,
+			api.InterfaceStatusResponse{
+				Name:        name,
+				DisplayName: displayName,
+				Healthy:     healthy,
+				Reason:      reason,
+				LastProbe:   now,
+				LastChange:  now,
+			},
+		)
+
+		return false
+	}
+
+	status.LastProbe = now
+	status.Reason = reason
+
+	changed := status.Healthy != healthy
+	if changed {
+		// Copy so statuses already handed out aren't modified
+		status.Transitions = slices.Clone(status.Transitions)
+		s.recordTransition(&status, healthy, reason, now)
+	}
+
+	s.statuses.Store(name, status)
+
+	return changed
+}
+
+// Number of updates so far, which changes whenever a status does
+func (s *Store) Generation() uint64 {
+	return s.generation.Load()
+}
+
+// Latest status of an interface
+func (s *Store) Load(name string) (api.InterfaceStatusResponse, bool) {
+	status, exists := s.statuses.Load(name)
+	if !exists {
+		return api.InterfaceStatusResponse{}, false
+	}
+
+	return status.(api.InterfaceStatusResponse), true
+}
+
+// Call a function with the latest status of each interface, until it
+// returns false
+func (s *Store) Range(f func(status api.InterfaceStatusResponse) bool) {
+	s.statuses.Range(func(key, val any) bool {
+		return f(val.(api.InterfaceStatusResponse))
+	})
+}
+
+// Record a change of health in an interface's status, keeping how long
+// the previous state lasted
+func (s *Store) recordTransition(status *api.InterfaceStatusResponse, healthy bool, reason string, now int64) {
+	status.PreviousStateDuration = now - status.LastChange
+	status.Healthy = healthy
+	status.LastChange = now
+
+	status.Transitions = append(status.Transitions, api.StateTransition{
+		Time:                  now,
+		Healthy:               healthy,
+		Reason:                reason,
+		PreviousStateDuration: status.PreviousStateDuration,
+	})
+
+	maxTransitions := s.MaxTransitions
+	if maxTransitions == 0 {
+		maxTransitions = DefaultMaxTransitions
+	}
+	if len(status.Transitions) > maxTransitions {
+		status.Transitions = status.Transitions[len(status.Transitions)-maxTransitions:]
+	}
+}
@@ -0,0 +1,70 @@
+package state
+
+import (
+	"testing"
+)
+
+func TestStoreUpdate(t *testing.T) {
+	store := &Store{}
+
+	if store.Update("eth0", "", true, "target_reachable", 100) {
+		t.Error("first round changed the state")
+	}
+
+	if store.Update("eth0", "", true, "target_reachable", 110) {
+		t.Error("round with the same health changed the state")
+	}
+	status, _ := store.Load("eth0")
+	if status.LastProbe != 110 || status.LastChange != 100 {
+		t.Errorf("last probe %d and change %d, want 110 and 100", status.LastProbe, status.LastChange)
+	}
+
+	if !store.Update("eth0", "", false, "all_targets_unreachable", 130) {
+		t.Error("round with different health didn't change the state")
+	}
+	status, _ = store.Load("eth0")
+	if status.Healthy || status.LastChange != 130 || status.PreviousStateDuration != 30 {
+		t.Errorf(
+			"healthy %v, last change %d and previous duration %d, want false, 130 and 30",
+			status.Healthy,
+			status.LastChange,
+			status.PreviousStateDuration,
+		)
+	}
+	if len(status.Transitions) != 1 || status.Transitions[0].Reason != "all_targets_unreachable" {
+		t.Errorf("transitions = %+v, want one to all_targets_unreachable", status.Transitions)
+	}
+}
+
+func TestStoreKeepsRecentTransitions(t *testing.T) {
+	store := &Store{MaxTransitions: 3}
+
+	store.Update("eth0", "", true, "", 0)
+	for i := int64(1); i <= 5; i++ {
+		store.Update("eth0", "", i%2 == 0, "", i)
+	}
+
+	status, _ := store.Load("eth0")
+	if len(status.Transitions) != 3 {
+		t.Fatalf("kept %d transitions, want 3", len(status.Transitions))
+	}
+	if first := status.Transitions[0].Time; first != 3 {
+		t.Errorf("oldest transition kept is at %d, want 3", first)
+	}
+}
+
+func TestStoreGeneration(t *testing.T) {
+	store := &Store{}
+
+	generation := store.Generation()
+	store.Update("eth0", "", true, "target_reachable", 100)
+	if store.Generation() == generation {
+		t.Error("first round didn't change the generation")
+	}
+
+	generation = store.Generation()
+	store.Update("eth0", "", true, "target_reachable", 110)
+	if store.Generation() == generation {
+		t.Error("round with the same health didn't change the generation")
+	}
+}
@@ -0,0 +1,99 @@
+// Package netbind wraps the platform specific system calls wan-prober
+// needs, like binding sockets to an interface and reading routes, behind a
+// portable API.
+//
+// Linux supports everything. Elsewhere the package builds, but calls
+// which the platform lacks fail with ErrUnsupported, so features relying on
+// them degrade instead of the build breaking.
+package netbind
+
+import (
+	"errors"
+	"runtime"
+	"syscall"
+)
+
+var (
+	ErrUnsupported = errors.New("not supported on " + runtime.GOOS)
+)
+
+// Function setting options on a socket before it is connected or bound, as
+// used by net.Dialer and net.ListenConfig
+type ControlFunc = func(network, address string, c syscall.RawConn) error
+
+// Returns a control function which binds sockets to an interface, sockets
+// are left unbound if no interface is given
+func BindToDevice(iface string) ControlFunc {
+	return func(network, address string, c syscall.RawConn) error {
+		if iface == "" {
+			return nil
+		}
+
+		return control(c, func(fd uintptr) error {
+			return bindToDevice(fd, iface)
+		})
+	}
+}
+
+// Returns a control function which sets the firewall mark of sockets, for
+// policy routing. Sockets are left unmarked if the mark is zero.
+func Mark(mark int) ControlFunc {
+	return func(network, address string, c syscall.RawConn) error {
+		if mark == 0 {
+			return nil
+		}
+
+		return control(c, func(fd uintptr) error {
+			return setMark(fd, mark)
+		})
+	}
+}
+
+// Control function which sets the don't fragment bit on IPv4 sockets and
+// stops the kernel fragmenting locally, for probing the path MTU
+func DontFragment(network, address string, c syscall.RawConn) error {
+	return control(c, setDontFragment)
+}
+
+// Returns a control function which runs each control function in turn,
+// stopping at the first error
+func Chain(fns ...ControlFunc) ControlFunc {
+	return func(network, address string, c syscall.RawConn) error {
+		for _, fn := range fns {
+			if err := fn(network, address, c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Move the calling thread into the network namespace at a path, e.g.
+// /run/netns/<name>. The caller must have locked the goroutine to its
+// thread, and sockets it opens afterwards stay in the namespace.
+func EnterNetns(path string) error {
+	return enterNetns(path)
+}
+
+// Check whether an interface has a default route in a routing table,
+// any table is checked when table is 0
+func HasDefaultRoute(ifindex int, table int) (bool, error) {
+	return hasDefaultRoute(ifindex, table)
+}
+
+// Set the administrative state of a link
+func SetLinkUp(ifindex int, up bool) error {
+	return setLinkUp(ifindex, up)
+}
+
+// Run fn on a socket's file descriptor, returning its error
+func control(c syscall.RawConn, fn func(fd uintptr) error) error {
+	var errSock error
+	err := c.Control(func(fd uintptr) {
+		errSock = fn(fd)
+	})
+	if err != nil {
+		return err
+	}
+	return errSock
+}
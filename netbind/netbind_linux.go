@@ -0,0 +1,27 @@
+package netbind
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+func bindToDevice(fd uintptr, iface string) error {
+	return unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, iface)
+}
+
+func setMark(fd uintptr, mark int) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, mark)
+}
+
+func setDontFragment(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_PROBE)
+}
+
+func enterNetns(path string) error {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	return unix.Setns(fd, unix.CLONE_NEWNET)
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package netbind
+
+func bindToDevice(fd uintptr, iface string) error {
+	return ErrUnsupported
+}
+
+func setMark(fd uintptr, mark int) error {
+	return ErrUnsupported
+}
+
+func setDontFragment(fd uintptr) error {
+	return ErrUnsupported
+}
+
+func enterNetns(path string) error {
+	return ErrUnsupported
+}
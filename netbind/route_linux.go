@@ -0,0 +1,110 @@
+package netbind
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+// Check whether an interface has a default route in a routing table,
+// any table is checked when table is 0
+func hasDefaultRoute(ifindex int, table int) (bool, error) {
+	for _, family := range []int{syscall.AF_INET, syscall.AF_INET6} {
+		rib, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, family)
+		if err != nil {
+			return false, fmt.Errorf("could not dump routes: %w", err)
+		}
+
+		messages, err := syscall.ParseNetlinkMessage(rib)
+		if err != nil {
+			return false, fmt.Errorf("could not parse routes: %w", err)
+		}
+
+		for _, message := range messages {
+			if message.Header.Type != syscall.RTM_NEWROUTE || len(message.Data) < syscall.SizeofRtMsg {
+				continue
+			}
+
+			// struct rtmsg: family, dst_len, src_len, tos, table, protocol, scope, type
+			if message.Data[1] != 0 || message.Data[7] != syscall.RTN_UNICAST {
+				continue
+			}
+			routeTable := int(message.Data[4])
+
+			attributes, err := syscall.ParseNetlinkRouteAttr(&message)
+			if err != nil {
+				continue
+			}
+
+			oif := 0
+			for _, attribute := range attributes {
+				switch attribute.Attr.Type {
+				case syscall.RTA_OIF:
+					oif = int(binary.NativeEndian.Uint32(attribute.Value))
+				case syscall.RTA_TABLE:
+					routeTable = int(binary.NativeEndian.Uint32(attribute.Value))
+				}
+			}
+
+			if oif == ifindex && (table == 0 || routeTable == table) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// Set the administrative state of a link with an RTM_NEWLINK message
+func setLinkUp(ifindex int, up bool) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	flags := uint32(0)
+	if up {
+		flags = syscall.IFF_UP
+	}
+
+	// struct nlmsghdr followed by struct ifinfomsg: family, pad, type,
+	// index, flags, change
+	message := make([]byte, syscall.NLMSG_HDRLEN+syscall.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(message[0:4], uint32(len(message)))
+	binary.NativeEndian.PutUint16(message[4:6], syscall.RTM_NEWLINK)
+	binary.NativeEndian.PutUint16(message[6:8], syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(message[8:12], 1)
+	message[16] = syscall.AF_UNSPEC
+	binary.NativeEndian.PutUint32(message[20:24], uint32(ifindex))
+	binary.NativeEndian.PutUint32(message[24:28], flags)
+	binary.NativeEndian.PutUint32(message[28:32], syscall.IFF_UP)
+
+	if err := syscall.Sendto(fd, message, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	response := make([]byte, syscall.Getpagesize())
+	n, _, err := syscall.Recvfrom(fd, response, 0)
+	if err != nil {
+		return err
+	}
+
+	replies, err := syscall.ParseNetlinkMessage(response[:n])
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if reply.Header.Type == syscall.NLMSG_ERROR && len(reply.Data) >= 4 {
+			if errno := int32(binary.NativeEndian.Uint32(reply.Data[0:4])); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package netbind
+
+func hasDefaultRoute(ifindex int, table int) (bool, error) {
+	return false, ErrUnsupported
+}
+
+func setLinkUp(ifindex int, up bool) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,87 @@
+package probe
+
+import (
+	"context"
+	"time"
+)
+
+// Time an attempt to probe a target may take, in total and in each of its
+// phases. Phases are bounded by the total too, so however long resolving
+// and connecting take, an attempt never takes longer than its total.
+type Budget struct {
+	// Whole attempt, from resolving the target to reading the response
+	Total time.Duration
+	// Resolving the target, shared by the host resolver and the fallback
+	// resolvers which are tried after it
+	DNS time.Duration
+	// Connecting to the target
+	Dial time.Duration
+}
+
+// Budget of attempts with a timeout, which allows the host resolver, the
+// fallback resolvers and the target a timeout each
+func DefaultBudget(timeout time.Duration) Budget {
+	return Budget{
+		Total: 3 * timeout,
+		DNS:   2 * timeout,
+		Dial:  timeout,
+	}
+}
+
+// Budget with the phases which aren't set taken from the default budget
+// of a timeout
+func (b Budget) WithDefaults(timeout time.Duration) Budget {
+	defaults := DefaultBudget(timeout)
+
+	if b.Total == 0 {
+		b.Total = defaults.Total
+	}
+	if b.DNS == 0 {
+		b.DNS = min(defaults.DNS, b.Total)
+	}
+	if b.Dial == 0 {
+		b.Dial = min(defaults.Dial, b.Total)
+	}
+
+	return b
+}
+
+// Budget of attempts with a configuration
+func (c Config) budget() Budget {
+	return c.Budget.WithDefaults(c.Timeout)
+}
+
+// Context of an attempt, which ends when its total budget is spent.
+// Probers start every attempt with it, so phases can't extend an attempt.
+func (b Budget) attempt(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, b.Total)
+}
+
+// Budget extended for probes which send a series of packets, so the
+// series isn't cut short
+func (b Budget) extend(d time.Duration) Budget {
+	b.Total += d
+	return b
+}
+
+// Context of a phase of an attempt, which ends when the phase's budget or
+// the attempt's is spent
+func phaseContext(ctx context.Context, phase time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, phase)
+}
+
+// Time left for a phase of an attempt, the lesser of the phase's budget
+// and what remains of the attempt's
+func phaseTimeout(ctx context.Context, phase time.Duration) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return min(phase, time.Until(deadline))
+	}
+
+	return phase
+}
+
+// Deadline of a phase of an attempt, for sockets which use deadlines
+// rather than contexts
+func phaseDeadline(ctx context.Context, phase time.Duration) time.Time {
+	return time.Now().Add(phaseTimeout(ctx, phase))
+}
@@ -1,6 +1,7 @@
 package probe
 
 import (
+	"net/netip"
 	"time"
 )
 
@@ -8,10 +9,115 @@ type Config struct {
 	BindInterface     string
 	HostResolver      string
 	FallbackResolvers []string
-	Timeout           time.Duration
-	HTTP              HTTPProbe
+	// Time exchanging packets with a target may take in an attempt
+	Timeout time.Duration
+	// Time each attempt and its phases may take, the default budget of
+	// Timeout is used for what isn't set
+	Budget Budget
+	// Addresses to dial instead of resolving the target
+	Addresses []netip.Addr
+	// Addresses of hostnames used before any resolver, keyed by lower case
+	// hostname without a trailing dot
+	StaticHosts map[string][]netip.Addr
+	// User-Agent sent by HTTP probes, a default is used when empty
+	UserAgent string
+	// Extra headers sent by HTTP probes
+	Headers map[string]string
+	// Keep connections of HTTP probes open between probes, instead of
+	// making a new connection for every probe
+	HTTPKeepAlive bool
+	// How long kept alive connections stay idle before they are closed, a
+	// default is used when zero
+	HTTPIdleTimeout time.Duration
+	// Targets kept in the internal DNS cache, a default is used when zero
+	MaxCacheEntries int
+	HTTP            HTTPProbe
+	DNS             DNSProbe
+	MTU             MTUProbe
+	TWAMP           TWAMPProbe
+	// IP protocol preferred by TCP and ICMP probes, ip4 or ip6
+	IPProtocol string
+	// EDNS options used when resolving with fallback resolvers
+	FallbackEDNS EDNS
+	// Also resolve targets with a fallback resolver while the host
+	// resolver is queried, and compare their answers
+	CompareResolvers bool
+	// Only use IPv6, for uplinks without IPv4 connectivity
+	IPv6Only bool
+	// Prefix used to synthesize IPv6 addresses for IPv4-only targets on
+	// IPv6-only uplinks, e.g. 64:ff9b::/96
+	NAT64Prefix netip.Prefix
+	// Resolvers and dialers probes use, the system network when nil
+	Network Network
 }
 
+// HTTP protocol versions which probes may use
+const (
+	// HTTP/1.1 only
+	HTTPProtocol1 = "http1"
+	// HTTP/2 when negotiated with ALPN, otherwise HTTP/1.1
+	HTTPProtocol2 = "h2"
+	// HTTP/2 only
+	HTTPProtocol2Only = "h2-only"
+	// HTTP/3 over QUIC, for https targets only
+	HTTPProtocol3 = "h3"
+)
+
+// IP protocols which TCP and ICMP probes may prefer
+const (
+	IPProtocol4 = "ip4"
+	IPProtocol6 = "ip6"
+)
+
 type HTTPProbe struct {
-	Method string
+	Method   string
+	Protocol string
+	// Maximum number of response body bytes to read for GET requests
+	MaxBodyBytes int64
+	// Hex encoded SHA-256 hash which the response body must match
+	ExpectedSHA256 string
+	// Status codes the response must have, any status when empty
+	ExpectedStatus []int
+}
+
+type DNSProbe struct {
+	// Resolver to query, the host resolver is used when empty
+	Server string
+	// Record type to query for, defaults to A
+	Type string
+	// Require the answer to be signed and validated by the resolver
+	DNSSEC bool
+	EDNS   EDNS
+}
+
+type MTUProbe struct {
+	// Smallest acceptable path MTU, the probe fails below it
+	Floor int
+	// Largest MTU to try, defaults to the MTU of the interface
+	Max int
+}
+
+type TWAMPProbe struct {
+	// Number of test packets to send, defaults to 10
+	Count int
+	// Time between test packets, defaults to 20ms
+	Interval time.Duration
+	// Largest acceptable fraction of lost packets, the probe fails above it
+	MaxLoss float64
+}
+
+// EDNS options sent with DNS queries, no OPT record is sent when all are unset
+type EDNS struct {
+	// Client subnet (ECS) to send, used by CDNs for geo steering
+	ClientSubnet netip.Prefix
+	// Set the DNSSEC OK bit
+	DO bool
+	// UDP buffer size to advertise, defaults to 4096 when EDNS is used
+	UDPSize uint16
+	// Retry over TCP when a UDP response is truncated
+	TCPFallback bool
+}
+
+func (e EDNS) enabled() bool {
+	return e.ClientSubnet.IsValid() || e.DO || e.UDPSize != 0
 }
@@ -0,0 +1,287 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+	"github.com/miekg/dns"
+)
+
+const (
+	resolvConfPath = "/etc/resolv.conf"
+)
+
+// Probe a DNS resolver by querying it for a record of the target name
+func ProbeDNS(
+	ctx context.Context,
+	target string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+) (Result, error) {
+	ctx, cancel := config.budget().attempt(ctx)
+	defer cancel()
+
+	dnsConfig := config.DNS
+	result := Result{
+		Resolver: ResolverHost,
+	}
+
+	server := dnsConfig.Server
+	if server == "" {
+		server = config.HostResolver
+	}
+	if server == "" {
+		resolvConf, err := dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			return result, fmt.Errorf("could not read resolver configuration: %w", err)
+		}
+		if len(resolvConf.Servers) == 0 {
+			return result, errors.New("no resolvers configured")
+		}
+		server = net.JoinHostPort(resolvConf.Servers[0], resolvConf.Port)
+	}
+	result.ResolverAddress = server
+
+	recordType := dns.TypeA
+	if dnsConfig.Type != "" {
+		var exists bool
+		recordType, exists = dns.StringToType[strings.ToUpper(dnsConfig.Type)]
+		if !exists {
+			return result, fmt.Errorf("unknown DNS record type: %s", dnsConfig.Type)
+		}
+	}
+
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(target), recordType)
+
+	edns := dnsConfig.EDNS
+	if dnsConfig.DNSSEC {
+		// Ask for signatures and for the resolver to validate them
+		edns.DO = true
+		query.AuthenticatedData = true
+	}
+
+	response, rtt, err := exchangeDNS(ctx, query, server, config, edns)
+	if err != nil {
+		logger.Info(
+			"Error making DNS query",
+			"interface",
+			config.BindInterface,
+			"target",
+			target,
+			"resolver",
+			server,
+			"error",
+			err.Error(),
+		)
+
+		return result, classifyError(target, PhaseResponse, err)
+	}
+	result.Timings.DNS = rtt
+
+	switch response.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError:
+		return result, newError(ClassDNSNXDomain, target, PhaseResponse, nil)
+	case dns.RcodeServerFailure:
+		if dnsConfig.DNSSEC {
+			// Validating resolvers answer SERVFAIL for bogus signatures
+			return result, newError(
+				ClassDNSSEC,
+				target,
+				PhaseResponse,
+				errors.New("resolver responded with SERVFAIL"),
+			)
+		}
+		return result, newError(ClassDNSServFail, target, PhaseResponse, nil)
+	default:
+		return result, newError(
+			ClassOther,
+			target,
+			PhaseResponse,
+			fmt.Errorf("DNS resolver responded with %s", dns.RcodeToString[response.Rcode]),
+		)
+	}
+
+	if dnsConfig.DNSSEC {
+		signed := false
+		for _, rr := range response.Answer {
+			if _, ok := rr.(*dns.RRSIG); ok {
+				signed = true
+				break
+			}
+		}
+
+		if !signed {
+			return result, newError(ClassDNSSEC, target, PhaseResponse, errors.New("answer has no signatures"))
+		}
+
+		if !response.AuthenticatedData {
+			return result, newError(ClassDNSSEC, target, PhaseResponse, errors.New("answer wasn't validated by resolver"))
+		}
+	}
+
+	logger.Debug(
+		"DNS query answered",
+		"interface",
+		config.BindInterface,
+		"target",
+		target,
+		"resolver",
+		server,
+		"answers",
+		len(response.Answer),
+		"rtt",
+		rtt.Round(time.Microsecond).String(),
+	)
+
+	return result, nil
+}
+
+// Add EDNS options to a query
+func setEDNS(query *dns.Msg, edns EDNS) {
+	if !edns.enabled() {
+		return
+	}
+
+	udpSize := edns.UDPSize
+	if udpSize == 0 {
+		udpSize = dns.DefaultMsgSize
+	}
+	query.SetEdns0(udpSize, edns.DO)
+
+	if edns.ClientSubnet.IsValid() {
+		prefix := edns.ClientSubnet.Masked()
+		subnet := &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(prefix.Bits()),
+			Address:       net.IP(prefix.Addr().AsSlice()),
+		}
+		if prefix.Addr().Is6() {
+			subnet.Family = 2
+		}
+		opt := query.IsEdns0()
+		opt.Option = append(opt.Option, subnet)
+	}
+}
+
+// Send a query over UDP from the bound interface, retrying over TCP
+// if the response is truncated and TCP fallback is enabled
+func exchangeDNS(
+	ctx context.Context,
+	query *dns.Msg,
+	server string,
+	config Config,
+	edns EDNS,
+) (*dns.Msg, time.Duration, error) {
+	setEDNS(query, edns)
+
+	budget := config.budget()
+	client := &dns.Client{
+		Net: "udp",
+		// Only a backstop, the context's deadline is earlier
+		Timeout: budget.Total,
+		Dialer: &net.Dialer{
+			Timeout: budget.Dial,
+			Control: netbind.BindToDevice(config.BindInterface),
+		},
+	}
+
+	timeout, cancel := phaseContext(ctx, config.Timeout)
+	defer cancel()
+
+	response, rtt, err := client.ExchangeContext(timeout, query, server)
+	if err != nil {
+		return nil, rtt, err
+	}
+
+	if response.Truncated && edns.TCPFallback {
+		client.Net = "tcp"
+		var tcpRTT time.Duration
+		response, tcpRTT, err = client.ExchangeContext(timeout, query, server)
+		rtt += tcpRTT
+		if err != nil {
+			return nil, rtt, fmt.Errorf("could not retry truncated query over TCP: %w", err)
+		}
+	}
+
+	return response, rtt, nil
+}
+
+// Look up the A and AAAA records of a hostname with EDNS options,
+// errors are returned as a *net.DNSError like the stdlib resolver does
+func lookupIPAddrEDNS(
+	ctx context.Context,
+	hostname string,
+	server string,
+	config Config,
+	edns EDNS,
+) ([]net.IPAddr, error) {
+	addrs := []net.IPAddr{}
+	notFound := 0
+
+	for _, recordType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		query := new(dns.Msg)
+		query.SetQuestion(dns.Fqdn(hostname), recordType)
+
+		response, _, err := exchangeDNS(ctx, query, server, config, edns)
+		if err != nil {
+			var netError net.Error
+			return nil, &net.DNSError{
+				Err:       err.Error(),
+				Name:      hostname,
+				Server:    server,
+				IsTimeout: errors.As(err, &netError) && netError.Timeout(),
+			}
+		}
+
+		switch response.Rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeNameError:
+			notFound += 1
+			continue
+		case dns.RcodeServerFailure:
+			return nil, &net.DNSError{
+				Err:         dnsServerMisbehaving,
+				Name:        hostname,
+				Server:      server,
+				IsTemporary: true,
+			}
+		default:
+			return nil, &net.DNSError{
+				Err:    "DNS resolver responded with " + dns.RcodeToString[response.Rcode],
+				Name:   hostname,
+				Server: server,
+			}
+		}
+
+		for _, rr := range response.Answer {
+			switch record := rr.(type) {
+			case *dns.A:
+				addrs = append(addrs, net.IPAddr{IP: record.A})
+			case *dns.AAAA:
+				addrs = append(addrs, net.IPAddr{IP: record.AAAA})
+			}
+		}
+	}
+
+	if notFound == 2 {
+		return nil, &net.DNSError{
+			Err:        "no such host",
+			Name:       hostname,
+			Server:     server,
+			IsNotFound: true,
+		}
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,136 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Class of a probe failure, which decides how it counts towards the
+// health of an interface
+type Class string
+
+const (
+	// Target didn't respond in time
+	ClassTimeout Class = "timeout"
+	// Target name doesn't exist
+	ClassDNSNXDomain Class = "dns_nxdomain"
+	// A resolver answered with SERVFAIL
+	ClassDNSServFail Class = "dns_servfail"
+	// No resolver answered at all
+	ClassDNSUnreachable Class = "dns_unreachable"
+	// Kernel reports the network is down or unreachable
+	ClassNetDown Class = "net_down"
+	// TLS handshake with the target failed
+	ClassTLS Class = "tls"
+	// Target answered with an unexpected HTTP status
+	ClassHTTPStatus Class = "http_status"
+	// Response body was modified or truncated on the way
+	ClassBodyMismatch Class = "body_mismatch"
+	// DNS answer was stripped of signatures or failed validation
+	ClassDNSSEC Class = "dnssec"
+	// Starlink dish reports an outage
+	ClassStarlinkOutage Class = "starlink_outage"
+	// Target is reachable, but packet loss is above the threshold
+	ClassPacketLoss Class = "packet_loss"
+	// Target is reachable, but not with full sized packets
+	ClassMTUBelowFloor Class = "mtu_below_floor"
+	// Anything else, which may be unrelated to the network connection
+	ClassOther Class = "other"
+)
+
+// Phase of an attempt a failure happened in
+type Phase string
+
+const (
+	PhaseResolve  Phase = "resolve"
+	PhaseConnect  Phase = "connect"
+	PhaseTLS      Phase = "tls"
+	PhaseRequest  Phase = "request"
+	PhaseResponse Phase = "response"
+)
+
+var (
+	classMessages = map[Class]string{
+		ClassTimeout:        "timeout waiting for probe target to respond",
+		ClassDNSNXDomain:    "DNS resolver responded with NXDOMAIN",
+		ClassDNSServFail:    "DNS resolver responded with SERVFAIL",
+		ClassDNSUnreachable: "all DNS resolvers are unreachable",
+		ClassNetDown:        "network is down or unreachable",
+		ClassTLS:            "TLS handshake failed",
+		ClassHTTPStatus:     "unexpected HTTP status",
+		ClassBodyMismatch:   "response body doesn't match expected hash",
+		ClassDNSSEC:         "DNSSEC validation failed",
+		ClassStarlinkOutage: "Starlink dish reports an outage",
+		ClassPacketLoss:     "packet loss is above threshold",
+		ClassMTUBelowFloor:  "path MTU is below floor",
+	}
+)
+
+// Error returned by probers, classifying why a probe failed
+type Error struct {
+	Class  Class
+	Target string
+	Phase  Phase
+	// Underlying error, if any
+	Cause error
+}
+
+func (e *Error) Error() string {
+	message, exists := classMessages[e.Class]
+	if !exists {
+		if e.Cause != nil {
+			return e.Cause.Error()
+		}
+		message = string(e.Class)
+	}
+
+	if e.Cause != nil {
+		return message + ": " + e.Cause.Error()
+	}
+	return message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+func newError(class Class, target string, phase Phase, cause error) *Error {
+	return &Error{Class: class, Target: target, Phase: phase, Cause: cause}
+}
+
+// Classify an error which happened in a phase of probing a target, errors
+// which are already classified are returned as they are
+func classifyError(target string, phase Phase, err error) error {
+	var probeError *Error
+	if errors.As(err, &probeError) {
+		return err
+	}
+
+	return newError(ErrorClass(err), target, phase, err)
+}
+
+// Class of an error returned by a prober. Errors which weren't classified
+// by the prober are classified by their cause, or as ClassOther.
+func ErrorClass(err error) Class {
+	var probeError *Error
+	if errors.As(err, &probeError) {
+		return probeError.Class
+	}
+
+	var netError net.Error
+	var alertError tls.AlertError
+	var recordError tls.RecordHeaderError
+	switch {
+	case errors.Is(err, syscall.ENETDOWN) || errors.Is(err, syscall.ENETUNREACH):
+		return ClassNetDown
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netError) && netError.Timeout()):
+		return ClassTimeout
+	case errors.As(err, &alertError) || errors.As(err, &recordError):
+		return ClassTLS
+	default:
+		return ClassOther
+	}
+}
@@ -2,21 +2,30 @@ package probe
 
 import (
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"math/rand/v2"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"net/netip"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
-	"syscall"
+	"time"
 
 	"github.com/prometheus/common/version"
 )
 
+const (
+	defaultMaxBodyBytes = 1024 * 1024
+)
+
 var (
 	userAgent = fmt.Sprintf("Adari WAN prober/%s", version.Version)
 )
@@ -27,8 +36,12 @@ func ProbeHTTP(
 	config Config,
 	dnsCache *sync.Map,
 	logger *slog.Logger,
-) error {
+) (Result, error) {
+	ctx, cancel := config.budget().attempt(ctx)
+	defer cancel()
+
 	httpConfig := config.HTTP
+	result := Result{}
 
 	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
 		target = "http://" + target
@@ -36,221 +49,92 @@ func ProbeHTTP(
 
 	targetURL, err := url.Parse(target)
 	if err != nil {
-		return fmt.Errorf("could not parse target URL: %w", err)
+		return result, fmt.Errorf("could not parse target URL: %w", err)
 	}
 
-	if targetURL.Hostname()[len(targetURL.Hostname())-1] != '.' {
-		// Make hostname fully qualified to prevent lookups with search domain
-		if targetURL.Port() != "" {
-			targetURL.Host = targetURL.Hostname() + ".:" + targetURL.Port()
-		} else {
-			targetURL.Host = targetURL.Hostname() + "."
-		}
-	}
+	var addrs []net.IPAddr
+	workingHostResolver := true
 
-	bindToDevice := func(network, address string, c syscall.RawConn) error {
-		if config.BindInterface == "" {
-			return nil
+	if len(config.Addresses) > 0 {
+		// Target has pinned addresses which are used instead of resolving it
+		for _, addr := range config.Addresses {
+			addrs = append(addrs, net.IPAddr{IP: net.IP(addr.AsSlice()), Zone: addr.Zone()})
 		}
-
-		var errSock error
-		err := c.Control((func(fd uintptr) {
-			errSock = syscall.SetsockoptString(
-				int(fd),
-				syscall.SOL_SOCKET,
-				syscall.SO_BINDTODEVICE,
-				config.BindInterface,
-			)
-		}))
-		if err != nil {
-			return err
+		result.Resolver = ResolverPinned
+	} else if addr, err := netip.ParseAddr(targetURL.Hostname()); err == nil {
+		// IP literal targets don't need resolving
+		addrs = []net.IPAddr{{IP: net.IP(addr.AsSlice()), Zone: addr.Zone()}}
+		result.Resolver = ResolverNone
+	} else if static, exists := staticHostAddrs(config, targetURL.Hostname()); exists {
+		// Hostname has static addresses which are used before any resolver
+		for _, addr := range static {
+			addrs = append(addrs, net.IPAddr{IP: net.IP(addr.AsSlice()), Zone: addr.Zone()})
 		}
-		return errSock
-	}
-
-	resolverDialer := net.Dialer{
-		Control: bindToDevice,
-	}
+		result.Resolver = ResolverStatic
+	} else {
+		dnsStart := time.Now()
 
-	hostResolver := net.DefaultResolver
-	if config.HostResolver != "" {
-		hostResolver = &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				return resolverDialer.DialContext(ctx, "udp", config.HostResolver)
-			},
+		if targetURL.Hostname()[len(targetURL.Hostname())-1] != '.' {
+			// Make hostname fully qualified to prevent lookups with search domain
+			if targetURL.Port() != "" {
+				targetURL.Host = targetURL.Hostname() + ".:" + targetURL.Port()
+			} else {
+				targetURL.Host = targetURL.Hostname() + "."
+			}
 		}
-	}
 
-	fallbackResolverMap := map[string]*net.Resolver{}
-	for _, resolver := range config.FallbackResolvers {
-		fallbackResolverMap[resolver] = &net.Resolver{
-			PreferGo: true,
-			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-				return resolverDialer.DialContext(ctx, "udp", resolver)
-			},
+		addrs, workingHostResolver, err = resolveTarget(
+			ctx,
+			target,
+			targetURL.Hostname(),
+			config,
+			dnsCache,
+			logger,
+			&result,
+		)
+		if err != nil {
+			return result, err
 		}
-	}
-
-	timeout, cancel := context.WithTimeout(ctx, config.Timeout)
-	defer cancel()
 
-	workingHostResolver := false
+		result.Timings.DNS = time.Since(dnsStart)
 
-	addrs, err := hostResolver.LookupIPAddr(timeout, targetURL.Hostname())
-	if err != nil {
-		var dnsError *net.DNSError
-		if errors.As(err, &dnsError) && !dnsError.IsTimeout && dnsError.IsNotFound {
-			// Host resolver returned NXDOMAIN, don't need to keep trying
-			return ErrDNSNXDomain
+		if len(addrs) == 0 {
+			return result, errors.New("No addresses found for hostname")
 		}
-		logger.Warn(
-			"Unable to resolve target with host DNS resolver",
-			"interface",
-			config.BindInterface,
-			"target",
-			target,
-			"error",
-			err.Error(),
-		)
 
-		servFails := 0
-		fallbackSuccess := false
-		for _, i := range rand.Perm(len(config.FallbackResolvers)) {
-			cache, exists := dnsCache.Load(target)
-			if exists {
-				logger.Info(
-					"Cache hit for target in internal DNS cache",
-					"interface",
-					config.BindInterface,
-					"target",
-					target,
-				)
-
-				switch v := cache.(type) {
-				case []net.IPAddr:
-					addrs = v
-				}
-
-				fallbackSuccess = true
-				break
-			} else {
-				logger.Warn(
-					"Cache miss for target in internal DNS cache",
-					"interface",
-					config.BindInterface,
-					"target",
-					target,
-				)
-			}
+		StoreCacheEntry(dnsCache, target, CacheEntry{Addrs: addrs, Time: time.Now()}, config.MaxCacheEntries)
+	}
 
-			var err error
-
-			fallbackResolver := fallbackResolverMap[config.FallbackResolvers[i]]
-
-			timeout, cancel := context.WithTimeout(ctx, config.Timeout)
-			defer cancel()
-
-			addrs, err = fallbackResolver.LookupIPAddr(timeout, targetURL.Hostname())
-			if err != nil {
-				var dnsError *net.DNSError
-				if errors.As(err, &dnsError) && !dnsError.IsTimeout {
-					if dnsError.IsNotFound {
-						// Fallback resolver returned NXDOMAIN, don't need to keep trying
-						return ErrDNSNXDomain
-					}
-
-					if dnsError.IsTemporary && dnsError.Err == ErrDNSServerMisbehaving.Error() {
-						// Fallback resolver returned a SERVFAIL
-						servFails += 1
-					}
-				}
-				logger.Error(
-					"Error resolving target with fallback DNS resolver",
-					"interface",
-					config.BindInterface,
-					"resolver",
-					config.FallbackResolvers[i],
-					"target",
-					target,
-					"error",
-					err.Error(),
-				)
-			} else {
-				logger.Error(
-					"Resolved target with fallback DNS resolver",
-					"interface",
-					config.BindInterface,
-					"resolver",
-					config.FallbackResolvers[i],
-					"target",
-					target,
-				)
-
-				fallbackSuccess = true
-				break
+	if config.IPv6Only {
+		// Probes must not fall back to IPv4, so always dial an address
+		// which is reachable over IPv6
+		netipAddrs := []netip.Addr{}
+		for _, addr := range addrs {
+			if ip, ok := netip.AddrFromSlice(addr.IP); ok {
+				netipAddrs = append(netipAddrs, ip.Unmap().WithZone(addr.Zone))
 			}
 		}
-
-		if !fallbackSuccess {
-			if servFails >= 1 {
-				// We didn't get a successful response,
-				// but did receive an error response
-				// which probably means the network has connectivity
-				return ErrDNSFallbackServFail
-			}
-			return ErrDNSResolutionImpossible
+		netipAddrs, err = reachableAddrs(target, netipAddrs, config)
+		if err != nil {
+			return result, err
 		}
-	} else {
-		workingHostResolver = true
-	}
 
-	if len(addrs) == 0 {
-		return errors.New("No addresses found for hostname")
+		addrs = []net.IPAddr{}
+		for _, addr := range netipAddrs {
+			addrs = append(addrs, net.IPAddr{IP: net.IP(addr.AsSlice()), Zone: addr.Zone()})
+		}
 	}
 
-	dnsCache.Store(target, addrs)
-
-	httpDialer := net.Dialer{
-		Timeout:   config.Timeout,
-		DualStack: true,
-		Control:   bindToDevice,
+	plan := &dialPlan{
+		target:   target,
+		addrs:    addrs,
+		override: len(config.Addresses) > 0 || config.IPv6Only,
+		degraded: !workingHostResolver,
+		logger:   logger,
 	}
-
-	transport := &http.Transport{
-		DisableKeepAlives: true,
-		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
-		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if !workingHostResolver {
-				// When host resolver isn't working, we enter a degraded mode
-				// where we dial a random IPv4 address from our internal DNS cache
-				// or from fallback DNS resolver
-				_, port, err := net.SplitHostPort(addr)
-				if err != nil {
-					logger.Error("Failed to split address", "addr", addr)
-				} else {
-					// Find a random ipv4 address from address list and use it
-					for _, i := range rand.Perm(len(addrs)) {
-						ip := addrs[i].IP
-						if ip.To4() != nil {
-							addr = net.JoinHostPort(ip.String(), port)
-							logger.Info(
-								"Overriding IP address for probe",
-								"interface",
-								config.BindInterface,
-								"target",
-								target,
-								"addr",
-								addr,
-							)
-							break
-						}
-					}
-				}
-			}
-
-			return httpDialer.DialContext(ctx, network, addr)
-		},
+	transport, err := sharedTransport(config, plan)
+	if err != nil {
+		return result, err
 	}
 
 	client := &http.Client{
@@ -261,23 +145,75 @@ func ProbeHTTP(
 		},
 	}
 
-	if config.Timeout > 0 {
-		client.Timeout = config.Timeout
-	}
-
 	if httpConfig.Method == "" {
 		httpConfig.Method = "GET"
 	}
 
 	request, err := http.NewRequest(httpConfig.Method, targetURL.String(), nil)
 	if err != nil {
-		return fmt.Errorf("error creating request: %w", err)
+		return result, fmt.Errorf("error creating request: %w", err)
+	}
+	// Trace callbacks run on the transport's goroutines, which can outlive
+	// the request when it times out mid-handshake
+	tracker := &requestTracker{phase: PhaseConnect}
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			tracker.setPhase(PhaseRequest)
+			if info.Reused {
+				interfaceConnStats(config.BindInterface).Reused.Add(1)
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+			tracker.connectStart = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+			tracker.timings.Connect = time.Since(tracker.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+			tracker.phase = PhaseTLS
+			tracker.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+			tracker.timings.TLS = time.Since(tracker.tlsStart)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+			tracker.phase = PhaseResponse
+			tracker.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+			tracker.timings.TTFB = time.Since(tracker.wroteRequest)
+		},
 	}
-	request = request.WithContext(ctx)
+	// The request's deadline covers connecting, the request and reading
+	// the body. The client has no timeout of its own, which would count
+	// the same time twice.
+	ctx, cancelRequest := phaseContext(ctx, config.Timeout)
+	defer cancelRequest()
+	ctx = context.WithValue(ctx, dialPlanKey{}, plan)
+	request = request.WithContext(httptrace.WithClientTrace(ctx, trace))
 
 	request.Header.Set("User-Agent", userAgent)
+	if config.UserAgent != "" {
+		request.Header.Set("User-Agent", config.UserAgent)
+	}
+	for key, value := range config.Headers {
+		request.Header.Set(key, value)
+	}
 
-	_, err = client.Do(request)
+	response, err := client.Do(request)
+	phase := tracker.snapshot(&result.Timings)
 	if err != nil {
 		logger.Info(
 			"Error making HTTP request",
@@ -289,12 +225,102 @@ func ProbeHTTP(
 			err.Error(),
 		)
 
-		if errors.Is(err, context.DeadlineExceeded) {
-			return ErrProbeTimeout
+		// Don't reuse connections kept alive over a path which may have
+		// stopped working
+		transport.CloseIdleConnections()
+
+		probeError := classifyError(target, phase, err).(*Error)
+		if phase == PhaseTLS && probeError.Class == ClassOther {
+			// Handshakes which fail without an alert, e.g. when the
+			// connection is reset by middleboxes
+			probeError.Class = ClassTLS
+		}
+
+		return result, probeError
+	}
+	defer response.Body.Close()
+
+	result.Protocol = response.Proto
+
+	if len(httpConfig.ExpectedStatus) > 0 && !slices.Contains(httpConfig.ExpectedStatus, response.StatusCode) {
+		return result, newError(
+			ClassHTTPStatus,
+			target,
+			PhaseResponse,
+			fmt.Errorf("target responded with status %d", response.StatusCode),
+		)
+	}
+
+	if response.TLS != nil && len(response.TLS.PeerCertificates) > 0 {
+		result.CertificateNotAfter = response.TLS.PeerCertificates[0].NotAfter
+	}
+
+	if serverTime, err := http.ParseTime(response.Header.Get("Date")); err == nil {
+		// Date header has a resolution of a second and is truncated
+		skew := serverTime.Add(500 * time.Millisecond).Sub(time.Now())
+		result.ClockSkew = &skew
+	}
+
+	if httpConfig.Method == "GET" {
+		maxBodyBytes := httpConfig.MaxBodyBytes
+		if maxBodyBytes == 0 {
+			maxBodyBytes = defaultMaxBodyBytes
+		}
+
+		buf := bodyBuffers.Get().(*[]byte)
+		defer bodyBuffers.Put(buf)
+
+		hash := sha256.New()
+		result.BodyBytes, err = io.CopyBuffer(hash, io.LimitReader(response.Body, maxBodyBytes), *buf)
+		if err != nil {
+			return result, classifyError(target, PhaseResponse, fmt.Errorf("error reading response body: %w", err))
 		}
+		result.BodySHA256 = hex.EncodeToString(hash.Sum(nil))
 
-		return err
+		if httpConfig.ExpectedSHA256 != "" &&
+			!strings.EqualFold(httpConfig.ExpectedSHA256, result.BodySHA256) {
+			logger.Warn(
+				"Response body doesn't match expected hash",
+				"interface",
+				config.BindInterface,
+				"target",
+				target,
+				"bytes",
+				result.BodyBytes,
+				"sha256",
+				result.BodySHA256,
+			)
+
+			return result, newError(ClassBodyMismatch, target, PhaseResponse, nil)
+		}
 	}
 
-	return nil
+	return result, nil
+}
+
+// Phase and timings of an HTTP request, recorded by trace callbacks
+type requestTracker struct {
+	mu           sync.Mutex
+	phase        Phase
+	timings      Timings
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+}
+
+func (t *requestTracker) setPhase(phase Phase) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.phase = phase
+}
+
+// Copies the connection timings into timings and returns the current phase
+func (t *requestTracker) snapshot(timings *Timings) Phase {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timings.Connect = t.timings.Connect
+	timings.TLS = t.timings.TLS
+	timings.TTFB = t.timings.TTFB
+
+	return t.phase
 }
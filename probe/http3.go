@@ -0,0 +1,152 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// HTTP/3 transport of probes through an interface. QUIC connections are
+// made over UDP sockets bound to the interface, one per connection, and
+// the handshake is traced as both the connect and the TLS phase.
+func newHTTP3Transport(config Config, idleTimeout time.Duration) *http3.Transport {
+	stats := interfaceConnStats(config.BindInterface)
+
+	return &http3.Transport{
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		QUICConfig: &quic.Config{
+			HandshakeIdleTimeout: config.budget().Dial,
+			MaxIdleTimeout:       idleTimeout,
+		},
+		Dial: func(ctx context.Context, addr string, tlsConfig *tls.Config, quicConfig *quic.Config) (*quic.Conn, error) {
+			if plan, ok := ctx.Value(dialPlanKey{}).(*dialPlan); ok {
+				addr = plan.dialAddress(config.BindInterface, addr)
+			}
+
+			stats.Dials.Add(1)
+			conn, err := dialQUIC(ctx, config.BindInterface, addr, tlsConfig, quicConfig)
+			if err != nil {
+				stats.DialErrors.Add(1)
+				return nil, err
+			}
+			stats.Open.Add(1)
+			go func() {
+				<-conn.Context().Done()
+				stats.Open.Add(-1)
+			}()
+
+			return conn, nil
+		},
+	}
+}
+
+// Make a QUIC connection to addr from a UDP socket bound to an interface,
+// which is closed with the connection
+func dialQUIC(
+	ctx context.Context,
+	bindInterface string,
+	addr string,
+	tlsConfig *tls.Config,
+	quicConfig *quic.Config,
+) (*quic.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	portNumber, err := net.LookupPort("udp", port)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("No addresses found for hostname")
+	}
+	remote := net.UDPAddrFromAddrPort(netip.AddrPortFrom(ips[0].Unmap(), uint16(portNumber)))
+
+	listenConfig := net.ListenConfig{
+		Control: netbind.BindToDevice(bindInterface),
+	}
+	packetConn, err := listenConfig.ListenPacket(ctx, "udp", ":0")
+	if err != nil {
+		return nil, err
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.ConnectStart != nil {
+		trace.ConnectStart("udp", remote.String())
+	}
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+
+	conn, err := quic.Dial(ctx, packetConn, remote, tlsConfig, quicConfig)
+
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		state := tls.ConnectionState{}
+		if conn != nil {
+			state = conn.ConnectionState().TLS
+		}
+		trace.TLSHandshakeDone(state, err)
+	}
+	if trace != nil && trace.ConnectDone != nil {
+		trace.ConnectDone("udp", remote.String(), err)
+	}
+
+	if err != nil {
+		packetConn.Close()
+		return nil, err
+	}
+	go func() {
+		<-conn.Context().Done()
+		packetConn.Close()
+	}()
+
+	return conn, nil
+}
+
+// HTTP/3 transport used for a single request, whose connection is closed
+// with the response body, as http.Transport does with DisableKeepAlives
+type singleUseTransport struct {
+	*http3.Transport
+}
+
+func (t singleUseTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	response, err := t.Transport.RoundTrip(request)
+	if err != nil {
+		t.Transport.Close()
+		return nil, err
+	}
+	response.Body = &closingBody{ReadCloser: response.Body, closer: t.Transport}
+
+	return response, nil
+}
+
+// Response body which closes its transport when it is closed
+type closingBody struct {
+	io.ReadCloser
+	closer io.Closer
+	once   sync.Once
+}
+
+func (b *closingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		b.closer.Close()
+	})
+
+	return err
+}
@@ -0,0 +1,215 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Serves a response body about the size of a captive portal's page
+func benchmarkServer(b *testing.B) *httptest.Server {
+	body := strings.Repeat("x", 16*1024)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	b.Cleanup(server.Close)
+
+	return server
+}
+
+func benchmarkProbeHTTP(b *testing.B, keepAlive bool) {
+	server := benchmarkServer(b)
+	config := Config{
+		Timeout:       5 * time.Second,
+		HTTPKeepAlive: keepAlive,
+		HTTP:          HTTPProbe{Method: "GET"},
+	}
+	dnsCache := sync.Map{}
+	logger := slog.New(slog.DiscardHandler)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := ProbeHTTP(context.Background(), server.URL, config, &dnsCache, logger); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProbeHTTP(b *testing.B) {
+	benchmarkProbeHTTP(b, false)
+}
+
+func BenchmarkProbeHTTPKeepAlive(b *testing.B) {
+	benchmarkProbeHTTP(b, true)
+}
+
+// A target which accepts connections but never answers the TLS handshake
+// times out in the TLS phase. Run with -race, the handshake's trace
+// callbacks race with the probe returning.
+func TestProbeHTTPStalledTLSHandshake(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+
+	config := Config{
+		Timeout: 100 * time.Millisecond,
+		HTTP:    HTTPProbe{Method: "GET"},
+	}
+	dnsCache := sync.Map{}
+	logger := slog.New(slog.DiscardHandler)
+
+	for range 5 {
+		_, err := ProbeHTTP(
+			context.Background(),
+			"https://"+listener.Addr().String(),
+			config,
+			&dnsCache,
+			logger,
+		)
+
+		var probeError *Error
+		if !errors.As(err, &probeError) {
+			t.Fatalf("expected probe error, got %v", err)
+		}
+		if probeError.Phase != PhaseTLS {
+			t.Errorf("expected phase %q, got %q", PhaseTLS, probeError.Phase)
+		}
+		if probeError.Class != ClassTimeout {
+			t.Errorf("expected class %q, got %q", ClassTimeout, probeError.Class)
+		}
+	}
+}
+
+// Probes with pinned addresses don't reuse a connection kept alive by an
+// earlier probe of the same target, which may go to another address
+func TestProbeHTTPPinnedSkipsPooledConnection(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+
+	config := Config{
+		Timeout:       time.Second,
+		HTTPKeepAlive: true,
+		HTTP:          HTTPProbe{Method: "GET"},
+	}
+	dnsCache := sync.Map{}
+	logger := slog.New(slog.DiscardHandler)
+
+	if _, err := ProbeHTTP(context.Background(), server.URL, config, &dnsCache, logger); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on the pinned address
+	config.Addresses = []netip.Addr{netip.MustParseAddr("127.0.0.2")}
+	if _, err := ProbeHTTP(context.Background(), server.URL, config, &dnsCache, logger); err == nil {
+		t.Error("probe with a pinned address succeeded over the pooled connection")
+	}
+}
+
+// Serves HTTP/3 on a UDP port of 127.0.0.1, returning its https URL
+func http3Server(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	// Borrow the self-signed certificate of an HTTPS test server
+	tlsServer := httptest.NewTLSServer(handler)
+	t.Cleanup(tlsServer.Close)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: tlsServer.TLS.Certificates}),
+	}
+	go server.Serve(conn)
+	t.Cleanup(func() {
+		server.Close()
+		conn.Close()
+	})
+
+	return "https://" + conn.LocalAddr().String()
+}
+
+func TestProbeHTTP3(t *testing.T) {
+	target := http3Server(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+
+	for _, keepAlive := range []bool{false, true} {
+		config := Config{
+			Timeout:       time.Second,
+			HTTPKeepAlive: keepAlive,
+			HTTP:          HTTPProbe{Method: "GET", Protocol: HTTPProtocol3},
+		}
+		dnsCache := sync.Map{}
+		logger := slog.New(slog.DiscardHandler)
+
+		for range 2 {
+			result, err := ProbeHTTP(context.Background(), target, config, &dnsCache, logger)
+			if err != nil {
+				t.Fatalf("keep-alive %t: %v", keepAlive, err)
+			}
+			if result.Protocol != "HTTP/3.0" || result.BodyBytes != 2 {
+				t.Errorf(
+					"keep-alive %t: protocol %s and %d body bytes, want HTTP/3.0 and 2",
+					keepAlive,
+					result.Protocol,
+					result.BodyBytes,
+				)
+			}
+		}
+	}
+}
+
+// A target which doesn't answer QUIC handshakes fails in the TLS phase
+func TestProbeHTTP3Unanswered(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	config := Config{
+		Timeout: 100 * time.Millisecond,
+		HTTP:    HTTPProbe{Method: "GET", Protocol: HTTPProtocol3},
+	}
+	dnsCache := sync.Map{}
+	logger := slog.New(slog.DiscardHandler)
+
+	_, err = ProbeHTTP(context.Background(), "https://"+conn.LocalAddr().String(), config, &dnsCache, logger)
+
+	var probeError *Error
+	if !errors.As(err, &probeError) {
+		t.Fatalf("expected probe error, got %v", err)
+	}
+	if probeError.Phase != PhaseTLS {
+		t.Errorf("expected phase %q, got %q", PhaseTLS, probeError.Phase)
+	}
+}
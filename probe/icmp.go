@@ -0,0 +1,104 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Send an ICMP echo request to a target and wait for its reply
+func ProbeICMP(
+	ctx context.Context,
+	target string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+) (Result, error) {
+	ctx, cancel := config.budget().attempt(ctx)
+	defer cancel()
+
+	result := Result{}
+
+	addrs, err := targetAddrs(ctx, target, target, config, dnsCache, logger, &result)
+	if err != nil {
+		return result, err
+	}
+	addrs = preferIPProtocol(addrs, config.IPProtocol)
+	if len(addrs) == 0 {
+		return result, errors.New("No addresses found for hostname")
+	}
+	addr := addrs[rand.IntN(len(addrs))].Unmap()
+
+	network, listenAddress, protocol := "ip4:icmp", "0.0.0.0", 1
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if addr.Is6() {
+		network, listenAddress, protocol = "ip6:ipv6-icmp", "::", 58
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	listenConfig := net.ListenConfig{
+		Control: netbind.BindToDevice(config.BindInterface),
+	}
+	packetConn, err := listenConfig.ListenPacket(ctx, network, listenAddress)
+	if err != nil {
+		return result, err
+	}
+	defer packetConn.Close()
+
+	id := os.Getpid() & 0xffff
+	seq := rand.IntN(0xffff)
+	request := icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{
+			ID:   id,
+			Seq:  seq,
+			Data: []byte(target),
+		},
+	}
+	packet, err := request.Marshal(nil)
+	if err != nil {
+		return result, err
+	}
+
+	start := time.Now()
+	deadline := phaseDeadline(ctx, config.Timeout)
+	packetConn.SetDeadline(deadline)
+
+	destination := &net.IPAddr{IP: net.IP(addr.AsSlice()), Zone: addr.Zone()}
+	if _, err := packetConn.WriteTo(packet, destination); err != nil {
+		return result, err
+	}
+
+	bufPtr := packetBuffers.Get().(*[]byte)
+	defer packetBuffers.Put(bufPtr)
+	buf := *bufPtr
+	for {
+		n, from, err := packetConn.ReadFrom(buf)
+		if err != nil {
+			return result, classifyError(target, PhaseResponse, err)
+		}
+
+		if fromAddr, ok := from.(*net.IPAddr); !ok || !fromAddr.IP.Equal(destination.IP) {
+			continue
+		}
+
+		reply, err := icmp.ParseMessage(protocol, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if body, ok := reply.Body.(*icmp.Echo); ok && body.ID == id && body.Seq == seq {
+			result.Timings.TTFB = time.Since(start)
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package probe
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// Large enough for any reply probes wait for on Ethernet
+	packetBufferSize = 1500
+	// Used to read HTTP response bodies, smaller than io.Copy's default
+	// so probes of many interfaces don't hold much memory
+	bodyBufferSize = 8 * 1024
+
+	DefaultMaxCacheEntries = 256
+)
+
+var (
+	// Buffers reused across probes, to keep the allocation rate down on
+	// devices where the garbage collector is expensive
+	packetBuffers = sync.Pool{
+		New: func() any {
+			buf := make([]byte, packetBufferSize)
+			return &buf
+		},
+	}
+	bodyBuffers = sync.Pool{
+		New: func() any {
+			buf := make([]byte, bodyBufferSize)
+			return &buf
+		},
+	}
+
+	// Serializes evictions, so concurrent probes don't both evict
+	cacheMu = sync.Mutex{}
+)
+
+// Store an entry in the internal DNS cache, evicting the oldest entries
+// when it holds more than maxEntries targets. Targets expanded from macros
+// change with the interface's addresses, so the cache would otherwise grow
+// for as long as the process runs.
+func StoreCacheEntry(dnsCache *sync.Map, target string, entry CacheEntry, maxEntries int) {
+	if maxEntries == 0 {
+		maxEntries = DefaultMaxCacheEntries
+	}
+
+	if _, exists := dnsCache.Swap(target, entry); exists {
+		return
+	}
+
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+
+	for {
+		entries := 0
+		oldestTarget := ""
+		oldestTime := time.Time{}
+		dnsCache.Range(func(key, val any) bool {
+			entries += 1
+			cached, ok := val.(CacheEntry)
+			if ok && key != target && (oldestTarget == "" || cached.Time.Before(oldestTime)) {
+				oldestTarget = key.(string)
+				oldestTime = cached.Time
+			}
+			return true
+		})
+
+		if entries <= maxEntries || oldestTarget == "" {
+			return
+		}
+		dnsCache.Delete(oldestTarget)
+	}
+}
@@ -0,0 +1,36 @@
+package probe
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStoreCacheEntryEvictsOldest(t *testing.T) {
+	dnsCache := sync.Map{}
+	now := time.Now()
+
+	for i := range 5 {
+		entry := CacheEntry{Time: now.Add(time.Duration(i) * time.Second)}
+		StoreCacheEntry(&dnsCache, fmt.Sprintf("target-%d", i), entry, 3)
+	}
+
+	for i := range 5 {
+		_, exists := dnsCache.Load(fmt.Sprintf("target-%d", i))
+		if want := i >= 2; exists != want {
+			t.Errorf("target-%d cached = %v, want %v", i, exists, want)
+		}
+	}
+
+	// Refreshing a cached target doesn't evict anything
+	StoreCacheEntry(&dnsCache, "target-2", CacheEntry{Time: now.Add(time.Minute)}, 3)
+	entries := 0
+	dnsCache.Range(func(key, val any) bool {
+		entries += 1
+		return true
+	})
+	if entries != 3 {
+		t.Errorf("cache has %d entries, want 3", entries)
+	}
+}
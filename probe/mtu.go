@@ -0,0 +1,209 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	// Smallest MTU every IPv4 path must support
+	minPathMTU = 576
+	// Size of IPv4 and ICMP echo headers
+	icmpEchoOverhead = 28
+	// Longest time to wait for each echo reply during discovery
+	maxMTUStepTimeout = time.Second
+	// Reachability check and the steps of a binary search up to a 64KiB MTU
+	maxMTUSteps = 17
+)
+
+// Discover the path MTU towards an IPv4 target with ICMP echo requests which
+// have the don't fragment bit set, the probe fails if the target can't be
+// reached or the path MTU is below the configured floor
+func ProbeMTU(
+	ctx context.Context,
+	target string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+) (Result, error) {
+	// Every step of the search may wait for its reply
+	stepTimeout := min(config.Timeout, maxMTUStepTimeout)
+	ctx, cancel := config.budget().extend(maxMTUSteps * stepTimeout).attempt(ctx)
+	defer cancel()
+
+	mtuConfig := config.MTU
+	result := Result{}
+
+	addrs, err := targetAddrs(ctx, target, target, config, dnsCache, logger, &result)
+	if err != nil {
+		return result, err
+	}
+
+	var addr netip.Addr
+	switch result.Resolver {
+	case ResolverPinned, ResolverNone:
+		addr = addrs[rand.IntN(len(addrs))]
+	default:
+		for _, a := range addrs {
+			if a.Unmap().Is4() {
+				addr = a
+				break
+			}
+		}
+		if !addr.IsValid() {
+			return result, errors.New("No IPv4 addresses found for hostname")
+		}
+	}
+	addr = addr.Unmap()
+	if !addr.Is4() {
+		return result, errors.New("path MTU probes only support IPv4 targets")
+	}
+
+	maxMTU := mtuConfig.Max
+	if maxMTU == 0 {
+		link, err := net.InterfaceByName(config.BindInterface)
+		if err != nil {
+			return result, fmt.Errorf("could not find interface MTU: %w", err)
+		}
+		maxMTU = link.MTU
+	}
+
+	listenConfig := net.ListenConfig{
+		Control: netbind.Chain(
+			netbind.BindToDevice(config.BindInterface),
+			// Set the don't fragment bit and don't fragment locally
+			netbind.DontFragment,
+		),
+	}
+
+	packetConn, err := listenConfig.ListenPacket(ctx, "ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return result, fmt.Errorf("could not open ICMP socket: %w", err)
+	}
+	defer packetConn.Close()
+
+	id := os.Getpid() & 0xffff
+	seq := 0
+
+	// Send an echo request of a total packet size and wait for its reply
+	echo := func(size int) (bool, error) {
+		seq += 1
+		request := icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{
+				ID:   id,
+				Seq:  seq,
+				Data: make([]byte, size-icmpEchoOverhead),
+			},
+		}
+		packet, err := request.Marshal(nil)
+		if err != nil {
+			return false, err
+		}
+
+		deadline := phaseDeadline(ctx, stepTimeout)
+		packetConn.SetDeadline(deadline)
+
+		if _, err := packetConn.WriteTo(packet, &net.IPAddr{IP: net.IP(addr.AsSlice())}); err != nil {
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return false, nil
+			}
+			return false, err
+		}
+
+		buf := make([]byte, maxMTU+icmpEchoOverhead)
+		for time.Now().Before(deadline) {
+			n, from, err := packetConn.ReadFrom(buf)
+			if err != nil {
+				var netError net.Error
+				if errors.As(err, &netError) && netError.Timeout() {
+					return false, nil
+				}
+				return false, err
+			}
+
+			if from.String() != addr.String() {
+				continue
+			}
+
+			reply, err := icmp.ParseMessage(1, buf[:n])
+			if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+				continue
+			}
+			if body, ok := reply.Body.(*icmp.Echo); ok && body.ID == id && body.Seq == seq {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+
+	start := time.Now()
+
+	// Target must answer small packets before the path MTU can be found
+	reachable, err := echo(minPathMTU)
+	if err != nil {
+		return result, err
+	}
+	if !reachable {
+		logger.Info(
+			"No echo reply from target",
+			"interface",
+			config.BindInterface,
+			"target",
+			target,
+		)
+		return result, newError(ClassTimeout, target, PhaseResponse, nil)
+	}
+	result.Timings.TTFB = time.Since(start)
+
+	// Binary search for the largest packet which gets through
+	low, high := minPathMTU, maxMTU
+	for low < high {
+		size := (low + high + 1) / 2
+		ok, err := echo(size)
+		if err != nil {
+			return result, err
+		}
+		if ok {
+			low = size
+		} else {
+			high = size - 1
+		}
+	}
+	result.PathMTU = low
+
+	logger.Debug(
+		"Discovered path MTU",
+		"interface",
+		config.BindInterface,
+		"target",
+		target,
+		"mtu",
+		result.PathMTU,
+	)
+
+	if mtuConfig.Floor > 0 && result.PathMTU < mtuConfig.Floor {
+		return result, newError(
+			ClassMTUBelowFloor,
+			target,
+			PhaseResponse,
+			fmt.Errorf("%d < %d", result.PathMTU, mtuConfig.Floor),
+		)
+	}
+
+	return result, nil
+}
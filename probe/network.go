@@ -0,0 +1,57 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+// Resolves hostnames to addresses, implemented by *net.Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Dials connections, implemented by *net.Dialer
+type Dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// Source of the resolvers and dialers probes use, tests replace the system
+// network through Config.Network to control what resolvers answer and
+// which addresses are dialed
+type Network interface {
+	// Resolver querying a DNS server through an interface, or the resolver
+	// of the host when address is empty
+	Resolver(bindInterface string, address string) Resolver
+	// Dialer binding its sockets to an interface
+	Dialer(bindInterface string, timeout time.Duration) Dialer
+}
+
+// Network of the host, which resolves and dials with the net package
+var SystemNetwork Network = systemNetwork{}
+
+type systemNetwork struct{}
+
+func (systemNetwork) Resolver(bindInterface string, address string) Resolver {
+	if address == "" {
+		return net.DefaultResolver
+	}
+	return interfaceResolver(bindInterface, address)
+}
+
+func (systemNetwork) Dialer(bindInterface string, timeout time.Duration) Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: netbind.BindToDevice(bindInterface),
+	}
+}
+
+// Network of a configuration, the system network when it isn't set
+func (c Config) network() Network {
+	if c.Network == nil {
+		return SystemNetwork
+	}
+	return c.Network
+}
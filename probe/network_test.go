@@ -0,0 +1,81 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// Resolver answering lookups with a function
+type resolverFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (f resolverFunc) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	return f(ctx, host)
+}
+
+// Resolver which answers with addresses
+func answering(ips ...string) Resolver {
+	return resolverFunc(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		addrs := []net.IPAddr{}
+		for _, ip := range ips {
+			addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+		}
+		return addrs, nil
+	})
+}
+
+// Resolver which fails with a DNS error
+func failing(dnsError net.DNSError) Resolver {
+	return resolverFunc(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		dnsError.Name = host
+		return nil, &dnsError
+	})
+}
+
+// Resolver which never answers, failing once the lookup's context is done
+var dead = resolverFunc(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+	<-ctx.Done()
+	return nil, &net.DNSError{Err: "i/o timeout", Name: host, IsTimeout: true}
+})
+
+var (
+	nxdomain = failing(net.DNSError{Err: "no such host", IsNotFound: true})
+	servfail = failing(net.DNSError{Err: dnsServerMisbehaving, IsTemporary: true})
+)
+
+// Network with scripted resolvers, which records the addresses it dials
+type fakeNetwork struct {
+	// Resolvers by address, the host resolver by ""
+	resolvers map[string]Resolver
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (n *fakeNetwork) Resolver(bindInterface string, address string) Resolver {
+	if resolver, exists := n.resolvers[address]; exists {
+		return resolver
+	}
+	return dead
+}
+
+func (n *fakeNetwork) Dialer(bindInterface string, timeout time.Duration) Dialer {
+	return n
+}
+
+func (n *fakeNetwork) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	n.mu.Lock()
+	n.dialed = append(n.dialed, address)
+	n.mu.Unlock()
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+func (n *fakeNetwork) addresses() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]string{}, n.dialed...)
+}
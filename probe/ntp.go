@@ -0,0 +1,111 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	ntpDefaultPort = "123"
+	ntpPacketSize  = 48
+
+	// Seconds between the NTP and unix epochs
+	ntpEpochOffset = 2208988800
+)
+
+// Encode a time as an NTP timestamp
+func ntpTimestamp(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// Decode an NTP timestamp
+func ntpTime(ts uint64) time.Time {
+	seconds := int64(ts>>32) - ntpEpochOffset
+	nanoseconds := int64((ts & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
+
+// Query an NTP server with SNTP and measure the offset of the local clock
+func ProbeNTP(
+	ctx context.Context,
+	target string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+) (Result, error) {
+	ctx, cancel := config.budget().attempt(ctx)
+	defer cancel()
+
+	result := Result{
+		Resolver: ResolverHost,
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, ntpDefaultPort)
+	}
+
+	dialer := config.network().Dialer(config.BindInterface, config.budget().Dial)
+
+	conn, err := dialer.DialContext(ctx, "udp", target)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(phaseDeadline(ctx, config.Timeout))
+
+	request := make([]byte, ntpPacketSize)
+	// Leap indicator 0, version 4, client mode
+	request[0] = 4<<3 | 3
+	originTime := time.Now()
+	binary.BigEndian.PutUint64(request[40:48], ntpTimestamp(originTime))
+
+	if _, err := conn.Write(request); err != nil {
+		return result, err
+	}
+
+	response := make([]byte, ntpPacketSize)
+	for {
+		n, err := conn.Read(response)
+		if err != nil {
+			logger.Info(
+				"Error querying NTP server",
+				"interface",
+				config.BindInterface,
+				"target",
+				target,
+				"error",
+				err.Error(),
+			)
+
+			return result, classifyError(target, PhaseResponse, err)
+		}
+
+		// Ignore anything which isn't a server response to our request
+		if n == ntpPacketSize && response[0]&0x7 == 4 &&
+			binary.BigEndian.Uint64(response[24:32]) == ntpTimestamp(originTime) {
+			break
+		}
+	}
+	destinationTime := time.Now()
+
+	if stratum := response[1]; stratum == 0 {
+		return result, fmt.Errorf("NTP server sent kiss code %s", string(response[12:16]))
+	}
+
+	receiveTime := ntpTime(binary.BigEndian.Uint64(response[32:40]))
+	transmitTime := ntpTime(binary.BigEndian.Uint64(response[40:48]))
+
+	// Offset of the server clock from ours, assuming symmetric delays
+	skew := (receiveTime.Sub(originTime) + transmitTime.Sub(destinationTime)) / 2
+	result.ClockSkew = &skew
+	result.Timings.TTFB = destinationTime.Sub(originTime) - transmitTime.Sub(receiveTime)
+
+	return result, nil
+}
@@ -2,18 +2,81 @@ package probe
 
 import (
 	"context"
-	"errors"
 	"log/slog"
+	"net"
+	"net/netip"
 	"sync"
+	"time"
 )
 
-type ProbeFn func(ctx context.Context, target string, config Config, dnsCache *sync.Map, logger *slog.Logger) error
+type ProbeFn func(ctx context.Context, target string, config Config, dnsCache *sync.Map, logger *slog.Logger) (Result, error)
 
-var (
-	ErrProbeTimeout = errors.New("timeout waiting for probe target to respond")
+// Resolver paths used to find the addresses of a probe target
+const (
+	ResolverHost     = "host"
+	ResolverFallback = "fallback"
+	ResolverCache    = "cache"
+	// Target is an IP literal which doesn't need resolving
+	ResolverNone = "none"
+	// Target addresses are pinned in the configuration
+	ResolverPinned = "pinned"
+	// Target hostname has static addresses in the configuration
+	ResolverStatic = "static"
+)
+
+// Entry of the internal DNS cache, keyed by target
+type CacheEntry struct {
+	Addrs []net.IPAddr
+	// When the addresses were resolved
+	Time time.Time
+}
+
+type Result struct {
+	// Resolver path used to find target addresses
+	Resolver string
+	// Address of the resolver that answered, if any
+	ResolverAddress string
+	// Time spent in each phase of the probe
+	Timings Timings
+	// Protocol version of the response, e.g. HTTP/2.0
+	Protocol string
+	// Number of response body bytes read and their SHA-256 hash
+	BodyBytes  int64
+	BodySHA256 string
+	// Dish telemetry of Starlink probes
+	Starlink *StarlinkStatus
+	// Path MTU discovered by MTU probes
+	PathMTU int
+	// Delay, jitter and loss measured by TWAMP probes
+	TWAMP *TWAMPStats
+	// Offset of the target's clock from the local clock, as seen in
+	// HTTP Date headers or NTP responses
+	ClockSkew *time.Duration
+	// Expiry of the leaf certificate presented by HTTPS targets
+	CertificateNotAfter time.Time
+	// Answers of the host and a fallback resolver, when compared
+	ResolverComparison *ResolverComparison
+}
+
+type ResolverComparison struct {
+	// Fallback resolver compared with the host resolver
+	Resolver      string
+	HostAddrs     []netip.Addr
+	FallbackAddrs []netip.Addr
+	// Answers have no address in common, a sign of DNS manipulation or
+	// split horizon DNS
+	Diverged bool
+}
+
+type Timings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// Time from the request being sent to the first response byte
+	TTFB time.Duration
+}
 
-	ErrDNSNXDomain             = errors.New("DNS resolver responded with NXDOMAIN")
-	ErrDNSFallbackServFail     = errors.New("fallback DNS resolver responded with SERVFAIL")
-	ErrDNSResolutionImpossible = errors.New("all DNS resolvers are unreachable")
-	ErrDNSServerMisbehaving    = errors.New("server misbehaving")
+const (
+	// Error the Go resolver reports for SERVFAIL answers
+	dnsServerMisbehaving = "server misbehaving"
 )
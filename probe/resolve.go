@@ -0,0 +1,417 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resolve the addresses of a target hostname with the host resolver, falling
+// back to the internal DNS cache and fallback resolvers, also returns whether
+// the host resolver is working
+func resolveTarget(
+	ctx context.Context,
+	target string,
+	hostname string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+	result *Result,
+) ([]net.IPAddr, bool, error) {
+	if config.IPv6Only {
+		// IPv4 resolvers can't be reached without IPv4
+		config.FallbackResolvers = ipv6Resolvers(config.FallbackResolvers)
+	}
+
+	hostResolver := config.network().Resolver(config.BindInterface, config.HostResolver)
+
+	budget := config.budget()
+	resolveCtx, cancel := phaseContext(ctx, budget.DNS)
+	defer cancel()
+
+	// Leave fallback resolvers half of the budget when the host resolver
+	// doesn't answer
+	hostTimeout := budget.DNS
+	if len(config.FallbackResolvers) > 0 {
+		hostTimeout = budget.DNS / 2
+	}
+	timeout, cancelHost := phaseContext(resolveCtx, hostTimeout)
+	defer cancelHost()
+
+	var comparison chan fallbackAnswer
+	if config.CompareResolvers && len(config.FallbackResolvers) > 0 {
+		resolver := config.FallbackResolvers[rand.IntN(len(config.FallbackResolvers))]
+		comparison = make(chan fallbackAnswer, 1)
+		go func() {
+			comparison <- lookupFallback(timeout, hostname, resolver, config)
+		}()
+	}
+
+	workingHostResolver := false
+
+	addrs, err := hostResolver.LookupIPAddr(timeout, hostname)
+	if err != nil {
+		var dnsError *net.DNSError
+		if errors.As(err, &dnsError) && !dnsError.IsTimeout && dnsError.IsNotFound {
+			// Host resolver returned NXDOMAIN, don't need to keep trying
+			return nil, false, newError(ClassDNSNXDomain, target, PhaseResolve, err)
+		}
+		logger.Warn(
+			"Unable to resolve target with host DNS resolver",
+			"interface",
+			config.BindInterface,
+			"target",
+			target,
+			"error",
+			err.Error(),
+		)
+
+		fallbackSuccess := false
+		if len(config.FallbackResolvers) > 0 {
+			cache, exists := dnsCache.Load(target)
+			if exists {
+				entry, _ := cache.(CacheEntry)
+				logger.Info(
+					"Cache hit for target in internal DNS cache",
+					"interface",
+					config.BindInterface,
+					"target",
+					target,
+					"age",
+					time.Since(entry.Time).Round(time.Second),
+				)
+
+				addrs = entry.Addrs
+
+				result.Resolver = ResolverCache
+				result.ResolverAddress = ""
+
+				fallbackSuccess = true
+			} else {
+				logger.Info(
+					"Cache miss for target in internal DNS cache",
+					"interface",
+					config.BindInterface,
+					"target",
+					target,
+				)
+			}
+		}
+
+		if !fallbackSuccess {
+			answer, err := raceFallbackResolvers(resolveCtx, target, hostname, config, logger)
+			if err != nil {
+				return nil, false, err
+			}
+
+			logger.Info(
+				"Resolved target with fallback DNS resolver",
+				"interface",
+				config.BindInterface,
+				"resolver",
+				answer.resolver,
+				"target",
+				target,
+			)
+
+			addrs = answer.addrs
+
+			result.Resolver = ResolverFallback
+			result.ResolverAddress = answer.resolver
+		}
+	} else {
+		workingHostResolver = true
+
+		result.Resolver = ResolverHost
+		result.ResolverAddress = config.HostResolver
+
+		if comparison != nil {
+			answer := <-comparison
+			if answer.err != nil {
+				logger.Warn(
+					"Unable to resolve target with fallback DNS resolver for comparison",
+					"interface",
+					config.BindInterface,
+					"resolver",
+					answer.resolver,
+					"target",
+					target,
+					"error",
+					answer.err.Error(),
+				)
+			} else {
+				result.ResolverComparison = compareAnswers(answer.resolver, addrs, answer.addrs)
+			}
+		}
+	}
+
+	return addrs, workingHostResolver, nil
+}
+
+// Query every fallback resolver at once and use the first answer, so
+// resolution takes one timeout however many resolvers are dead. An
+// NXDOMAIN answer ends the race like a successful one.
+func raceFallbackResolvers(
+	ctx context.Context,
+	target string,
+	hostname string,
+	config Config,
+	logger *slog.Logger,
+) (fallbackAnswer, error) {
+	timeout, cancel := context.WithCancel(ctx)
+	// Stops the resolvers which lost the race
+	defer cancel()
+
+	answers := make(chan fallbackAnswer, len(config.FallbackResolvers))
+	for _, resolver := range config.FallbackResolvers {
+		go func() {
+			answers <- lookupFallback(timeout, hostname, resolver, config)
+		}()
+	}
+
+	servFails := 0
+	for range config.FallbackResolvers {
+		answer := <-answers
+		if answer.err == nil {
+			return answer, nil
+		}
+
+		var dnsError *net.DNSError
+		if errors.As(answer.err, &dnsError) && !dnsError.IsTimeout {
+			if dnsError.IsNotFound {
+				// Fallback resolver returned NXDOMAIN, don't need to wait for others
+				return answer, newError(ClassDNSNXDomain, target, PhaseResolve, answer.err)
+			}
+
+			if dnsError.IsTemporary && dnsError.Err == dnsServerMisbehaving {
+				// Fallback resolver returned a SERVFAIL
+				servFails += 1
+			}
+		}
+		logger.Warn(
+			"Error resolving target with fallback DNS resolver",
+			"interface",
+			config.BindInterface,
+			"resolver",
+			answer.resolver,
+			"target",
+			target,
+			"error",
+			answer.err.Error(),
+		)
+	}
+
+	if servFails >= 1 {
+		// We didn't get a successful response,
+		// but did receive an error response
+		// which probably means the network has connectivity
+		return fallbackAnswer{}, newError(
+			ClassDNSServFail,
+			target,
+			PhaseResolve,
+			errors.New("fallback DNS resolver responded with SERVFAIL"),
+		)
+	}
+
+	return fallbackAnswer{}, newError(ClassDNSUnreachable, target, PhaseResolve, nil)
+}
+
+// Answer of a fallback resolver
+type fallbackAnswer struct {
+	resolver string
+	addrs    []net.IPAddr
+	err      error
+}
+
+// Resolve a hostname with a fallback resolver, with EDNS options when
+// they are configured
+func lookupFallback(
+	ctx context.Context,
+	hostname string,
+	resolver string,
+	config Config,
+) fallbackAnswer {
+	var addrs []net.IPAddr
+	var err error
+	if config.FallbackEDNS.enabled() {
+		addrs, err = lookupIPAddrEDNS(ctx, hostname, resolver, config, config.FallbackEDNS)
+	} else {
+		addrs, err = config.network().Resolver(config.BindInterface, resolver).LookupIPAddr(ctx, hostname)
+	}
+
+	return fallbackAnswer{resolver: resolver, addrs: addrs, err: err}
+}
+
+// Compare the answers of the host and a fallback resolver. CDNs answer
+// with different addresses depending on the resolver, so answers only
+// diverge when they have no address in common.
+func compareAnswers(resolver string, hostAddrs []net.IPAddr, fallbackAddrs []net.IPAddr) *ResolverComparison {
+	comparison := &ResolverComparison{
+		Resolver:      resolver,
+		HostAddrs:     ipAddrsToAddrs(hostAddrs),
+		FallbackAddrs: ipAddrsToAddrs(fallbackAddrs),
+		Diverged:      true,
+	}
+
+	for _, addr := range comparison.HostAddrs {
+		if slices.Contains(comparison.FallbackAddrs, addr) {
+			comparison.Diverged = false
+			break
+		}
+	}
+	if len(comparison.HostAddrs) == 0 && len(comparison.FallbackAddrs) == 0 {
+		comparison.Diverged = false
+	}
+
+	return comparison
+}
+
+// Convert resolver answers to addresses without zones
+func ipAddrsToAddrs(ipAddrs []net.IPAddr) []netip.Addr {
+	addrs := []netip.Addr{}
+	for _, a := range ipAddrs {
+		if ip, ok := netip.AddrFromSlice(a.IP); ok {
+			addrs = append(addrs, ip.Unmap())
+		}
+	}
+	slices.SortFunc(addrs, func(a, b netip.Addr) int { return a.Compare(b) })
+
+	return slices.Compact(addrs)
+}
+
+// Find the addresses to probe a target host at, pinned addresses and IP
+// literals are used as they are while hostnames are resolved
+func targetAddrs(
+	ctx context.Context,
+	target string,
+	host string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+	result *Result,
+) ([]netip.Addr, error) {
+	if len(config.Addresses) > 0 {
+		result.Resolver = ResolverPinned
+		return reachableAddrs(target, config.Addresses, config)
+	}
+
+	if literal, err := netip.ParseAddr(host); err == nil {
+		result.Resolver = ResolverNone
+		return reachableAddrs(target, []netip.Addr{literal}, config)
+	}
+
+	if static, exists := staticHostAddrs(config, host); exists {
+		result.Resolver = ResolverStatic
+		return reachableAddrs(target, static, config)
+	}
+
+	dnsStart := time.Now()
+
+	// Make hostname fully qualified to prevent lookups with search domain
+	hostname := host
+	if !strings.HasSuffix(hostname, ".") {
+		hostname += "."
+	}
+
+	ipAddrs, _, err := resolveTarget(ctx, target, hostname, config, dnsCache, logger, result)
+	if err != nil {
+		return nil, err
+	}
+	result.Timings.DNS = time.Since(dnsStart)
+
+	addrs := []netip.Addr{}
+	for _, a := range ipAddrs {
+		if ip, ok := netip.AddrFromSlice(a.IP); ok {
+			addrs = append(addrs, ip.Unmap().WithZone(a.Zone))
+		}
+	}
+
+	return reachableAddrs(target, addrs, config)
+}
+
+// Static addresses of a hostname from the configuration
+func staticHostAddrs(config Config, hostname string) ([]netip.Addr, bool) {
+	addrs, exists := config.StaticHosts[strings.ToLower(strings.TrimSuffix(hostname, "."))]
+	return addrs, exists && len(addrs) > 0
+}
+
+// Keep the addresses of a target which can be reached from the uplink, on
+// IPv6-only uplinks these are its IPv6 addresses, or addresses synthesized
+// with the NAT64 prefix when it only has IPv4 addresses
+func reachableAddrs(target string, addrs []netip.Addr, config Config) ([]netip.Addr, error) {
+	if !config.IPv6Only {
+		return addrs, nil
+	}
+
+	ipv6Addrs := []netip.Addr{}
+	for _, addr := range addrs {
+		if !addr.Unmap().Is4() {
+			ipv6Addrs = append(ipv6Addrs, addr)
+		}
+	}
+	if len(ipv6Addrs) > 0 {
+		return ipv6Addrs, nil
+	}
+
+	if !config.NAT64Prefix.IsValid() {
+		return nil, newError(
+			ClassOther,
+			target,
+			PhaseResolve,
+			errors.New("target has no IPv6 address and no NAT64 prefix is set"),
+		)
+	}
+	for _, addr := range addrs {
+		ipv6Addrs = append(ipv6Addrs, synthesizeNAT64(config.NAT64Prefix, addr.Unmap()))
+	}
+
+	return ipv6Addrs, nil
+}
+
+// Embed an IPv4 address in a /96 NAT64 prefix as described in RFC 6052
+func synthesizeNAT64(prefix netip.Prefix, addr netip.Addr) netip.Addr {
+	bytes := prefix.Masked().Addr().As16()
+	ipv4 := addr.As4()
+	copy(bytes[12:], ipv4[:])
+
+	return netip.AddrFrom16(bytes)
+}
+
+// Keep the resolvers with IPv6 addresses
+func ipv6Resolvers(resolvers []string) []string {
+	ipv6 := []string{}
+	for _, resolver := range resolvers {
+		if addrPort, err := netip.ParseAddrPort(resolver); err == nil && !addrPort.Addr().Unmap().Is4() {
+			ipv6 = append(ipv6, resolver)
+		}
+	}
+
+	return ipv6
+}
+
+// Keep the addresses of a preferred IP protocol, ip4 or ip6, all addresses
+// are kept when the protocol is empty or the target has no addresses of it
+func preferIPProtocol(addrs []netip.Addr, ipProtocol string) []netip.Addr {
+	preferred := []netip.Addr{}
+	for _, addr := range addrs {
+		switch {
+		case ipProtocol == IPProtocol4 && !addr.Unmap().Is4():
+		case ipProtocol == IPProtocol6 && addr.Unmap().Is4():
+		default:
+			preferred = append(preferred, addr)
+		}
+	}
+
+	if len(preferred) == 0 {
+		return addrs
+	}
+
+	return preferred
+}
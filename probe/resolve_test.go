@@ -0,0 +1,221 @@
+package probe
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func resolveConfig(network *fakeNetwork, fallbacks ...string) Config {
+	return Config{
+		BindInterface:     "eth0",
+		FallbackResolvers: fallbacks,
+		Timeout:           100 * time.Millisecond,
+		Network:           network,
+	}
+}
+
+func TestResolveTarget(t *testing.T) {
+	cached := CacheEntry{Addrs: []net.IPAddr{{IP: net.ParseIP("192.0.2.9")}}, Time: time.Now()}
+
+	tests := []struct {
+		name      string
+		resolvers map[string]Resolver
+		fallbacks []string
+		cache     *CacheEntry
+		// Expected outcome
+		addrs           []string
+		resolver        string
+		resolverAddress string
+		working         bool
+		class           Class
+	}{
+		{
+			name:      "host resolver answers",
+			resolvers: map[string]Resolver{"": answering("192.0.2.1")},
+			fallbacks: []string{"fallback:53"},
+			addrs:     []string{"192.0.2.1"},
+			resolver:  ResolverHost,
+			working:   true,
+		},
+		{
+			name: "host resolver NXDOMAIN isn't retried with fallbacks",
+			resolvers: map[string]Resolver{
+				"":            nxdomain,
+				"fallback:53": answering("192.0.2.2"),
+			},
+			fallbacks: []string{"fallback:53"},
+			class:     ClassDNSNXDomain,
+		},
+		{
+			name:      "cache is used when the host resolver fails",
+			resolvers: map[string]Resolver{"": servfail, "fallback:53": answering("192.0.2.2")},
+			fallbacks: []string{"fallback:53"},
+			cache:     &cached,
+			addrs:     []string{"192.0.2.9"},
+			resolver:  ResolverCache,
+		},
+		{
+			name:      "cache isn't used without fallback resolvers",
+			resolvers: map[string]Resolver{"": servfail},
+			cache:     &cached,
+			class:     ClassDNSUnreachable,
+		},
+		{
+			name: "first fallback to answer wins the race",
+			resolvers: map[string]Resolver{
+				"":          dead,
+				"slow:53":   dead,
+				"answer:53": answering("192.0.2.3"),
+			},
+			fallbacks:       []string{"slow:53", "answer:53"},
+			addrs:           []string{"192.0.2.3"},
+			resolver:        ResolverFallback,
+			resolverAddress: "answer:53",
+		},
+		{
+			name: "fallback NXDOMAIN ends the race",
+			resolvers: map[string]Resolver{
+				"":        servfail,
+				"nx:53":   nxdomain,
+				"slow:53": dead,
+			},
+			fallbacks: []string{"nx:53", "slow:53"},
+			class:     ClassDNSNXDomain,
+		},
+		{
+			name: "fallback SERVFAIL means the network is up",
+			resolvers: map[string]Resolver{
+				"":        servfail,
+				"fail:53": servfail,
+				"dead:53": dead,
+			},
+			fallbacks: []string{"fail:53", "dead:53"},
+			class:     ClassDNSServFail,
+		},
+		{
+			name:      "no resolver answers",
+			resolvers: map[string]Resolver{"": dead},
+			fallbacks: []string{"a:53", "b:53"},
+			class:     ClassDNSUnreachable,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			network := &fakeNetwork{resolvers: test.resolvers}
+			config := resolveConfig(network, test.fallbacks...)
+			dnsCache := sync.Map{}
+			if test.cache != nil {
+				dnsCache.Store("target", *test.cache)
+			}
+			result := Result{}
+
+			addrs, working, err := resolveTarget(
+				context.Background(),
+				"target",
+				"target.example.",
+				config,
+				&dnsCache,
+				slog.New(slog.DiscardHandler),
+				&result,
+			)
+
+			if test.class != "" {
+				if class := ErrorClass(err); class != test.class {
+					t.Fatalf("failed with %v (class %s), want class %s", err, class, test.class)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed with %v", err)
+			}
+
+			got := []string{}
+			for _, addr := range addrs {
+				got = append(got, addr.IP.String())
+			}
+			if !slices.Equal(got, test.addrs) {
+				t.Errorf("resolved %v, want %v", got, test.addrs)
+			}
+			if result.Resolver != test.resolver || result.ResolverAddress != test.resolverAddress {
+				t.Errorf(
+					"resolved with %s %q, want %s %q",
+					result.Resolver,
+					result.ResolverAddress,
+					test.resolver,
+					test.resolverAddress,
+				)
+			}
+			if working != test.working {
+				t.Errorf("host resolver working = %v, want %v", working, test.working)
+			}
+		})
+	}
+}
+
+func TestResolveTargetComparesAnswers(t *testing.T) {
+	network := &fakeNetwork{resolvers: map[string]Resolver{
+		"":            answering("192.0.2.1"),
+		"fallback:53": answering("198.51.100.1"),
+	}}
+	config := resolveConfig(network, "fallback:53")
+	config.CompareResolvers = true
+	result := Result{}
+
+	_, _, err := resolveTarget(
+		context.Background(),
+		"target",
+		"target.example.",
+		config,
+		&sync.Map{},
+		slog.New(slog.DiscardHandler),
+		&result,
+	)
+	if err != nil {
+		t.Fatalf("failed with %v", err)
+	}
+
+	if result.ResolverComparison == nil || !result.ResolverComparison.Diverged {
+		t.Errorf("compared answers %+v, want them to diverge", result.ResolverComparison)
+	}
+}
+
+// When the host resolver fails, HTTP probes dial an IPv4 address from the
+// fallback resolvers instead of the address of the request
+func TestProbeHTTPDegradedMode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(server.Close)
+	serverURL, _ := url.Parse(server.URL)
+
+	network := &fakeNetwork{resolvers: map[string]Resolver{
+		"":            dead,
+		"fallback:53": answering("2001:db8::1", "127.0.0.1"),
+	}}
+	config := resolveConfig(network, "fallback:53")
+	config.Timeout = time.Second
+	target := "http://target.example:" + serverURL.Port() + "/"
+
+	dnsCache := sync.Map{}
+	result, err := ProbeHTTP(context.Background(), target, config, &dnsCache, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatalf("probe failed with %v", err)
+	}
+
+	if result.Resolver != ResolverFallback {
+		t.Errorf("resolved with %s, want %s", result.Resolver, ResolverFallback)
+	}
+	if dialed := network.addresses(); !slices.Equal(dialed, []string{"127.0.0.1:" + serverURL.Port()}) {
+		t.Errorf("dialed %v, want the IPv4 address of the fallback answer", dialed)
+	}
+	if _, exists := dnsCache.Load(target); !exists {
+		t.Error("fallback answer wasn't cached")
+	}
+}
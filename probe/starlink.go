@@ -0,0 +1,268 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	starlinkDefaultPort = "9200"
+	starlinkHandlePath  = "/SpaceX.API.Device.Device/Handle"
+
+	// Field numbers from the dish device API
+	starlinkRequestGetStatus         = 1004
+	starlinkResponseDishGetStatus    = 2004
+	starlinkStatusPopPingDropRate    = 1003
+	starlinkStatusObstructionStats   = 1004
+	starlinkStatusPopPingLatencyMs   = 1009
+	starlinkStatusOutage             = 1014
+	starlinkStatusSNRAboveNoiseFloor = 1018
+	starlinkObstructionFraction      = 1
+	starlinkObstructionCurrently     = 5
+	starlinkOutageCause              = 1
+)
+
+// Telemetry reported by a Starlink dish
+type StarlinkStatus struct {
+	PopPingDropRate     float64
+	PopPingLatencyMs    float64
+	FractionObstructed  float64
+	CurrentlyObstructed bool
+	SNRAboveNoiseFloor  bool
+	Outage              bool
+	OutageCause         uint64
+}
+
+// Query the local gRPC API of a Starlink dish for its status, the probe
+// fails when the dish reports an outage, an obstruction or all pings
+// to its point of presence being dropped
+func ProbeStarlink(
+	ctx context.Context,
+	target string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+) (Result, error) {
+	ctx, cancel := config.budget().attempt(ctx)
+	defer cancel()
+
+	result := Result{
+		Resolver: ResolverNone,
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, starlinkDefaultPort)
+	}
+
+	dialer := config.network().Dialer(config.BindInterface, config.budget().Dial)
+
+	// The dish speaks gRPC over cleartext HTTP/2
+	protocols := &http.Protocols{}
+	protocols.SetUnencryptedHTTP2(true)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			Protocols:         protocols,
+			DialContext:       dialer.DialContext,
+		},
+	}
+
+	// Request{get_status: GetStatusRequest{}}
+	message := protowire.AppendTag(nil, starlinkRequestGetStatus, protowire.BytesType)
+	message = protowire.AppendBytes(message, nil)
+
+	// gRPC frame of an uncompressed flag and message length
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+
+	request, err := http.NewRequestWithContext(
+		ctx,
+		"POST",
+		"http://"+target+starlinkHandlePath,
+		bytes.NewReader(frame),
+	)
+	if err != nil {
+		return result, fmt.Errorf("error creating request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/grpc")
+	request.Header.Set("TE", "trailers")
+
+	response, err := client.Do(request)
+	if err != nil {
+		logger.Info(
+			"Error querying Starlink dish",
+			"interface",
+			config.BindInterface,
+			"target",
+			target,
+			"error",
+			err.Error(),
+		)
+
+		return result, classifyError(target, PhaseRequest, err)
+	}
+	defer response.Body.Close()
+
+	result.Protocol = response.Proto
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, defaultMaxBodyBytes))
+	if err != nil {
+		return result, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if grpcStatus := response.Trailer.Get("Grpc-Status"); grpcStatus != "" && grpcStatus != "0" {
+		return result, fmt.Errorf(
+			"Starlink dish responded with gRPC status %s: %s",
+			grpcStatus,
+			response.Trailer.Get("Grpc-Message"),
+		)
+	}
+
+	if len(body) < 5 || body[0] != 0 {
+		return result, errors.New("invalid gRPC response from Starlink dish")
+	}
+	body = body[5:min(len(body), 5+int(binary.BigEndian.Uint32(body[1:5])))]
+
+	status, err := parseStarlinkResponse(body)
+	if err != nil {
+		return result, fmt.Errorf("could not decode Starlink dish status: %w", err)
+	}
+	result.Starlink = &status
+
+	logger.Debug(
+		"Starlink dish status",
+		"interface",
+		config.BindInterface,
+		"target",
+		target,
+		"pop_ping_drop_rate",
+		status.PopPingDropRate,
+		"pop_ping_latency_ms",
+		status.PopPingLatencyMs,
+		"fraction_obstructed",
+		status.FractionObstructed,
+		"outage",
+		status.Outage,
+	)
+
+	if status.Outage {
+		return result, newError(ClassStarlinkOutage, target, PhaseResponse, fmt.Errorf("cause %d", status.OutageCause))
+	}
+	if status.CurrentlyObstructed {
+		return result, newError(ClassStarlinkOutage, target, PhaseResponse, errors.New("dish is obstructed"))
+	}
+	if status.PopPingDropRate >= 1 {
+		return result, newError(ClassStarlinkOutage, target, PhaseResponse, errors.New("all pings are dropped"))
+	}
+
+	return result, nil
+}
+
+// Walk the fields of a protobuf message, calling fn with each field's
+// number, type and raw value
+func walkProtobuf(b []byte, fn func(number protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(b) > 0 {
+		number, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		m := protowire.ConsumeFieldValue(number, typ, b)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+
+		value := b[:m]
+		if typ == protowire.BytesType {
+			value, _ = protowire.ConsumeBytes(b)
+		}
+		if err := fn(number, typ, value); err != nil {
+			return err
+		}
+		b = b[m:]
+	}
+
+	return nil
+}
+
+func protobufFloat(value []byte) float64 {
+	v, n := protowire.ConsumeFixed32(value)
+	if n < 0 {
+		return 0
+	}
+	return float64(math.Float32frombits(v))
+}
+
+func protobufVarint(value []byte) uint64 {
+	v, n := protowire.ConsumeVarint(value)
+	if n < 0 {
+		return 0
+	}
+	return v
+}
+
+// Decode the dish status from a Response{dish_get_status} message
+func parseStarlinkResponse(b []byte) (StarlinkStatus, error) {
+	status := StarlinkStatus{}
+	found := false
+
+	err := walkProtobuf(b, func(number protowire.Number, typ protowire.Type, value []byte) error {
+		if number != starlinkResponseDishGetStatus || typ != protowire.BytesType {
+			return nil
+		}
+		found = true
+
+		return walkProtobuf(value, func(number protowire.Number, typ protowire.Type, value []byte) error {
+			switch number {
+			case starlinkStatusPopPingDropRate:
+				status.PopPingDropRate = protobufFloat(value)
+			case starlinkStatusPopPingLatencyMs:
+				status.PopPingLatencyMs = protobufFloat(value)
+			case starlinkStatusSNRAboveNoiseFloor:
+				status.SNRAboveNoiseFloor = protobufVarint(value) != 0
+			case starlinkStatusObstructionStats:
+				return walkProtobuf(value, func(number protowire.Number, typ protowire.Type, value []byte) error {
+					switch number {
+					case starlinkObstructionFraction:
+						status.FractionObstructed = protobufFloat(value)
+					case starlinkObstructionCurrently:
+						status.CurrentlyObstructed = protobufVarint(value) != 0
+					}
+					return nil
+				})
+			case starlinkStatusOutage:
+				status.Outage = true
+				return walkProtobuf(value, func(number protowire.Number, typ protowire.Type, value []byte) error {
+					if number == starlinkOutageCause {
+						status.OutageCause = protobufVarint(value)
+					}
+					return nil
+				})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return status, err
+	}
+
+	if !found {
+		return status, errors.New("response has no dish status")
+	}
+
+	return status, nil
+}
@@ -0,0 +1,76 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Open a TCP connection to a target host and port, the probe succeeds
+// as soon as one of the target's addresses accepts the connection
+func ProbeTCP(
+	ctx context.Context,
+	target string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+) (Result, error) {
+	ctx, cancel := config.budget().attempt(ctx)
+	defer cancel()
+
+	result := Result{}
+
+	host, portString, err := net.SplitHostPort(target)
+	if err != nil {
+		return result, fmt.Errorf("target must be host:port: %w", err)
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return result, fmt.Errorf("invalid target port: %s", portString)
+	}
+
+	addrs, err := targetAddrs(ctx, target, host, config, dnsCache, logger, &result)
+	if err != nil {
+		return result, err
+	}
+	addrs = preferIPProtocol(addrs, config.IPProtocol)
+	if len(addrs) == 0 {
+		return result, errors.New("No addresses found for hostname")
+	}
+
+	dialer := config.network().Dialer(config.BindInterface, config.budget().Dial)
+
+	var lastErr error
+	for _, addr := range addrs {
+		connectStart := time.Now()
+		conn, err := dialer.DialContext(ctx, "tcp", netip.AddrPortFrom(addr, uint16(port)).String())
+		if err != nil {
+			logger.Debug(
+				"Could not connect to target address",
+				"interface",
+				config.BindInterface,
+				"target",
+				target,
+				"address",
+				addr.String(),
+				"error",
+				err.Error(),
+			)
+
+			lastErr = classifyError(target, PhaseConnect, err)
+			continue
+		}
+		result.Timings.Connect = time.Since(connectStart)
+		conn.Close()
+
+		return result, nil
+	}
+
+	return result, lastErr
+}
@@ -0,0 +1,260 @@
+package probe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adaricorp/wan-prober/netbind"
+)
+
+const (
+	defaultIdleConnTimeout = 90 * time.Second
+)
+
+var (
+	// HTTP transports shared by probes, by transportKey
+	transports = sync.Map{}
+	// Resolvers dialing through an interface, by resolverKey
+	resolvers = sync.Map{}
+	// Socket accounting of HTTP probes, by interface
+	connStats = sync.Map{}
+)
+
+type transportKey struct {
+	bindInterface string
+	protocol      string
+	dialTimeout   time.Duration
+	keepAlive     bool
+	idleTimeout   time.Duration
+	network       Network
+	// Connections are dialed to addresses chosen by the probe's dial plan
+	planned bool
+}
+
+type resolverKey struct {
+	bindInterface string
+	address       string
+}
+
+// Counters of the connections HTTP probes made through an interface
+type ConnStats struct {
+	// Connections dialed, and dials which failed
+	Dials      atomic.Uint64
+	DialErrors atomic.Uint64
+	// Connections currently open
+	Open atomic.Int64
+	// Requests sent over a connection kept alive from an earlier probe
+	Reused atomic.Uint64
+}
+
+// How a probe's connections are dialed, passed to the shared transport in
+// the request context
+type dialPlan struct {
+	target string
+	addrs  []net.IPAddr
+	// Dial a random address of addrs instead of the address of the request
+	override bool
+	// Dial a random IPv4 address of addrs, when the host resolver isn't
+	// working and addrs come from the DNS cache or fallback resolvers
+	degraded bool
+	logger   *slog.Logger
+}
+
+type dialPlanKey struct{}
+
+// Connection which updates the open connection count when it is closed
+type countedConn struct {
+	net.Conn
+	stats *ConnStats
+	once  sync.Once
+}
+
+func (c *countedConn) Close() error {
+	c.once.Do(func() {
+		c.stats.Open.Add(-1)
+	})
+
+	return c.Conn.Close()
+}
+
+// Socket accounting of HTTP probes through an interface
+func interfaceConnStats(bindInterface string) *ConnStats {
+	stats, _ := connStats.LoadOrStore(bindInterface, &ConnStats{})
+	return stats.(*ConnStats)
+}
+
+// Call a function with the socket accounting of each interface HTTP probes
+// have made connections through
+func RangeConnStats(f func(bindInterface string, stats *ConnStats)) {
+	connStats.Range(func(key, val any) bool {
+		f(key.(string), val.(*ConnStats))
+		return true
+	})
+}
+
+// Transport of HTTP probes, an *http.Transport, or an *http3.Transport for
+// HTTP/3
+type probeTransport interface {
+	http.RoundTripper
+	CloseIdleConnections()
+}
+
+// Transport shared by HTTP probes through an interface with the same
+// protocol and keep-alive settings, built on first use. Connections of
+// probes whose dial plan chooses the address are never kept alive, since
+// the pool only knows the target's hostname, so a later probe could reuse
+// a connection to an address its plan wouldn't dial.
+func sharedTransport(config Config, plan *dialPlan) (probeTransport, error) {
+	key := transportKey{
+		bindInterface: config.BindInterface,
+		protocol:      config.HTTP.Protocol,
+		dialTimeout:   config.budget().Dial,
+		keepAlive:     config.HTTPKeepAlive,
+		idleTimeout:   config.HTTPIdleTimeout,
+		network:       config.network(),
+		planned:       plan.override || plan.degraded,
+	}
+	if transport, exists := transports.Load(key); exists {
+		return transport.(probeTransport), nil
+	}
+
+	idleTimeout := config.HTTPIdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultIdleConnTimeout
+	}
+
+	if config.HTTP.Protocol == HTTPProtocol3 {
+		transport := newHTTP3Transport(config, idleTimeout)
+		if !config.HTTPKeepAlive || key.planned {
+			// A transport of its own, closed with the response
+			return singleUseTransport{transport}, nil
+		}
+
+		actual, _ := transports.LoadOrStore(key, transport)
+		return actual.(probeTransport), nil
+	}
+
+	protocols := &http.Protocols{}
+	switch config.HTTP.Protocol {
+	case "", HTTPProtocol1:
+		protocols.SetHTTP1(true)
+	case HTTPProtocol2:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case HTTPProtocol2Only:
+		protocols.SetHTTP2(true)
+	default:
+		return nil, fmt.Errorf("unsupported HTTP protocol: %s", config.HTTP.Protocol)
+	}
+
+	dialer := config.network().Dialer(config.BindInterface, config.budget().Dial)
+	stats := interfaceConnStats(config.BindInterface)
+
+	transport := &http.Transport{
+		DisableKeepAlives: !config.HTTPKeepAlive || key.planned,
+		// Probes only need one connection per target
+		MaxIdleConnsPerHost: 1,
+		IdleConnTimeout:     idleTimeout,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		Protocols:           protocols,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if plan, ok := ctx.Value(dialPlanKey{}).(*dialPlan); ok {
+				addr = plan.dialAddress(config.BindInterface, addr)
+			}
+
+			stats.Dials.Add(1)
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				stats.DialErrors.Add(1)
+				return nil, err
+			}
+			stats.Open.Add(1)
+
+			return &countedConn{Conn: conn, stats: stats}, nil
+		},
+	}
+
+	actual, _ := transports.LoadOrStore(key, transport)
+	return actual.(probeTransport), nil
+}
+
+// Address to dial for a request to addr
+func (p *dialPlan) dialAddress(bindInterface string, addr string) string {
+	if !p.override && !p.degraded {
+		return addr
+	}
+
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		p.logger.Error("Failed to split address", "addr", addr)
+		return addr
+	}
+
+	if p.override {
+		// Dial one of the pinned or IPv6 addresses, while the request
+		// keeps using the target hostname for Host and SNI
+		ip := p.addrs[rand.IntN(len(p.addrs))].IP
+		addr = net.JoinHostPort(ip.String(), port)
+		p.logger.Debug(
+			"Dialing address for probe",
+			"interface",
+			bindInterface,
+			"target",
+			p.target,
+			"addr",
+			addr,
+		)
+		return addr
+	}
+
+	// When host resolver isn't working, we enter a degraded mode
+	// where we dial a random IPv4 address from our internal DNS cache
+	// or from fallback DNS resolver
+	for _, i := range rand.Perm(len(p.addrs)) {
+		ip := p.addrs[i].IP
+		if ip.To4() != nil {
+			addr = net.JoinHostPort(ip.String(), port)
+			p.logger.Info(
+				"Overriding IP address for probe",
+				"interface",
+				bindInterface,
+				"target",
+				p.target,
+				"addr",
+				addr,
+			)
+			break
+		}
+	}
+
+	return addr
+}
+
+// Resolver querying a DNS server through an interface, shared by probes
+func interfaceResolver(bindInterface string, address string) *net.Resolver {
+	key := resolverKey{bindInterface: bindInterface, address: address}
+	if resolver, exists := resolvers.Load(key); exists {
+		return resolver.(*net.Resolver)
+	}
+
+	dialer := net.Dialer{
+		Control: netbind.BindToDevice(bindInterface),
+	}
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "udp", address)
+		},
+	}
+
+	actual, _ := resolvers.LoadOrStore(key, resolver)
+	return actual.(*net.Resolver)
+}
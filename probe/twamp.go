@@ -0,0 +1,201 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	twampDefaultPort     = "862"
+	twampDefaultCount    = 10
+	twampDefaultInterval = 20 * time.Millisecond
+	// Reflected test packets are at least this big, sender packets are
+	// padded to the same size so both directions carry equal load
+	twampPacketSize = 41
+)
+
+// Delay, jitter and loss measured by TWAMP probes
+type TWAMPStats struct {
+	Sent     int
+	Received int
+	Loss     float64
+	RTT      time.Duration
+	Jitter   time.Duration
+	// One way delays, only meaningful when clocks of both ends are synchronized
+	ForwardDelay  time.Duration
+	BackwardDelay time.Duration
+}
+
+// Send TWAMP-light test packets to a reflector and measure delay, jitter
+// and loss from its replies
+func ProbeTWAMP(
+	ctx context.Context,
+	target string,
+	config Config,
+	dnsCache *sync.Map,
+	logger *slog.Logger,
+) (Result, error) {
+	twampConfig := config.TWAMP
+	result := Result{
+		Resolver: ResolverHost,
+	}
+
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(target, twampDefaultPort)
+	}
+
+	count := twampConfig.Count
+	if count == 0 {
+		count = twampDefaultCount
+	}
+	interval := twampConfig.Interval
+	if interval == 0 {
+		interval = twampDefaultInterval
+	}
+
+	ctx, cancel := config.budget().extend(time.Duration(count) * interval).attempt(ctx)
+	defer cancel()
+
+	dialer := config.network().Dialer(config.BindInterface, config.budget().Dial)
+
+	conn, err := dialer.DialContext(ctx, "udp", target)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+
+	sendTimes := make([]time.Time, count)
+	rtts := make([]time.Duration, count)
+	received := make([]bool, count)
+	var forwardDelay, backwardDelay time.Duration
+	var mu sync.Mutex
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		bufPtr := packetBuffers.Get().(*[]byte)
+		defer packetBuffers.Put(bufPtr)
+		buf := *bufPtr
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netError net.Error
+				if errors.Is(err, net.ErrClosed) || (errors.As(err, &netError) && netError.Timeout()) {
+					return
+				}
+				// e.g. ICMP port unreachable for an earlier packet
+				continue
+			}
+			now := time.Now()
+
+			if n < twampPacketSize {
+				continue
+			}
+
+			// Reflector timestamps and the fields we sent, echoed back
+			reflectorSendTime := ntpTime(binary.BigEndian.Uint64(buf[4:12]))
+			reflectorReceiveTime := ntpTime(binary.BigEndian.Uint64(buf[16:24]))
+			seq := binary.BigEndian.Uint32(buf[24:28])
+
+			mu.Lock()
+			if int(seq) < count && !received[seq] && !sendTimes[seq].IsZero() {
+				received[seq] = true
+
+				// Time spent in the reflector isn't part of the round trip
+				rtts[seq] = now.Sub(sendTimes[seq]) - reflectorSendTime.Sub(reflectorReceiveTime)
+				forwardDelay += reflectorReceiveTime.Sub(sendTimes[seq])
+				backwardDelay += now.Sub(reflectorSendTime)
+			}
+			mu.Unlock()
+		}
+	}()
+
+	for seq := range count {
+		packet := make([]byte, twampPacketSize)
+		now := time.Now()
+		mu.Lock()
+		sendTimes[seq] = now
+		mu.Unlock()
+		binary.BigEndian.PutUint32(packet[0:4], uint32(seq))
+		binary.BigEndian.PutUint64(packet[4:12], ntpTimestamp(now))
+		// Error estimate with the unsynchronized bit clear and a scale of 1
+		binary.BigEndian.PutUint16(packet[12:14], 0x0001)
+
+		if _, err := conn.Write(packet); err != nil {
+			return result, err
+		}
+
+		if seq < count-1 {
+			time.Sleep(interval)
+		}
+	}
+
+	// Wait for the last replies to arrive
+	conn.SetReadDeadline(phaseDeadline(ctx, config.Timeout))
+	<-done
+
+	stats := TWAMPStats{
+		Sent: count,
+	}
+	var rttSum, jitterSum time.Duration
+	jitterSamples := 0
+	previous := time.Duration(-1)
+	for seq := range count {
+		if !received[seq] {
+			continue
+		}
+		stats.Received += 1
+		rttSum += rtts[seq]
+
+		if previous >= 0 {
+			jitterSum += time.Duration(math.Abs(float64(rtts[seq] - previous)))
+			jitterSamples += 1
+		}
+		previous = rtts[seq]
+	}
+	stats.Loss = 1 - float64(stats.Received)/float64(stats.Sent)
+
+	if stats.Received > 0 {
+		stats.RTT = rttSum / time.Duration(stats.Received)
+		stats.ForwardDelay = forwardDelay / time.Duration(stats.Received)
+		stats.BackwardDelay = backwardDelay / time.Duration(stats.Received)
+	}
+	if jitterSamples > 0 {
+		stats.Jitter = jitterSum / time.Duration(jitterSamples)
+	}
+	result.TWAMP = &stats
+
+	logger.Debug(
+		"TWAMP test session finished",
+		"interface",
+		config.BindInterface,
+		"target",
+		target,
+		"sent",
+		stats.Sent,
+		"received",
+		stats.Received,
+		"rtt",
+		stats.RTT.String(),
+		"jitter",
+		stats.Jitter.String(),
+	)
+
+	if stats.Received == 0 {
+		return result, newError(ClassTimeout, target, PhaseResponse, nil)
+	}
+
+	if twampConfig.MaxLoss > 0 && stats.Loss > twampConfig.MaxLoss {
+		return result, newError(ClassPacketLoss, target, PhaseResponse, fmt.Errorf("%.1f%%", stats.Loss*100))
+	}
+
+	return result, nil
+}